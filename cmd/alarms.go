@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hasssanezzz/goldb/cmd/api"
+	"github.com/hasssanezzz/goldb/internal"
+)
+
+// webhookTimeout bounds a call to the alarm webhook.
+const webhookTimeout = 10 * time.Second
+
+// alarmConfig is the soft limits watched by runAlarms, a zero limit is not
+// watched, and where their events go.
+type alarmConfig struct {
+	diskUsage      int64  // Bytes of the engine's directory.
+	compactionDebt int64  // Bytes of SSTables waiting to be compacted.
+	walSize        int64  // Bytes of the WAL.
+	readOnly       bool   // Alarm while the engine only accepts reads.
+	dataGarbage    bool   // Alarm while a compaction of the data file is due, see shared.EngineConfig.DataGarbagePercent.
+	webhook        string // URL the events are POSTed to as JSON.
+	eventKey       string // Prefix of the keys the latest event of every alarm is written to.
+}
+
+// enabled reports whether a limit is watched and its events go somewhere.
+func (c alarmConfig) enabled() bool {
+	watched := c.diskUsage > 0 || c.compactionDebt > 0 || c.walSize > 0 || c.readOnly || c.dataGarbage
+	return watched && (c.webhook != "" || c.eventKey != "")
+}
+
+// alarmEvent is sent when a value crosses its limit and again when it is back
+// under it.
+type alarmEvent struct {
+	Alarm  string    `json:"alarm"`
+	Firing bool      `json:"firing"` // False once the value is back under the limit.
+	Value  int64     `json:"value"`
+	Limit  int64     `json:"limit"`
+	Time   time.Time `json:"time"`
+}
+
+// runAlarms checks the limits of config against the served engine every
+// interval until done is closed, sending an event whenever an alarm starts or
+// stops firing.
+func runAlarms(api *api.API, config alarmConfig, interval time.Duration, done <-chan struct{}) {
+	client := &http.Client{Timeout: webhookTimeout}
+	firing := map[string]bool{}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		db, release := api.Acquire()
+		if db == nil {
+			release()
+			continue
+		}
+
+		for _, event := range checkAlarms(db, config) {
+			if event.Firing == firing[event.Alarm] {
+				continue
+			}
+			firing[event.Alarm] = event.Firing
+			log.Printf("alarms: %s firing=%t value=%d limit=%d", event.Alarm, event.Firing, event.Value, event.Limit)
+
+			if err := sendAlarm(client, db, config, event); err != nil {
+				log.Printf("alarms: can not send the %s event: %v", event.Alarm, err)
+			}
+		}
+		release()
+	}
+}
+
+// checkAlarms returns the current state of every watched alarm.
+func checkAlarms(db *internal.Engine, config alarmConfig) []alarmEvent {
+	now := time.Now()
+	events := []alarmEvent{}
+	check := func(alarm string, value, limit int64) {
+		if limit > 0 {
+			events = append(events, alarmEvent{Alarm: alarm, Firing: value >= limit, Value: value, Limit: limit, Time: now})
+		}
+	}
+
+	if config.diskUsage > 0 {
+		usage, err := directorySize(db.Config.Homepath)
+		if err != nil {
+			log.Printf("alarms: can not measure %q: %v", db.Config.Homepath, err)
+		} else {
+			check("disk_usage", usage, config.diskUsage)
+		}
+	}
+	if config.compactionDebt > 0 {
+		check("compaction_debt", db.Stats().CompactionDebt, config.compactionDebt)
+	}
+	if config.walSize > 0 {
+		var size int64
+		if info, err := os.Stat(filepath.Join(db.Config.Homepath, internal.WALFileName)); err == nil {
+			size = info.Size()
+		}
+		check("wal_size", size, config.walSize)
+	}
+	if config.readOnly {
+		readOnly := int64(0)
+		if db.State() == internal.StateReadOnly {
+			readOnly = 1
+		}
+		check("read_only", readOnly, 1)
+	}
+	if config.dataGarbage {
+		stats := db.Stats()
+		percent := int64(0)
+		if total := stats.DataLiveBytes + stats.DataGarbageBytes; total > 0 {
+			percent = stats.DataGarbageBytes * 100 / total
+		}
+		check("data_garbage", percent, int64(db.Config.DataGarbagePercent))
+	}
+	return events
+}
+
+// sendAlarm POSTs the event to the webhook and writes it under the event key
+// of its alarm, whichever are configured.
+func sendAlarm(client *http.Client, db *internal.Engine, config alarmConfig, event alarmEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if config.eventKey != "" {
+		// a read-only engine can not record its own alarm, the webhook still can
+		if err := db.Set(config.eventKey+event.Alarm, body); err != nil {
+			log.Printf("alarms: can not write the %s event: %v", event.Alarm, err)
+		}
+	}
+
+	if config.webhook == "" {
+		return nil
+	}
+	resp, err := client.Post(config.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// directorySize returns the bytes of the regular files under dir.
+func directorySize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size, err
+}
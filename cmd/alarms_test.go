@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/cmd/api"
+	"github.com/hasssanezzz/goldb/internal"
+)
+
+func TestAlarms(t *testing.T) {
+	db, err := internal.NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer db.Close()
+
+	events := make(chan alarmEvent, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event alarmEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("webhook body is not an event: %v", err)
+		}
+		events <- event
+	}))
+	defer webhook.Close()
+
+	server, _ := api.New("", db)
+	config := alarmConfig{walSize: 1000, webhook: webhook.URL, eventKey: "alarms:"}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		runAlarms(server, config, time.Millisecond, done)
+		close(stopped)
+	}()
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+
+	next := func(firing bool) {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Alarm != "wal_size" || event.Firing != firing || event.Limit != 1000 {
+				t.Fatalf("event = %+v, want wal_size firing=%t with limit 1000", event, firing)
+			}
+			if firing != (event.Value >= event.Limit) {
+				t.Errorf("event = %+v, value and limit disagree with firing", event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event firing=%t after 5s", firing)
+		}
+	}
+	quiet := func() {
+		t.Helper()
+		select {
+		case event := <-events:
+			t.Errorf("event = %+v while the alarm kept its state, want none", event)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// nothing is sent while under the limit
+	quiet()
+
+	// crossing the limit fires once
+	db.Set("big", []byte(strings.Repeat("v", 2000)))
+	next(true)
+	quiet()
+	recorded, err := db.Get("alarms:wal_size")
+	if err != nil {
+		t.Fatalf("Get() of the event key error: %v", err)
+	}
+	var event alarmEvent
+	if err := json.Unmarshal(recorded, &event); err != nil || !event.Firing {
+		t.Errorf("event key holds %s, %v, want the firing event", recorded, err)
+	}
+
+	// a checkpoint flushes the memtable and truncates the WAL, clearing it once
+	if err := db.Checkpoint(filepath.Join(t.TempDir(), "checkpoint")); err != nil {
+		t.Fatalf("Checkpoint() error: %v", err)
+	}
+	next(false)
+	quiet()
+	if recorded, err := db.Get("alarms:wal_size"); err != nil || json.Unmarshal(recorded, &event) != nil || event.Firing {
+		t.Errorf("event key holds %s, %v, want the cleared event", recorded, err)
+	}
+}
@@ -1,56 +1,179 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hasssanezzz/goldb/internal"
 	"github.com/hasssanezzz/goldb/shared"
 )
 
+// Bounds of a scan response, a scan matching more keys is split into pages.
+const (
+	maxScanKeys  = 10000
+	maxScanBytes = 4 << 20
+)
+
 type API struct {
-	DB *internal.Engine
+	mu     sync.RWMutex
+	served *servedEngine // Nil until an engine is open.
 }
 
+// servedEngine is an engine with the requests using it, once replaced it is
+// only closed after they are done.
+type servedEngine struct {
+	db       *internal.Engine
+	users    sync.WaitGroup
+	replaced chan struct{} // Closed once the engine is replaced, ends the requests streaming from it.
+}
+
+func newServedEngine(db *internal.Engine) *servedEngine {
+	return &servedEngine{db: db, replaced: make(chan struct{})}
+}
+
+// engineKey holds the engine acquired for a request in its context, see ready.
+type engineKey struct{}
+
 func New(source string, db *internal.Engine) (*API, error) {
-	return &API{DB: db}, nil
+	api := &API{}
+	if db != nil {
+		api.served = newServedEngine(db)
+	}
+	return api, nil
+}
+
+// SwapDB serves db in place of the previous engine, which it returns along
+// with a function waiting until the requests using it are done, used by
+// read-only replicas when a newer checkpoint appears. Watch streams of the
+// previous engine end, their clients reconnect to the new one.
+func (api *API) SwapDB(db *internal.Engine) (*internal.Engine, func()) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	old := api.served
+	api.served = nil
+	if db != nil {
+		api.served = newServedEngine(db)
+	}
+	if old == nil {
+		return nil, func() {}
+	}
+	close(old.replaced)
+	return old.db, old.users.Wait
+}
+
+// Acquire returns the served engine, nil until it is open, and the function
+// releasing it. A replaced engine stays open until it is released.
+func (api *API) Acquire() (*internal.Engine, func()) {
+	served, release := api.acquire()
+	if served == nil {
+		return nil, release
+	}
+	return served.db, release
+}
+
+// acquire is Acquire returning the served engine with its replacement signal.
+func (api *API) acquire() (*servedEngine, func()) {
+	api.mu.RLock()
+	defer api.mu.RUnlock()
+
+	if api.served == nil {
+		return nil, func() {}
+	}
+	api.served.users.Add(1)
+	return api.served, api.served.users.Done
+}
+
+// requestEngine returns the engine acquired by ready for the request.
+func (api *API) requestEngine(r *http.Request) *internal.Engine {
+	return r.Context().Value(engineKey{}).(*servedEngine).db
 }
 
 func (api *API) getHandler(w http.ResponseWriter, r *http.Request) {
-	// check if this is a prefix scan query
+	if r.Method == http.MethodHead {
+		api.headHandler(w, r)
+		return
+	}
+
+	db := api.requestEngine(r)
+
+	// check if this is a prefix or glob pattern scan query
 	prefix := r.Header.Get("prefix")
 	if len(prefix) > 0 {
 		if prefix == "*" {
 			prefix = ""
 		}
 
-		results, err := api.DB.Scan(prefix)
+		limit := maxScanKeys
+		if value := r.Header.Get("Limit"); len(value) > 0 {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				http.Error(w, "Limit must be a positive number", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxScanKeys)
+		}
+
+		// a page is bounded in keys and bytes, the client asks for the next
+		// one with the last key it got in the After header
+		page := bytes.Buffer{}
+		keys, truncated, last := 0, false, ""
+		err := db.ScanKeysAfter(prefix, r.Header.Get("After"), func(key string) (bool, error) {
+			if keys == limit || page.Len()+len(key)+1 > maxScanBytes {
+				truncated = true
+				return true, nil
+			}
+			page.WriteString(key + "\n")
+			keys, last = keys+1, key
+			return false, nil
+		})
 		if err != nil {
+			var errPattern *shared.ErrInvalidPattern
+			if errors.As(err, &errPattern) {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			log.Printf("api: error scanning %q: %v\n", prefix, err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 
-		stringResponse := new(strings.Builder)
-		for _, key := range results {
-			stringResponse.WriteString(key + "\n")
-		}
-
 		w.Header().Set("Content-Type", "text/plain")
-		w.Write([]byte(stringResponse.String()))
+		w.Header().Set("Scan-Truncated", strconv.FormatBool(truncated))
+		if truncated {
+			w.Header().Set("Scan-Next", last)
+		}
+		w.Write(page.Bytes())
 		return
 	}
 
 	key := r.Header.Get("Key")
-	if len([]byte(key)) > int(api.DB.Config.KeySize) {
-		http.Error(w, fmt.Sprintf("Key size must be less than or equal %d bytes", api.DB.Config.KeySize), http.StatusBadRequest)
+	if len([]byte(key)) > int(db.Config.KeySize) {
+		http.Error(w, fmt.Sprintf("Key size must be less than or equal %d bytes", db.Config.KeySize), http.StatusBadRequest)
+		return
+	}
+
+	// an explain query returns how the lookup was served instead of the value
+	if len(r.Header.Get("Explain")) > 0 {
+		ctx, trace := internal.WithTrace(r.Context())
+		db.GetContext(ctx, key)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(trace)
 		return
 	}
 
-	data, err := api.DB.Get(key)
+	data, err := db.Get(key)
 	if err != nil {
 		var errKeyRemoved *shared.ErrKeyRemoved
 		var errKeyNotFound *shared.ErrKeyNotFound
@@ -67,10 +190,34 @@ func (api *API) getHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// headHandler answers whether the key exists without reading its value.
+func (api *API) headHandler(w http.ResponseWriter, r *http.Request) {
+	db := api.requestEngine(r)
+
+	key := r.Header.Get("Key")
+	if len([]byte(key)) > int(db.Config.KeySize) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ok, err := db.Has(key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
 func (api *API) postHandler(w http.ResponseWriter, r *http.Request) {
+	db := api.requestEngine(r)
+
 	key := r.Header.Get("Key")
-	if len([]byte(key)) > int(api.DB.Config.KeySize) {
-		http.Error(w, fmt.Sprintf("Key size must be less than or equal %d bytes", api.DB.Config.KeySize), http.StatusBadRequest)
+	if len([]byte(key)) > int(db.Config.KeySize) {
+		http.Error(w, fmt.Sprintf("Key size must be less than or equal %d bytes", db.Config.KeySize), http.StatusBadRequest)
 		return
 	}
 
@@ -81,8 +228,24 @@ func (api *API) postHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	err = api.DB.Set(key, body)
+	err = db.Set(key, body)
 	if err != nil {
+		var errInvalidKey *shared.ErrInvalidKey
+		if errors.As(err, &errInvalidKey) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var errReadOnly *shared.ErrReadOnly
+		if errors.As(err, &errReadOnly) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		var errQuota *shared.ErrQuotaExceeded
+		if errors.As(err, &errQuota) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+
 		log.Printf("api: error setting (%q, %X): %v\n", key, body, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -93,14 +256,43 @@ func (api *API) postHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (api *API) deleteHandler(w http.ResponseWriter, r *http.Request) {
+	db := api.requestEngine(r)
+
 	key := r.Header.Get("Key")
-	if len([]byte(key)) > int(api.DB.Config.KeySize) {
-		http.Error(w, fmt.Sprintf("Key size must be less than or equal %d bytes", api.DB.Config.KeySize), http.StatusBadRequest)
+	if len([]byte(key)) > int(db.Config.KeySize) {
+		http.Error(w, fmt.Sprintf("Key size must be less than or equal %d bytes", db.Config.KeySize), http.StatusBadRequest)
 		return
 	}
 
-	err := api.DB.Delete(key)
+	// a durable delete waits for the tombstone to be synced and reports the
+	// number of tables still holding older values
+	// a purge also erases the key's values, Stale-Tables then counts the
+	// tables still referencing them
+	var err error
+	if len(r.Header.Get("Purge")) > 0 {
+		var report internal.PurgeReport
+		report, err = db.Purge(key)
+		w.Header().Set("Erased-Values", strconv.Itoa(report.ErasedValues))
+		w.Header().Set("Stale-Tables", strconv.Itoa(report.StaleTables))
+	} else if len(r.Header.Get("Durable")) > 0 {
+		var result internal.DeleteResult
+		result, err = db.DeleteDurable(key)
+		w.Header().Set("Stale-Tables", strconv.Itoa(result.StaleTables))
+	} else {
+		err = db.Delete(key)
+	}
 	if err != nil {
+		var errInvalidKey *shared.ErrInvalidKey
+		if errors.As(err, &errInvalidKey) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var errReadOnly *shared.ErrReadOnly
+		if errors.As(err, &errReadOnly) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
 		log.Printf("api: error deleting (%q): %v\n", key, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -108,9 +300,571 @@ func (api *API) deleteHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+type casRequest struct {
+	Key         string  `json:"key"`
+	Expected    []byte  `json:"expected"`               // empty means the key must not exist
+	ExpectedSeq *uint64 `json:"expected_seq,omitempty"` // compared instead of expected when set, 0 means the key must not exist
+	Value       []byte  `json:"value"`
+}
+
+// casResponse tells the sequence number of the key's latest write once the
+// swap was tried, so a client can swap again on it. It is 0 for a missing key.
+type casResponse struct {
+	Seq uint64 `json:"seq"`
+}
+
+func (api *API) casHandler(w http.ResponseWriter, r *http.Request) {
+	db := api.requestEngine(r)
+
+	var req casRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Unable to parse body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if len([]byte(req.Key)) > int(db.Config.KeySize) {
+		http.Error(w, fmt.Sprintf("Key size must be less than or equal %d bytes", db.Config.KeySize), http.StatusBadRequest)
+		return
+	}
+
+	var swapped bool
+	var err error
+	if req.ExpectedSeq != nil {
+		swapped, err = db.CompareAndSwapSeq(req.Key, *req.ExpectedSeq, req.Value)
+	} else {
+		swapped, err = db.CompareAndSwap(req.Key, req.Expected, req.Value)
+	}
+	if err != nil {
+		var errInvalidKey *shared.ErrInvalidKey
+		if errors.As(err, &errInvalidKey) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var errReadOnly *shared.ErrReadOnly
+		if errors.As(err, &errReadOnly) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		var errQuota *shared.ErrQuotaExceeded
+		if errors.As(err, &errQuota) {
+			http.Error(w, err.Error(), http.StatusInsufficientStorage)
+			return
+		}
+		var errUnsequenced *shared.ErrUnsequenced
+		if errors.As(err, &errUnsequenced) {
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+
+		log.Printf("api: error swapping (%q, %X): %v\n", req.Key, req.Value, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// a write landing since the swap only makes the next one fail
+	seq, err := db.KeySequence(req.Key)
+	var errKeyNotFound *shared.ErrKeyNotFound
+	if err != nil && !errors.As(err, &errKeyNotFound) {
+		log.Printf("api: error reading the sequence number of %q: %v\n", req.Key, err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if !swapped {
+		w.WriteHeader(http.StatusConflict)
+	}
+	json.NewEncoder(w).Encode(casResponse{Seq: seq})
+}
+
+// mgetHandler returns the found values of a JSON array of keys as a JSON object,
+// values are base64 encoded unless the "Value-Encoding: inline" header is set.
+// Both encodings are application/json, so the content type can not tell them
+// apart, the header is the one the bulk and hash endpoints read too.
+func (api *API) mgetHandler(w http.ResponseWriter, r *http.Request) {
+	db := api.requestEngine(r)
+
+	var keys []string
+	if err := json.NewDecoder(r.Body).Decode(&keys); err != nil {
+		http.Error(w, "Unable to parse body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	for _, key := range keys {
+		if len([]byte(key)) > int(db.Config.KeySize) {
+			http.Error(w, fmt.Sprintf("Key size must be less than or equal %d bytes", db.Config.KeySize), http.StatusBadRequest)
+			return
+		}
+	}
+
+	results, err := db.GetMulti(keys)
+	if err != nil {
+		log.Printf("api: error getting %d keys: %v\n", len(keys), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.Header.Get("Value-Encoding") == "inline" {
+		json.NewEncoder(w).Encode(inlineValues(results))
+		return
+	}
+
+	json.NewEncoder(w).Encode(results)
+}
+
+// inlineValues returns the values as strings, for the "Value-Encoding: inline" header.
+func inlineValues(values map[string][]byte) map[string]string {
+	inline := make(map[string]string, len(values))
+	for key, value := range values {
+		inline[key] = string(value)
+	}
+	return inline
+}
+
+type bulkRecord struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"` // empty deletes the key
+}
+
+type inlineBulkRecord struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// bulkHandler ingests a stream of newline delimited JSON records straight into
+// SSTables, values are base64 encoded unless the "Value-Encoding: inline"
+// header is set. Records read before a failure are still written, the response
+// holds the number of written pairs.
+func (api *API) bulkHandler(w http.ResponseWriter, r *http.Request) {
+	db := api.requestEngine(r)
+	defer r.Body.Close()
+
+	ingester, err := db.NewIngester()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	inline := r.Header.Get("Value-Encoding") == "inline"
+	decoder := json.NewDecoder(r.Body)
+	status := http.StatusOK
+	for {
+		var record bulkRecord
+		if inline {
+			var inlineRecord inlineBulkRecord
+			err = decoder.Decode(&inlineRecord)
+			record = bulkRecord{Key: inlineRecord.Key, Value: []byte(inlineRecord.Value)}
+		} else {
+			err = decoder.Decode(&record)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = nil
+			} else {
+				status = http.StatusBadRequest
+			}
+			break
+		}
+
+		if err = ingester.Add(record.Key, record.Value); err != nil {
+			status = http.StatusInternalServerError
+			var errKeyTooLong *shared.ErrKeyTooLong
+			var errInvalidKey *shared.ErrInvalidKey
+			if errors.As(err, &errKeyTooLong) || errors.As(err, &errInvalidKey) {
+				status = http.StatusBadRequest
+			}
+			break
+		}
+	}
+	if closeErr := ingester.Close(); closeErr != nil && err == nil {
+		err, status = closeErr, http.StatusInternalServerError
+	}
+
+	response := map[string]any{"written": ingester.Written()}
+	if err != nil {
+		log.Printf("api: bulk upload failed after %d pairs: %v\n", ingester.Written(), err)
+		response["error"] = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// hashHandler reads (GET), sets (POST) or deletes (DELETE) the fields of the
+// hash named by the "name" query parameter. GET returns the field named by
+// "field" as is, or every field as a JSON object. POST takes a JSON object of
+// fields and DELETE a JSON array of field names. Values are base64 encoded
+// unless the "Value-Encoding: inline" header is set.
+func (api *API) hashHandler(w http.ResponseWriter, r *http.Request) {
+	db := api.requestEngine(r)
+	defer r.Body.Close()
+
+	name := r.URL.Query().Get("name")
+	if len(name) == 0 || strings.Contains(name, ":") {
+		http.Error(w, "Hash name must be set and can not contain ':'", http.StatusBadRequest)
+		return
+	}
+	inline := r.Header.Get("Value-Encoding") == "inline"
+
+	var response map[string]any
+	var err error
+	switch r.Method {
+	case http.MethodGet:
+		if field := r.URL.Query().Get("field"); len(field) > 0 {
+			var value []byte
+			if value, err = db.HGet(name, field); err == nil {
+				w.Write(value)
+				return
+			}
+			break
+		}
+
+		var fields map[string][]byte
+		if fields, err = db.HGetAll(name); err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			if inline {
+				json.NewEncoder(w).Encode(inlineValues(fields))
+				return
+			}
+			json.NewEncoder(w).Encode(fields)
+			return
+		}
+	case http.MethodPost:
+		fields := map[string][]byte{}
+		if inline {
+			var inlineFields map[string]string
+			err = json.NewDecoder(r.Body).Decode(&inlineFields)
+			for field, value := range inlineFields {
+				fields[field] = []byte(value)
+			}
+		} else {
+			err = json.NewDecoder(r.Body).Decode(&fields)
+		}
+		if err != nil {
+			http.Error(w, "Unable to parse body", http.StatusBadRequest)
+			return
+		}
+
+		var added int
+		if added, err = db.HSet(name, fields); err == nil {
+			response = map[string]any{"added": added}
+		}
+	case http.MethodDelete:
+		var fields []string
+		if err := json.NewDecoder(r.Body).Decode(&fields); err != nil {
+			http.Error(w, "Unable to parse body", http.StatusBadRequest)
+			return
+		}
+
+		var removed int
+		if removed, err = db.HDel(name, fields...); err == nil {
+			response = map[string]any{"removed": removed}
+		}
+	}
+
+	if err != nil {
+		var errKeyNotFound *shared.ErrKeyNotFound
+		var errKeyTooLong *shared.ErrKeyTooLong
+		var errReadOnly *shared.ErrReadOnly
+		switch {
+		case errors.As(err, &errKeyNotFound):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.As(err, &errKeyTooLong):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.As(err, &errReadOnly):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			log.Printf("api: error with hash %q: %v\n", name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+type leaseRequest struct {
+	Key   string `json:"key"`
+	Owner string `json:"owner"`
+	TTLMs int64  `json:"ttl_ms"` // acquisitions only
+	Token uint64 `json:"token"`  // releases only
+}
+
+// leaseHandler acquires or renews a lease (POST) or releases it (DELETE), a
+// lease held by another owner answers 409 Conflict with its holder.
+func (api *API) leaseHandler(w http.ResponseWriter, r *http.Request) {
+	db := api.requestEngine(r)
+
+	var req leaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Unable to parse body", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if len([]byte(req.Key)) > int(db.Config.KeySize) {
+		http.Error(w, fmt.Sprintf("Key size must be less than or equal %d bytes", db.Config.KeySize), http.StatusBadRequest)
+		return
+	}
+
+	var lease internal.Lease
+	var err error
+	if r.Method == http.MethodDelete {
+		err = db.ReleaseLease(req.Key, req.Owner, req.Token)
+	} else {
+		lease, err = db.AcquireLease(req.Key, req.Owner, time.Duration(req.TTLMs)*time.Millisecond)
+	}
+	if err != nil {
+		var errHeld *shared.ErrLeaseHeld
+		if errors.As(err, &errHeld) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{"owner": errHeld.Owner, "expires_at": errHeld.ExpiresAt})
+			return
+		}
+		var errReadOnly *shared.ErrReadOnly
+		if errors.As(err, &errReadOnly) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		log.Printf("api: error with the lease of %q: %v\n", req.Key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(lease)
+}
+
+// watchHandler streams the changes of the keys starting with any of the
+// "prefix" query parameters as newline delimited JSON, values are base64
+// encoded and left out with "keys_only=true". The stream ends when the client
+// goes away, the subscription overflows or the engine is replaced, see SwapDB.
+func (api *API) watchHandler(w http.ResponseWriter, r *http.Request) {
+	served := r.Context().Value(engineKey{}).(*servedEngine)
+	db := served.db
+
+	query := r.URL.Query()
+	keysOnly, _ := strconv.ParseBool(query.Get("keys_only"))
+	sub := db.Subscribe(internal.SubscribeOptions{Prefixes: query["prefix"], KeysOnly: keysOnly})
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-served.replaced:
+			// the stream would keep the replaced engine open
+			return
+		case change, ok := <-sub.Changes():
+			if !ok {
+				log.Printf("api: watch ended: %v\n", sub.Err())
+				return
+			}
+			if err := encoder.Encode(change); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// stateHandler reports the engine's lifecycle stage and pending background
+// work, the server reports "recovering" until it is handed an opened engine.
+func (api *API) stateHandler(w http.ResponseWriter, r *http.Request) {
+	report := internal.StateReport{State: internal.StateRecovering}
+	db, release := api.Acquire()
+	defer release()
+	if db != nil {
+		report = db.StateReport()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// tenantsHandler returns the usage and quota of every configured tenant.
+func (api *API) tenantsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(api.requestEngine(r).TenantStats())
+}
+
+// approximateHandler returns the estimated keys and bytes under the "prefix"
+// query parameter, computed from table metadata.
+func (api *API) approximateHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(api.requestEngine(r).ApproximateStats(r.URL.Query().Get("prefix")))
+}
+
+// hotKeysHandler returns the most read keys, see -hot-keys.
+func (api *API) hotKeysHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(api.requestEngine(r).HotKeys())
+}
+
+// warmupHandler reads the blocks logged by the last shutdown into the page
+// cache, see -prime-blocks, and returns how many were read.
+func (api *API) warmupHandler(w http.ResponseWriter, r *http.Request) {
+	primed, err := api.requestEngine(r).Warmup()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"primed": primed})
+}
+
+// writeAmpHandler returns the bytes ingested and rewritten per keyspace, see
+// -write-amp-separator.
+func (api *API) writeAmpHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(api.requestEngine(r).WriteAmplification())
+}
+
+// indexHandler returns the keys whose JSON value holds the "value" query
+// parameter in the field indexed by the index "name", see -json-index.
+func (api *API) indexHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if len(name) == 0 {
+		http.Error(w, "Index name must be set", http.StatusBadRequest)
+		return
+	}
+
+	keys, err := api.requestEngine(r).QueryIndex(name, r.URL.Query().Get("value"))
+	if err != nil {
+		if _, ok := err.(*shared.ErrIndexNotFound); ok {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("api: error querying index %q: %v\n", name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keys)
+}
+
+// debugState is the runtime debug switches of the engine, fields left out of
+// a POST body keep their value.
+type debugState struct {
+	Debug   *bool `json:"debug,omitempty"`   // Debug logging.
+	Tracing *bool `json:"tracing,omitempty"` // Every lookup is traced to the log.
+}
+
+// debugHandler reports the runtime debug switches, and on POST updates them
+// from a JSON body, so a running server can be inspected without a restart.
+func (api *API) debugHandler(w http.ResponseWriter, r *http.Request) {
+	db := api.requestEngine(r)
+
+	if r.Method == http.MethodPost {
+		var req debugState
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Unable to parse body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		if req.Debug != nil {
+			db.SetDebug(*req.Debug)
+		}
+		if req.Tracing != nil {
+			db.SetTracing(*req.Tracing)
+		}
+	}
+
+	debug, tracing := db.Debug(), db.Tracing()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(debugState{Debug: &debug, Tracing: &tracing})
+}
+
+// failpointsHandler reports the active failpoints, and on POST replaces them
+// with the JSON body. Servers built without the failpoints tag answer 501.
+func (api *API) failpointsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		var req internal.Failpoints
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Unable to parse body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		if err := internal.SetFailpoints(req); err != nil {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		log.Printf("api: failpoints set to %+v\n", req)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(internal.ActiveFailpoints())
+}
+
+// ToggleDebug flips debug logging of the served engine, if there is one yet.
+func (api *API) ToggleDebug() {
+	db, release := api.Acquire()
+	defer release()
+	if db != nil {
+		db.SetDebug(!db.Debug())
+	}
+}
+
+// ready rejects requests while the server has no engine yet, the handler
+// uses the engine acquired for the request until it returns.
+func (api *API) ready(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		served, release := api.acquire()
+		defer release()
+		if served == nil {
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "engine is recovering", http.StatusServiceUnavailable)
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), engineKey{}, served)))
+	}
+}
+
 func (api *API) SetupRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("GET /", api.getHandler)
-	mux.HandleFunc("POST /", api.postHandler)
-	mux.HandleFunc("PUT /", api.postHandler)
-	mux.HandleFunc("DELETE /", api.deleteHandler)
+	mux.HandleFunc("GET /admin/state", api.stateHandler)
+	mux.HandleFunc("GET /admin/tenants", api.ready(api.tenantsHandler))
+	mux.HandleFunc("GET /admin/approximate", api.ready(api.approximateHandler))
+	mux.HandleFunc("GET /admin/hotkeys", api.ready(api.hotKeysHandler))
+	mux.HandleFunc("GET /admin/writeamp", api.ready(api.writeAmpHandler))
+	mux.HandleFunc("POST /admin/warmup", api.ready(api.warmupHandler))
+	mux.HandleFunc("GET /admin/debug", api.ready(api.debugHandler))
+	mux.HandleFunc("POST /admin/debug", api.ready(api.debugHandler))
+	mux.HandleFunc("GET /admin/failpoints", api.failpointsHandler)
+	mux.HandleFunc("POST /admin/failpoints", api.failpointsHandler)
+	mux.HandleFunc("POST /v1/cas", api.ready(api.casHandler))
+	mux.HandleFunc("POST /v1/mget", api.ready(api.mgetHandler))
+	mux.HandleFunc("POST /v1/bulk", api.ready(api.bulkHandler))
+	mux.HandleFunc("POST /v1/lease", api.ready(api.leaseHandler))
+	mux.HandleFunc("GET /v1/watch", api.ready(api.watchHandler))
+	mux.HandleFunc("GET /v1/index", api.ready(api.indexHandler))
+	mux.HandleFunc("GET /v1/hash", api.ready(api.hashHandler))
+	mux.HandleFunc("POST /v1/hash", api.ready(api.hashHandler))
+	mux.HandleFunc("DELETE /v1/hash", api.ready(api.hashHandler))
+	mux.HandleFunc("DELETE /v1/lease", api.ready(api.leaseHandler))
+	mux.HandleFunc("GET /", api.ready(api.getHandler))
+	mux.HandleFunc("POST /", api.ready(api.postHandler))
+	mux.HandleFunc("PUT /", api.ready(api.postHandler))
+	mux.HandleFunc("DELETE /", api.ready(api.deleteHandler))
 }
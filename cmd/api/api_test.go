@@ -0,0 +1,149 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal"
+)
+
+func TestMget(t *testing.T) {
+	db, err := internal.NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer db.Close()
+	db.Set("text", []byte("value"))
+	db.Set("binary", []byte{0, 0xFF, '"'})
+
+	server, _ := New("", db)
+	mux := http.NewServeMux()
+	server.SetupRoutes(mux)
+
+	mget := func(encoding string) (int, map[string]string) {
+		t.Helper()
+		r := httptest.NewRequest("POST", "/v1/mget", strings.NewReader(`["text", "binary", "missing"]`))
+		if encoding != "" {
+			r.Header.Set("Value-Encoding", encoding)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		values := map[string]string{}
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&values); err != nil {
+				t.Fatalf("response is not a JSON object: %v", err)
+			}
+		}
+		return w.Code, values
+	}
+
+	for _, test := range []struct {
+		encoding string
+		want     map[string]string
+	}{
+		{"", map[string]string{"text": "dmFsdWU=", "binary": "AP8i"}},
+		{"inline", map[string]string{"text": "value", "binary": "\x00\uFFFD\""}},
+	} {
+		code, values := mget(test.encoding)
+		if code != http.StatusOK {
+			t.Errorf("mget with encoding %q = %d, want 200", test.encoding, code)
+			continue
+		}
+		if len(values) != len(test.want) {
+			t.Errorf("mget with encoding %q = %q, want %q without the missing key", test.encoding, values, test.want)
+		}
+		for key, value := range test.want {
+			if values[key] != value {
+				t.Errorf("mget with encoding %q: %q = %q, want %q", test.encoding, key, values[key], value)
+			}
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/v1/mget", strings.NewReader(`{"keys": ["text"]}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("mget of an object = %d, want 400", w.Code)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	db, err := internal.NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer db.Close()
+	db.Set("key", []byte("value"))
+
+	server, _ := New("", db)
+	mux := http.NewServeMux()
+	server.SetupRoutes(mux)
+
+	for _, test := range []struct {
+		key      string
+		memtable bool
+		err      bool
+	}{{"key", true, false}, {"missing", false, true}} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Key", test.key)
+		r.Header.Set("Explain", "1")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		var trace internal.Trace
+		if err := json.NewDecoder(w.Body).Decode(&trace); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("explain of %q = %d, %v, want a JSON trace", test.key, w.Code, err)
+		}
+		if trace.Key != test.key || trace.MemtableHit != test.memtable || (trace.Err != "") != test.err {
+			t.Errorf("explain of %q = %+v, want memtable hit %t and error %t", test.key, trace, test.memtable, test.err)
+		}
+	}
+}
+
+func TestWatchEndsOnSwap(t *testing.T) {
+	old, err := internal.NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer old.Close()
+
+	server, _ := New("", old)
+	mux := http.NewServeMux()
+	server.SetupRoutes(mux)
+	web := httptest.NewServer(mux)
+	defer web.Close()
+
+	resp, err := web.Client().Get(web.URL + "/v1/watch?prefix=key")
+	if err != nil {
+		t.Fatalf("watch error: %v", err)
+	}
+	defer resp.Body.Close()
+	old.Set("key", []byte("value"))
+	var change map[string]any
+	decoder := json.NewDecoder(resp.Body)
+	if err := decoder.Decode(&change); err != nil {
+		t.Fatalf("watch stream error: %v, want the change", err)
+	}
+
+	// the replaced engine is released once its stream ended
+	_, wait := server.SwapDB(nil)
+	released := make(chan struct{})
+	go func() {
+		wait()
+		close(released)
+	}()
+	select {
+	case <-released:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("replaced engine still held by a watch stream after 5s")
+	}
+	if err := decoder.Decode(&change); err != io.EOF {
+		t.Errorf("watch stream = %v after the swap, want it ended", err)
+	}
+}
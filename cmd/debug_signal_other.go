@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "github.com/hasssanezzz/goldb/cmd/api"
+
+// handleDebugSignal does nothing where there is no SIGUSR1, debug logging is
+// toggled through the /admin/debug endpoint instead.
+func handleDebugSignal(api *api.API, done <-chan struct{}) {}
@@ -0,0 +1,28 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/hasssanezzz/goldb/cmd/api"
+)
+
+// handleDebugSignal toggles debug logging on every SIGUSR1 until done is
+// closed, see also the /admin/debug endpoint.
+func handleDebugSignal(api *api.API, done <-chan struct{}) {
+	debugSignal := make(chan os.Signal, 1)
+	signal.Notify(debugSignal, syscall.SIGUSR1)
+	defer signal.Stop(debugSignal)
+
+	for {
+		select {
+		case <-debugSignal:
+			api.ToggleDebug()
+		case <-done:
+			return
+		}
+	}
+}
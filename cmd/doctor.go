@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hasssanezzz/goldb/internal"
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// runDoctor checks a database directory without modifying it,
+// usage: goldb doctor [-spot N] <dir>
+func runDoctor(args []string) int {
+	flags := flag.NewFlagSet("doctor", flag.ExitOnError)
+	spotChecks := flags.Int("spot", 16, "Values per table to read back")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: goldb doctor [-spot N] <dir>")
+		return 2
+	}
+	source := flags.Arg(0)
+
+	config := *shared.NewEngineConfig().WithReadOnly(true)
+	db, err := internal.NewEngine(source, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "doctor: can not open %q: %v\n", source, err)
+		return 1
+	}
+	defer db.Close()
+
+	if err := db.CheckConsistency(*spotChecks); err != nil {
+		fmt.Fprintf(os.Stderr, "doctor: %q is inconsistent: %v\n", source, err)
+		return 1
+	}
+
+	// data files written before records were introduced can not be scanned
+	records := 0
+	err = internal.ScanDataFile(filepath.Join(source, internal.DataFileName), func(internal.DataRecord) error {
+		records++
+		return nil
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "doctor: skipping the data file check of %q: %v\n", source, err)
+		var corrupt *shared.ErrCorruptRecord
+		if errors.As(err, &corrupt) {
+			return 1
+		}
+	} else {
+		fmt.Printf("doctor: %d data records verified\n", records)
+	}
+
+	fmt.Printf("doctor: %q is consistent\n", source)
+	return 0
+}
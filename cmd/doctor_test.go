@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/internal"
+)
+
+// doctor runs the doctor subcommand on dir and returns its exit code and what
+// it printed.
+func doctor(t *testing.T, dir string) (int, string) {
+	t.Helper()
+	out, err := os.CreateTemp(t.TempDir(), "output")
+	if err != nil {
+		t.Fatalf("CreateTemp() error: %v", err)
+	}
+	defer out.Close()
+
+	stdout, stderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = out, out
+	code := runDoctor([]string{"-spot", "0", dir})
+	os.Stdout, os.Stderr = stdout, stderr
+
+	output, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	return code, string(output)
+}
+
+func TestDoctor(t *testing.T) {
+	dir := t.TempDir()
+	db, err := internal.NewEngine(dir)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	for i := range 20 {
+		db.Set(fmt.Sprintf("key%02d", i), []byte("value"))
+	}
+	// a checkpoint flushes the memtable, the keys are then only in tables
+	if err := db.Checkpoint(filepath.Join(t.TempDir(), "checkpoint")); err != nil {
+		t.Fatalf("Checkpoint() error: %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	if code, output := doctor(t, dir); code != 0 {
+		t.Errorf("doctor = %d, %q on an intact database, want 0", code, output)
+	}
+
+	// the data file loses the values of the last keys
+	data := filepath.Join(dir, internal.DataFileName)
+	info, err := os.Stat(data)
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if err := os.Truncate(data, info.Size()/2); err != nil {
+		t.Fatalf("Truncate() error: %v", err)
+	}
+	if code, output := doctor(t, dir); code != 1 || !strings.Contains(output, "inconsistent") {
+		t.Errorf("doctor = %d, %q with a truncated data file, want 1 reporting the inconsistency", code, output)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hasssanezzz/goldb/internal"
+)
+
+// runInspect opens a checkpoint read-only to verify and query it in place,
+// usage: goldb inspect [-get KEY] [-scan PREFIX] [-limit N] <checkpoint-dir>
+func runInspect(args []string) int {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	get := flags.String("get", "", "Print the value of a key")
+	scan := flags.String("scan", "", "List the keys matching a prefix or pattern, * for all")
+	limit := flags.Int("limit", 100, "Maximum number of keys listed by -scan")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: goldb inspect [-get KEY] [-scan PREFIX] [-limit N] <checkpoint-dir>")
+		return 2
+	}
+	dir := flags.Arg(0)
+
+	manifest, err := internal.ReadCheckpointManifest(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "inspect: %v\n", err)
+		return 1
+	}
+
+	db, err := internal.OpenCheckpoint(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "inspect: can not open %q: %v\n", dir, err)
+		return 1
+	}
+	defer db.Close()
+
+	if len(*get) > 0 {
+		value, err := db.Get(*get)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "inspect: %v\n", err)
+			return 1
+		}
+		os.Stdout.Write(value)
+		fmt.Println()
+		return 0
+	}
+
+	if len(*scan) > 0 {
+		pattern := *scan
+		if pattern == "*" {
+			pattern = ""
+		}
+
+		listed := 0
+		err := db.ScanKeysFunc(pattern, func(key string) (bool, error) {
+			fmt.Println(key)
+			listed++
+			return listed >= *limit, nil
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "inspect: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	keys := 0
+	if err := db.ScanKeysFunc("", func(string) (bool, error) { keys++; return false, nil }); err != nil {
+		fmt.Fprintf(os.Stderr, "inspect: can not read the keys of %q: %v\n", dir, err)
+		return 1
+	}
+
+	fmt.Printf("checkpoint: %s\n", dir)
+	fmt.Printf("created at: %s\n", manifest.CreatedAt)
+	for _, file := range manifest.Files {
+		fmt.Printf("file:       %s (%d bytes)\n", file.Name, file.Size)
+	}
+	fmt.Printf("keys:       %d\n", keys)
+	return 0
+}
@@ -3,11 +3,14 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
@@ -16,19 +19,129 @@ import (
 	"github.com/hasssanezzz/goldb/shared"
 )
 
-func parseFlags() (string, string, bool) {
-	addr := flag.String("a", ":3011", "Host to bind the server to")
-	debug := flag.Bool("d", false, "Debug mode")
-	source := flag.String("s", ".goldb", "Path to the source directory")
+type options struct {
+	addr               string
+	source             string
+	debug              bool
+	checkpoints        string        // Directory holding checkpoints.
+	checkpointInterval time.Duration // How often a primary writes a checkpoint, zero disables it.
+	checkpointKeep     int           // Number of checkpoints a primary keeps.
+	replica            bool          // Serve the latest checkpoint read-only instead of the source.
+	refreshInterval    time.Duration // How often a replica looks for a newer checkpoint.
+	quotas             []shared.TenantQuota
+	strictKeys         bool               // Validate user keys, reserving the internal prefixes.
+	keyPolicy          shared.KeyPolicy   // Limits of the strict key validation.
+	rebuildFilters     bool               // Rebuild broken or outdated bloom filters when opening.
+	sidecars           bool               // Copy the filter and index of new tables to sidecar files.
+	mmap               bool               // Read tables through a memory mapping.
+	repairSources      []string           // Checkpoints read for intact copies of corrupt blocks.
+	colocatedValues    []string           // Key prefixes whose values are stored with their tables.
+	jsonIndexes        []shared.JSONIndex // Fields of JSON values indexed for /v1/index.
+	compression        shared.Compression // Codec of the blocks of new tables.
+	dictionarySize     uint               // Size of the zstd dictionary of new levels.
+	filterPolicy       shared.FilterPolicy
+	preallocate        uint64        // Bytes reserved ahead of the data file.
+	garbagePercent     uint          // Share of the data file held by dead values making a compaction due.
+	falsePositiveRate  float64       // Target false positive rate of new filters.
+	bitsPerKey         float64       // Bits per key of new filters, overriding the rate.
+	hotKeys            uint          // Most read keys reported by /admin/hotkeys.
+	writeAmpSeparator  string        // Separator of the keyspaces reported by /admin/writeamp.
+	primeBlocks        uint          // Most read blocks logged on shutdown and read back on start.
+	scanNiceness       time.Duration // Longest a scan yields to point lookups and writes before each block.
+	alarms             alarmConfig
+	alarmInterval      time.Duration // How often the alarm limits are checked.
+}
+
+func parseFlags() options {
+	opts := options{}
+	flag.StringVar(&opts.addr, "a", ":3011", "Host to bind the server to")
+	flag.BoolVar(&opts.debug, "d", false, "Debug mode")
+	flag.StringVar(&opts.source, "s", ".goldb", "Path to the source directory")
+	flag.StringVar(&opts.checkpoints, "checkpoints", "", "Path to the checkpoints directory")
+	flag.DurationVar(&opts.checkpointInterval, "checkpoint-every", 0, "Interval between checkpoints written to the checkpoints directory")
+	flag.IntVar(&opts.checkpointKeep, "checkpoint-keep", 3, "Number of checkpoints to keep")
+	flag.BoolVar(&opts.replica, "replica", false, "Serve the latest checkpoint of the checkpoints directory read-only")
+	flag.DurationVar(&opts.refreshInterval, "refresh-every", 10*time.Second, "Interval between replica checks for a newer checkpoint")
+	flag.Func("quota", "Tenant quota as prefix=maxKeys,maxBytes, zero means unlimited, repeatable", func(value string) error {
+		quota, err := parseQuota(value)
+		opts.quotas = append(opts.quotas, quota)
+		return err
+	})
+	flag.BoolVar(&opts.strictKeys, "strict-keys", false, "Validate user keys and reserve the internal key prefixes")
+	flag.Func("key-max-length", "Longest user key in bytes under -strict-keys", func(value string) error {
+		n, err := strconv.ParseUint(value, 10, 32)
+		opts.keyPolicy.MaxLength = uint32(n)
+		return err
+	})
+	flag.StringVar(&opts.keyPolicy.AllowedChars, "key-chars", "", "Characters user keys can be made of under -strict-keys, empty allows any")
+	flag.Func("reserve-prefix", "Key prefix users can not write under -strict-keys, repeatable", func(value string) error {
+		opts.keyPolicy.ReservedPrefixes = append(opts.keyPolicy.ReservedPrefixes, value)
+		return nil
+	})
+	flag.BoolVar(&opts.rebuildFilters, "rebuild-filters", false, "Rebuild broken or outdated bloom filters into sidecar files when opening")
+	flag.BoolVar(&opts.sidecars, "sidecars", false, "Copy the bloom filter and block index of new tables to sidecar files")
+	flag.BoolVar(&opts.mmap, "mmap", false, "Read SSTables through a memory mapping of their files instead of read calls")
+	flag.Func("repair-from", "Checkpoint, or directory of checkpoints, read for an intact copy of a block failing its checksum, repeatable", func(value string) error {
+		opts.repairSources = append(opts.repairSources, value)
+		return nil
+	})
+	flag.Func("colocate-values", "Key prefix whose values move into a value file per table when flushed, removed with the table by compactions, \"\" selects every key, repeatable", func(value string) error {
+		opts.colocatedValues = append(opts.colocatedValues, value)
+		return nil
+	})
+	flag.Func("json-index", "Index a field of the JSON values under a key prefix as name:path:prefix, such as email:$.email:user:, queried with /v1/index, repeatable", func(value string) error {
+		fields := strings.SplitN(value, ":", 3)
+		if len(fields) != 3 {
+			return fmt.Errorf("json index %q must be name:path:prefix", value)
+		}
+		opts.jsonIndexes = append(opts.jsonIndexes, shared.JSONIndex{Name: fields[0], Path: fields[1], Prefix: fields[2]})
+		return nil
+	})
+	flag.Func("block-compression", "Codec compressing the blocks of new tables: none, snappy, lz4 or zstd", func(value string) (err error) {
+		opts.compression, err = shared.ParseCompression(value)
+		return err
+	})
+	flag.Func("filter-policy", "Filter built into new tables: bloom, cuckoo or ribbon", func(value string) (err error) {
+		opts.filterPolicy, err = shared.ParseFilterPolicy(value)
+		return err
+	})
+	flag.Float64Var(&opts.falsePositiveRate, "false-positive-rate", shared.DefaultConfig.FalsePositiveRate, "Share of absent keys the filters of new tables let through")
+	flag.Float64Var(&opts.bitsPerKey, "bits-per-key", 0, "Bits per key of the filters of new tables, sizing them in place of -false-positive-rate, 0 disables it")
+	flag.UintVar(&opts.hotKeys, "hot-keys", 0, "Most read keys reported by /admin/hotkeys, found from a sample of the reads, 0 disables it")
+	flag.StringVar(&opts.writeAmpSeparator, "write-amp-separator", "", "Keys are grouped into keyspaces up to this separator to report their write amplification at /admin/writeamp, empty disables it")
+	flag.UintVar(&opts.primeBlocks, "prime-blocks", 0, "Most read table blocks logged on shutdown and read back on start to warm the page cache, 0 disables it")
+	flag.DurationVar(&opts.scanNiceness, "scan-niceness", 0, "Longest a scan waits for the point lookups and writes in flight before each table block it reads, 0 scans at full speed")
+	flag.Uint64Var(&opts.preallocate, "preallocate", 0, "Bytes of disk reserved ahead of the end of the data file in the background, 0 disables it")
+	flag.UintVar(&opts.garbagePercent, "data-garbage-percent", 0, "Percent of the data file held by overwritten and deleted values at which a compaction is due, 0 disables counting them")
+	flag.UintVar(&opts.dictionarySize, "block-dictionary-size", 0, "Size of the dictionary trained for every new level compressed with zstd, 0 disables it")
+	flag.StringVar(&opts.alarms.webhook, "alarm-webhook", "", "URL alarm events are POSTed to as JSON when a limit is crossed and when it is cleared")
+	flag.StringVar(&opts.alarms.eventKey, "alarm-key-prefix", "", "Prefix of the keys the latest event of every alarm is written to")
+	flag.Int64Var(&opts.alarms.diskUsage, "alarm-disk-usage", 0, "Bytes of the source directory raising an alarm, 0 disables it")
+	flag.Int64Var(&opts.alarms.compactionDebt, "alarm-compaction-debt", 0, "Bytes of SSTables waiting to be compacted raising an alarm, 0 disables it")
+	flag.Int64Var(&opts.alarms.walSize, "alarm-wal-size", 0, "Bytes of the WAL raising an alarm, 0 disables it")
+	flag.BoolVar(&opts.alarms.readOnly, "alarm-read-only", false, "Raise an alarm while a primary engine only accepts reads")
+	flag.BoolVar(&opts.alarms.dataGarbage, "alarm-data-garbage", false, "Raise an alarm while the garbage of the data file is over -data-garbage-percent")
+	flag.DurationVar(&opts.alarmInterval, "alarm-every", 30*time.Second, "Interval between checks of the alarm limits")
 	flag.Parse()
 
-	return *addr, *source, *debug
+	return opts
 }
 
 func main() {
-	addr, source, debug := parseFlags()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "doctor":
+			os.Exit(runDoctor(os.Args[2:]))
+		case "inspect":
+			os.Exit(runInspect(os.Args[2:]))
+		case "restore":
+			os.Exit(runRestore(os.Args[2:]))
+		}
+	}
+
+	opts := parseFlags()
 
-	if debug {
+	if opts.debug {
 		println("[DEBUG MODE]")
 		go func() {
 			http.ListenAndServe("localhost:6060", nil)
@@ -37,35 +150,50 @@ func main() {
 
 	config := *shared.DefaultConfig.
 		WithMemtableSizeThreshold(500).
-		WithDebug(debug)
-
-	db, err := internal.NewEngine(source, config) // for debugging
-	if err != nil {
-		panic(err)
+		WithDebug(opts.debug)
+	config.TenantQuotas = opts.quotas
+	config.RebuildFilters = opts.rebuildFilters
+	config.SidecarFiles = opts.sidecars
+	config.MmapTables = opts.mmap
+	config.RepairSources = opts.repairSources
+	// a primary repairs its tables from its own checkpoints
+	if opts.checkpoints != "" && !opts.replica {
+		config.RepairSources = append(config.RepairSources, opts.checkpoints)
+	}
+	config.ColocatedValuePrefixes = opts.colocatedValues
+	config.JSONIndexes = opts.jsonIndexes
+	config.BlockCompression = opts.compression
+	config.FilterPolicy = opts.filterPolicy
+	config.FalsePositiveRate = opts.falsePositiveRate
+	config.BitsPerKey = opts.bitsPerKey
+	config.HotKeys = uint32(opts.hotKeys)
+	config.WriteAmpSeparator = opts.writeAmpSeparator
+	config.PrimeBlocks = uint32(opts.primeBlocks)
+	config.ScanNiceness = opts.scanNiceness
+	config.DataPreallocateSize = opts.preallocate
+	config.DataGarbagePercent = uint32(opts.garbagePercent)
+	config.BlockDictionarySize = uint32(opts.dictionarySize)
+	if opts.strictKeys {
+		config.WithKeyPolicy(opts.keyPolicy)
 	}
 
-	api, err := api.New(source, db)
+	api, err := api.New(opts.source, nil)
 	if err != nil {
 		log.Fatalf("can not open db: %v", err)
 	}
 
-	defer func() {
-		if err := db.Close(); err != nil {
-			panic(err)
-		}
-	}()
-
 	mux := http.NewServeMux()
 	api.SetupRoutes(mux)
 
 	server := &http.Server{
-		Addr:    addr,
+		Addr:    opts.addr,
 		Handler: mux,
 	}
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	// serve while the engine recovers so its state can be polled
 	go func() {
 		log.Println("server is listening on", server.Addr)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -73,6 +201,50 @@ func main() {
 		}
 	}()
 
+	var (
+		db      *internal.Engine
+		replica *replicaRefresher
+	)
+
+	if opts.replica {
+		if len(opts.checkpoints) == 0 {
+			log.Fatalf("replica mode requires a checkpoints directory")
+		}
+		replica = newReplicaRefresher(opts.checkpoints, config)
+		db, err = replica.open()
+	} else {
+		db, err = internal.NewEngine(opts.source, config) // for debugging
+	}
+	if err != nil {
+		panic(err)
+	}
+	api.SwapDB(db)
+
+	defer func() {
+		// the server is shut down, no request uses the engine anymore
+		db, _ := api.SwapDB(nil)
+		if err := db.Close(); err != nil {
+			panic(err)
+		}
+	}()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	if replica != nil {
+		go replica.run(api, opts.refreshInterval, done)
+	} else if len(opts.checkpoints) > 0 && opts.checkpointInterval > 0 {
+		go runCheckpointer(db, opts.checkpoints, opts.checkpointInterval, opts.checkpointKeep, done)
+	}
+
+	if opts.alarms.enabled() {
+		// a replica is read-only by design
+		opts.alarms.readOnly = opts.alarms.readOnly && replica == nil
+		go runAlarms(api, opts.alarms, opts.alarmInterval, done)
+	}
+
+	go handleDebugSignal(api, done)
+
 	<-stop
 	log.Println("shutting down server...")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -82,3 +254,22 @@ func main() {
 	}
 	log.Println("server gracefully stopped.")
 }
+
+// parseQuota parses a tenant quota flag, e.g. "app1:=10000,1048576".
+func parseQuota(value string) (shared.TenantQuota, error) {
+	prefix, limits, ok := strings.Cut(value, "=")
+	maxKeys, maxBytes, ok2 := strings.Cut(limits, ",")
+	if !ok || !ok2 {
+		return shared.TenantQuota{}, fmt.Errorf("quota %q is not in the prefix=maxKeys,maxBytes form", value)
+	}
+
+	quota := shared.TenantQuota{Prefix: prefix}
+	var err error
+	if quota.MaxKeys, err = strconv.ParseUint(maxKeys, 10, 64); err != nil {
+		return quota, fmt.Errorf("quota %q has an invalid key limit: %v", value, err)
+	}
+	if quota.MaxBytes, err = strconv.ParseUint(maxBytes, 10, 64); err != nil {
+		return quota, fmt.Errorf("quota %q has an invalid byte limit: %v", value, err)
+	}
+	return quota, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hasssanezzz/goldb/cmd/api"
+	"github.com/hasssanezzz/goldb/internal"
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// replicaRefresher serves the latest checkpoint of a directory read-only
+// and swaps in newer checkpoints as they appear.
+type replicaRefresher struct {
+	root    string
+	config  shared.EngineConfig
+	current string
+}
+
+func newReplicaRefresher(root string, config shared.EngineConfig) *replicaRefresher {
+	config.ReadOnly = true
+	return &replicaRefresher{root: root, config: config}
+}
+
+// open opens the latest checkpoint, it returns a nil engine if the
+// latest checkpoint is already the one being served.
+func (r *replicaRefresher) open() (*internal.Engine, error) {
+	path, _, err := internal.LatestCheckpoint(r.root)
+	if err != nil {
+		return nil, err
+	}
+
+	if path == r.current {
+		return nil, nil
+	}
+
+	db, err := internal.NewEngine(path, r.config)
+	if err != nil {
+		return nil, fmt.Errorf("replica can not open checkpoint %q: %v", path, err)
+	}
+
+	r.current = path
+	log.Printf("replica: serving checkpoint %q", path)
+	return db, nil
+}
+
+func (r *replicaRefresher) run(api *api.API, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		db, err := r.open()
+		if err != nil {
+			log.Printf("replica: refresh failed: %v", err)
+			continue
+		}
+		if db == nil {
+			continue
+		}
+
+		// requests that picked up the previous checkpoint finish on it
+		old, wait := api.SwapDB(db)
+		go func() {
+			wait()
+			if err := old.Close(); err != nil {
+				log.Printf("replica: can not close previous checkpoint: %v", err)
+			}
+		}()
+	}
+}
+
+// runCheckpointer periodically writes a checkpoint of db under root, keeping the newest ones.
+func runCheckpointer(db *internal.Engine, root string, interval time.Duration, keep int, done <-chan struct{}) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		log.Printf("checkpointer: can not create %q: %v", root, err)
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		dir := filepath.Join(root, strconv.FormatInt(time.Now().UnixNano(), 10))
+		if err := db.Checkpoint(dir); err != nil {
+			log.Printf("checkpointer: checkpoint failed: %v", err)
+			continue
+		}
+
+		if err := internal.PruneCheckpoints(root, keep); err != nil {
+			log.Printf("checkpointer: can not prune old checkpoints: %v", err)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/cmd/api"
+	"github.com/hasssanezzz/goldb/internal"
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestReplicaRefresh(t *testing.T) {
+	primary, err := internal.NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer primary.Close()
+
+	root := t.TempDir()
+	checkpoint := func(name, value string) {
+		t.Helper()
+		if err := primary.Set("key", []byte(value)); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+		if err := primary.Checkpoint(filepath.Join(root, name)); err != nil {
+			t.Fatalf("Checkpoint() error: %v", err)
+		}
+	}
+	checkpoint("1", "old")
+
+	replica := newReplicaRefresher(root, *shared.NewEngineConfig())
+	db, err := replica.open()
+	if err != nil || db == nil {
+		t.Fatalf("open() = %v, %v, want the first checkpoint", db, err)
+	}
+	if again, err := replica.open(); again != nil || err != nil {
+		t.Errorf("open() = %v, %v with no newer checkpoint, want nothing", again, err)
+	}
+	server, _ := api.New("", db)
+
+	// a request in flight keeps using the engine it picked up
+	first, release := server.Acquire()
+	checkpoint("2", "new")
+	defer func() {
+		db, wait := server.SwapDB(nil)
+		wait()
+		db.Close()
+	}()
+	done := make(chan struct{})
+	defer close(done)
+	go replica.run(server, time.Millisecond, done)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		current, release := server.Acquire()
+		value, err := current.Get("key")
+		release()
+		if err == nil && string(value) == "new" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replica still serves %q, %v after 5s, want the newer checkpoint", value, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if value, err := first.Get("key"); err != nil || string(value) != "old" {
+		t.Errorf("Get() = %q, %v on the replaced engine still in use, want \"old\"", value, err)
+	}
+
+	// it is closed once released
+	release()
+	for first.State() != internal.StateClosed {
+		if time.Now().After(deadline) {
+			t.Fatalf("replaced engine still open after it was released")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
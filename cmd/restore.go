@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hasssanezzz/goldb/internal"
+)
+
+// runRestore restores a checkpoint to a new database directory,
+// usage: goldb restore <checkpoint-dir> <target-dir>
+func runRestore(args []string) int {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 2 {
+		fmt.Fprintln(os.Stderr, "usage: goldb restore <checkpoint-dir> <target-dir>")
+		return 2
+	}
+	backup, target := flags.Arg(0), flags.Arg(1)
+
+	if err := internal.RestoreEngine(backup, target); err != nil {
+		fmt.Fprintf(os.Stderr, "restore: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("restore: %q restored to %q\n", backup, target)
+	return 0
+}
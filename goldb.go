@@ -0,0 +1,202 @@
+// Package goldb is the entry point for embedding the Goldb engine in Go applications.
+package goldb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hasssanezzz/goldb/internal"
+)
+
+type Engine = internal.Engine
+
+// Handle is a reference to an engine opened through OpenNamed. Each handle
+// must be closed once, the engine is closed when its last handle is.
+type Handle struct {
+	*internal.Engine
+	name   string
+	entry  *registryEntry
+	closed atomic.Bool
+}
+
+type registryEntry struct {
+	engine *internal.Engine
+	path   string
+	names  map[string]struct{}
+	refs   int
+}
+
+// registry tracks the engines opened in this process by path and by name.
+var registry = struct {
+	mu     sync.Mutex
+	byPath map[string]*registryEntry
+	byName map[string]*registryEntry
+}{
+	byPath: map[string]*registryEntry{},
+	byName: map[string]*registryEntry{},
+}
+
+// OpenNamed opens the database at path under the given name, or returns a new
+// handle to the engine already serving that path in this process, so the same
+// directory is never opened twice. The options only apply to the first open
+// of a path, see Open. Opening a name already bound to another path is an error.
+func OpenNamed(name, path string, opts *Options) (*Handle, error) {
+	absPath, err := canonicalPath(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	if entry, ok := registry.byName[name]; ok && entry.path != absPath {
+		return nil, fmt.Errorf("goldb: name %q is already bound to %q", name, entry.path)
+	}
+
+	entry, ok := registry.byPath[absPath]
+	if !ok {
+		engine, err := internal.NewEngine(path, config)
+		if err != nil {
+			return nil, err
+		}
+
+		entry = &registryEntry{engine: engine, path: absPath, names: map[string]struct{}{}}
+		registry.byPath[absPath] = entry
+	}
+
+	entry.refs++
+	entry.names[name] = struct{}{}
+	registry.byName[name] = entry
+
+	return &Handle{Engine: entry.engine, name: name, entry: entry}, nil
+}
+
+// OpenCheckpoint opens the checkpoint in dir read-only, see internal.OpenCheckpoint.
+func OpenCheckpoint(dir string, opts *Options) (*Engine, error) {
+	config, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+	return internal.OpenCheckpoint(dir, config)
+}
+
+// RestoreEngine restores the checkpoint in backupDir to the new database
+// directory targetDir, see internal.RestoreEngine.
+func RestoreEngine(backupDir, targetDir string) error {
+	return internal.RestoreEngine(backupDir, targetDir)
+}
+
+// CheckpointNamed checkpoints the engines opened under names, every one opened
+// through OpenNamed when none are given, into dir/<name> at a single logical
+// point, see internal.CheckpointGroup. The checkpoints of a multi-database
+// application are thus a consistent state of the whole system. An engine
+// opened under several of the names is checkpointed once, under the first.
+func CheckpointNamed(dir string, names ...string) error {
+	registry.mu.Lock()
+	if len(names) == 0 {
+		for name := range registry.byName {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	// the references keep the engines open until the checkpoint is written
+	var handles []*Handle
+	seen := map[*registryEntry]bool{}
+	for _, name := range names {
+		entry, ok := registry.byName[name]
+		if !ok {
+			registry.mu.Unlock()
+			closeHandles(handles)
+			return fmt.Errorf("goldb: no engine is opened under name %q", name)
+		}
+		if seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		entry.refs++
+		handles = append(handles, &Handle{Engine: entry.engine, name: name, entry: entry})
+	}
+	registry.mu.Unlock()
+	defer closeHandles(handles)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("goldb: can not create directory %q: %v", dir, err)
+	}
+	engines := make([]*internal.Engine, len(handles))
+	dirs := make([]string, len(handles))
+	for i, handle := range handles {
+		engines[i] = handle.Engine
+		dirs[i] = filepath.Join(dir, handle.name)
+	}
+	return internal.CheckpointGroup(engines, dirs)
+}
+
+func closeHandles(handles []*Handle) {
+	for _, handle := range handles {
+		handle.Close()
+	}
+}
+
+// Lookup returns a new handle to the engine opened under name, if any.
+func Lookup(name string) (*Handle, bool) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	entry, ok := registry.byName[name]
+	if !ok {
+		return nil, false
+	}
+
+	entry.refs++
+	return &Handle{Engine: entry.engine, name: name, entry: entry}, true
+}
+
+// Name returns the name the handle was opened under.
+func (h *Handle) Name() string {
+	return h.name
+}
+
+// Close releases the handle, closing the engine if this was its last handle.
+// Closing a handle more than once has no effect.
+func (h *Handle) Close() error {
+	if h.closed.Swap(true) {
+		return nil
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	h.entry.refs--
+	if h.entry.refs > 0 {
+		return nil
+	}
+
+	delete(registry.byPath, h.entry.path)
+	for name := range h.entry.names {
+		delete(registry.byName, name)
+	}
+
+	return h.entry.engine.Close()
+}
+
+// canonicalPath resolves path so different spellings of one directory compare equal.
+func canonicalPath(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("goldb: can not resolve path %q: %v", path, err)
+	}
+
+	if resolved, err := filepath.EvalSymlinks(absPath); err == nil {
+		return resolved, nil
+	}
+	return absPath, nil
+}
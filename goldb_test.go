@@ -0,0 +1,144 @@
+package goldb
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestOpenNamed(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := OpenNamed("main", dir, nil)
+	if err != nil {
+		t.Fatalf("OpenNamed() error: %v", err)
+	}
+
+	// the same directory spelled differently must share the engine
+	second, err := OpenNamed("alias", filepath.Join(dir, "."), nil)
+	if err != nil {
+		t.Fatalf("OpenNamed() error: %v", err)
+	}
+	if first.Engine != second.Engine {
+		t.Errorf("OpenNamed() of the same path returned different engines")
+	}
+
+	if _, err := OpenNamed("main", t.TempDir(), nil); err == nil {
+		t.Errorf("OpenNamed() with a name bound to another path should fail")
+	}
+
+	if err := first.Set("key", []byte("value")); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+
+	// closing twice must only release one reference
+	first.Close()
+	first.Close()
+
+	value, err := second.Get("key")
+	if err != nil || string(value) != "value" {
+		t.Errorf("Get() after closing another handle = %q, %v, want %q", value, err, "value")
+	}
+
+	second.Close()
+	if _, ok := Lookup("main"); ok {
+		t.Errorf("Lookup() found an engine after its last handle was closed")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	e, err := Open(t.TempDir(), &Options{SmallTableMergeSize: Value[uint32](0), RowCacheSize: Value[uint64](1 << 20)})
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer e.Close()
+
+	// a zero value is applied, unset options keep their defaults
+	if e.Config.SmallTableMergeSize != 0 || e.Config.RowCacheSize != 1<<20 {
+		t.Errorf("Open() configured SmallTableMergeSize %d and RowCacheSize %d, want 0 and %d", e.Config.SmallTableMergeSize, e.Config.RowCacheSize, 1<<20)
+	}
+	if e.Config.CompactionThreshold != shared.DefaultConfig.CompactionThreshold {
+		t.Errorf("Open() configured CompactionThreshold %d, want the default %d", e.Config.CompactionThreshold, shared.DefaultConfig.CompactionThreshold)
+	}
+
+	tests := []struct {
+		opts  Options
+		field string
+	}{
+		{Options{ReadOnly: Value(true), DisableWAL: Value(true)}, "DisableWAL"},
+		{Options{DisableWAL: Value(true), WALSync: Value(shared.SyncAlways)}, "WALSync"},
+		{Options{CacheTTL: Value(time.Minute)}, "CacheTTL"},
+		{Options{MemtableSize: Value[uint32](0)}, "MemtableSize"},
+		{Options{BlockSize: Value[uint32](1)}, "BlockSizeBytes"},
+	}
+	for _, tt := range tests {
+		var invalid *shared.ErrInvalidConfig
+		if _, err := Open(t.TempDir(), &tt.opts); !errors.As(err, &invalid) || invalid.Field != tt.field {
+			t.Errorf("Open(%+v) error = %v, want an ErrInvalidConfig of %s", tt.opts, err, tt.field)
+		}
+	}
+}
+
+func TestCheckpointNamed(t *testing.T) {
+	orders, err := OpenNamed("orders", t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("OpenNamed() error: %v", err)
+	}
+	defer orders.Close()
+	ledger, err := OpenNamed("ledger", t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("OpenNamed() error: %v", err)
+	}
+	defer ledger.Close()
+
+	// every order is written before its ledger entry
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			key := fmt.Sprintf("%06d", i)
+			orders.Set(key, []byte("order"))
+			ledger.Set(key, []byte("entry"))
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	dir := t.TempDir()
+	err = CheckpointNamed(dir)
+	close(done)
+	<-stopped
+	if err != nil {
+		t.Fatalf("CheckpointNamed() error: %v", err)
+	}
+
+	counts := map[string]int{}
+	for _, name := range []string{"orders", "ledger"} {
+		checkpoint, err := OpenCheckpoint(filepath.Join(dir, name), nil)
+		if err != nil {
+			t.Fatalf("OpenCheckpoint(%s) error: %v", name, err)
+		}
+		keys, err := checkpoint.Scan("")
+		if err != nil {
+			t.Fatalf("Scan() of %s error: %v", name, err)
+		}
+		counts[name] = len(keys)
+		checkpoint.Close()
+	}
+	if diff := counts["orders"] - counts["ledger"]; counts["orders"] == 0 || diff != 0 && diff != 1 {
+		t.Errorf("checkpoints hold %d orders and %d ledger entries, want at most the last order without its entry", counts["orders"], counts["ledger"])
+	}
+
+	if err := CheckpointNamed(t.TempDir(), "missing"); err == nil {
+		t.Errorf("CheckpointNamed() of an unknown name should fail")
+	}
+}
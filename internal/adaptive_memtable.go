@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// adaptiveFillTime is how long the memtable should take to fill at the
+// recent write rate, fewer and larger flushes absorb write bursts.
+const adaptiveFillTime = time.Second
+
+// rateSmoothing is the weight of the last flush in the smoothed write rate.
+const rateSmoothing = 0.3
+
+// memtableSizer adapts the memtable flush threshold after every flush: it
+// grows with the write rate and as SSTables pile up waiting for compaction,
+// so flushes slow down when compaction is behind, and shrinks back to the
+// lower bound when writes calm down. A nil memtableSizer keeps the configured
+// threshold.
+type memtableSizer struct {
+	min, max  uint32
+	threshold atomic.Uint32
+
+	mu        sync.Mutex
+	lastFlush time.Time
+	rate      float64 // Smoothed pairs written per second.
+}
+
+func newMemtableSizer(min, max, initial uint32) *memtableSizer {
+	s := &memtableSizer{min: min, max: max, lastFlush: time.Now()}
+	s.threshold.Store(clamp(initial, min, max))
+	return s
+}
+
+// current returns the flush threshold, fallback for a nil sizer.
+func (s *memtableSizer) current(fallback uint32) uint32 {
+	if s == nil {
+		return fallback
+	}
+	return s.threshold.Load()
+}
+
+// flushed adapts the threshold once a memtable of entries pairs was flushed,
+// backlog is the SSTables count over the compaction threshold.
+func (s *memtableSizer) flushed(entries uint32, backlog float64) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if elapsed := now.Sub(s.lastFlush).Seconds(); elapsed > 0 {
+		rate := float64(entries) / elapsed
+		if s.rate == 0 {
+			s.rate = rate
+		} else {
+			s.rate = s.rate*(1-rateSmoothing) + rate*rateSmoothing
+		}
+	}
+	s.lastFlush = now
+
+	byRate := s.rate * adaptiveFillTime.Seconds()
+	byBacklog := float64(s.min) + float64(s.max-s.min)*min(max(backlog, 0), 1)
+	target := min(max(byRate, byBacklog), float64(s.max))
+	s.threshold.Store(clamp(uint32(target), s.min, s.max))
+}
+
+func clamp(value, low, high uint32) uint32 {
+	return min(max(value, low), high)
+}
+
+// flushThreshold returns the memtable size triggering a flush.
+func (e *Engine) flushThreshold() uint32 {
+	return e.sizer.current(e.Config.MemtableSizeThreshold)
+}
+
+// compactionBacklog returns the SSTables count over the compaction threshold.
+func (im *IndexManager) compactionBacklog() float64 {
+	if im.config.CompactionThreshold == 0 {
+		return 0
+	}
+
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	return float64(len(im.sstables)) / float64(im.config.CompactionThreshold)
+}
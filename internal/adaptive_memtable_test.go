@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMemtableSizer(t *testing.T) {
+	var disabled *memtableSizer
+	if got := disabled.current(100); got != 100 {
+		t.Errorf("nil sizer current() = %d, want the fallback 100", got)
+	}
+
+	sizer := newMemtableSizer(100, 1000, 5000)
+	if got := sizer.current(0); got != 1000 {
+		t.Errorf("initial threshold = %d, want it clamped to 1000", got)
+	}
+
+	// idle writes and no backlog bring the threshold down to the lower bound
+	sizer.lastFlush = time.Now().Add(-time.Hour)
+	sizer.flushed(100, 0)
+	if got := sizer.current(0); got != 100 {
+		t.Errorf("idle threshold = %d, want 100", got)
+	}
+
+	// a compaction backlog grows the memtable even at a low write rate
+	sizer.lastFlush = time.Now().Add(-time.Hour)
+	sizer.flushed(100, 0.5)
+	if got := sizer.current(0); got != 550 {
+		t.Errorf("threshold with half a backlog = %d, want 550", got)
+	}
+
+	// a burst of writes grows it up to the upper bound
+	for i := 0; i < 20; i++ {
+		sizer.lastFlush = time.Now().Add(-100 * time.Millisecond)
+		sizer.flushed(1000, 0)
+	}
+	if got := sizer.current(0); got != 1000 {
+		t.Errorf("threshold under a write burst = %d, want 1000", got)
+	}
+}
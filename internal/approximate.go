@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ApproximateStats estimates the footprint of the keys under a prefix from
+// table metadata and block indexes, without reading any pair. Keys overwritten
+// or deleted but not compacted away yet are counted by every table holding them.
+type ApproximateStats struct {
+	Keys      uint64             `json:"keys"`       // Estimated keys, the memtable's are counted exactly.
+	DataBytes uint64             `json:"data_bytes"` // Estimated bytes of their values in the data file.
+	DiskBytes uint64             `json:"disk_bytes"` // Estimated bytes of the tables holding them.
+	Levels    []ApproximateLevel `json:"levels"`     // Level 0 holds the flushed SSTables, the following levels the compacted ones.
+}
+
+// ApproximateLevel is the estimated share of a level holding the prefix.
+type ApproximateLevel struct {
+	Level     int    `json:"level"`
+	Keys      uint64 `json:"keys"`
+	DiskBytes uint64 `json:"disk_bytes"`
+}
+
+// ApproximateStats estimates the keys starting with prefix and the bytes they
+// take, an empty prefix covers every key. A table holds the prefix in the
+// proportion of its blocks whose key range overlaps it, and values are assumed
+// to have the average size of the data file.
+func (e *Engine) ApproximateStats(prefix string) ApproximateStats {
+	im := e.indexManager
+	stats := ApproximateStats{}
+
+	total := uint64(0)
+	for _, pair := range im.memtable.Items() {
+		total++
+		if pair.Value.Size > 0 && strings.HasPrefix(pair.Key, prefix) {
+			stats.Keys++
+		}
+	}
+
+	im.mu.RLock()
+	levels := im.levelGroups()
+
+	end := prefixEnd(prefix)
+	for i, tables := range levels {
+		level := ApproximateLevel{Level: i}
+		for _, table := range tables {
+			total += uint64(table.metadata.Size)
+			share := table.prefixShare(prefix, end)
+			level.Keys += uint64(share * float64(table.metadata.Size))
+			level.DiskBytes += uint64(share * float64(tablesSize([]*SSTable{table})))
+		}
+		stats.Keys += level.Keys
+		stats.DiskBytes += level.DiskBytes
+		stats.Levels = append(stats.Levels, level)
+	}
+	im.mu.RUnlock()
+
+	if info, err := os.Stat(filepath.Join(e.Config.Homepath, DataFileName)); err == nil && total > 0 {
+		stats.DataBytes = uint64(float64(info.Size()) / float64(total) * float64(stats.Keys))
+	}
+	return stats
+}
+
+// prefixShare estimates the share of the table's pairs in [prefix, end), an
+// empty end means no upper bound. Tables without a block index only tell
+// whether their whole key range is in, out, or across the bounds, the latter
+// counting as half of the table.
+func (s *SSTable) prefixShare(prefix, end string) float64 {
+	if s.metadata.MaxKey < prefix || (end != "" && s.metadata.MinKey >= end) {
+		return 0
+	}
+	if s.metadata.MinKey >= prefix && (end == "" || s.metadata.MaxKey < end) {
+		return 1
+	}
+	if len(s.index) == 0 {
+		return 0.5
+	}
+
+	overlapping := 0
+	for i, handle := range s.index {
+		last := s.metadata.MaxKey
+		if i+1 < len(s.index) {
+			last = s.index[i+1].firstKey
+		}
+		if last >= prefix && (end == "" || handle.firstKey < end) {
+			overlapping++
+		}
+	}
+	return float64(overlapping) / float64(len(s.index))
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestApproximateStats(t *testing.T) {
+	e, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithMemtableSizeThreshold(1000).WithSmallTableMergeSize(0).WithBlockSize(1024))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	// one table only holding a:, one holding both prefixes across its small blocks
+	for i := range 200 {
+		e.Set(fmt.Sprintf("a:%04d", i), []byte("value"))
+	}
+	e.indexManager.Flush()
+	for i := range 200 {
+		e.Set(fmt.Sprintf("b:%04d", i), []byte("value"))
+		e.Set(fmt.Sprintf("c:%04d", i), []byte("value"))
+	}
+	e.indexManager.Flush()
+	e.Set("b:memtable", []byte("value"))
+
+	all := e.ApproximateStats("")
+	if all.Keys != 601 || all.DiskBytes == 0 || all.DataBytes == 0 {
+		t.Errorf("ApproximateStats(\"\") = %+v, want 601 keys", all)
+	}
+
+	if a := e.ApproximateStats("a:"); a.Keys != 200 {
+		t.Errorf("ApproximateStats(a:).Keys = %d, want 200", a.Keys)
+	}
+
+	// the estimate of b: is off by at most the block it shares with c:
+	b := e.ApproximateStats("b:")
+	if b.Keys < 201 || b.Keys > 260 || b.DiskBytes >= all.DiskBytes/2 {
+		t.Errorf("ApproximateStats(b:) = %+v, want about 201 keys", b)
+	}
+}
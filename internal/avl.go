@@ -5,6 +5,7 @@ import "sync"
 type treeNode struct {
 	key    string
 	value  Position
+	seq    uint64
 	left   *treeNode
 	right  *treeNode
 	height int
@@ -24,11 +25,25 @@ func (t *AVLTree) Set(pair KVPair) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	t.set(pair)
+}
+
+// SetBatch inserts the pairs in order, readers see either none or all of them.
+func (t *AVLTree) SetBatch(pairs []KVPair) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, pair := range pairs {
+		t.set(pair)
+	}
+}
+
+func (t *AVLTree) set(pair KVPair) {
 	_, found := t.get(t.root, pair.Key)
 	if !found {
 		t.size++
 	}
-	t.root = t.insert(t.root, pair.Key, pair.Value)
+	t.root = t.insert(t.root, pair)
 }
 
 func (t *AVLTree) Get(key string) Position {
@@ -56,6 +71,8 @@ func (t *AVLTree) Items() []KVPair {
 }
 
 func (t *AVLTree) Size() uint32 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
 	return t.size
 }
 
@@ -140,24 +157,24 @@ func (t *AVLTree) balance(node *treeNode, key string) *treeNode {
 	return node
 }
 
-func (t *AVLTree) insert(node *treeNode, key string, value Position) *treeNode {
+func (t *AVLTree) insert(node *treeNode, pair KVPair) *treeNode {
 	// perform normal bst insertion
 	if node == nil {
-		return &treeNode{key: key, value: value, height: 1}
+		return &treeNode{key: pair.Key, value: pair.Value, seq: pair.Seq, height: 1}
 	}
 
-	if key < node.key {
-		node.left = t.insert(node.left, key, value)
-	} else if key > node.key {
-		node.right = t.insert(node.right, key, value)
+	if pair.Key < node.key {
+		node.left = t.insert(node.left, pair)
+	} else if pair.Key > node.key {
+		node.right = t.insert(node.right, pair)
 	} else {
-		node.value = value
+		node.value, node.seq = pair.Value, pair.Seq
 		return node
 	}
 
 	node.height = 1 + max(t.height(node.left), t.height(node.right))
 
-	return t.balance(node, key)
+	return t.balance(node, pair.Key)
 }
 
 func (t *AVLTree) get(node *treeNode, key string) (Position, bool) {
@@ -177,7 +194,7 @@ func (t *AVLTree) get(node *treeNode, key string) (Position, bool) {
 func (t *AVLTree) inOrder(node *treeNode, result *[]KVPair) {
 	if node != nil {
 		t.inOrder(node.left, result)
-		*result = append(*result, KVPair{node.key, node.value})
+		*result = append(*result, KVPair{node.key, node.value, node.seq})
 		t.inOrder(node.right, result)
 	}
 }
@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// Batch groups sets and deletes applied atomically by Commit: they are logged
+// as a single WAL record and applied to the memtable at once, so neither a
+// crash nor a concurrent reader sees part of the batch. Operations apply in
+// the order they were added. A batch is not safe for concurrent use.
+type Batch struct {
+	engine    *Engine
+	entries   []WALEntry
+	indexed   []WALEntry // Index entries of the sets, written after them, see jsonIndexes.
+	committed bool
+	internal  bool // Written by the engine, exempt from the key policy.
+}
+
+// WriteBatch returns an empty batch of writes to the engine.
+func (e *Engine) WriteBatch() *Batch {
+	return &Batch{engine: e}
+}
+
+// Set adds setting key to value to the batch.
+func (b *Batch) Set(key string, value []byte) {
+	b.entries = append(b.entries, WALEntry{Key: key, Value: value})
+	if !b.internal {
+		for _, entry := range b.engine.indexes.entries(key, value) {
+			b.indexed = append(b.indexed, WALEntry{Key: entry, Value: indexEntryValue})
+		}
+	}
+}
+
+// Delete adds deleting key to the batch.
+func (b *Batch) Delete(key string) {
+	b.entries = append(b.entries, WALEntry{Key: key})
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.entries) + len(b.indexed)
+}
+
+// Commit writes the batch, either every operation is applied or none is.
+// A batch can only be committed once.
+func (b *Batch) Commit() error {
+	if b.committed {
+		return fmt.Errorf("batch is already committed")
+	}
+
+	e := b.engine
+	if e.Config.ReadOnly {
+		return &shared.ErrReadOnly{Path: e.Config.Homepath}
+	}
+	for _, entry := range b.entries {
+		if len([]byte(entry.Key)) > int(e.Config.KeySize) {
+			return &shared.ErrKeyTooLong{Key: entry.Key, KeySize: e.Config.KeySize}
+		}
+		if !b.internal {
+			if err := e.Config.KeyPolicy.Check(entry.Key); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range b.indexed {
+		if len([]byte(entry.Key)) > int(e.Config.KeySize) {
+			return &shared.ErrKeyTooLong{Key: entry.Key, KeySize: e.Config.KeySize}
+		}
+	}
+	b.entries, b.indexed = append(b.entries, b.indexed...), nil
+	if len(b.entries) == 0 {
+		b.committed = true
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	end := e.io.foreground()
+	defer end()
+
+	if err := b.reserveQuotas(); err != nil {
+		return err
+	}
+
+	// the batch takes consecutive sequence numbers, in the order of its operations
+	first := e.reserveSeqs(len(b.entries))
+	for i := range b.entries {
+		b.entries[i].Seq = first + uint64(i)
+	}
+
+	if err := e.wal.AppendBatch(b.entries); err != nil {
+		b.unreserveQuotas(len(b.entries))
+		return err
+	}
+	b.committed = true
+
+	// values are unreachable until the memtable references them
+	pairs := make([]KVPair, 0, len(b.entries))
+	previous := b.previousValues()
+	for _, entry := range b.entries {
+		position := Position{}
+		if len(entry.Value) > 0 {
+			var err error
+			position, err = e.storeValue(entry.Key, entry.Value)
+			if err != nil {
+				return fmt.Errorf("engine failed to write (%q, %x), the batch is only in the WAL: %v", entry.Key, entry.Value, err)
+			}
+		}
+		pairs = append(pairs, KVPair{Key: entry.Key, Value: position, Seq: entry.Seq})
+		e.indexManager.writeAmp.ingested(entry.Key, len(entry.Key)+len(entry.Value))
+	}
+
+	e.indexManager.SetBatch(pairs)
+	// every write releases the value it replaced, including earlier ones of the batch
+	if previous != nil {
+		for _, pair := range pairs {
+			e.dedup.release(previous[pair.Key])
+			e.discard(pair.Key, previous[pair.Key])
+			previous[pair.Key] = pair.Value
+		}
+	}
+	changes := make([]Change, len(b.entries))
+	for i, entry := range b.entries {
+		e.rows.Invalidate(entry.Key)
+		if len(entry.Value) > 0 {
+			e.cache.written(entry.Key)
+		} else {
+			e.cache.forget(entry.Key)
+		}
+		changes[i] = Change{Seq: entry.Seq, Key: entry.Key, Value: entry.Value, Deleted: len(entry.Value) == 0}
+	}
+	e.feed.publish(changes...)
+
+	if e.indexManager.memtable.Size() >= e.flushThreshold() {
+		end()
+		return e.flush()
+	}
+	return nil
+}
+
+// previousValues returns the values held by the batch's keys before it
+// applies, they are only needed to release deduplicated values and count the
+// garbage of the data file.
+func (b *Batch) previousValues() map[string]Position {
+	if b.engine.dedup == nil && b.engine.space == nil {
+		return nil
+	}
+
+	previous := map[string]Position{}
+	for _, entry := range b.entries {
+		if _, ok := previous[entry.Key]; !ok {
+			previous[entry.Key], _ = b.engine.indexManager.Get(entry.Key)
+		}
+	}
+	return previous
+}
+
+// reserveQuotas reserves the tenants' usage of every operation, reverting
+// the reservations already made if one does not fit.
+func (b *Batch) reserveQuotas() error {
+	e := b.engine
+	for i, entry := range b.entries {
+		tenant := e.tenants.of(entry.Key)
+		if tenant == nil {
+			continue
+		}
+
+		old, _ := e.indexManager.Get(entry.Key)
+		if len(entry.Value) == 0 {
+			tenant.release(entry.Key, old)
+			continue
+		}
+		if err := tenant.reserve(entry.Key, old, len(entry.Value)); err != nil {
+			b.unreserveQuotas(i)
+			return err
+		}
+	}
+	return nil
+}
+
+// unreserveQuotas reverts the reservations of the first n operations.
+func (b *Batch) unreserveQuotas(n int) {
+	e := b.engine
+	for _, entry := range b.entries[:n] {
+		tenant := e.tenants.of(entry.Key)
+		if tenant == nil {
+			continue
+		}
+
+		old, _ := e.indexManager.Get(entry.Key)
+		if len(entry.Value) == 0 {
+			tenant.unrelease(entry.Key, old)
+			continue
+		}
+		tenant.unreserve(entry.Key, old, len(entry.Value))
+	}
+}
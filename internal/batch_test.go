@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestWriteBatch(t *testing.T) {
+	home := t.TempDir()
+	engine, err := NewEngine(home, *shared.NewEngineConfig().WithWALCompression(true))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	engine.Set("gone", []byte("value"))
+
+	batch := engine.WriteBatch()
+	batch.Set("a", []byte("1"))
+	batch.Set("b", []byte("2"))
+	batch.Set("a", []byte("3"))
+	batch.Delete("gone")
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+	if err := batch.Commit(); err == nil {
+		t.Errorf("second Commit() succeeded")
+	}
+
+	// a torn batch at the tail of the log is dropped as a whole
+	torn := engine.WriteBatch()
+	torn.Set("c", []byte("4"))
+	torn.Set("d", []byte("5"))
+	torn.Commit()
+	engine.Close()
+
+	wal := filepath.Join(home, WALFileName)
+	info, _ := os.Stat(wal)
+	os.Truncate(wal, info.Size()-3)
+
+	engine, err = NewEngine(home)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for key, want := range map[string]string{"a": "3", "b": "2"} {
+		if value, err := engine.Get(key); err != nil || string(value) != want {
+			t.Errorf("Get(%q) = %q, %v, want %q", key, value, err, want)
+		}
+	}
+	for _, key := range []string{"gone", "c", "d"} {
+		if _, err := engine.Get(key); err == nil {
+			t.Errorf("Get(%q) found a key the replay should not have", key)
+		}
+	}
+}
+
+func TestWriteBatchQuota(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithTenantQuota("t:", 2, 0))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	batch := engine.WriteBatch()
+	batch.Set("t:1", []byte("1"))
+	batch.Set("t:2", []byte("2"))
+	batch.Set("t:3", []byte("3"))
+
+	var exceeded *shared.ErrQuotaExceeded
+	if err := batch.Commit(); !errors.As(err, &exceeded) {
+		t.Fatalf("Commit() error = %v, want a quota error", err)
+	}
+	if _, err := engine.Get("t:1"); err == nil {
+		t.Errorf("Get(t:1) found a key of a rejected batch")
+	}
+	if keys := engine.TenantStats()[0].Keys; keys != 0 {
+		t.Errorf("tenant keys after a rejected batch = %d, want 0", keys)
+	}
+}
@@ -0,0 +1,390 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"sort"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// blockHandle locates a data block inside a table file.
+type blockHandle struct {
+	firstKey string
+	offset   uint32
+	size     uint32
+}
+
+// Bounds of encoded entries and blocks, lengths read from disk are checked
+// against them before allocating.
+const (
+	maxBlockEntrySize = 3*binary.MaxVarintLen64 + shared.KeySize + 8 + shared.UintSize
+
+	// a block is flushed once it reaches the block size, so the entry
+	// crossing it and its restart point may spill over
+	maxEncodedBlockSize = shared.MaxBlockSizeBytes + maxBlockEntrySize + shared.UintSize
+)
+
+// blockBuilder encodes sorted pairs into a data block. Every key is stored as
+// the length of the prefix it shares with the previous key and the remaining
+// suffix, every restartInterval keys the full key is stored instead and its
+// offset recorded as a restart point so the block can be binary searched:
+//
+//	entry:   <shared uvarint><unshared uvarint><suffix><position>[<seq uvarint>]
+//	trailer: <restart offsets uint32...><restart count uint32>
+//
+// Offsets take 64 bits and the sequence number is stored from the table
+// formats introducing them on, see entryLayout. Delta tables store the
+// position as <offset delta varint><size uvarint>, the delta being taken from
+// the offset of the previous entry, or from zero at a restart point: values
+// of a flush are appended in about the order of their keys, so neighbouring
+// offsets are close and the delta takes a byte or two.
+type blockBuilder struct {
+	restartInterval int
+	layout          entryLayout
+	buf             []byte
+	restarts        []uint32
+	count           int
+	lastKey         string
+	lastOffset      uint64
+}
+
+func newBlockBuilder(restartInterval int, format uint8) *blockBuilder {
+	return &blockBuilder{restartInterval: restartInterval, layout: layoutOf(format)}
+}
+
+// entryLayout tells how the block entries of a table format are encoded.
+type entryLayout struct {
+	wide      bool // Offsets take 64 bits instead of 32.
+	sequenced bool // Entries end with their sequence number.
+	delta     bool // Positions are varints, offsets relative to the previous entry.
+}
+
+func layoutOf(format uint8) entryLayout {
+	return entryLayout{
+		wide:      format >= tableFormatWide,
+		sequenced: format >= tableFormatSequenced,
+		delta:     format >= tableFormatDelta,
+	}
+}
+
+// positionSize is the size of a fixed width position, delta positions take
+// from two varint bytes up.
+func (l entryLayout) positionSize() int {
+	if l.delta {
+		return 2
+	}
+	if l.wide {
+		return 8 + shared.UintSize
+	}
+	return 2 * shared.UintSize
+}
+
+// minEntrySize is the size of the smallest entry, an empty suffix at best.
+func (l entryLayout) minEntrySize() int {
+	return 2 + l.positionSize()
+}
+
+func (b *blockBuilder) add(pair KVPair) {
+	prefix := 0
+	if b.count%b.restartInterval == 0 {
+		b.restarts = append(b.restarts, uint32(len(b.buf)))
+		b.lastOffset = 0
+	} else {
+		for prefix < len(b.lastKey) && prefix < len(pair.Key) && b.lastKey[prefix] == pair.Key[prefix] {
+			prefix++
+		}
+	}
+
+	b.buf = binary.AppendUvarint(b.buf, uint64(prefix))
+	b.buf = binary.AppendUvarint(b.buf, uint64(len(pair.Key)-prefix))
+	b.buf = append(b.buf, pair.Key[prefix:]...)
+	switch {
+	case b.layout.delta:
+		b.buf = binary.AppendVarint(b.buf, int64(pair.Value.Offset-b.lastOffset))
+		b.buf = binary.AppendUvarint(b.buf, uint64(pair.Value.Size))
+	case b.layout.wide:
+		b.buf = binary.LittleEndian.AppendUint64(b.buf, pair.Value.Offset)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, pair.Value.Size)
+	default:
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(pair.Value.Offset))
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, pair.Value.Size)
+	}
+	if b.layout.sequenced {
+		b.buf = binary.AppendUvarint(b.buf, pair.Seq)
+	}
+
+	b.lastKey, b.lastOffset = pair.Key, pair.Value.Offset
+	b.count++
+}
+
+// estimatedSize is the size of the block if it was finished now.
+func (b *blockBuilder) estimatedSize() int {
+	return len(b.buf) + (len(b.restarts)+1)*shared.UintSize
+}
+
+// finish appends the restart points and returns the encoded block,
+// the builder is reset for the next block.
+func (b *blockBuilder) finish() []byte {
+	for _, restart := range b.restarts {
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, restart)
+	}
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(b.restarts)))
+
+	block := b.buf
+	b.buf, b.restarts, b.count, b.lastKey, b.lastOffset = nil, nil, 0, "", 0
+	return block
+}
+
+// buildBlocks splits the sorted pairs into blocks of about blockSize bytes
+// and returns their encoding, appended to dst, and their handles, offsets
+// start at baseOffset. The entries are encoded for the given table format
+// and the blocks compressed with codec and dict, see compressBlock, then
+// followed by their checksum from the checksummed format on.
+func buildBlocks(dst []byte, pairs []KVPair, baseOffset uint32, blockSize, restartInterval int, format uint8, codec shared.Compression, dict []byte) ([]byte, []blockHandle) {
+	data := dst[:0]
+	index := []blockHandle{}
+	builder := newBlockBuilder(restartInterval, format)
+
+	firstKey := ""
+	flush := func() {
+		block := builder.finish()
+		if codec != shared.CompressionNone {
+			block = compressBlock(block, codec, dict)
+		}
+		if format >= tableFormatChecksummed {
+			block = binary.LittleEndian.AppendUint32(block, crc32.Checksum(block, crcTable))
+		}
+		index = append(index, blockHandle{
+			firstKey: firstKey,
+			offset:   baseOffset + uint32(len(data)),
+			size:     uint32(len(block)),
+		})
+		data = append(data, block...)
+	}
+
+	for _, pair := range pairs {
+		if builder.count == 0 {
+			firstKey = pair.Key
+		}
+		builder.add(pair)
+		if builder.estimatedSize() >= blockSize {
+			flush()
+		}
+	}
+	if builder.count > 0 {
+		flush()
+	}
+
+	return data, index
+}
+
+// checkBlock strips the checksum following a block stored by a table of the
+// given format, reporting whether the block matches it. Blocks of older
+// formats have no checksum and are returned as is.
+func checkBlock(stored []byte, format uint8) ([]byte, bool) {
+	if format < tableFormatChecksummed {
+		return stored, true
+	}
+	if len(stored) < shared.UintSize {
+		return nil, false
+	}
+	block, checksum := stored[:len(stored)-shared.UintSize], binary.LittleEndian.Uint32(stored[len(stored)-shared.UintSize:])
+	return block, crc32.Checksum(block, crcTable) == checksum
+}
+
+// decodeBlock returns every pair stored in a block of the given table format.
+func decodeBlock(block []byte, format uint8) ([]KVPair, error) {
+	layout := layoutOf(format)
+	entries, restarts, err := blockEntries(block)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs := []KVPair{}
+	previous := KVPair{}
+	for offset := 0; offset < entries; {
+		if isRestart(restarts, offset) {
+			previous = KVPair{}
+		}
+		pair, next, err := decodeBlockEntry(block, offset, previous, layout)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, pair)
+		previous, offset = pair, next
+	}
+
+	return pairs, nil
+}
+
+// searchBlock looks up key in the block, binary searching its restart
+// points and scanning forward from the closest one.
+func searchBlock(block []byte, key string, format uint8) (Position, bool, error) {
+	layout := layoutOf(format)
+	entries, restarts, err := blockEntries(block)
+	if err != nil {
+		return Position{}, false, err
+	}
+
+	// find the last restart point whose key is not greater than key
+	var searchErr error
+	i := sort.Search(len(restarts), func(i int) bool {
+		pair, _, err := decodeBlockEntry(block, int(restarts[i]), KVPair{}, layout)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return pair.Key > key
+	})
+	if searchErr != nil {
+		return Position{}, false, searchErr
+	}
+	if i == 0 {
+		return Position{}, false, nil
+	}
+
+	previous := KVPair{}
+	for offset := int(restarts[i-1]); offset < entries; {
+		if isRestart(restarts, offset) {
+			previous = KVPair{}
+		}
+		pair, next, err := decodeBlockEntry(block, offset, previous, layout)
+		if err != nil {
+			return Position{}, false, err
+		}
+		if pair.Key == key {
+			return pair.Value, true, nil
+		}
+		if pair.Key > key {
+			break
+		}
+		previous, offset = pair, next
+	}
+
+	return Position{}, false, nil
+}
+
+// isRestart reports whether the entry at offset is a restart point, whose
+// key and delta position do not depend on the previous entry.
+func isRestart(restarts []uint32, offset int) bool {
+	i := sort.Search(len(restarts), func(i int) bool { return int(restarts[i]) >= offset })
+	return i < len(restarts) && int(restarts[i]) == offset
+}
+
+// blockEntries parses the block trailer, returning where the entries
+// end and the offsets of the restart points.
+func blockEntries(block []byte) (int, []uint32, error) {
+	if len(block) < shared.UintSize {
+		return 0, nil, fmt.Errorf("block of %d bytes is too short", len(block))
+	}
+
+	count := int(binary.LittleEndian.Uint32(block[len(block)-shared.UintSize:]))
+	entries := len(block) - (count+1)*shared.UintSize
+	if count == 0 || entries < 0 {
+		return 0, nil, fmt.Errorf("block of %d bytes has an invalid restart count %d", len(block), count)
+	}
+
+	restarts := make([]uint32, count)
+	for i := range restarts {
+		restarts[i] = binary.LittleEndian.Uint32(block[entries+i*shared.UintSize:])
+		if int(restarts[i]) >= entries {
+			return 0, nil, fmt.Errorf("block restart point %d is out of bounds", restarts[i])
+		}
+	}
+
+	return entries, restarts, nil
+}
+
+// decodeBlockEntry decodes the entry at offset given the previous entry, an
+// empty one at restart points, it returns the offset of the next entry.
+func decodeBlockEntry(block []byte, offset int, previous KVPair, layout entryLayout) (KVPair, int, error) {
+	prefix, n := binary.Uvarint(block[offset:])
+	if n <= 0 {
+		return KVPair{}, 0, fmt.Errorf("block entry at %d has an invalid shared length", offset)
+	}
+	offset += n
+
+	unshared, n := binary.Uvarint(block[offset:])
+	if n <= 0 {
+		return KVPair{}, 0, fmt.Errorf("block entry at %d has an invalid key length", offset)
+	}
+	offset += n
+
+	if int(prefix) > len(previous.Key) || offset+int(unshared)+layout.positionSize() > len(block) {
+		return KVPair{}, 0, fmt.Errorf("block entry at %d is out of bounds", offset)
+	}
+
+	key := previous.Key[:prefix] + string(block[offset:offset+int(unshared)])
+	offset += int(unshared)
+
+	pair := KVPair{Key: key}
+	switch {
+	case layout.delta:
+		delta, n := binary.Varint(block[offset:])
+		if n <= 0 {
+			return KVPair{}, 0, fmt.Errorf("block entry at %d has an invalid offset", offset)
+		}
+		offset += n
+		size, n := binary.Uvarint(block[offset:])
+		if n <= 0 || size > math.MaxUint32 {
+			return KVPair{}, 0, fmt.Errorf("block entry at %d has an invalid size", offset)
+		}
+		offset += n
+		pair.Value = Position{Offset: previous.Value.Offset + uint64(delta), Size: uint32(size)}
+	case layout.wide:
+		pair.Value.Offset = binary.LittleEndian.Uint64(block[offset:])
+		pair.Value.Size = binary.LittleEndian.Uint32(block[offset+8:])
+		offset += layout.positionSize()
+	default:
+		pair.Value.Offset = uint64(binary.LittleEndian.Uint32(block[offset:]))
+		pair.Value.Size = binary.LittleEndian.Uint32(block[offset+shared.UintSize:])
+		offset += layout.positionSize()
+	}
+
+	if layout.sequenced {
+		seq, n := binary.Uvarint(block[offset:])
+		if n <= 0 {
+			return KVPair{}, 0, fmt.Errorf("block entry at %d has an invalid sequence number", offset)
+		}
+		pair.Seq = seq
+		offset += n
+	}
+
+	return pair, offset, nil
+}
+
+// encodeBlockIndex encodes the handles as <key length uvarint><key><offset uint32><size uint32>.
+func encodeBlockIndex(index []blockHandle) ([]byte, error) {
+	buf := []byte{}
+	for _, handle := range index {
+		var err error
+		if buf, err = shared.AppendKey(buf, handle.firstKey); err != nil {
+			return nil, err
+		}
+		buf = binary.LittleEndian.AppendUint32(buf, handle.offset)
+		buf = binary.LittleEndian.AppendUint32(buf, handle.size)
+	}
+	return buf, nil
+}
+
+func decodeBlockIndex(buf []byte) ([]blockHandle, error) {
+	index := []blockHandle{}
+	for offset := 0; offset < len(buf); {
+		firstKey, n, err := shared.ReadKey(buf[offset:])
+		if err != nil || offset+n+2*shared.UintSize > len(buf) {
+			return nil, fmt.Errorf("block index entry at %d is invalid", offset)
+		}
+		offset += n
+
+		handle := blockHandle{firstKey: firstKey}
+		handle.offset = binary.LittleEndian.Uint32(buf[offset:])
+		handle.size = binary.LittleEndian.Uint32(buf[offset+shared.UintSize:])
+		offset += 2 * shared.UintSize
+
+		index = append(index, handle)
+	}
+	return index, nil
+}
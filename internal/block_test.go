@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestBlocks(t *testing.T) {
+	pairs := []KVPair{}
+	for i := range 1000 {
+		pairs = append(pairs, KVPair{Key: fmt.Sprintf("user:%04d:profile", i), Value: Position{Offset: uint64(i) << 33, Size: uint32(i % 7)}, Seq: uint64(i) * 300})
+	}
+
+	data, index := buildBlocks(nil, pairs, 100, 512, 4, currentTableFormat, shared.CompressionNone, nil)
+	if len(index) < 2 {
+		t.Fatalf("buildBlocks() returned %d blocks, want several", len(index))
+	}
+
+	decoded := []KVPair{}
+	for _, handle := range index {
+		block, ok := checkBlock(data[handle.offset-100:handle.offset-100+handle.size], currentTableFormat)
+		if !ok {
+			t.Fatalf("block at %d does not match its checksum", handle.offset)
+		}
+		blockPairs, err := decodeBlock(block, currentTableFormat)
+		if err != nil {
+			t.Fatalf("decodeBlock() error: %v", err)
+		}
+		if blockPairs[0].Key != handle.firstKey {
+			t.Errorf("block first key = %q, index has %q", blockPairs[0].Key, handle.firstKey)
+		}
+		decoded = append(decoded, blockPairs...)
+
+		for _, pair := range blockPairs {
+			position, found, err := searchBlock(block, pair.Key, currentTableFormat)
+			if err != nil || !found || position != pair.Value {
+				t.Fatalf("searchBlock(%q) = %v, %v, %v, want %v", pair.Key, position, found, err, pair.Value)
+			}
+		}
+		if _, found, _ := searchBlock(block, handle.firstKey+"x", currentTableFormat); found {
+			t.Errorf("searchBlock(%q) found a missing key", handle.firstKey+"x")
+		}
+	}
+
+	if len(decoded) != len(pairs) {
+		t.Fatalf("decoded %d pairs, want %d", len(decoded), len(pairs))
+	}
+	for i := range pairs {
+		if decoded[i] != pairs[i] {
+			t.Fatalf("pair %d = %v, want %v", i, decoded[i], pairs[i])
+		}
+	}
+
+	encoded, err := encodeBlockIndex(index)
+	if err != nil {
+		t.Fatalf("encodeBlockIndex() error: %v", err)
+	}
+	handles, err := decodeBlockIndex(encoded)
+	if err != nil || len(handles) != len(index) || handles[1] != index[1] {
+		t.Errorf("block index did not round trip: %v", err)
+	}
+}
+
+func TestDeltaPositions(t *testing.T) {
+	// values of a flush are appended in about key order, with a few going backwards
+	pairs := []KVPair{}
+	offset := uint64(1) << 40
+	for i := range 500 {
+		pairs = append(pairs, KVPair{Key: fmt.Sprintf("key%04d", i), Value: Position{Offset: offset, Size: uint32(i % 100)}, Seq: uint64(i)})
+		offset += uint64(i % 100)
+		if i%10 == 0 {
+			offset -= 50
+		}
+	}
+	pairs[42].Value.Size = 0
+	pairs[43].Value.Size |= mergeOperandFlag
+
+	delta, index := buildBlocks(nil, pairs, 0, 4096, 16, tableFormatDelta, shared.CompressionNone, nil)
+	wide, _ := buildBlocks(nil, pairs, 0, 4096, 16, tableFormatWide, shared.CompressionNone, nil)
+	if len(delta) >= len(wide)*3/4 {
+		t.Errorf("delta blocks take %d bytes, wide ones %d", len(delta), len(wide))
+	}
+
+	decoded := []KVPair{}
+	for _, handle := range index {
+		block := delta[handle.offset : handle.offset+handle.size]
+		blockPairs, err := decodeBlock(block, tableFormatDelta)
+		if err != nil {
+			t.Fatalf("decodeBlock() error: %v", err)
+		}
+		decoded = append(decoded, blockPairs...)
+
+		for _, pair := range blockPairs {
+			if position, found, err := searchBlock(block, pair.Key, tableFormatDelta); err != nil || !found || position != pair.Value {
+				t.Fatalf("searchBlock(%q) = %v, %v, %v, want %v", pair.Key, position, found, err, pair.Value)
+			}
+		}
+	}
+	for i := range pairs {
+		if decoded[i] != pairs[i] {
+			t.Fatalf("pair %d = %v, want %v", i, decoded[i], pairs[i])
+		}
+	}
+}
+
+func TestFixedFormatTables(t *testing.T) {
+	config := shared.NewEngineConfig()
+	pairs := []KVPair{
+		{Key: "a", Value: Position{Offset: 0, Size: 1}},
+		{Key: "b", Value: Position{Offset: 1, Size: 0}},
+		{Key: "c", Value: Position{Offset: 1, Size: 2}},
+	}
+
+	// tables written before the blocks format must stay readable
+	metadata := TableMetadata{Path: filepath.Join(t.TempDir(), "sst_1"), IsLevel: true, Format: tableFormatFixed, Serial: 1, Size: 3, MinKey: "a", MaxKey: "c"}
+	table, err := serializeSSTable(metadata, config, NewFilterCache(0, false), nil, pairs)
+	if err != nil {
+		t.Fatalf("serializeSSTable() error: %v", err)
+	}
+	table.Close()
+
+	table, err = deserializeSSTable(TableMetadata{Path: metadata.Path}, config, NewFilterCache(0, false), nil)
+	if err != nil {
+		t.Fatalf("deserializeSSTable() error: %v", err)
+	}
+	defer table.Close()
+
+	if table.metadata.Format != tableFormatFixed || !table.metadata.IsLevel {
+		t.Fatalf("metadata = %+v, want a fixed format level", table.metadata)
+	}
+	if position, err := table.Search("c"); err != nil || position != pairs[2].Value {
+		t.Errorf("Search(c) = %v, %v, want %v", position, err, pairs[2].Value)
+	}
+	if keys, err := table.Keys(); err != nil || len(keys) != 2 {
+		t.Errorf("Keys() = %v, %v, want the 2 live keys", keys, err)
+	}
+}
+
+func TestFixedFormatFences(t *testing.T) {
+	config := shared.NewEngineConfig()
+	pairs := []KVPair{}
+	for i := range 3*fenceInterval + 5 {
+		pairs = append(pairs, KVPair{Key: fmt.Sprintf("key%04d", 2*i), Value: Position{Offset: uint64(i), Size: uint32(i % 3)}})
+	}
+
+	metadata := TableMetadata{Path: filepath.Join(t.TempDir(), "sst_1"), Format: tableFormatFixed, Serial: 1, Size: uint32(len(pairs)), MinKey: pairs[0].Key, MaxKey: pairs[len(pairs)-1].Key}
+	table, err := serializeSSTable(metadata, config, NewFilterCache(0, false), nil, pairs)
+	if err != nil {
+		t.Fatalf("serializeSSTable() error: %v", err)
+	}
+	written := table.fences
+	table.Close()
+
+	table, err = deserializeSSTable(TableMetadata{Path: metadata.Path}, config, NewFilterCache(0, false), nil)
+	if err != nil {
+		t.Fatalf("deserializeSSTable() error: %v", err)
+	}
+	defer table.Close()
+	if len(table.fences) != 4 || !slices.Equal(table.fences, written) {
+		t.Fatalf("fences = %q, written %q", table.fences, written)
+	}
+
+	for i, pair := range pairs {
+		position, probe, err := table.search(pair.Key, true)
+		if probe.Seeks != 1 {
+			t.Errorf("search(%q) took %d seeks, want 1", pair.Key, probe.Seeks)
+		}
+		if pair.Value.Size == 0 {
+			if _, ok := err.(*shared.ErrKeyRemoved); !ok {
+				t.Errorf("search(%q) error = %v, want ErrKeyRemoved", pair.Key, err)
+			}
+		} else if err != nil || position != pair.Value {
+			t.Errorf("search(%q) = %v, %v, want %v", pair.Key, position, err, pair.Value)
+		}
+
+		missing := fmt.Sprintf("key%04d", 2*i+1)
+		if _, _, err := table.search(missing, false); !errors.As(err, new(*shared.ErrKeyNotFound)) {
+			t.Errorf("search(%q) error = %v, want ErrKeyNotFound", missing, err)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Package bloom implements the bloom filters of the SSTables.
+//
+// A filter is a bitset probed at k positions derived from a seeded xxhash64
+// of the item by double hashing. Filters written before this package hashed
+// every probe with the same unseeded FNV-1 hash, so all of their k probes hit
+// the same bit; they are still read and tested the way they were built so
+// existing tables never see a false negative, and report Legacy so they can
+// be rebuilt.
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+// MaxHashes bounds the hash count read from disk, optimal filters use far
+// fewer hashes even for tiny false positive rates.
+const MaxHashes = 64
+
+// DefaultSeed seeds the hash of the filters New creates.
+const DefaultSeed uint64 = 0x9E3779B97F4A7C15
+
+// Serialized filters start with a little endian uint32 holding the hash
+// count in its low 16 bits and the format in its high 16 bits, followed by
+// a uint32 bit count. Legacy filters have format 0 and are followed by the
+// bits. xxhash filters are followed by their uint64 seed, then the bits. The
+// bits are stored least significant bit first, as they are held in memory.
+const (
+	formatLegacy = 0
+	formatXXHash = 1
+
+	legacyHeaderSize = 8
+	xxhashHeaderSize = 16
+)
+
+// Filter is a bloom filter. Add is not safe for concurrent use, Test is
+// safe once the filter is built.
+type Filter struct {
+	bits   []byte
+	m      uint64
+	k      uint32
+	seed   uint64
+	legacy bool
+}
+
+// New creates a filter sized for capacity items at the given false positive
+// rate, e.g. 0.01 for 1%, hashed with DefaultSeed.
+func New(capacity int, falsePositiveRate float64) *Filter {
+	return NewWithSeed(capacity, falsePositiveRate, DefaultSeed)
+}
+
+// NewWithSeed creates a filter like New, hashed with seed.
+func NewWithSeed(capacity int, falsePositiveRate float64, seed uint64) *Filter {
+	capacity = max(capacity, 1)
+	bitSize := int(-float64(capacity) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	bitSize = max(bitSize, 1)
+	hashCount := int(float64(bitSize) * math.Ln2 / float64(capacity))
+	hashCount = min(max(hashCount, 1), MaxHashes)
+
+	return &Filter{
+		bits: make([]byte, (bitSize+7)/8),
+		m:    uint64(bitSize),
+		k:    uint32(hashCount),
+		seed: seed,
+	}
+}
+
+// Add inserts an item into the filter.
+func (f *Filter) Add(item []byte) {
+	if f.m == 0 {
+		return
+	}
+	if f.legacy {
+		f.set(legacyIndex(item, f.m))
+		return
+	}
+	h1, h2 := f.hashes(item)
+	for i := uint32(0); i < f.k; i++ {
+		f.set(h1 % f.m)
+		h1 += h2
+		h2 += uint64(i)
+	}
+}
+
+// Test reports whether item might have been added, false means it
+// definitely was not.
+func (f *Filter) Test(item []byte) bool {
+	if f.m == 0 || f.k == 0 {
+		return true
+	}
+	if f.legacy {
+		return f.get(legacyIndex(item, f.m))
+	}
+	h1, h2 := f.hashes(item)
+	for i := uint32(0); i < f.k; i++ {
+		if !f.get(h1 % f.m) {
+			return false
+		}
+		h1 += h2
+		h2 += uint64(i)
+	}
+	return true
+}
+
+// hashes returns the two hashes the probes of item are derived from by
+// enhanced double hashing.
+func (f *Filter) hashes(item []byte) (uint64, uint64) {
+	h := Hash64(item, f.seed)
+	return h, h>>32 | h<<32 | 1
+}
+
+// legacyIndex returns the single bit every probe of a legacy filter hits.
+func legacyIndex(item []byte, m uint64) uint64 {
+	h := fnv.New64()
+	h.Write(item)
+	return h.Sum64() % m
+}
+
+func (f *Filter) set(i uint64) { f.bits[i/8] |= 1 << (i % 8) }
+
+func (f *Filter) get(i uint64) bool { return f.bits[i/8]&(1<<(i%8)) != 0 }
+
+// Bits returns the number of bits of the filter.
+func (f *Filter) Bits() int { return int(f.m) }
+
+// Hashes returns the number of probes of an item.
+func (f *Filter) Hashes() int { return int(f.k) }
+
+// Legacy reports whether the filter uses the legacy FNV hashing, whose
+// probes all hit the same bit.
+func (f *Filter) Legacy() bool { return f.legacy }
+
+// MemoryUsage returns the approximate number of bytes held by the filter.
+func (f *Filter) MemoryUsage() uint64 {
+	return uint64(len(f.bits))
+}
+
+// ToBytes serializes the filter in the format it was built or read in.
+func (f *Filter) ToBytes() []byte {
+	var buf []byte
+	if f.legacy {
+		buf = make([]byte, legacyHeaderSize, legacyHeaderSize+len(f.bits))
+		binary.LittleEndian.PutUint32(buf, f.k|formatLegacy<<16)
+	} else {
+		buf = make([]byte, xxhashHeaderSize, xxhashHeaderSize+len(f.bits))
+		binary.LittleEndian.PutUint32(buf, f.k|formatXXHash<<16)
+		binary.LittleEndian.PutUint64(buf[8:], f.seed)
+	}
+	binary.LittleEndian.PutUint32(buf[4:], uint32(f.m))
+	return append(buf, f.bits...)
+}
+
+// FromBytes deserializes a filter written by ToBytes, or by the filters
+// that preceded this package.
+func FromBytes(data []byte) (*Filter, error) {
+	if len(data) < legacyHeaderSize {
+		return nil, fmt.Errorf("bloom filter of %d bytes has no header", len(data))
+	}
+	header := binary.LittleEndian.Uint32(data)
+	f := &Filter{
+		k: header & 0xFFFF,
+		m: uint64(binary.LittleEndian.Uint32(data[4:])),
+	}
+
+	switch format := header >> 16; format {
+	case formatLegacy:
+		f.legacy = true
+		data = data[legacyHeaderSize:]
+	case formatXXHash:
+		if len(data) < xxhashHeaderSize {
+			return nil, fmt.Errorf("bloom filter of %d bytes has no seed", len(data))
+		}
+		f.seed = binary.LittleEndian.Uint64(data[8:])
+		data = data[xxhashHeaderSize:]
+	default:
+		return nil, fmt.Errorf("bloom filter has unknown format %d", format)
+	}
+
+	if f.k > MaxHashes {
+		return nil, fmt.Errorf("bloom filter has %d hash functions, at most %d are supported", f.k, MaxHashes)
+	}
+	if f.k > 0 && f.m == 0 {
+		return nil, fmt.Errorf("bloom filter has %d hash functions and no bits", f.k)
+	}
+
+	// the length is checked before allocating
+	byteLen := (f.m + 7) / 8
+	if byteLen > uint64(len(data)) {
+		return nil, fmt.Errorf("bloom filter of %d bits is cut short at %d bytes", f.m, len(data))
+	}
+	f.bits = make([]byte, byteLen)
+	copy(f.bits, data)
+	// bits past the bit count are never probed, clearing them keeps a
+	// round trip exact
+	if rem := f.m % 8; rem != 0 {
+		f.bits[byteLen-1] &= 1<<rem - 1
+	}
+	return f, nil
+}
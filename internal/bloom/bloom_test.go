@@ -0,0 +1,167 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"testing"
+)
+
+func TestHash64(t *testing.T) {
+	long := []byte("Nobody inspects the spammish repetition, it goes on and on and on")
+	tests := []struct {
+		data []byte
+		seed uint64
+		want uint64
+	}{
+		{nil, 0, 0xEF46DB3751D8E999},
+		{[]byte("a"), 0, 0xD24EC4F1A98C6E5B},
+		{[]byte("abc"), 0, 0x44BC2CF5AD770999},
+	}
+	for _, tt := range tests {
+		if got := Hash64(tt.data, tt.seed); got != tt.want {
+			t.Errorf("Hash64(%q, %d) = %#x, want %#x", tt.data, tt.seed, got, tt.want)
+		}
+	}
+	if Hash64(long, 0) == Hash64(long, 1) {
+		t.Errorf("Hash64() ignores the seed")
+	}
+}
+
+func TestFalsePositiveRate(t *testing.T) {
+	const n = 10000
+	f := New(n, 0.01)
+	for i := range n {
+		f.Add([]byte(fmt.Sprintf("key%d", i)))
+	}
+	for i := range n {
+		if !f.Test([]byte(fmt.Sprintf("key%d", i))) {
+			t.Fatalf("Test(key%d) missed an added key", i)
+		}
+	}
+
+	positives := 0
+	for i := range n {
+		if f.Test([]byte(fmt.Sprintf("absent%d", i))) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / n; rate > 0.02 {
+		t.Errorf("false positive rate is %.4f, want about 0.01", rate)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	f := NewWithSeed(100, 0.01, 42)
+	f.Add([]byte("key"))
+
+	again, err := FromBytes(f.ToBytes())
+	if err != nil {
+		t.Fatalf("FromBytes() error: %v", err)
+	}
+	if again.Bits() != f.Bits() || again.Hashes() != f.Hashes() || again.seed != 42 || again.Legacy() {
+		t.Errorf("round trip changed the filter")
+	}
+	if !again.Test([]byte("key")) {
+		t.Errorf("Test() of the decoded filter missed an added key")
+	}
+}
+
+func TestLegacyFilter(t *testing.T) {
+	// a filter as written before this package: every probe hits the FNV bit
+	const bits = 1000
+	data := make([]byte, 8+(bits+7)/8)
+	binary.LittleEndian.PutUint32(data, 7)
+	binary.LittleEndian.PutUint32(data[4:], bits)
+	h := fnv.New64()
+	h.Write([]byte("key"))
+	i := h.Sum64() % bits
+	data[8+i/8] |= 1 << (i % 8)
+
+	f, err := FromBytes(data)
+	if err != nil {
+		t.Fatalf("FromBytes() error: %v", err)
+	}
+	if !f.Legacy() || f.Hashes() != 7 || f.Bits() != bits {
+		t.Errorf("FromBytes() = legacy %v, %d hashes, %d bits, want a legacy filter of 7 hashes and %d bits", f.Legacy(), f.Hashes(), f.Bits(), bits)
+	}
+	if !f.Test([]byte("key")) {
+		t.Errorf("Test() of a legacy filter missed its key")
+	}
+	if string(f.ToBytes()) != string(data) {
+		t.Errorf("ToBytes() of a legacy filter changed its serialization")
+	}
+}
+
+func TestFromBytesRejects(t *testing.T) {
+	tests := map[string][]byte{
+		"short header":   {1, 0, 0},
+		"unknown format": {1, 0, 9, 0, 8, 0, 0, 0, 0},
+		"missing seed":   {1, 0, 1, 0, 8, 0, 0, 0, 0},
+		"too many hash":  {65, 0, 0, 0, 8, 0, 0, 0, 0},
+		"no bits":        {1, 0, 0, 0, 0, 0, 0, 0},
+		"cut short":      {1, 0, 0, 0, 64, 0, 0, 0, 0},
+	}
+	for name, data := range tests {
+		if _, err := FromBytes(data); err == nil {
+			t.Errorf("FromBytes() of %s succeeded", name)
+		}
+	}
+}
+
+func FuzzFromBytes(f *testing.F) {
+	bf := New(100, 0.01)
+	bf.Add([]byte("key"))
+	f.Add(bf.ToBytes())
+	f.Add(bf.ToBytes()[:20])
+	f.Add([]byte{1, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		bf, err := FromBytes(data)
+		if err != nil {
+			return
+		}
+		if bf.Bits() > 8*len(data) {
+			t.Fatalf("filter of %d bits decoded from %d bytes", bf.Bits(), len(data))
+		}
+
+		bf.Add([]byte("key"))
+		if !bf.Test([]byte("key")) {
+			t.Fatalf("Test() missed an added key")
+		}
+
+		again, err := FromBytes(bf.ToBytes())
+		if err != nil {
+			t.Fatalf("FromBytes() of a serialized filter error: %v", err)
+		}
+		if string(bf.bits) != string(again.bits) || bf.Hashes() != again.Hashes() || bf.Legacy() != again.Legacy() {
+			t.Fatalf("round trip changed the filter")
+		}
+	})
+}
+
+func BenchmarkAdd(b *testing.B) {
+	f := New(b.N, 0.01)
+	key := make([]byte, 256)
+	b.ResetTimer()
+	for i := range b.N {
+		binary.LittleEndian.PutUint64(key, uint64(i))
+		f.Add(key)
+	}
+}
+
+func BenchmarkTest(b *testing.B) {
+	const n = 100000
+	f := New(n, 0.01)
+	key := make([]byte, 256)
+	for i := range n {
+		binary.LittleEndian.PutUint64(key, uint64(i))
+		f.Add(key)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		binary.LittleEndian.PutUint64(key, uint64(i%(2*n)))
+		f.Test(key)
+	}
+}
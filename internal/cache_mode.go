@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// cacheTier expires the keys of an engine in cache mode. Expiries are kept in
+// memory, keys found on open expire one TTL after it. Expired keys are hidden
+// from reads right away and deleted before the memtable is flushed. A nil
+// cacheTier never expires anything.
+type cacheTier struct {
+	ttl      time.Duration
+	openedAt time.Time
+	mu       sync.Mutex
+	expiries map[string]time.Time // Keys written since open.
+	swept    bool                 // Keys older than the open were deleted.
+}
+
+func newCacheTier(ttl time.Duration) *cacheTier {
+	return &cacheTier{ttl: ttl, openedAt: time.Now(), expiries: map[string]time.Time{}}
+}
+
+// written restarts the key's TTL.
+func (c *cacheTier) written(key string) {
+	if c == nil || c.ttl == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expiries[key] = time.Now().Add(c.ttl)
+}
+
+// forget stops tracking a deleted key.
+func (c *cacheTier) forget(key string) {
+	if c == nil || c.ttl == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.expiries, key)
+}
+
+// expired reports whether the key outlived its TTL.
+func (c *cacheTier) expired(key string) bool {
+	if c == nil || c.ttl == 0 {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	deadline, ok := c.expiries[key]
+	if !ok {
+		deadline = c.openedAt.Add(c.ttl)
+	}
+	return !time.Now().Before(deadline)
+}
+
+// due returns the tracked keys past their expiry, and whether the keys found
+// on open are due and have to be looked up in the index, which is reported once.
+func (c *cacheTier) due() (keys []string, sweepOpened bool) {
+	if c == nil || c.ttl == 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for key, deadline := range c.expiries {
+		if !now.Before(deadline) {
+			keys = append(keys, key)
+		}
+	}
+	if !c.swept && !now.Before(c.openedAt.Add(c.ttl)) {
+		c.swept, sweepOpened = true, true
+	}
+	return keys, sweepOpened
+}
+
+// tracked reports whether the key was written since open.
+func (c *cacheTier) tracked(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.expiries[key]
+	return ok
+}
+
+// expireCached deletes the expired keys of an engine in cache mode, e.mu
+// must be held by the caller.
+func (e *Engine) expireCached() error {
+	keys, sweepOpened := e.cache.due()
+	if sweepOpened {
+		err := e.scan("", func(pair KVPair) (bool, error) {
+			if !e.cache.tracked(pair.Key) {
+				keys = append(keys, pair.Key)
+			}
+			return false, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		if err := e.delete(key, 0, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
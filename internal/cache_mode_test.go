@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestCacheMode(t *testing.T) {
+	home := t.TempDir()
+	config := *shared.NewCacheConfig().WithCacheMode(true, 50*time.Millisecond).WithMemtableSizeThreshold(10)
+	e, err := NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(home, WALFileName)); !os.IsNotExist(err) {
+		t.Errorf("cache mode created a WAL, Stat() error: %v", err)
+	}
+	var bestEffort *shared.ErrBestEffort
+	if err := e.Sync(); !errors.As(err, &bestEffort) || !e.Stats().BestEffort {
+		t.Errorf("Sync() error = %v, want ErrBestEffort", err)
+	}
+
+	e.Set("old", []byte("value"))
+	time.Sleep(60 * time.Millisecond)
+	e.Set("new", []byte("value"))
+
+	if _, err := e.Get("old"); err == nil {
+		t.Errorf("Get(old) found an expired key")
+	}
+	if keys, _ := e.Scan(""); len(keys) != 1 || keys[0] != "new" {
+		t.Errorf("Scan() = %v, want only the unexpired key", keys)
+	}
+
+	// expired keys are deleted before the memtable is flushed
+	for i := range 10 {
+		e.Set(fmt.Sprintf("key%d", i), []byte("value"))
+	}
+	if _, err := e.indexManager.Get("old"); err == nil {
+		t.Errorf("expired key is still in the index after a flush")
+	}
+
+	// the memtable is flushed on close so the cache survives a restart
+	e.Set("last", []byte("value"))
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	e, err = NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+	if value, err := e.Get("last"); err != nil || string(value) != "value" {
+		t.Errorf("Get(last) after reopening = %q, %v", value, err)
+	}
+}
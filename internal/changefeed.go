@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultSubscriptionBuffer is the number of changes queued for a subscriber
+// when SubscribeOptions.Buffer is zero.
+const DefaultSubscriptionBuffer = 1024
+
+// ErrSubscriptionOverflow ends a subscription whose consumer fell behind by
+// more than its buffer, the consumer has to resynchronize, e.g. with a scan.
+var ErrSubscriptionOverflow = errors.New("subscription overflowed its buffer")
+
+// ErrSubscriptionClosed ends a subscription closed by its consumer or by the engine.
+var ErrSubscriptionClosed = errors.New("subscription closed")
+
+// Change is a write published to subscriptions once applied.
+type Change struct {
+	Seq     uint64 `json:"seq"` // Sequence number of the write, see Engine.LastSequence.
+	Key     string `json:"key"`
+	Value   []byte `json:"value,omitempty"` // Nil for deletions and keys only subscriptions.
+	Deleted bool   `json:"deleted,omitempty"`
+	Operand bool   `json:"operand,omitempty"` // Value is a merge operand, see Engine.Merge.
+}
+
+// SubscribeOptions filters the changes a subscription receives.
+type SubscribeOptions struct {
+	Prefixes []string // Only changes to keys starting with one of them, none means every key.
+	KeysOnly bool     // Leave the values out of the changes.
+	Buffer   int      // Changes queued before the subscription overflows, zero means DefaultSubscriptionBuffer.
+}
+
+// Subscription receives the changes matching its options in the order they
+// were applied, consumers can tell which writes they saw by their sequence
+// numbers. Writers never wait for subscribers: a subscription that falls
+// more than its buffer behind is ended with ErrSubscriptionOverflow.
+type Subscription struct {
+	feed    *changefeed
+	options SubscribeOptions
+	changes chan Change
+	once    sync.Once
+	err     error
+}
+
+// Changes returns the channel changes are delivered on, it is closed when the
+// subscription ends, Err then tells why.
+func (s *Subscription) Changes() <-chan Change {
+	return s.changes
+}
+
+// Err returns why the subscription ended, nil while it is active.
+func (s *Subscription) Err() error {
+	s.feed.mu.RLock()
+	defer s.feed.mu.RUnlock()
+	return s.err
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+	s.feed.end(s, ErrSubscriptionClosed)
+}
+
+func (s *Subscription) matches(key string) bool {
+	if len(s.options.Prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range s.options.Prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// changefeed fans applied writes out to subscriptions.
+type changefeed struct {
+	mu    sync.RWMutex
+	subs  map[*Subscription]struct{}
+	count atomic.Int32 // Subscriptions, checked before taking the lock.
+}
+
+func newChangefeed() *changefeed {
+	return &changefeed{subs: map[*Subscription]struct{}{}}
+}
+
+// Subscribe starts receiving the changes applied from now on that match
+// options, filters are evaluated before changes are queued. Writes made
+// through an Ingester are not published.
+func (e *Engine) Subscribe(options SubscribeOptions) *Subscription {
+	if options.Buffer <= 0 {
+		options.Buffer = DefaultSubscriptionBuffer
+	}
+
+	s := &Subscription{feed: e.feed, options: options, changes: make(chan Change, options.Buffer)}
+
+	e.feed.mu.Lock()
+	defer e.feed.mu.Unlock()
+	e.feed.subs[s] = struct{}{}
+	e.feed.count.Add(1)
+	return s
+}
+
+// publish queues the changes for the subscriptions matching their keys, the
+// values are copied once for the subscriptions wanting them.
+func (f *changefeed) publish(changes ...Change) {
+	if f.count.Load() == 0 {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, change := range changes {
+		if change.Value != nil {
+			change.Value = bytes.Clone(change.Value)
+		}
+
+		for s := range f.subs {
+			if !s.matches(change.Key) {
+				continue
+			}
+
+			delivered := change
+			if s.options.KeysOnly {
+				delivered.Value = nil
+			}
+			select {
+			case s.changes <- delivered:
+			default:
+				f.end(s, ErrSubscriptionOverflow)
+			}
+		}
+	}
+}
+
+// close ends every subscription, f.mu must not be held.
+func (f *changefeed) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for s := range f.subs {
+		f.end(s, ErrSubscriptionClosed)
+	}
+}
+
+// end removes the subscription and closes its channel, f.mu must be held.
+func (f *changefeed) end(s *Subscription, err error) {
+	s.once.Do(func() {
+		delete(f.subs, s)
+		f.count.Add(-1)
+		s.err = err
+		close(s.changes)
+	})
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestSubscribeFilters(t *testing.T) {
+	e, err := NewEngine(t.TempDir(), *shared.NewEngineConfig())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	all := e.Subscribe(SubscribeOptions{})
+	users := e.Subscribe(SubscribeOptions{Prefixes: []string{"user:", "admin:"}, KeysOnly: true})
+	small := e.Subscribe(SubscribeOptions{Buffer: 1})
+
+	e.Set("user:1", []byte("alice"))
+	e.Set("item:1", []byte("book"))
+	batch := e.WriteBatch()
+	batch.Set("admin:1", []byte("root"))
+	batch.Delete("user:1")
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+
+	want := []Change{{Seq: 1, Key: "user:1"}, {Seq: 3, Key: "admin:1"}, {Seq: 4, Key: "user:1", Deleted: true}}
+	for _, change := range want {
+		got := <-users.Changes()
+		if got.Seq != change.Seq || got.Key != change.Key || got.Deleted != change.Deleted || got.Value != nil {
+			t.Errorf("keys only change = %+v, want %+v", got, change)
+		}
+	}
+	if len(users.Changes()) != 0 {
+		t.Errorf("filtered subscription received a change outside its prefixes")
+	}
+
+	if got := <-all.Changes(); got.Key != "user:1" || string(got.Value) != "alice" {
+		t.Errorf("first change = %+v, want user:1 with its value", got)
+	}
+	if len(all.Changes()) != 3 {
+		t.Errorf("unfiltered subscription queued %d more changes, want 3", len(all.Changes()))
+	}
+
+	// the overflowing subscriber is ended instead of blocking writers
+	<-small.Changes()
+	if _, ok := <-small.Changes(); ok || !errors.Is(small.Err(), ErrSubscriptionOverflow) {
+		t.Errorf("overflowed subscription Err() = %v, want ErrSubscriptionOverflow", small.Err())
+	}
+
+	users.Close()
+	if _, ok := <-users.Changes(); ok || !errors.Is(users.Err(), ErrSubscriptionClosed) {
+		t.Errorf("closed subscription Err() = %v, want ErrSubscriptionClosed", users.Err())
+	}
+}
@@ -0,0 +1,437 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// CheckpointManifestName is the file written last into a checkpoint directory,
+// a directory without it is an incomplete checkpoint.
+const CheckpointManifestName = "CHECKPOINT"
+
+type CheckpointFile struct {
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"crc32c,omitempty"` // Hex CRC32C of the file, missing from older checkpoints.
+}
+
+// CheckpointManifest lists the files of a checkpoint and when it was taken.
+type CheckpointManifest struct {
+	CreatedAt time.Time        `json:"created_at"`
+	Files     []CheckpointFile `json:"files"`
+}
+
+// Checkpoint writes a consistent copy of the database to dir, which must not exist.
+// The memtable is flushed first so the checkpoint needs no WAL, SSTables and
+// levels are hard linked when possible. The data file and the value files are
+// copied, purges erase values in place.
+func (e *Engine) Checkpoint(dir string) error {
+	files, err := e.copyTo(dir)
+	if err != nil {
+		return err
+	}
+	return finishCheckpoint(dir, files, time.Now().UTC())
+}
+
+// groupCheckpoints serializes CheckpointGroup, two groups sharing engines
+// would otherwise pause them in different orders.
+var groupCheckpoints sync.Mutex
+
+// CheckpointGroup checkpoints engines[i] to dirs[i] at a single logical point:
+// the writes of every engine are paused until all of their files are copied,
+// so a checkpoint never holds a write without the writes the other engines
+// acknowledged before it. The manifests are written once every copy is
+// checksummed and share their creation time. On failure the directories
+// created are removed, manifests already written included, so no checkpoint
+// of the group is left behind.
+func CheckpointGroup(engines []*Engine, dirs []string) error {
+	return checkpointGroup(engines, dirs, writeCheckpointManifest)
+}
+
+// checkpointGroup is CheckpointGroup writing the manifests with write.
+func checkpointGroup(engines []*Engine, dirs []string, write func(dir string, manifest CheckpointManifest) error) error {
+	if len(engines) != len(dirs) {
+		return fmt.Errorf("checkpoint group of %d engines has %d directories", len(engines), len(dirs))
+	}
+	seen := map[*Engine]bool{}
+	for _, e := range engines {
+		if e.Config.ReadOnly {
+			return &shared.ErrReadOnly{Path: e.Config.Homepath}
+		}
+		if seen[e] {
+			return fmt.Errorf("engine %q appears twice in the checkpoint group", e.Config.Homepath)
+		}
+		seen[e] = true
+	}
+
+	groupCheckpoints.Lock()
+	defer groupCheckpoints.Unlock()
+
+	// directories that existed before are not the group's to remove
+	created := []string{}
+	fail := func(err error) error {
+		for _, dir := range created {
+			os.RemoveAll(dir)
+		}
+		return err
+	}
+
+	// pause every engine, copy them all, then let the writes resume
+	files := make([][]CheckpointFile, len(engines))
+	err := func() error {
+		for _, e := range engines {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+		}
+		for i, e := range engines {
+			if _, err := os.Stat(dirs[i]); os.IsNotExist(err) {
+				created = append(created, dirs[i])
+			}
+			var err error
+			if files[i], err = e.copyToLocked(dirs[i]); err != nil {
+				return fmt.Errorf("checkpoint of %q: %w", e.Config.Homepath, err)
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		return fail(err)
+	}
+
+	for i := range engines {
+		if err := checksumFiles(dirs[i], files[i]); err != nil {
+			return fail(err)
+		}
+	}
+	createdAt := time.Now().UTC()
+	for i := range engines {
+		if err := write(dirs[i], CheckpointManifest{CreatedAt: createdAt, Files: files[i]}); err != nil {
+			return fail(fmt.Errorf("checkpoint of %q: %w", engines[i].Config.Homepath, err))
+		}
+	}
+	return nil
+}
+
+// finishCheckpoint checksums the files copied to dir and writes its manifest.
+func finishCheckpoint(dir string, files []CheckpointFile, createdAt time.Time) error {
+	if err := checksumFiles(dir, files); err != nil {
+		return err
+	}
+	return writeCheckpointManifest(dir, CheckpointManifest{CreatedAt: createdAt, Files: files})
+}
+
+// checksumFiles records the checksum of every file copied to dir.
+func checksumFiles(dir string, files []CheckpointFile) error {
+	var err error
+	for i := range files {
+		if files[i].Checksum, err = fileChecksum(filepath.Join(dir, files[i].Name)); err != nil {
+			return fmt.Errorf("engine can not checksum %q of the checkpoint: %v", files[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// Clone creates an independent database in dir, which must not exist, that can
+// be opened with NewEngine. SSTables are shared with this database through hard
+// links, the data file and the value files are cloned copy-on-write where the
+// filesystem supports it.
+func (e *Engine) Clone(dir string) error {
+	_, err := e.copyTo(dir)
+	return err
+}
+
+// copyTo flushes the memtable and writes a consistent copy of the database files to dir.
+func (e *Engine) copyTo(dir string) ([]CheckpointFile, error) {
+	if e.Config.ReadOnly {
+		return nil, &shared.ErrReadOnly{Path: e.Config.Homepath}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.copyToLocked(dir)
+}
+
+// copyToLocked is copyTo for a caller holding e.mu.
+func (e *Engine) copyToLocked(dir string) ([]CheckpointFile, error) {
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return nil, fmt.Errorf("engine can not create directory %q: %v", dir, err)
+	}
+
+	// the copy holds no WAL, merge operands are collapsed like on any flush
+	if err := e.collapseOperands(); err != nil {
+		return nil, fmt.Errorf("engine can not collapse the merge operands: %v", err)
+	}
+
+	// the values referenced by the flushed memtable must be durable before the WAL goes away
+	if err := e.storageManager.Sync(); err != nil {
+		return nil, fmt.Errorf("engine can not sync the data file: %v", err)
+	}
+
+	files, err := e.indexManager.linkTables(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// the memtable was flushed, its entries are durable in the new SSTable
+	if err := e.wal.Clear(); err != nil {
+		return nil, fmt.Errorf("engine can not clear the WAL after flushing: %v", err)
+	}
+
+	dataFile, err := cloneOrCopyFile(filepath.Join(e.Config.Homepath, DataFileName), filepath.Join(dir, DataFileName))
+	if err != nil {
+		return nil, fmt.Errorf("engine can not copy the data file to %q: %v", dir, err)
+	}
+
+	return append(files, dataFile), nil
+}
+
+// linkTables flushes the memtable and links every table into dir.
+func (im *IndexManager) linkTables(dir string) ([]CheckpointFile, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	if im.memtable.Size() > 0 {
+		if err := im.flush(); err != nil {
+			return nil, fmt.Errorf("index manager can not flush before linking tables: %v", err)
+		}
+	}
+
+	tables := make([]*SSTable, 0, len(im.sstables)+len(im.levels))
+	tables = append(tables, im.sstables...)
+	tables = append(tables, im.levels...)
+
+	files := []CheckpointFile{}
+	for _, table := range tables {
+		file, err := linkOrCopyFile(table.metadata.Path, filepath.Join(dir, filepath.Base(table.metadata.Path)))
+		if err != nil {
+			return nil, fmt.Errorf("index manager can not link table %d: %v", table.metadata.Serial, err)
+		}
+		files = append(files, file)
+
+		for _, path := range []string{table.filterSidecarPath(), table.indexSidecarPath()} {
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			sidecar, err := linkOrCopyFile(path, filepath.Join(dir, filepath.Base(path)))
+			if err != nil {
+				return nil, fmt.Errorf("index manager can not link sidecar %q of table %d: %v", path, table.metadata.Serial, err)
+			}
+			files = append(files, sidecar)
+		}
+
+		// purges erase colocated values in place, a link would erase them from the copy too
+		if path := table.valuesPath(); path != "" {
+			values, err := cloneOrCopyFile(path, filepath.Join(dir, filepath.Base(path)))
+			if err != nil {
+				return nil, fmt.Errorf("index manager can not copy the value file %q of table %d: %v", path, table.metadata.Serial, err)
+			}
+			files = append(files, values)
+		}
+	}
+
+	return files, nil
+}
+
+// ReadCheckpointManifest reads the manifest of the checkpoint in dir.
+func ReadCheckpointManifest(dir string) (CheckpointManifest, error) {
+	manifest := CheckpointManifest{}
+
+	data, err := os.ReadFile(filepath.Join(dir, CheckpointManifestName))
+	if err != nil {
+		return manifest, fmt.Errorf("can not read checkpoint manifest of %q: %v", dir, err)
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("can not parse checkpoint manifest of %q: %v", dir, err)
+	}
+
+	return manifest, nil
+}
+
+// OpenCheckpoint opens the checkpoint in dir read-only after checking that
+// every file listed in its manifest is present with the recorded size. The
+// checkpoint is never modified, so backups can be verified and queried in place.
+func OpenCheckpoint(dir string, configs ...shared.EngineConfig) (*Engine, error) {
+	manifest, err := ReadCheckpointManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range manifest.Files {
+		info, err := os.Stat(filepath.Join(dir, file.Name))
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint %q is missing %q: %v", dir, file.Name, err)
+		}
+		if info.Size() != file.Size {
+			return nil, fmt.Errorf("checkpoint %q has %q of %d bytes, its manifest records %d", dir, file.Name, info.Size(), file.Size)
+		}
+	}
+
+	config := shared.DefaultConfig
+	if len(configs) > 0 {
+		config = configs[0]
+	}
+	config.ReadOnly = true
+	config.ScrubInterval = 0
+
+	return NewEngine(dir, config)
+}
+
+// LatestCheckpoint returns the path of the most recent complete checkpoint
+// found directly under root.
+func LatestCheckpoint(root string) (string, CheckpointManifest, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return "", CheckpointManifest{}, err
+	}
+
+	latest, latestManifest := "", CheckpointManifest{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(root, entry.Name())
+		manifest, err := ReadCheckpointManifest(path)
+		if err != nil {
+			continue // incomplete or foreign directory
+		}
+
+		if latest == "" || manifest.CreatedAt.After(latestManifest.CreatedAt) {
+			latest, latestManifest = path, manifest
+		}
+	}
+
+	if latest == "" {
+		return "", CheckpointManifest{}, fmt.Errorf("no checkpoint found in %q", root)
+	}
+
+	return latest, latestManifest, nil
+}
+
+// PruneCheckpoints removes all but the newest keep checkpoints under root.
+func PruneCheckpoints(root string, keep int) error {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+
+	type checkpoint struct {
+		path      string
+		createdAt time.Time
+	}
+
+	checkpoints := []checkpoint{}
+	for _, entry := range entries {
+		path := filepath.Join(root, entry.Name())
+		if manifest, err := ReadCheckpointManifest(path); err == nil {
+			checkpoints = append(checkpoints, checkpoint{path, manifest.CreatedAt})
+		}
+	}
+
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].createdAt.After(checkpoints[j].createdAt)
+	})
+
+	for i := keep; i < len(checkpoints); i++ {
+		if err := os.RemoveAll(checkpoints[i].path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeCheckpointManifest(dir string, manifest CheckpointManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write then rename so a reader never sees a partial manifest
+	tmp := filepath.Join(dir, CheckpointManifestName+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("can not write checkpoint manifest: %v", err)
+	}
+
+	return os.Rename(tmp, filepath.Join(dir, CheckpointManifestName))
+}
+
+// fileChecksum returns the hex CRC32C of the file at path.
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := crc32.New(crcTable)
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%08x", hash.Sum32()), nil
+}
+
+func linkOrCopyFile(src, dst string) (CheckpointFile, error) {
+	if err := os.Link(src, dst); err != nil {
+		return copyFile(src, dst)
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		return CheckpointFile{}, err
+	}
+
+	return CheckpointFile{Name: filepath.Base(dst), Size: info.Size()}, nil
+}
+
+// cloneOrCopyFile makes a copy-on-write clone of src when the filesystem
+// supports it and falls back to a full copy otherwise.
+func cloneOrCopyFile(src, dst string) (CheckpointFile, error) {
+	if err := cloneFile(src, dst); err != nil {
+		os.Remove(dst)
+		return copyFile(src, dst)
+	}
+
+	info, err := os.Stat(dst)
+	if err != nil {
+		return CheckpointFile{}, err
+	}
+
+	return CheckpointFile{Name: filepath.Base(dst), Size: info.Size()}, nil
+}
+
+func copyFile(src, dst string) (CheckpointFile, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return CheckpointFile{}, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return CheckpointFile{}, err
+	}
+	defer out.Close()
+
+	size, err := io.Copy(out, in)
+	if err != nil {
+		return CheckpointFile{}, err
+	}
+
+	if err := out.Sync(); err != nil {
+		return CheckpointFile{}, err
+	}
+
+	return CheckpointFile{Name: filepath.Base(dst), Size: size}, nil
+}
@@ -0,0 +1,238 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// checkpoints writes n checkpoints of engine under root, oldest first, and
+// returns their paths.
+func checkpoints(t *testing.T, engine *Engine, root string, n int) []string {
+	t.Helper()
+	paths := []string{}
+	for i := range n {
+		if err := engine.Set("latest", []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+		path := filepath.Join(root, fmt.Sprintf("checkpoint-%d", i))
+		if err := engine.Checkpoint(path); err != nil {
+			t.Fatalf("Checkpoint() error: %v", err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestLatestCheckpoint(t *testing.T) {
+	engine, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	root := t.TempDir()
+	if _, _, err := LatestCheckpoint(root); err == nil {
+		t.Errorf("LatestCheckpoint() found a checkpoint in an empty directory")
+	}
+
+	paths := checkpoints(t, engine, root, 3)
+	// incomplete checkpoints and other files are skipped
+	if err := os.Mkdir(filepath.Join(root, "incomplete"), 0755); err != nil {
+		t.Fatalf("Mkdir() error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "notes"), []byte("x"), 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	path, manifest, err := LatestCheckpoint(root)
+	if err != nil || path != paths[2] || manifest.CreatedAt.IsZero() {
+		t.Fatalf("LatestCheckpoint() = %q, %+v, %v, want %q", path, manifest, err, paths[2])
+	}
+	checkpoint, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatalf("OpenCheckpoint() error: %v", err)
+	}
+	defer checkpoint.Close()
+	if value, err := checkpoint.Get("latest"); err != nil || string(value) != "2" {
+		t.Errorf("Get() = %q, %v from the latest checkpoint, want \"2\"", value, err)
+	}
+}
+
+func TestPruneCheckpoints(t *testing.T) {
+	engine, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	root := t.TempDir()
+	paths := checkpoints(t, engine, root, 4)
+	incomplete := filepath.Join(root, "incomplete")
+	if err := os.Mkdir(incomplete, 0755); err != nil {
+		t.Fatalf("Mkdir() error: %v", err)
+	}
+
+	if err := PruneCheckpoints(root, 2); err != nil {
+		t.Fatalf("PruneCheckpoints() error: %v", err)
+	}
+	for i, path := range paths {
+		_, err := os.Stat(path)
+		if kept := i >= 2; kept != (err == nil) {
+			t.Errorf("checkpoint %d kept = %t, want %t", i, err == nil, kept)
+		}
+	}
+	// directories without a manifest are not checkpoints, they are left alone
+	if _, err := os.Stat(incomplete); err != nil {
+		t.Errorf("PruneCheckpoints() removed a directory without a manifest: %v", err)
+	}
+
+	if err := PruneCheckpoints(root, 5); err != nil {
+		t.Fatalf("PruneCheckpoints() error: %v", err)
+	}
+	if path, _, err := LatestCheckpoint(root); err != nil || path != paths[3] {
+		t.Errorf("LatestCheckpoint() = %q, %v after pruning, want %q", path, err, paths[3])
+	}
+}
+
+func TestCloneIsIndependent(t *testing.T) {
+	config := *shared.NewEngineConfig().WithColocatedValues("c:").WithSmallTableMergeSize(0)
+	origin, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer origin.Close()
+
+	for _, key := range []string{"c:a", "c:b", "p:a", "p:b"} {
+		if err := origin.Set(key, []byte("value of "+key)); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+	}
+	dir := filepath.Join(t.TempDir(), "clone")
+	if err := origin.Clone(dir); err != nil {
+		t.Fatalf("Clone() error: %v", err)
+	}
+	clone, err := NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer clone.Close()
+
+	// each side writes and purges keys the other keeps
+	for _, side := range []struct {
+		engine *Engine
+		purged []string
+	}{{origin, []string{"c:a", "p:a"}}, {clone, []string{"c:b", "p:b"}}} {
+		if err := side.engine.Set("written", []byte(side.engine.Config.Homepath)); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+		for _, key := range side.purged {
+			if _, err := side.engine.Purge(key); err != nil {
+				t.Fatalf("Purge(%q) error: %v", key, err)
+			}
+		}
+	}
+
+	check := func(engine *Engine, purged, kept []string) {
+		t.Helper()
+		for _, key := range purged {
+			if _, err := engine.Get(key); err == nil {
+				t.Errorf("Get(%q) found a key purged from %q", key, engine.Config.Homepath)
+			}
+		}
+		for _, key := range kept {
+			if value, err := engine.Get(key); err != nil || string(value) != "value of "+key {
+				t.Errorf("Get(%q) = %q, %v in %q, want the value written before cloning", key, value, err, engine.Config.Homepath)
+			}
+		}
+		if value, err := engine.Get("written"); err != nil || string(value) != engine.Config.Homepath {
+			t.Errorf("Get(\"written\") = %q, %v in %q, want its own write", value, err, engine.Config.Homepath)
+		}
+	}
+	check(origin, []string{"c:a", "p:a"}, []string{"c:b", "p:b"})
+	check(clone, []string{"c:b", "p:b"}, []string{"c:a", "p:a"})
+}
+
+func TestCheckpointGroupFailureLeavesNoCheckpoint(t *testing.T) {
+	engines := make([]*Engine, 3)
+	for i := range engines {
+		engine, err := NewEngine(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewEngine() error: %v", err)
+		}
+		defer engine.Close()
+		engine.Set("key", []byte(fmt.Sprint(i)))
+		engines[i] = engine
+	}
+	dirs := func(root string) []string {
+		return []string{filepath.Join(root, "0"), filepath.Join(root, "1"), filepath.Join(root, "2")}
+	}
+	removed := func(dirs ...string) {
+		t.Helper()
+		for _, dir := range dirs {
+			if _, err := os.Stat(dir); !os.IsNotExist(err) {
+				t.Errorf("checkpoint %q = %v after the group failed, want it removed", dir, err)
+			}
+		}
+	}
+
+	// the last manifest fails once the others are written
+	root := t.TempDir()
+	written := 0
+	err := checkpointGroup(engines, dirs(root), func(dir string, manifest CheckpointManifest) error {
+		if written == 2 {
+			return fmt.Errorf("manifest write")
+		}
+		written++
+		return writeCheckpointManifest(dir, manifest)
+	})
+	if err == nil {
+		t.Fatalf("checkpointGroup() succeeded with a failing manifest write")
+	}
+	removed(dirs(root)...)
+	if _, _, err := LatestCheckpoint(root); err == nil {
+		t.Errorf("LatestCheckpoint() found a checkpoint of a failed group")
+	}
+
+	// a failed copy removes the copies made, not a directory that was there
+	root = t.TempDir()
+	taken := dirs(root)[1]
+	if err := os.Mkdir(taken, 0755); err != nil {
+		t.Fatalf("Mkdir() error: %v", err)
+	}
+	if err := CheckpointGroup(engines, dirs(root)); err == nil {
+		t.Fatalf("CheckpointGroup() succeeded into an existing directory")
+	}
+	removed(dirs(root)[0], dirs(root)[2])
+	if _, err := os.Stat(taken); err != nil {
+		t.Errorf("existing directory removed by the failed group: %v", err)
+	}
+
+	// a group that succeeds shares its creation time
+	root = t.TempDir()
+	if err := CheckpointGroup(engines, dirs(root)); err != nil {
+		t.Fatalf("CheckpointGroup() error: %v", err)
+	}
+	var createdAt []string
+	for i, dir := range dirs(root) {
+		manifest, err := ReadCheckpointManifest(dir)
+		if err != nil {
+			t.Fatalf("ReadCheckpointManifest() error: %v", err)
+		}
+		createdAt = append(createdAt, manifest.CreatedAt.String())
+		checkpoint, err := OpenCheckpoint(dir)
+		if err != nil {
+			t.Fatalf("OpenCheckpoint() error: %v", err)
+		}
+		if value, err := checkpoint.Get("key"); err != nil || string(value) != fmt.Sprint(i) {
+			t.Errorf("Get(key) = %q, %v in checkpoint %d, want %d", value, err, i, i)
+		}
+		checkpoint.Close()
+	}
+	if createdAt[0] != createdAt[1] || createdAt[1] != createdAt[2] {
+		t.Errorf("manifests created at %q, want a single time", createdAt)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package internal
+
+import "errors"
+
+// cloneFile is only supported on Linux, other platforms fall back to copying.
+func cloneFile(src, dst string) error {
+	return errors.New("copy-on-write clones are not supported on this platform")
+}
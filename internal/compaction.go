@@ -0,0 +1,390 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Levels 1 and deeper hold tables whose key ranges do not overlap within
+// their level, each level holding LevelSizeRatio times more pairs than the
+// one above it. A compaction merges tables into the tables of the next level
+// overlapping them and nothing else, so a key is found in at most one table
+// per level and the data of a level is newer than the data of the levels
+// below it.
+
+const (
+	// maxLevels is the deepest level, it grows without bound.
+	maxLevels = 7
+	// defaultLevelSizeRatio is used when Config.LevelSizeRatio is zero.
+	defaultLevelSizeRatio = 10
+)
+
+// levelTableName returns the file name of a table of the given level.
+func (im *IndexManager) levelTableName(level, serial int) string {
+	return fmt.Sprintf(im.config.LevelFileNamePrefix+"%d_%d", level, serial)
+}
+
+// levelOf returns the level of the level table named name. Level files
+// written before levels were numbered, each a merge of all the SSTables of
+// their time, are in level 1 and may overlap, compactions take all of them
+// covering a key range at once.
+func (im *IndexManager) levelOf(name string) int {
+	var level, serial int
+	if _, err := fmt.Sscanf(strings.TrimPrefix(name, im.config.LevelFileNamePrefix), "%d_%d", &level, &serial); err != nil || level < 1 {
+		return 1
+	}
+	return min(level, maxLevels)
+}
+
+// levelTarget returns the number of pairs the level holds before one of its
+// tables is compacted into the next one.
+func (im *IndexManager) levelTarget(level int) uint64 {
+	target := im.config.LevelBaseSize
+	if target == 0 {
+		target = uint64(im.config.CompactionThreshold) * uint64(im.config.MemtableSizeThreshold)
+	}
+	ratio := uint64(im.config.LevelSizeRatio)
+	if ratio == 0 {
+		ratio = defaultLevelSizeRatio
+	}
+	for range level - 1 {
+		target *= ratio
+	}
+	return max(target, 1)
+}
+
+// levelTableSize returns the number of pairs compactions write per table.
+func (im *IndexManager) levelTableSize() int {
+	if im.config.LevelTableSize > 0 {
+		return int(im.config.LevelTableSize)
+	}
+	return int(max(im.config.MemtableSizeThreshold, 1))
+}
+
+// levelTables returns the tables of the given level, newest first. im.mu must
+// be held by the caller.
+func (im *IndexManager) levelTables(level int) []*SSTable {
+	if level == 0 {
+		return im.sstables
+	}
+	tables := []*SSTable{}
+	for _, table := range im.levels {
+		if table.metadata.Level == level {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// levelGroups returns the tables of every level, the SSTables first, down to
+// the deepest level holding tables. im.mu must be held by the caller.
+func (im *IndexManager) levelGroups() [][]*SSTable {
+	groups := [][]*SSTable{im.sstables}
+	for _, table := range im.levels {
+		for len(groups) <= table.metadata.Level {
+			groups = append(groups, nil)
+		}
+		groups[table.metadata.Level] = append(groups[table.metadata.Level], table)
+	}
+	return groups
+}
+
+// overfullLevel returns the shallowest level above the deepest one holding more
+// pairs than its target, zero if there is none. im.mu must be held by the
+// caller.
+func (im *IndexManager) overfullLevel() int {
+	for level := 1; level < maxLevels; level++ {
+		pairs := uint64(0)
+		for _, table := range im.levelTables(level) {
+			pairs += uint64(table.metadata.Size)
+		}
+		if pairs > im.levelTarget(level) {
+			return level
+		}
+	}
+	return 0
+}
+
+// compactLevel moves a table of the overfull level into the next one. Tables
+// are picked in key order, resuming after the last one moved, so every part
+// of the key space is compacted in turn. im.mu must be held by the caller.
+func (im *IndexManager) compactLevel(level int) error {
+	tables := im.levelTables(level)
+	if len(tables) == 0 || im.compacting {
+		return nil
+	}
+
+	var picked *SSTable
+	for _, table := range tables {
+		if table.metadata.MinKey > im.compactPointers[level] && (picked == nil || table.metadata.MinKey < picked.metadata.MinKey) {
+			picked = table
+		}
+	}
+	if picked == nil {
+		// past the last table, start over from the first one
+		picked = tables[0]
+		for _, table := range tables {
+			if table.metadata.MinKey < picked.metadata.MinKey {
+				picked = table
+			}
+		}
+	}
+	im.compactPointers[level] = picked.metadata.MaxKey
+
+	// tables of the level overlapping the picked one move with it so none is
+	// left above older data of its keys
+	inputs, low, high := overlappingTables(tables, picked.metadata.MinKey, picked.metadata.MaxKey)
+	if targets, _, _ := overlappingTables(im.levelTables(level+1), low, high); len(inputs) == 1 && len(targets) == 0 {
+		return im.moveTable(picked, level+1)
+	}
+	return im.compactInto(inputs, level+1)
+}
+
+// moveTable moves a table of levels 1 and deeper overlapping no table of the
+// given level into it by renaming its files, its pairs are not rewritten. The
+// renamed file stays open for readers of the previous handle. im.mu must be
+// held by the caller.
+func (im *IndexManager) moveTable(table *SSTable, level int) error {
+	path := filepath.Join(im.config.Homepath, im.levelTableName(level, int(table.metadata.Serial)))
+
+	// the value file is linked under its new name first, a crash leaves one
+	// of the names without a table and it is removed on open
+	values := table.values
+	valuesPath := ""
+	if values != nil {
+		valuesPath = fmt.Sprintf("%s.%d%s", path, values.number, ValueFileSuffix)
+		if err := os.Link(values.path, valuesPath); err != nil {
+			return fmt.Errorf("IndexManager can not link the value file of table %q: %v", table.metadata.Path, err)
+		}
+	}
+	if err := os.Rename(table.metadata.Path, path); err != nil {
+		if values != nil {
+			os.Remove(valuesPath)
+		}
+		return fmt.Errorf("IndexManager can not move table %q to level %d: %v", table.metadata.Path, level, err)
+	}
+	// a sidecar left behind is ignored, the table falls back to its embedded copy
+	for _, suffix := range []string{FilterSidecarSuffix, IndexSidecarSuffix} {
+		if err := os.Rename(table.metadata.Path+suffix, path+suffix); err != nil && !os.IsNotExist(err) {
+			log.Printf("IndexManager can not move sidecar %q: %v", table.metadata.Path+suffix, err)
+		}
+	}
+	if values != nil {
+		os.Remove(values.path)
+		im.values.renamed(values, valuesPath)
+	}
+	if err := syncDir(im.config.Homepath); err != nil {
+		return fmt.Errorf("IndexManager can not sync the move of table %q: %v", table.metadata.Path, err)
+	}
+
+	moved, err := deserializeSSTable(TableMetadata{Path: path}, im.config, im.filters, im.retry)
+	if err != nil {
+		return fmt.Errorf("IndexManager can not open table %q moved to level %d: %v", path, level, err)
+	}
+	moved.repair = im.repair
+	moved.values = values
+	moved.access = im.access
+	moved.metadata.Level = level
+
+	for i, live := range im.levels {
+		if live == table {
+			im.levels[i] = moved
+		}
+	}
+	table.release()
+	im.sortTablesBySerial()
+	im.moves.Add(1)
+
+	if im.debug.Load() {
+		log.Printf("IndexManager moved table %d to level %d", moved.metadata.Serial, level)
+	}
+	return nil
+}
+
+// compactInto merges the input tables, newest first, with the tables of the
+// given level overlapping them into new tables of that level, replacing them
+// all. Deleted keys are dropped when no deeper level may hold an older value
+// of them. im.mu must be held by the caller, it is released between the
+// blocks read so lookups are not held up, see pacer, and no other compaction
+// starts meanwhile.
+func (im *IndexManager) compactInto(inputs []*SSTable, level int) error {
+	low, high := minKey(inputs), maxKey(inputs)
+	targets, low, high := overlappingTables(im.levelTables(level), low, high)
+
+	bottom := true
+	for _, table := range im.levels {
+		if table.metadata.Level > level && table.metadata.MinKey <= high && low <= table.metadata.MaxKey {
+			bottom = false
+			break
+		}
+	}
+
+	merging := append(slices.Clone(inputs), targets...)
+	// the tables stay open if they are dropped, or the index closed, while
+	// im.mu is released
+	for _, table := range merging {
+		table.acquire()
+	}
+	defer releaseTables(merging)
+
+	im.compacting = true
+	outputs, err := im.writeLevelTables(merging, level, !bottom)
+	im.compacting = false
+	if err != nil {
+		return err
+	}
+
+	replaced := make(map[*SSTable]struct{}, len(merging))
+	for _, table := range merging {
+		replaced[table] = struct{}{}
+	}
+	// tables quarantined or dropped meanwhile already lost the index's reference
+	for _, table := range slices.Concat(im.sstables, im.levels) {
+		if _, ok := replaced[table]; ok {
+			table.retire()
+		}
+	}
+	im.sstables = slices.DeleteFunc(im.sstables, func(table *SSTable) bool { _, ok := replaced[table]; return ok })
+	im.levels = slices.DeleteFunc(im.levels, func(table *SSTable) bool { _, ok := replaced[table]; return ok })
+	im.levels = append(im.levels, outputs...)
+	im.sortTablesBySerial()
+
+	if im.debug.Load() {
+		log.Printf("IndexManager compacted %d tables into %d tables of level %d", len(merging), len(outputs), level)
+	}
+
+	// compactions are rare enough to check the new tables' filters on the way
+	im.tightenFilters(outputs)
+	return nil
+}
+
+// writeLevelTables merges the tables, newest first, into new tables of the
+// given level holding levelTableSize pairs each. im.mu is released while
+// yielding to user operations before every block read and table write, and
+// the merge stops once the index is closed. Tables written before a failure
+// are removed.
+func (im *IndexManager) writeLevelTables(tables []*SSTable, level int, keepDeleted bool) ([]*SSTable, error) {
+	pacer := &pacer{ctx: im.closed, io: im.io, delay: im.config.BackgroundIOMaxDelay, lock: &im.mu}
+	sources := []pairSource{}
+	for _, table := range tables {
+		if err := pacer.block(); err != nil {
+			return nil, fmt.Errorf("IndexManager stopped compacting into level %d: %w", level, err)
+		}
+		source, err := newTableSource(table, "")
+		if err != nil {
+			return nil, fmt.Errorf("IndexManager can not read table %q to compact it: %v", table.metadata.Path, err)
+		}
+		sources = append(sources, source)
+	}
+	it := newMergeIterator(sources)
+	it.keepDeleted = keepDeleted
+	it.pace(pacer)
+
+	outputs := []*SSTable{}
+	fail := func(err error) ([]*SSTable, error) {
+		for _, table := range outputs {
+			table.retire()
+		}
+		return nil, err
+	}
+
+	size := im.levelTableSize()
+	pairs := getPairs(size)
+	defer func() { putPairs(pairs) }()
+	for {
+		pair, ok, err := it.Next()
+		if err != nil {
+			return fail(fmt.Errorf("IndexManager can not merge tables into level %d: %v", level, err))
+		}
+		if ok && !im.purged.erased(pair) {
+			pairs = append(pairs, pair)
+		}
+
+		if len(pairs) > 0 && (len(pairs) >= size || !ok) {
+			if err := pacer.block(); err != nil {
+				return fail(fmt.Errorf("IndexManager stopped compacting into level %d: %w", level, err))
+			}
+			table, err := im.writeLevelTable(level, pairs)
+			if err != nil {
+				return fail(err)
+			}
+			outputs = append(outputs, table)
+			pairs = pairs[:0]
+		}
+		if !ok {
+			break
+		}
+	}
+	return outputs, nil
+}
+
+// writeLevelTable writes the sorted pairs to a new table of the given level.
+func (im *IndexManager) writeLevelTable(level int, pairs []KVPair) (*SSTable, error) {
+	metadata := TableMetadata{
+		Path:    filepath.Join(im.config.Homepath, im.levelTableName(level, im.lvlSerial)),
+		IsLevel: true,
+		Level:   level,
+		Format:  currentTableFormat,
+		Size:    uint32(len(pairs)),
+		Serial:  uint32(im.lvlSerial),
+		MinKey:  pairs[0].Key,
+		MaxKey:  pairs[len(pairs)-1].Key,
+	}
+
+	if im.config.Paranoid {
+		if err := checkNewSerial(metadata, im.levels); err != nil {
+			return nil, err
+		}
+	}
+
+	table, err := im.writeTable(metadata, pairs)
+	if err != nil {
+		return nil, fmt.Errorf("IndexManager failed to create a table of level %d: %v", level, err)
+	}
+	im.writeAmp.written(pairs, false)
+	im.lvlSerial++
+	return table, nil
+}
+
+// overlappingTables returns the tables overlapping [low, high], extending the
+// range with theirs until no other table overlaps it, and the extended range.
+func overlappingTables(tables []*SSTable, low, high string) ([]*SSTable, string, string) {
+	taken := make([]bool, len(tables))
+	for extended := true; extended; {
+		extended = false
+		for i, table := range tables {
+			if !taken[i] && table.metadata.MinKey <= high && low <= table.metadata.MaxKey {
+				taken[i], extended = true, true
+				low, high = min(low, table.metadata.MinKey), max(high, table.metadata.MaxKey)
+			}
+		}
+	}
+
+	overlapping := []*SSTable{}
+	for i, table := range tables {
+		if taken[i] {
+			overlapping = append(overlapping, table)
+		}
+	}
+	return overlapping, low, high
+}
+
+func minKey(tables []*SSTable) string {
+	key := tables[0].metadata.MinKey
+	for _, table := range tables[1:] {
+		key = min(key, table.metadata.MinKey)
+	}
+	return key
+}
+
+func maxKey(tables []*SSTable) string {
+	key := tables[0].metadata.MaxKey
+	for _, table := range tables[1:] {
+		key = max(key, table.metadata.MaxKey)
+	}
+	return key
+}
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// compactionScheduler decides when heavy compactions may run. Inside the
+// configured off-peak windows, or if none are configured, they always may,
+// outside them at most maxConcurrent run at once and the others are deferred
+// to a later flush. Small table merges are cheap and never deferred.
+type compactionScheduler struct {
+	windows       []shared.TimeWindow
+	maxConcurrent int32
+	active        atomic.Int32
+	deferred      atomic.Uint64
+	now           func() time.Time
+}
+
+func newCompactionScheduler(config *shared.EngineConfig) (*compactionScheduler, error) {
+	s := &compactionScheduler{maxConcurrent: int32(config.CompactionMaxConcurrent), now: time.Now}
+	for _, spec := range config.CompactionWindows {
+		window, err := shared.ParseTimeWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		s.windows = append(s.windows, window)
+	}
+	return s, nil
+}
+
+// offPeak reports whether heavy compactions are currently preferred.
+func (s *compactionScheduler) offPeak() bool {
+	if len(s.windows) == 0 {
+		return true
+	}
+
+	now := s.now()
+	for _, window := range s.windows {
+		if window.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// begin reports whether a heavy compaction may start now, if so end must be
+// called once it is done.
+func (s *compactionScheduler) begin() bool {
+	if s.offPeak() {
+		s.active.Add(1)
+		return true
+	}
+
+	for {
+		active := s.active.Load()
+		if active >= s.maxConcurrent {
+			s.deferred.Add(1)
+			return false
+		}
+		if s.active.CompareAndSwap(active, active+1) {
+			return true
+		}
+	}
+}
+
+func (s *compactionScheduler) end() {
+	s.active.Add(-1)
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestLeveledCompaction(t *testing.T) {
+	config := shared.NewEngineConfig().WithMemtableSizeThreshold(20).WithCompactionThreshold(2).WithSmallTableMergeSize(0).WithLevels(60, 3, 20)
+	config.Homepath = t.TempDir()
+
+	im, err := NewIndexManager(config, nopWAL{}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewIndexManager() error: %v", err)
+	}
+
+	// keys are overwritten and deleted across many flushes
+	latest := map[string]uint64{}
+	for i := range 2000 {
+		key := fmt.Sprintf("key%04d", i*7919%600)
+		seq := uint64(i + 1)
+		if i%5 == 4 {
+			im.Delete(key, seq)
+			delete(latest, key)
+		} else {
+			im.Set(KVPair{Key: key, Value: Position{Offset: seq, Size: 1}, Seq: seq})
+			latest[key] = seq
+		}
+		if i%20 == 19 {
+			if err := im.Flush(); err != nil {
+				t.Fatalf("Flush() error: %v", err)
+			}
+		}
+	}
+
+	check := func(when string) {
+		groups := im.levelGroups()
+		if len(groups) < 3 {
+			t.Fatalf("%s: tables reach level %d, want at least level 2", when, len(groups)-1)
+		}
+		for level, tables := range groups[1:] {
+			if overlapping(tables) {
+				t.Errorf("%s: tables of level %d overlap", when, level+1)
+			}
+			pairs := uint64(0)
+			for _, table := range tables {
+				pairs += uint64(table.metadata.Size)
+			}
+			if level+1 < maxLevels && pairs > im.levelTarget(level+1) {
+				t.Errorf("%s: level %d holds %d pairs, over its target of %d", when, level+1, pairs, im.levelTarget(level+1))
+			}
+		}
+
+		for i := range 600 {
+			key := fmt.Sprintf("key%04d", i)
+			position, err := im.Get(key)
+			seq, live := latest[key]
+			var notFound *shared.ErrKeyNotFound
+			switch {
+			case live && (err != nil || position.Offset != seq):
+				t.Fatalf("%s: Get(%s) = %v, %v, want offset %d", when, key, position, err, seq)
+			case !live && !errors.As(err, &notFound):
+				t.Fatalf("%s: Get(%s) = %v, %v, want it deleted", when, key, position, err)
+			}
+		}
+	}
+	check("after compacting")
+	im.Close()
+
+	// the levels of the tables are read back from their names
+	im, err = NewIndexManager(config, nopWAL{}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewIndexManager() error: %v", err)
+	}
+	defer im.Close()
+	check("after reopening")
+
+	if level := im.levelOf("lvl_12"); level != 1 {
+		t.Errorf("levelOf(lvl_12) = %d, want level files without a level in level 1", level)
+	}
+}
+
+func TestPartialCompaction(t *testing.T) {
+	config := shared.NewEngineConfig().WithMemtableSizeThreshold(20).WithCompactionThreshold(2).WithSmallTableMergeSize(0).WithLevels(60, 3, 20)
+	config.Homepath = t.TempDir()
+
+	im, err := NewIndexManager(config, nopWAL{}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewIndexManager() error: %v", err)
+	}
+
+	// keys written in order leave every table disjoint from the older ones
+	for i := range 1000 {
+		seq := uint64(i + 1)
+		im.Set(KVPair{Key: fmt.Sprintf("key%04d", i), Value: Position{Offset: seq, Size: 1}, Seq: seq})
+		if i%20 == 19 {
+			if err := im.Flush(); err != nil {
+				t.Fatalf("Flush() error: %v", err)
+			}
+		}
+	}
+	if moves := im.moves.Load(); moves == 0 {
+		t.Errorf("no table was moved down without being rewritten")
+	}
+
+	check := func(when string) {
+		for level, tables := range im.levelGroups()[1:] {
+			if overlapping(tables) {
+				t.Errorf("%s: tables of level %d overlap", when, level+1)
+			}
+		}
+		for i := range 1000 {
+			key := fmt.Sprintf("key%04d", i)
+			if position, err := im.Get(key); err != nil || position.Offset != uint64(i+1) {
+				t.Fatalf("%s: Get(%s) = %v, %v, want offset %d", when, key, position, err, i+1)
+			}
+		}
+	}
+	check("after compacting")
+	im.Close()
+
+	im, err = NewIndexManager(config, nopWAL{}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewIndexManager() error: %v", err)
+	}
+	defer im.Close()
+	check("after reopening")
+
+	// only the oldest SSTable and the ones overlapping it leave level 0
+	flush := func(keys ...string) {
+		for _, key := range keys {
+			im.Set(KVPair{Key: key, Value: Position{Offset: 1, Size: 1}, Seq: 2000})
+		}
+		if err := im.Flush(); err != nil {
+			t.Fatalf("Flush() error: %v", err)
+		}
+	}
+	flush("x1", "x5")
+	flush("y1", "y5")
+	flush("x3")
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+	if len(im.sstables) != 1 || im.sstables[0].metadata.MinKey != "y1" {
+		t.Errorf("SSTables left = %d, want only the one not overlapping the oldest", len(im.sstables))
+	}
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// Blocks of a compressed table end with a byte telling how they are stored,
+// a block the codec does not shrink is stored as is.
+const (
+	blockStoredPlain      = 0
+	blockStoredCompressed = 1
+
+	// largest stored block, a block kept as is, its trailing byte and checksum
+	maxStoredBlockSize = maxEncodedBlockSize + 1 + shared.UintSize
+)
+
+// compressBlock returns the stored form of an encoded block in a table
+// compressed with codec, zstd matches may refer to the table's dictionary.
+func compressBlock(block []byte, codec shared.Compression, dict []byte) []byte {
+	var compressed []byte
+	switch codec {
+	case shared.CompressionSnappy:
+		compressed = snappyEncode(nil, block)
+	case shared.CompressionLZ4:
+		compressed = lz4Encode(nil, block)
+	case shared.CompressionZstd:
+		compressed = zstdEncode(nil, block, dict)
+	}
+
+	if len(compressed) >= len(block) {
+		return append(block, blockStoredPlain)
+	}
+	return append(compressed, blockStoredCompressed)
+}
+
+// decompressBlock returns the encoded block of a stored block, as written
+// by compressBlock.
+func decompressBlock(stored []byte, codec shared.Compression, dict []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, fmt.Errorf("stored block is empty")
+	}
+
+	data, kind := stored[:len(stored)-1], stored[len(stored)-1]
+	switch kind {
+	case blockStoredPlain:
+		return data, nil
+	case blockStoredCompressed:
+	default:
+		return nil, fmt.Errorf("stored block has an unknown kind %d", kind)
+	}
+
+	switch codec {
+	case shared.CompressionSnappy:
+		return snappyDecode(data, maxEncodedBlockSize)
+	case shared.CompressionLZ4:
+		return lz4Decode(data, maxEncodedBlockSize)
+	case shared.CompressionZstd:
+		return zstdDecode(data, dict, maxEncodedBlockSize)
+	}
+	return nil, fmt.Errorf("unknown block compression %d", codec)
+}
+
+// blockData returns the encoded block of the block read from the table at
+// offset. A block not matching its checksum is read from a checkpoint holding
+// an intact copy of the table, an ErrCorruption if there is none.
+func (s *SSTable) blockData(stored []byte, offset uint32) ([]byte, error) {
+	data, ok := checkBlock(stored, s.metadata.Format)
+	if !ok {
+		repaired, found := s.repair.block(s, offset, len(stored))
+		if !found {
+			return nil, &shared.ErrCorruption{Path: s.metadata.Path, Offset: int64(offset), Reason: "block checksum mismatch"}
+		}
+		data, _ = checkBlock(repaired, s.metadata.Format)
+	}
+	if s.metadata.Compression == shared.CompressionNone {
+		return data, nil
+	}
+	return decompressBlock(data, s.metadata.Compression, s.dictionary)
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestCodecs(t *testing.T) {
+	random := make([]byte, 100_000)
+	rand.New(rand.NewSource(1)).Read(random)
+	// a repeat further back than 16-bit offsets reach
+	far := append(bytes.Clone(random), random[:1000]...)
+
+	inputs := map[string][]byte{
+		"empty":      {},
+		"short":      []byte("abc"),
+		"run":        bytes.Repeat([]byte{'x'}, 5000),
+		"structured": bytes.Repeat([]byte("user:1234:profile|"), 300),
+		"random":     random,
+		"far":        far,
+	}
+
+	codecs := map[string]struct {
+		encode func(dst, src []byte) []byte
+		decode func(src []byte, maxSize int) ([]byte, error)
+	}{
+		"snappy": {snappyEncode, snappyDecode},
+		"lz4":    {lz4Encode, lz4Decode},
+		"zstd": {
+			func(dst, src []byte) []byte { return zstdEncode(dst, src, nil) },
+			func(src []byte, maxSize int) ([]byte, error) { return zstdDecode(src, nil, maxSize) },
+		},
+	}
+
+	for codecName, codec := range codecs {
+		for name, input := range inputs {
+			encoded := codec.encode(nil, input)
+			decoded, err := codec.decode(encoded, len(input))
+			if err != nil || !bytes.Equal(decoded, input) {
+				t.Errorf("%s: %s did not round trip: %v", codecName, name, err)
+			}
+			if name == "run" && len(encoded) > len(input)/10 {
+				t.Errorf("%s: a run of %d bytes took %d bytes", codecName, len(input), len(encoded))
+			}
+			if len(input) > 0 {
+				if _, err := codec.decode(encoded, len(input)-1); err == nil {
+					t.Errorf("%s: %s decoded over its size limit", codecName, name)
+				}
+				if _, err := codec.decode(encoded[:len(encoded)-1], len(input)); err == nil {
+					t.Errorf("%s: %s decoded once cut short", codecName, name)
+				}
+			}
+		}
+	}
+}
+
+func TestCompressedTables(t *testing.T) {
+	for _, codec := range []shared.Compression{shared.CompressionSnappy, shared.CompressionLZ4, shared.CompressionZstd} {
+		dir := t.TempDir()
+		config := *shared.NewEngineConfig().WithSmallTableMergeSize(0).WithBlockCompression(codec)
+		engine, err := NewEngine(dir, config)
+		if err != nil {
+			t.Fatalf("NewEngine() error: %v", err)
+		}
+		for i := range 1000 {
+			engine.Set(fmt.Sprintf("user:%06d:profile", i), []byte("value"))
+		}
+		engine.indexManager.Flush()
+
+		table := engine.indexManager.sstables[0]
+		if table.metadata.Compression != codec {
+			t.Errorf("codec %d: table compression = %d", codec, table.metadata.Compression)
+		}
+		engine.Close()
+
+		// tables keep their codec whatever the engine is configured with
+		engine, err = NewEngine(dir, *shared.NewEngineConfig().WithSmallTableMergeSize(0))
+		if err != nil {
+			t.Fatalf("NewEngine() error: %v", err)
+		}
+		if value, err := engine.Get("user:000500:profile"); err != nil || string(value) != "value" {
+			t.Errorf("codec %d: Get() = %q, %v", codec, value, err)
+		}
+		if keys, err := engine.Scan("user:*"); err != nil || len(keys) != 1000 {
+			t.Errorf("codec %d: Scan() = %d keys, %v", codec, len(keys), err)
+		}
+		iterator := engine.NewIterator()
+		count := 0
+		for iterator.Next() {
+			count++
+		}
+		iterator.Close()
+		if count != 1000 {
+			t.Errorf("codec %d: iterated %d pairs, want 1000", codec, count)
+		}
+		engine.Close()
+	}
+}
+
+func TestZstdDictionary(t *testing.T) {
+	dict := []byte("user:000000:profile|")
+	input := []byte("user:123456:profile|")
+	plain, withDict := zstdEncode(nil, input, nil), zstdEncode(nil, input, dict)
+	if len(withDict) >= len(plain) {
+		t.Errorf("dictionary did not shrink the frame: %d bytes, %d without", len(withDict), len(plain))
+	}
+	if decoded, err := zstdDecode(withDict, dict, len(input)); err != nil || !bytes.Equal(decoded, input) {
+		t.Errorf("zstdDecode() = %q, %v, want %q", decoded, err, input)
+	}
+	if decoded, err := zstdDecode(withDict, nil, len(input)); err == nil && bytes.Equal(decoded, input) {
+		t.Errorf("frame referring to the dictionary decoded without it")
+	}
+
+	samples := [][]byte{}
+	for i := range 500 {
+		samples = append(samples, []byte(fmt.Sprintf("tenant:%03d:order:%06d:status", i%7, i*13)))
+	}
+	trained := trainDictionary(samples, 256)
+	if len(trained) == 0 || len(trained) > 256 || !bytes.Contains(trained, []byte(":order:")) {
+		t.Errorf("trainDictionary() = %q", trained)
+	}
+
+	// levels train their dictionary, kept across restarts
+	dir := t.TempDir()
+	config := *shared.NewEngineConfig().WithSmallTableMergeSize(0).WithBlockSize(256).WithBlockCompression(shared.CompressionZstd).WithBlockDictionarySize(1024)
+	engine, err := NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	for i := range 2000 {
+		engine.Set(fmt.Sprintf("tenant:%03d:order:%06d:status", i%7, i*13), []byte("value"))
+	}
+	engine.indexManager.Flush()
+	engine.indexManager.mu.Lock()
+	err = engine.indexManager.createLevel()
+	engine.indexManager.mu.Unlock()
+	if err != nil {
+		t.Fatalf("createLevel() error: %v", err)
+	}
+	if level := engine.indexManager.levels[0]; len(level.dictionary) == 0 {
+		t.Errorf("level was written without a dictionary")
+	}
+	engine.Close()
+
+	engine, err = NewEngine(dir, *shared.NewEngineConfig().WithSmallTableMergeSize(0))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+	if len(engine.indexManager.levels[0].dictionary) == 0 {
+		t.Errorf("level dictionary was not loaded")
+	}
+	if value, err := engine.Get("tenant:003:order:000130:status"); err != nil || string(value) != "value" {
+		t.Errorf("Get() = %q, %v", value, err)
+	}
+	if keys, err := engine.Scan("tenant:*"); err != nil || len(keys) != 2000 {
+		t.Errorf("Scan() = %d keys, %v", len(keys), err)
+	}
+}
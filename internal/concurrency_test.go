@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// TestConcurrentUse exercises the engine from many goroutines at once, writes
+// flushing and compacting under concurrent reads and scans, then closing
+// while they run. It is meant to be run with -race.
+func TestConcurrentUse(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithMemtableSizeThreshold(50).WithSmallTableMergeSize(0))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	const workers, keys = 4, 200
+	var (
+		wg      sync.WaitGroup
+		stop    atomic.Bool
+		writers sync.WaitGroup
+	)
+	run := func(group *sync.WaitGroup, fn func(i int)) {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for i := 0; !stop.Load(); i++ {
+				fn(i)
+			}
+		}()
+	}
+
+	for w := range workers {
+		writers.Add(1)
+		go func() {
+			defer writers.Done()
+			for i := range keys {
+				key := fmt.Sprintf("w%d:%04d", w, i)
+				if err := engine.Set(key, []byte(key)); err != nil {
+					t.Errorf("Set(%q) error: %v", key, err)
+					return
+				}
+				if i%5 == 0 {
+					if err := engine.Delete(key); err != nil {
+						t.Errorf("Delete(%q) error: %v", key, err)
+						return
+					}
+				}
+			}
+		}()
+
+		run(&wg, func(i int) {
+			key := fmt.Sprintf("w%d:%04d", w, i%keys)
+			if value, err := engine.Get(key); err == nil && string(value) != key {
+				t.Errorf("Get(%q) = %q", key, value)
+			}
+		})
+	}
+
+	run(&wg, func(int) { engine.Scan(fmt.Sprintf("w%d:*", 1)) })
+	run(&wg, func(int) {
+		engine.Range("w0:", "w2:", func(key string, value []byte) (bool, error) { return false, nil })
+	})
+	run(&wg, func(int) {
+		iterator := engine.NewIterator()
+		for iterator.Next() {
+		}
+		iterator.Close()
+	})
+	run(&wg, func(int) {
+		engine.mu.Lock()
+		engine.flush()
+		engine.mu.Unlock()
+		time.Sleep(time.Millisecond)
+	})
+	run(&wg, func(int) {
+		engine.indexManager.mu.Lock()
+		engine.indexManager.createLevel()
+		engine.indexManager.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+	})
+	run(&wg, func(int) { engine.Stats() })
+	run(&wg, func(int) { engine.ApproximateStats("w1:") })
+
+	writers.Add(1)
+	go func() {
+		defer writers.Done()
+		for i := range keys / 10 {
+			batch := engine.WriteBatch()
+			for j := range 10 {
+				key := fmt.Sprintf("b:%04d", i*10+j)
+				batch.Set(key, []byte(key))
+			}
+			if err := batch.Commit(); err != nil {
+				t.Errorf("Commit() error: %v", err)
+				return
+			}
+		}
+	}()
+
+	writers.Wait()
+
+	// every surviving key is readable once the writers are done
+	for w := range workers {
+		for i := range keys {
+			key := fmt.Sprintf("w%d:%04d", w, i)
+			value, err := engine.Get(key)
+			if i%5 == 0 {
+				if err == nil {
+					t.Errorf("Get(%q) = %q, want it deleted", key, value)
+				}
+			} else if err != nil || string(value) != key {
+				t.Errorf("Get(%q) = %q, %v", key, value, err)
+			}
+		}
+	}
+
+	if value, err := engine.Get("b:0123"); err != nil || string(value) != "b:0123" {
+		t.Errorf("Get(b:0123) = %q, %v", value, err)
+	}
+
+	// closing under readers must not race or panic, their errors are expected
+	engine.Close()
+	stop.Store(true)
+	wg.Wait()
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// CheckConsistency verifies that every position referenced by the SSTables and
+// levels lies within the data file, and reads back up to spotChecks evenly spread
+// values of every table. It returns the first inconsistency found.
+func (e *Engine) CheckConsistency(spotChecks int) error {
+	info, err := os.Stat(filepath.Join(e.Config.Homepath, DataFileName))
+	if err != nil {
+		return fmt.Errorf("engine can not stat the data file: %v", err)
+	}
+	dataSize := info.Size()
+
+	snapshot := e.indexManager.snapshot()
+	defer snapshot.Release()
+
+	for _, table := range snapshot.tables {
+		pairs, err := table.Items()
+		if err != nil {
+			return fmt.Errorf("engine can not read pairs of table %d: %v", table.metadata.Serial, err)
+		}
+
+		live := []KVPair{}
+		for _, pair := range pairs {
+			if pair.Value.Size == 0 {
+				continue // deleted key
+			}
+
+			// merge operands are checked as the records holding them
+			pair.Value = pair.Value.stored()
+			if pair.Value.colocated() {
+				if err := e.indexManager.values.check(pair.Value); err != nil {
+					return fmt.Errorf("engine found the value of key %q of table %d out of its value file: %v", pair.Key, table.metadata.Serial, err)
+				}
+				live = append(live, pair)
+				continue
+			}
+			if int64(pair.Value.Offset)+int64(pair.Value.Size) > dataSize {
+				return &shared.ErrPositionOutOfRange{
+					Table:    table.metadata.Serial,
+					Key:      pair.Key,
+					Offset:   pair.Value.Offset,
+					Size:     pair.Value.Size,
+					DataSize: dataSize,
+				}
+			}
+			live = append(live, pair)
+		}
+
+		if spotChecks <= 0 || len(live) == 0 {
+			continue
+		}
+
+		step := max(len(live)/spotChecks, 1)
+		for i := 0; i < len(live); i += step {
+			if _, err := e.storageManager.Retrieve(live[i].Value); err != nil {
+				return fmt.Errorf("engine can not read value of key %q from table %d: %v", live[i].Key, table.metadata.Serial, err)
+			}
+		}
+	}
+
+	return nil
+}
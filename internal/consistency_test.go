@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestCheckConsistency(t *testing.T) {
+	dir := t.TempDir()
+	engine, err := NewEngine(dir)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	for i := range 20 {
+		engine.Set(fmt.Sprintf("key%02d", i), []byte("value"))
+	}
+	engine.indexManager.Flush()
+	if err := engine.CheckConsistency(100); err != nil {
+		t.Errorf("CheckConsistency() error: %v on an intact database", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	// the data file loses the values of the last keys
+	data := filepath.Join(dir, DataFileName)
+	info, err := os.Stat(data)
+	if err != nil {
+		t.Fatalf("Stat() error: %v", err)
+	}
+	if err := os.Truncate(data, info.Size()/2); err != nil {
+		t.Fatalf("Truncate() error: %v", err)
+	}
+
+	var outOfRange *shared.ErrPositionOutOfRange
+	if _, err := NewEngine(dir, *shared.NewEngineConfig().WithVerifyOnOpen(true, 0).WithReadOnly(true)); !errors.As(err, &outOfRange) {
+		t.Errorf("NewEngine() error = %v with a truncated data file, want ErrPositionOutOfRange", err)
+	} else if outOfRange.DataSize != info.Size()/2 {
+		t.Errorf("ErrPositionOutOfRange = %+v, want the truncated size %d", outOfRange, info.Size()/2)
+	}
+
+	engine, err = NewEngine(dir, *shared.NewEngineConfig().WithReadOnly(true))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v without the open check", err)
+	}
+	defer engine.Close()
+	if err := engine.CheckConsistency(0); !errors.As(err, &outOfRange) {
+		t.Errorf("CheckConsistency() error = %v with a truncated data file, want ErrPositionOutOfRange", err)
+	}
+}
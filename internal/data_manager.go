@@ -1,49 +1,161 @@
 package internal
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"log"
 	"os"
+	"sync"
+	"sync/atomic"
 
 	"github.com/hasssanezzz/goldb/shared"
 )
 
+// dataFileMagic starts data files storing values in self-describing records:
+//
+//	<key length uint32><value length uint32><key><value><crc32c uint32>
+//
+// where the checksum covers everything before it. Positions point at the value
+// so reads do not depend on the record layout. Data files created before
+// records were introduced hold raw values and keep being written that way.
+var dataFileMagic = []byte("GOLDBDAT\x01")
+
+const dataRecordHeaderSize = 2 * shared.UintSize
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// DataRecord is a value read back from the data file with the key it was written for.
+type DataRecord struct {
+	Key      string
+	Position Position
+	Value    []byte
+}
+
 type DiskDataManager struct {
-	writer   WriteSeekCloser
-	reader   io.ReadSeekCloser
+	writer   *os.File
+	reader   *os.File
 	filename string
+	readOnly bool
+	records  bool // The file stores records, false for files of raw values.
+	retry    *retrier
+	size     atomic.Int64 // Known size of the file, positions past it are refreshed before being read.
+
+	preallocate   int64          // Bytes reserved ahead of the end of the file, zero disables it.
+	reserved      atomic.Int64   // End of the disk space reserved for the file.
+	preallocating atomic.Bool    // A reservation is running in the background.
+	preallocated  atomic.Uint64  // Bytes reserved ahead of the appends since open.
+	background    sync.WaitGroup // Running reservations, waited for by Close.
 }
 
-func NewDiskDataManager(filename string) (DataManager, error) {
-	sm := &DiskDataManager{filename: filename}
+// NewDiskDataManager opens the data file, preallocate bytes of disk are kept
+// reserved ahead of its end in the background, see preallocateAhead.
+func NewDiskDataManager(filename string, readOnly bool, retry *retrier, preallocate int64) (DataManager, error) {
+	sm := &DiskDataManager{filename: filename, readOnly: readOnly, retry: retry, preallocate: preallocate}
 	return sm, sm.Open()
 }
 
 func (s *DiskDataManager) Open() error {
-	wfile, err := os.OpenFile(s.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("storage manager can not open file for appending %q: %v", s.filename, err)
+	if !s.readOnly {
+		wfile, err := os.OpenFile(s.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("storage manager can not open file for appending %q: %v", s.filename, err)
+		}
+		s.writer = wfile
 	}
 	rfile, err := os.Open(s.filename)
 	if err != nil {
 		return fmt.Errorf("storage manager can not open file for reading %q: %v", s.filename, err)
 	}
-	s.writer = wfile
 	s.reader = rfile
+
+	info, err := rfile.Stat()
+	if err != nil {
+		return fmt.Errorf("storage manager can not stat %q: %v", s.filename, err)
+	}
+	s.size.Store(info.Size())
+
+	// new data files store records
+	if info.Size() == 0 {
+		s.records = true
+		if s.writer != nil {
+			if _, err := s.writer.Write(dataFileMagic); err != nil {
+				return fmt.Errorf("storage manager can not write the header of %q: %v", s.filename, err)
+			}
+			s.size.Store(int64(len(dataFileMagic)))
+		}
+		return nil
+	}
+
+	s.records, err = hasDataFileMagic(rfile)
+	if err != nil {
+		return fmt.Errorf("storage manager can not read the header of %q: %v", s.filename, err)
+	}
 	return nil
 }
 
-func (s *DiskDataManager) Store(value []byte) (Position, error) {
-	offset, err := s.writer.Seek(0, io.SeekEnd)
-	if err != nil {
-		return Position{}, fmt.Errorf("storage manager can not seek to end: %v", err)
+func (s *DiskDataManager) Store(key string, value []byte) (Position, error) {
+	if s.readOnly {
+		return Position{}, &shared.ErrReadOnly{Path: s.filename}
+	}
+
+	// the highest bit of a position's size flags merge operands
+	if uint64(len(value)) >= mergeOperandFlag {
+		return Position{}, fmt.Errorf("storage manager can not store a value of %d bytes, at most %d fit in a position", len(value), mergeOperandFlag-1)
 	}
 
-	_, err = s.writer.Write(value)
+	data, valueOffset := value, int64(0)
+	if s.records {
+		data = encodeDataRecord(key, value)
+		valueOffset = int64(dataRecordHeaderSize + len(key))
+	}
+
+	// a retried write starts over at the new end of the file,
+	// bytes left by a failed partial write are never referenced
+	var offset int64
+	err := s.retry.do(func() (err error) {
+		offset, err = s.writer.Seek(0, io.SeekEnd)
+		if err != nil {
+			return err
+		}
+		_, err = s.writer.Write(data)
+		return err
+	})
 	if err != nil {
 		return Position{}, fmt.Errorf("storage manager can not write value %q: %v", value, err)
 	}
-	return Position{uint32(offset), uint32(len(value))}, err
+	s.grow(offset + int64(len(data)))
+	s.preallocateAhead(offset + int64(len(data)))
+	return Position{uint64(offset + valueOffset), uint32(len(value))}, err
+}
+
+// preallocateAhead reserves the next preallocate bytes of disk in the
+// background once the appends reach the second half of the reserved space,
+// so the file is extended by large contiguous allocations instead of one per
+// write. A platform or filesystem without preallocation turns it off.
+func (s *DiskDataManager) preallocateAhead(end int64) {
+	if s.preallocate <= 0 || end+s.preallocate/2 < s.reserved.Load() || !s.preallocating.CompareAndSwap(false, true) {
+		return
+	}
+
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+
+		// a failed reservation leaves preallocating set, turning it off
+		if err := preallocateFile(s.writer, end, s.preallocate); err != nil {
+			log.Printf("storage manager: preallocation of %q turned off: %v", s.filename, err)
+			return
+		}
+		previous := max(s.reserved.Load(), end)
+		s.reserved.Store(end + s.preallocate)
+		s.preallocated.Add(uint64(end + s.preallocate - previous))
+		s.preallocating.Store(false)
+	}()
 }
 
 // Retrieve gets a value based on node position
@@ -52,28 +164,246 @@ func (s *DiskDataManager) Retrieve(position Position) ([]byte, error) {
 		return nil, &shared.ErrKeyNotFound{}
 	}
 
-	_, err := s.reader.Seek(int64(position.Offset), io.SeekStart)
-	if err != nil {
-		return []byte{}, fmt.Errorf("storage manager can not read (%d, %d): %v", position.Offset, position.Size, err)
+	// a position from a corrupt index must not size the read buffer
+	if err := s.checkPosition(position); err != nil {
+		return nil, err
 	}
+
+	// positional reads let concurrent lookups share the file
 	buf := make([]byte, position.Size)
-	_, err = s.reader.Read(buf)
+	err := s.retry.do(func() error {
+		_, err := s.reader.ReadAt(buf, int64(position.Offset))
+		return err
+	})
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("storage manager can not read (%d, %d): %v", position.Offset, position.Size, err)
 	}
 	return buf, nil
 }
 
+// checkPosition verifies the position lies within the file, the size is
+// refreshed first in case another process appended to it.
+func (s *DiskDataManager) checkPosition(position Position) error {
+	end := int64(position.Offset) + int64(position.Size)
+	if end <= s.size.Load() {
+		return nil
+	}
+
+	info, err := s.reader.Stat()
+	if err != nil {
+		return fmt.Errorf("storage manager can not stat %q: %v", s.filename, err)
+	}
+	s.grow(info.Size())
+	if end > info.Size() {
+		return fmt.Errorf("storage manager can not read (%d, %d) past the end of %q at %d", position.Offset, position.Size, s.filename, info.Size())
+	}
+	return nil
+}
+
+// grow raises the known size of the file to size.
+func (s *DiskDataManager) grow(size int64) {
+	for {
+		current := s.size.Load()
+		if size <= current || s.size.CompareAndSwap(current, size) {
+			return
+		}
+	}
+}
+
+// Erase overwrites the value at position, and the key of its record, with
+// zeros so it can not be recovered from the data file. The record checksum is
+// rewritten so the file still scans cleanly. The change is synced before
+// returning.
+func (s *DiskDataManager) Erase(key string, position Position) error {
+	if s.readOnly {
+		return &shared.ErrReadOnly{Path: s.filename}
+	}
+	if position.Size == 0 {
+		return nil
+	}
+
+	if err := s.checkPosition(position); err != nil {
+		return err
+	}
+
+	start, size := int64(position.Offset), int(position.Size)
+	var record []byte
+	if s.records {
+		start -= int64(dataRecordHeaderSize + len(key))
+		record = make([]byte, dataRecordHeaderSize+len(key)+size+shared.UintSize)
+		if _, err := s.reader.ReadAt(record, start); err != nil {
+			return fmt.Errorf("storage manager can not read the record of %q: %v", key, err)
+		}
+		if binary.LittleEndian.Uint32(record) != uint32(len(key)) || binary.LittleEndian.Uint32(record[shared.UintSize:]) != uint32(size) ||
+			string(record[dataRecordHeaderSize:dataRecordHeaderSize+len(key)]) != key {
+			// a deduplicated value sits in the record of the key that first wrote it
+			var err error
+			record, start, err = s.findRecord(position)
+			if err != nil {
+				return fmt.Errorf("storage manager found no record of %q at %d: %v", key, position.Offset, err)
+			}
+		}
+
+		clear(record[dataRecordHeaderSize : len(record)-shared.UintSize])
+		checksum := crc32.Checksum(record[:len(record)-shared.UintSize], crcTable)
+		binary.LittleEndian.PutUint32(record[len(record)-shared.UintSize:], checksum)
+	} else {
+		record = make([]byte, size)
+	}
+
+	// the appending writer can not write at an offset
+	file, err := os.OpenFile(s.filename, os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("storage manager can not open %q for erasing: %v", s.filename, err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteAt(record, start); err != nil {
+		return fmt.Errorf("storage manager can not erase the value of %q: %v", key, err)
+	}
+	return file.Sync()
+}
+
+// findRecord returns the record holding the value at position, and where it
+// starts, without knowing its key. Every key length is tried until the header
+// and checksum match.
+func (s *DiskDataManager) findRecord(position Position) ([]byte, int64, error) {
+	end := int64(position.Offset) + int64(position.Size) + shared.UintSize
+	start := max(int64(len(dataFileMagic)), int64(position.Offset)-dataRecordHeaderSize-shared.KeySize)
+	if start > int64(position.Offset) {
+		return nil, 0, fmt.Errorf("position is inside the file header")
+	}
+
+	window := make([]byte, end-start)
+	if _, err := s.reader.ReadAt(window, start); err != nil {
+		return nil, 0, err
+	}
+
+	for keySize := 0; keySize <= shared.KeySize; keySize++ {
+		offset := int(int64(position.Offset)-start) - dataRecordHeaderSize - keySize
+		if offset < 0 {
+			break
+		}
+
+		record := window[offset:]
+		if binary.LittleEndian.Uint32(record) != uint32(keySize) || binary.LittleEndian.Uint32(record[shared.UintSize:]) != position.Size {
+			continue
+		}
+		checksum := crc32.Checksum(record[:len(record)-shared.UintSize], crcTable)
+		if checksum == binary.LittleEndian.Uint32(record[len(record)-shared.UintSize:]) {
+			return record, start + int64(offset), nil
+		}
+	}
+	return nil, 0, fmt.Errorf("no record ends with the value")
+}
+
+// Sync commits the written values to stable storage.
+func (s *DiskDataManager) Sync() error {
+	if s.writer == nil {
+		return nil
+	}
+	return s.writer.Sync()
+}
+
 // Compact deletes all unused values
 func (s *DiskDataManager) Compact() error {
 	panic("unimplemented")
 }
 
 func (s *DiskDataManager) Close() error {
-	err := s.writer.Close()
+	s.background.Wait()
+	if s.writer != nil {
+		if err := s.writer.Close(); err != nil {
+			return err
+		}
+	}
+	err := s.reader.Close()
+	return err
+}
+
+func encodeDataRecord(key string, value []byte) []byte {
+	record := make([]byte, 0, dataRecordHeaderSize+len(key)+len(value)+shared.UintSize)
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(key)))
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(value)))
+	record = append(record, key...)
+	record = append(record, value...)
+	return binary.LittleEndian.AppendUint32(record, crc32.Checksum(record, crcTable))
+}
+
+func hasDataFileMagic(file io.ReaderAt) (bool, error) {
+	header := make([]byte, len(dataFileMagic))
+	if _, err := file.ReadAt(header, 0); err != nil {
+		if errors.Is(err, io.EOF) {
+			return false, nil // shorter than the header, raw values
+		}
+		return false, err
+	}
+	return bytes.Equal(header, dataFileMagic), nil
+}
+
+// ScanDataFile calls fn with every record of the data file at path in write
+// order, checking their checksums. A corrupt or truncated record stops the
+// scan with an ErrCorruptRecord holding its offset. Files of raw values have no
+// records to scan and return an error.
+func ScanDataFile(path string, fn func(record DataRecord) error) error {
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
-	err = s.reader.Close()
-	return err
+	defer file.Close()
+
+	records, err := hasDataFileMagic(file)
+	if err != nil {
+		return fmt.Errorf("can not read the header of %q: %v", path, err)
+	}
+	if !records {
+		return fmt.Errorf("data file %q stores raw values without keys", path)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(file, int64(len(dataFileMagic)), 1<<62))
+	offset := int64(len(dataFileMagic))
+	header := make([]byte, dataRecordHeaderSize)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return &shared.ErrCorruptRecord{Offset: offset, Reason: "truncated header"}
+		}
+
+		keySize := binary.LittleEndian.Uint32(header)
+		valueSize := binary.LittleEndian.Uint32(header[shared.UintSize:])
+		if keySize > shared.KeySize {
+			return &shared.ErrCorruptRecord{Offset: offset, Reason: fmt.Sprintf("key length %d exceeds the maximum key size", keySize)}
+		}
+		if remaining := info.Size() - offset - dataRecordHeaderSize; int64(keySize)+int64(valueSize)+shared.UintSize > remaining {
+			return &shared.ErrCorruptRecord{Offset: offset, Reason: fmt.Sprintf("value length %d exceeds the %d bytes left", valueSize, remaining)}
+		}
+
+		body := make([]byte, int(keySize)+int(valueSize)+shared.UintSize)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return &shared.ErrCorruptRecord{Offset: offset, Reason: "truncated record"}
+		}
+
+		checksum := crc32.Update(crc32.Checksum(header, crcTable), crcTable, body[:len(body)-shared.UintSize])
+		if checksum != binary.LittleEndian.Uint32(body[len(body)-shared.UintSize:]) {
+			return &shared.ErrCorruptRecord{Offset: offset, Reason: "checksum mismatch"}
+		}
+
+		record := DataRecord{
+			Key:      string(body[:keySize]),
+			Position: Position{Offset: uint64(offset) + dataRecordHeaderSize + uint64(keySize), Size: valueSize},
+			Value:    body[keySize : keySize+valueSize],
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+
+		offset += int64(len(header) + len(body))
+	}
 }
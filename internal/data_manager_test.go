@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestDataRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DataFileName)
+
+	dm, err := NewDiskDataManager(path, false, nil, 0)
+	if err != nil {
+		t.Fatalf("NewDiskDataManager() error: %v", err)
+	}
+
+	want := map[string]string{"a": "first", "bb": "second", "ccc": ""}
+	positions := map[string]Position{}
+	for _, key := range []string{"a", "bb", "ccc"} {
+		positions[key], err = dm.Store(key, []byte(want[key]))
+		if err != nil {
+			t.Fatalf("Store(%q) error: %v", key, err)
+		}
+	}
+
+	value, err := dm.Retrieve(positions["bb"])
+	if err != nil || string(value) != "second" {
+		t.Fatalf("Retrieve(bb) = %q, %v, want \"second\"", value, err)
+	}
+	dm.Close()
+
+	seen := 0
+	err = ScanDataFile(path, func(record DataRecord) error {
+		seen++
+		if string(record.Value) != want[record.Key] || record.Position != positions[record.Key] {
+			t.Errorf("record %q = %q at %v, want %q at %v", record.Key, record.Value, record.Position, want[record.Key], positions[record.Key])
+		}
+		return nil
+	})
+	if err != nil || seen != 3 {
+		t.Fatalf("ScanDataFile() saw %d records, error: %v", seen, err)
+	}
+
+	// flip a value byte, the scan must stop at its record
+	data, _ := os.ReadFile(path)
+	data[positions["bb"].Offset] ^= 0xff
+	os.WriteFile(path, data, 0644)
+
+	var corrupt *shared.ErrCorruptRecord
+	err = ScanDataFile(path, func(DataRecord) error { return nil })
+	if !errors.As(err, &corrupt) || corrupt.Reason != "checksum mismatch" {
+		t.Errorf("ScanDataFile() error = %v, want a checksum mismatch", err)
+	}
+}
+
+func TestLegacyDataFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DataFileName)
+	os.WriteFile(path, []byte("rawvalue"), 0644)
+
+	dm, err := NewDiskDataManager(path, false, nil, 0)
+	if err != nil {
+		t.Fatalf("NewDiskDataManager() error: %v", err)
+	}
+	defer dm.Close()
+
+	// old files keep raw values so existing positions stay valid
+	position, err := dm.Store("key", []byte("next"))
+	if err != nil || position != (Position{Offset: 8, Size: 4}) {
+		t.Fatalf("Store() = %v, %v, want offset 8 size 4", position, err)
+	}
+	value, err := dm.Retrieve(Position{Offset: 0, Size: 8})
+	if err != nil || string(value) != "rawvalue" {
+		t.Errorf("Retrieve() = %q, %v, want \"rawvalue\"", value, err)
+	}
+}
+
+func TestRetrievePastEnd(t *testing.T) {
+	dm, err := NewDiskDataManager(filepath.Join(t.TempDir(), DataFileName), false, nil, 0)
+	if err != nil {
+		t.Fatalf("NewDiskDataManager() error: %v", err)
+	}
+	defer dm.Close()
+
+	position, err := dm.Store("key", []byte("value"))
+	if err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+
+	// a corrupt position must fail before a buffer of its size is allocated
+	if _, err := dm.Retrieve(Position{Offset: position.Offset, Size: 0xFFFFFFF0}); err == nil {
+		t.Errorf("Retrieve() of a position past the end of the file succeeded")
+	}
+	if value, err := dm.Retrieve(position); err != nil || string(value) != "value" {
+		t.Errorf("Retrieve() = %q, %v, want \"value\"", value, err)
+	}
+}
+
+func TestDataPreallocation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DataFileName)
+
+	manager, err := NewDiskDataManager(path, false, nil, 1<<16)
+	if err != nil {
+		t.Fatalf("NewDiskDataManager() error: %v", err)
+	}
+	dm := manager.(*DiskDataManager)
+
+	value := make([]byte, 1000)
+	positions := make([]Position, 200)
+	for i := range positions {
+		if positions[i], err = dm.Store("key", value); err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+	}
+	dm.background.Wait()
+
+	// reserved space is not part of the file, reads stop at the records
+	info, _ := os.Stat(path)
+	last := positions[len(positions)-1]
+	if info.Size() != int64(last.Offset)+int64(last.Size)+4 {
+		t.Errorf("file size = %d, want it to end at the last record", info.Size())
+	}
+	if _, err := dm.Retrieve(positions[0]); err != nil {
+		t.Errorf("Retrieve() error: %v", err)
+	}
+	dm.Close()
+
+	if dm.preallocating.Load() {
+		t.Skip("preallocation is not supported here")
+	}
+	if dm.preallocated.Load() == 0 {
+		t.Errorf("no space was preallocated for %d bytes of records", info.Size())
+	}
+}
@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// SpaceFileName is the file, inside the home path, holding the garbage of the
+// data file counted up to the last flush.
+const SpaceFileName = "space.json"
+
+// dataSpace counts the garbage of the data file, the bytes of the records of
+// values overwritten or deleted since they were written, the rest of the file
+// is live. The count is saved whenever a flush truncates the WAL, replaying
+// the WAL on open releases the values it overwrote again. Values dropped
+// without being overwritten or deleted, such as expired ones, count as live
+// until recount scans the index. A nil dataSpace counts nothing.
+type dataSpace struct {
+	path    string
+	data    *DiskDataManager
+	dedup   *valueDedup // Values still referenced by another key are not garbage.
+	percent uint32      // See Config.DataGarbagePercent.
+	garbage atomic.Int64
+}
+
+// spaceFile is the content of the space file.
+type spaceFile struct {
+	Garbage int64  `json:"garbage"`
+	Size    int64  `json:"size"` // Size of the data file when saved.
+	Seq     uint64 `json:"seq"`  // Latest sequence number of the tables when saved.
+}
+
+// newDataSpace counts the garbage of data if config.DataGarbagePercent is set,
+// it returns nil otherwise or without a data file.
+func newDataSpace(config *shared.EngineConfig, data *DiskDataManager, dedup *valueDedup) *dataSpace {
+	if config.DataGarbagePercent == 0 || config.ReadOnly || data == nil {
+		return nil
+	}
+	return &dataSpace{path: filepath.Join(config.Homepath, SpaceFileName), data: data, dedup: dedup, percent: config.DataGarbagePercent}
+}
+
+// load reads the garbage saved by the last flush, before the WAL is replayed.
+// Bytes written since were logged in the WAL, or lost with the memtable, and
+// are garbage once replayed. The index is scanned instead when there is no
+// space file or it was saved before tables written since, by a flush that
+// failed to save it or an ingestion.
+func (s *dataSpace) load(e *Engine) error {
+	if s == nil {
+		return nil
+	}
+
+	saved := spaceFile{}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		err = json.Unmarshal(data, &saved)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("engine can not read the space file, the garbage of the data file is recounted: %v", err)
+	}
+	size := s.data.size.Load()
+	if err != nil || saved.Seq != e.indexManager.lastSequence() || saved.Size > size {
+		return s.recount(e)
+	}
+	s.garbage.Store(saved.Garbage + size - saved.Size)
+	return nil
+}
+
+// recount replaces the garbage by the bytes of the data file no key of the
+// index references, used when the count can not be trusted.
+func (s *dataSpace) recount(e *Engine) error {
+	if s == nil {
+		return nil
+	}
+
+	size := s.data.size.Load()
+	live := map[Position]int64{}
+	err := e.scan("", func(pair KVPair) (bool, error) {
+		position := pair.Value
+		if position.operand() {
+			chain, base, err := e.mergeChain(position)
+			if err != nil {
+				return false, err
+			}
+			for _, link := range chain {
+				live[link] = s.recordSize(pair.Key, link)
+			}
+			position = base
+		}
+		if position.Size > 0 && !position.colocated() {
+			live[position] = s.recordSize(pair.Key, position)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	garbage := size - s.header()
+	for _, bytes := range live {
+		garbage -= bytes
+	}
+	s.garbage.Store(max(garbage, 0))
+	return nil
+}
+
+// save writes the garbage counted so far, the WAL must be empty.
+func (s *dataSpace) save(e *Engine) error {
+	if s == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(spaceFile{Garbage: s.garbage.Load(), Size: s.data.size.Load(), Seq: e.indexManager.lastSequence()})
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("can not write the space file: %v", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("can not replace the space file: %v", err)
+	}
+	return syncDir(filepath.Dir(s.path))
+}
+
+// header returns the bytes of the data file before its first value.
+func (s *dataSpace) header() int64 {
+	if !s.data.records {
+		return 0
+	}
+	return int64(len(dataFileMagic))
+}
+
+// recordSize returns the bytes of the data file taken by the value of key at
+// position.
+func (s *dataSpace) recordSize(key string, position Position) int64 {
+	if !s.data.records {
+		return int64(position.Size)
+	}
+	return int64(dataRecordHeaderSize + len(key) + int(position.Size) + shared.UintSize)
+}
+
+// discard counts the record of the value at position as garbage unless
+// another key still references it. Colocated values are not in the data file.
+// A deduplicated value is counted with the key releasing it last, its record
+// holds the key it was first written for.
+func (s *dataSpace) discard(key string, position Position) {
+	if s == nil || position.Size == 0 || position.colocated() || s.dedup.referenced(position) {
+		return
+	}
+	s.garbage.Add(s.recordSize(key, position))
+}
+
+// stats returns the live and garbage bytes of the data file and whether a
+// compaction is due.
+func (s *dataSpace) stats() (live, garbage int64, due bool) {
+	if s == nil {
+		return 0, 0, false
+	}
+
+	size := s.data.size.Load() - s.header()
+	garbage = min(s.garbage.Load(), size)
+	live = size - garbage
+	return live, garbage, size > 0 && garbage*100 >= int64(s.percent)*size
+}
+
+// discard counts the value key held at old as garbage once it is replaced or
+// deleted, along with the merge operands leading to it.
+func (e *Engine) discard(key string, old Position) {
+	if e.space == nil {
+		return
+	}
+
+	if old.operand() {
+		chain, base, err := e.mergeChain(old)
+		if err != nil {
+			log.Printf("engine can not count the merge operands of %q as garbage: %v", key, err)
+			return
+		}
+		for _, link := range chain {
+			e.space.discard(key, link)
+		}
+		old = base
+	}
+	e.space.discard(key, old)
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestDataSpace(t *testing.T) {
+	dir := t.TempDir()
+	config := *shared.NewEngineConfig().WithDataGarbagePercent(50)
+	engine, err := NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	value := []byte(strings.Repeat("v", 100))
+	record := int64(dataRecordHeaderSize + len("key0") + len(value) + shared.UintSize)
+	for i := range 10 {
+		if err := engine.Set(fmt.Sprintf("key%d", i), value); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+	}
+	for i := range 3 {
+		if err := engine.Set(fmt.Sprintf("key%d", i), value); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+	}
+	for _, key := range []string{"key8", "key9"} {
+		if err := engine.Delete(key); err != nil {
+			t.Fatalf("Delete() error: %v", err)
+		}
+	}
+	if stats := engine.Stats(); stats.DataGarbageBytes != 5*record || stats.DataLiveBytes != 8*record || stats.DataCompactionDue {
+		t.Errorf("stats = %d live and %d garbage bytes, due %t, want %d and %d, not due", stats.DataLiveBytes, stats.DataGarbageBytes, stats.DataCompactionDue, 8*record, 5*record)
+	}
+
+	// checked compares the garbage counted with a scan of the index
+	checked := func(engine *Engine) {
+		t.Helper()
+		counted := engine.space.garbage.Load()
+		if err := engine.space.recount(engine); err != nil {
+			t.Fatalf("recount() error: %v", err)
+		}
+		if recounted := engine.space.garbage.Load(); counted != recounted {
+			t.Errorf("garbage = %d, want %d found by a scan", counted, recounted)
+		}
+	}
+
+	// the count is saved by flushes, the writes since are replayed from the WAL
+	engine.mu.Lock()
+	err = engine.flush()
+	engine.mu.Unlock()
+	if err != nil {
+		t.Fatalf("flush() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, SpaceFileName)); err != nil {
+		t.Fatalf("space file not saved: %v", err)
+	}
+	for i := range 4 {
+		if err := engine.Set(fmt.Sprintf("key%d", i), value); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	engine, err = NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+	checked(engine)
+	if stats := engine.Stats(); !stats.DataCompactionDue {
+		t.Errorf("stats = %d live and %d garbage bytes, want a compaction due", stats.DataLiveBytes, stats.DataGarbageBytes)
+	}
+
+	// a stale space file is recounted
+	want := engine.space.garbage.Load()
+	if err := os.WriteFile(filepath.Join(dir, SpaceFileName), []byte(`{"garbage":1,"size":1,"seq":1}`), 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+	if err := engine.space.load(engine); err != nil {
+		t.Fatalf("load() error: %v", err)
+	}
+	if garbage := engine.space.garbage.Load(); garbage != want {
+		t.Errorf("garbage = %d after loading a stale space file, want %d", garbage, want)
+	}
+}
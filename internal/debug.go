@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// SetDebug turns debug logging on or off while the engine runs, it starts as
+// set by shared.EngineConfig.Debug.
+func (e *Engine) SetDebug(enabled bool) {
+	e.indexManager.debug.Store(enabled)
+	e.indexManager.filters.debug.Store(enabled)
+	log.Printf("engine: debug logging set to %t", enabled)
+}
+
+// Debug reports whether debug logging is on.
+func (e *Engine) Debug() bool {
+	return e.indexManager.debug.Load()
+}
+
+// SetTracing turns tracing of every lookup on or off. Traced lookups are
+// logged with the tables they probed, as returned for a single lookup by
+// GetContext, which slows down reads and floods the log: it is meant to
+// inspect a running engine for a short while.
+func (e *Engine) SetTracing(enabled bool) {
+	e.tracing.Store(enabled)
+	log.Printf("engine: lookup tracing set to %t", enabled)
+}
+
+// Tracing reports whether every lookup is traced.
+func (e *Engine) Tracing() bool {
+	return e.tracing.Load()
+}
+
+// logTrace logs a finished lookup trace.
+func logTrace(trace *Trace) {
+	data, err := json.Marshal(trace)
+	if err != nil {
+		log.Printf("trace: can not encode the trace of %q: %v", trace.Key, err)
+		return
+	}
+	log.Printf("trace: %s", data)
+}
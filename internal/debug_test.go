@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestRuntimeDebugSwitches(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	if engine.Debug() || engine.Tracing() {
+		t.Fatalf("Debug() = %t, Tracing() = %t, want both off", engine.Debug(), engine.Tracing())
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	engine.Set("key", []byte("value"))
+	engine.Get("key")
+	if strings.Contains(logs.String(), "trace:") {
+		t.Errorf("lookup was traced with tracing off: %s", logs.String())
+	}
+
+	engine.SetDebug(true)
+	engine.SetTracing(true)
+	if !engine.Debug() || !engine.Tracing() {
+		t.Fatalf("Debug() = %t, Tracing() = %t, want both on", engine.Debug(), engine.Tracing())
+	}
+
+	engine.Get("key")
+	if !strings.Contains(logs.String(), `trace: {"key":"key"`) {
+		t.Errorf("lookup was not traced: %s", logs.String())
+	}
+}
@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// dedupMinValueSize is the smallest value stored once, below it the
+// bookkeeping costs about as much as the record it saves.
+const dedupMinValueSize = 64
+
+type dedupEntry struct {
+	hash [sha256.Size]byte
+	refs int // Live keys referencing the value.
+}
+
+// valueDedup lets identical values be stored once: a value already written
+// for a live key is referenced again instead of being appended to the data
+// file. Values are counted by the live keys referencing them, the counts are
+// rebuilt from the index on open and a value is forgotten once no key
+// references it, its record is then garbage left for data file compaction.
+// A nil valueDedup deduplicates nothing.
+type valueDedup struct {
+	mu      sync.Mutex
+	byHash  map[[sha256.Size]byte]Position
+	entries map[Position]*dedupEntry
+	saved   atomic.Uint64 // Bytes not written since open.
+}
+
+func newValueDedup() *valueDedup {
+	return &valueDedup{byHash: map[[sha256.Size]byte]Position{}, entries: map[Position]*dedupEntry{}}
+}
+
+// load counts the references of the values in the index and hashes them,
+// reading the data file once. Values that can not be read are still counted
+// so they are never erased while shared, but are not deduplicated against.
+func (d *valueDedup) load(e *Engine) error {
+	if d == nil {
+		return nil
+	}
+
+	refs, err := liveReferences(e)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.byHash, d.entries = map[[sha256.Size]byte]Position{}, map[Position]*dedupEntry{}
+	// a file of raw values or a corrupt record ends the scan early, the
+	// values left are then read one by one
+	ScanDataFile(filepath.Join(e.Config.Homepath, DataFileName), func(record DataRecord) error {
+		if count, ok := refs[record.Position]; ok {
+			d.track(sha256.Sum256(record.Value), record.Position, count, true)
+			delete(refs, record.Position)
+		}
+		return nil
+	})
+
+	positions := make([]Position, 0, len(refs))
+	for position := range refs {
+		positions = append(positions, position)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i].Offset < positions[j].Offset })
+	for _, position := range positions {
+		value, err := e.storageManager.Retrieve(position)
+		d.track(sha256.Sum256(value), position, refs[position], err == nil)
+	}
+	return nil
+}
+
+// recount replaces the reference counts by those of the index, used after
+// writes that bypass the memtable.
+func (d *valueDedup) recount(e *Engine) error {
+	if d == nil {
+		return nil
+	}
+
+	refs, err := liveReferences(e)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for position, entry := range d.entries {
+		entry.refs = refs[position]
+		if entry.refs == 0 {
+			d.forget(position, entry)
+		}
+	}
+	return nil
+}
+
+// liveReferences counts the live keys referencing every value large enough to
+// be deduplicated.
+func liveReferences(e *Engine) (map[Position]int, error) {
+	refs := map[Position]int{}
+	err := e.scan("", func(pair KVPair) (bool, error) {
+		// merge operands reference the value they were written over
+		if pair.Value.operand() {
+			_, base, err := e.mergeChain(pair.Value)
+			if err != nil {
+				return false, err
+			}
+			pair.Value = base
+		}
+		// values colocated with a table go away with it, they are never shared
+		if pair.Value.Size >= dedupMinValueSize && !pair.Value.colocated() {
+			refs[pair.Value]++
+		}
+		return false, nil
+	})
+	return refs, err
+}
+
+// track records a value referenced count times, d.mu must be held by the caller.
+func (d *valueDedup) track(hash [sha256.Size]byte, position Position, count int, hashed bool) {
+	d.entries[position] = &dedupEntry{hash: hash, refs: count}
+	if _, ok := d.byHash[hash]; hashed && !ok {
+		d.byHash[hash] = position
+	}
+}
+
+// forget drops a value no longer referenced, d.mu must be held by the caller.
+func (d *valueDedup) forget(position Position, entry *dedupEntry) {
+	delete(d.entries, position)
+	if d.byHash[entry.hash] == position {
+		delete(d.byHash, entry.hash)
+	}
+}
+
+// reference returns the position of a stored value with the given hash and
+// counts one more reference to it.
+func (d *valueDedup) reference(hash [sha256.Size]byte) (Position, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	position, ok := d.byHash[hash]
+	if !ok {
+		return Position{}, false
+	}
+	d.entries[position].refs++
+	d.saved.Add(uint64(position.Size))
+	return position, true
+}
+
+// add records a value just written.
+func (d *valueDedup) add(hash [sha256.Size]byte, position Position) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.track(hash, position, 1, true)
+}
+
+// release drops a reference to the value at position, once replaced or deleted.
+func (d *valueDedup) release(position Position) {
+	if d == nil || position.Size < dedupMinValueSize || position.operand() {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.entries[position]
+	if !ok {
+		return
+	}
+	if entry.refs--; entry.refs <= 0 {
+		d.forget(position, entry)
+	}
+}
+
+// referenced reports whether a live key still references the value at position.
+func (d *valueDedup) referenced(position Position) bool {
+	if d == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.entries[position]
+	return ok
+}
+
+// stats returns the number of tracked values and the bytes saved since open.
+func (d *valueDedup) stats() (int, uint64) {
+	if d == nil {
+		return 0, 0
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.entries), d.saved.Load()
+}
+
+// storeValue writes value for key, or references an identical stored value
+// when deduplication is enabled.
+func (e *Engine) storeValue(key string, value []byte) (Position, error) {
+	if e.dedup == nil || len(value) < dedupMinValueSize {
+		return e.storageManager.Store(key, value)
+	}
+
+	hash := sha256.Sum256(value)
+	if position, ok := e.dedup.reference(hash); ok {
+		return position, nil
+	}
+
+	position, err := e.storageManager.Store(key, value)
+	if err != nil {
+		return Position{}, err
+	}
+	e.dedup.add(hash, position)
+	return position, nil
+}
@@ -0,0 +1,89 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestDedupValues(t *testing.T) {
+	home := t.TempDir()
+	config := *shared.NewEngineConfig().WithMemtableSizeThreshold(10).WithDedupValues(true)
+	e, err := NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	blob := bytes.Repeat([]byte("blob"), 64)
+	dataSize := func() int64 {
+		info, err := os.Stat(filepath.Join(home, DataFileName))
+		if err != nil {
+			t.Fatalf("Stat() error: %v", err)
+		}
+		return info.Size()
+	}
+
+	e.Set("a", blob)
+	written := dataSize()
+	e.Set("b", blob)
+	e.Set("c", blob)
+	if size := dataSize(); size != written {
+		t.Fatalf("data file grew from %d to %d bytes writing duplicates", written, size)
+	}
+	if stats := e.Stats(); stats.DedupValues != 1 || stats.DedupSavedBytes != 2*uint64(len(blob)) {
+		t.Errorf("Stats() = %d values, %d saved bytes, want 1 and %d", stats.DedupValues, stats.DedupSavedBytes, 2*len(blob))
+	}
+
+	// purging a key keeps the value the others still reference
+	report, err := e.Purge("a")
+	if err != nil || report.ErasedValues != 0 {
+		t.Fatalf("Purge(a) = %+v, %v, want no erased values", report, err)
+	}
+	if value, err := e.Get("b"); err != nil || !bytes.Equal(value, blob) {
+		t.Fatalf("Get(b) after purging a = %q, %v", value, err)
+	}
+	e.Close()
+
+	// references are counted again on open
+	e, err = NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	written = dataSize()
+	e.Set("d", blob)
+	if size := dataSize(); size != written {
+		t.Errorf("data file grew from %d to %d bytes writing a duplicate after reopening", written, size)
+	}
+
+	// the value is erased once its last reference is purged, even from the
+	// record of the key that first wrote it
+	batch := e.WriteBatch()
+	batch.Delete("b")
+	batch.Set("c", []byte("small"))
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+	report, err = e.Purge("d")
+	if err != nil || report.ErasedValues != 1 {
+		t.Fatalf("Purge(d) = %+v, %v, want one erased value", report, err)
+	}
+	if err := ScanDataFile(filepath.Join(home, DataFileName), func(record DataRecord) error {
+		if bytes.Equal(record.Value, blob) {
+			t.Errorf("record of %q still holds the purged value", record.Key)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("ScanDataFile() error: %v", err)
+	}
+
+	// a fresh copy is written instead of referencing the erased one
+	e.Set("e", blob)
+	if value, err := e.Get("e"); err != nil || !bytes.Equal(value, blob) {
+		t.Errorf("Get(e) = %q, %v", value, err)
+	}
+}
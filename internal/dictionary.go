@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"sort"
+)
+
+// A dictionary is trained by picking the segments of the samples made of the
+// substrings most samples share, a simplified take on the COVER algorithm of
+// the zstd library: the samples are split into epochs and the best segment of
+// every epoch joins the dictionary, its substrings then count for nothing.
+const (
+	dictSegmentSize = 32
+	dictDmerSize    = 6 // Length of the substrings segments are scored by.
+)
+
+// dictSegment is a segment of a sample scored by the frequency of its substrings.
+type dictSegment struct {
+	data  []byte
+	score int
+}
+
+// dictionarySamples returns keys sampled evenly from the sorted pairs, the
+// blocks of a table hold its keys and their value positions. About a hundred
+// times the dictionary size is sampled.
+func dictionarySamples(pairs []KVPair, size int) [][]byte {
+	total := 0
+	for _, pair := range pairs {
+		total += len(pair.Key)
+	}
+	step := max(total/(100*size), 1)
+
+	samples := make([][]byte, 0, len(pairs)/step+1)
+	for i := 0; i < len(pairs); i += step {
+		samples = append(samples, []byte(pairs[i].Key))
+	}
+	return samples
+}
+
+// trainDictionary returns a raw content dictionary of at most size bytes
+// trained from samples, empty if they share nothing.
+func trainDictionary(samples [][]byte, size int) []byte {
+	// number of samples holding every substring
+	frequency := map[string]int{}
+	for _, sample := range samples {
+		seen := map[string]bool{}
+		for i := 0; i+dictDmerSize <= len(sample); i++ {
+			dmer := string(sample[i : i+dictDmerSize])
+			if !seen[dmer] {
+				seen[dmer] = true
+				frequency[dmer]++
+			}
+		}
+	}
+
+	segments := []dictSegment{}
+	epochs := max(min(size/dictSegmentSize, len(samples)), 1)
+	for epoch := range epochs {
+		best := dictSegment{}
+		for _, sample := range samples[epoch*len(samples)/epochs : (epoch+1)*len(samples)/epochs] {
+			if candidate := bestSegment(sample, frequency); candidate.score > best.score {
+				best = candidate
+			}
+		}
+		if best.score == 0 {
+			continue
+		}
+
+		segments = append(segments, best)
+		for i := 0; i+dictDmerSize <= len(best.data); i++ {
+			delete(frequency, string(best.data[i:i+dictDmerSize]))
+		}
+	}
+
+	// the best segments go last, closer to the data they are matched by
+	sort.SliceStable(segments, func(i, j int) bool { return segments[i].score < segments[j].score })
+	dict := []byte{}
+	for _, segment := range segments {
+		dict = append(dict, segment.data...)
+	}
+	return dict[max(len(dict)-size, 0):]
+}
+
+// bestSegment returns the segment of sample whose substrings held by other
+// samples are the most frequent.
+func bestSegment(sample []byte, frequency map[string]int) dictSegment {
+	// score of every substring, those of a single sample do not count
+	scores := make([]int, max(len(sample)-dictDmerSize+1, 0))
+	for i := range scores {
+		if n := frequency[string(sample[i:i+dictDmerSize])]; n > 1 {
+			scores[i] = n
+		}
+	}
+
+	// slide the segment over the sample, scoring the substrings it holds
+	window := max(dictSegmentSize-dictDmerSize+1, 1)
+	best, bestStart, score := 0, 0, 0
+	for i := range scores {
+		score += scores[i]
+		if i >= window {
+			score -= scores[i-window]
+		}
+		if score > best {
+			best, bestStart = score, max(i-window+1, 0)
+		}
+	}
+	return dictSegment{sample[bestStart:min(bestStart+dictSegmentSize, len(sample))], best}
+}
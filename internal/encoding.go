@@ -9,22 +9,65 @@ import (
 	"github.com/hasssanezzz/goldb/shared"
 )
 
-func (tm *TableMetadata) Serialize() []byte {
+// The first metadata byte holds the table format in its high nibble, the
+// block compression in the next three bits and the level flag in its lowest
+// bit. Tables of the fixed width format used 0x00 for SSTables and 0xFF for
+// levels.
+const (
+	legacyLevelByte  = 0xFF
+	levelFlag        = 0x01
+	compressionShift = 1
+	compressionMask  = 0x07
+)
+
+// Serialize encodes the metadata, its keys can not be longer than KeySize.
+func (tm *TableMetadata) Serialize() ([]byte, error) {
+	minKey, err := shared.PadKey(tm.MinKey)
+	if err != nil {
+		return nil, fmt.Errorf("can not encode the min key: %w", err)
+	}
+	maxKey, err := shared.PadKey(tm.MaxKey)
+	if err != nil {
+		return nil, fmt.Errorf("can not encode the max key: %w", err)
+	}
+
 	buffer := bytes.NewBuffer(nil)
 
-	isLevelAsByte := byte(0x00)
+	kindByte := tm.Format<<4 | uint8(tm.Compression)<<compressionShift
 	if tm.IsLevel {
-		isLevelAsByte = byte(0xFF)
+		kindByte |= levelFlag
+		if tm.Format == tableFormatFixed {
+			kindByte = legacyLevelByte
+		}
 	}
 
-	binary.Write(buffer, binary.LittleEndian, isLevelAsByte)
+	binary.Write(buffer, binary.LittleEndian, kindByte)
 	binary.Write(buffer, binary.LittleEndian, tm.Serial)
 	binary.Write(buffer, binary.LittleEndian, tm.Size)
 	binary.Write(buffer, binary.LittleEndian, tm.FilterSize)
-	buffer.Write(shared.KeyToBytes(tm.MinKey))
-	buffer.Write(shared.KeyToBytes(tm.MaxKey))
+	buffer.Write(minKey)
+	buffer.Write(maxKey)
 
-	return buffer.Bytes()
+	if tm.hasBlocks() {
+		binary.Write(buffer, binary.LittleEndian, tm.IndexOffset)
+		binary.Write(buffer, binary.LittleEndian, tm.IndexSize)
+	}
+	if tm.sequenced() {
+		binary.Write(buffer, binary.LittleEndian, tm.MaxSeq)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// SerializedSize returns the size of the metadata section on disk.
+func (tm *TableMetadata) SerializedSize(config *shared.EngineConfig) uint32 {
+	switch {
+	case tm.sequenced():
+		return config.GetMetadataSize() + shared.UintSize*2 + 8
+	case tm.hasBlocks():
+		return config.GetMetadataSize() + shared.UintSize*2
+	}
+	return config.GetMetadataSize()
 }
 
 func (tm *TableMetadata) Deserialize(r io.Reader) error {
@@ -33,59 +76,125 @@ func (tm *TableMetadata) Deserialize(r io.Reader) error {
 
 	// read isLevel
 	isLevelBuffer := make([]byte, 1)
-	_, err := r.Read(isLevelBuffer)
+	_, err := io.ReadFull(r, isLevelBuffer)
 	if err != nil {
 		return fmt.Errorf("failed to deserialize metadata: %v", err)
 	}
-	tm.IsLevel = isLevelBuffer[0] == 0xFF
+	if isLevelBuffer[0] == legacyLevelByte {
+		tm.IsLevel, tm.Format = true, tableFormatFixed
+	} else {
+		tm.IsLevel, tm.Format = isLevelBuffer[0]&levelFlag != 0, isLevelBuffer[0]>>4
+		tm.Compression = shared.Compression(isLevelBuffer[0] >> compressionShift & compressionMask)
+	}
+	if tm.Format > tableFormatChecksummed {
+		return fmt.Errorf("unknown table format %d", tm.Format)
+	}
+	if tm.Compression > shared.CompressionZstd || (tm.Compression != shared.CompressionNone && !tm.hasBlocks()) {
+		return fmt.Errorf("unknown block compression %d", tm.Compression)
+	}
 
 	// read serial
-	_, err = r.Read(uintBuffer)
+	_, err = io.ReadFull(r, uintBuffer)
 	if err != nil {
 		return fmt.Errorf("failed to deserialize serial: %v", err)
 	}
 	tm.Serial = binary.LittleEndian.Uint32(uintBuffer)
 
 	// read table size
-	_, err = r.Read(uintBuffer)
+	_, err = io.ReadFull(r, uintBuffer)
 	if err != nil {
 		return fmt.Errorf("failed to deserialize table size: %v", err)
 	}
 	tm.Size = binary.LittleEndian.Uint32(uintBuffer)
 
 	// read filter size
-	_, err = r.Read(uintBuffer)
+	_, err = io.ReadFull(r, uintBuffer)
 	if err != nil {
 		return fmt.Errorf("failed to deserialize filter size: %v", err)
 	}
 	tm.FilterSize = binary.LittleEndian.Uint32(uintBuffer)
 
 	// read min key
-	_, err = r.Read(keyBuffer)
+	_, err = io.ReadFull(r, keyBuffer)
 	if err != nil {
 		return fmt.Errorf("failed to deserialize min key: %v", err)
 	}
 	tm.MinKey = shared.TrimPaddedKey(string(keyBuffer))
 
 	// read max key
-	_, err = r.Read(keyBuffer)
+	_, err = io.ReadFull(r, keyBuffer)
 	if err != nil {
 		return fmt.Errorf("failed to deserialize max key: %v", err)
 	}
 	tm.MaxKey = shared.TrimPaddedKey(string(keyBuffer))
 
+	if tm.hasBlocks() {
+		// read block index location
+		_, err = io.ReadFull(r, uintBuffer)
+		if err != nil {
+			return fmt.Errorf("failed to deserialize index offset: %v", err)
+		}
+		tm.IndexOffset = binary.LittleEndian.Uint32(uintBuffer)
+
+		_, err = io.ReadFull(r, uintBuffer)
+		if err != nil {
+			return fmt.Errorf("failed to deserialize index size: %v", err)
+		}
+		tm.IndexSize = binary.LittleEndian.Uint32(uintBuffer)
+	}
+
+	if tm.sequenced() {
+		// read the highest sequence number
+		seqBuffer := make([]byte, 8)
+		_, err = io.ReadFull(r, seqBuffer)
+		if err != nil {
+			return fmt.Errorf("failed to deserialize max sequence number: %v", err)
+		}
+		tm.MaxSeq = binary.LittleEndian.Uint64(seqBuffer)
+	}
+
+	return nil
+}
+
+// checkBounds verifies that the sections described by the metadata fit in a
+// table file of fileSize bytes.
+func (tm *TableMetadata) checkBounds(config *shared.EngineConfig, fileSize int64) error {
+	end := int64(tm.SerializedSize(config)) + int64(tm.FilterSize)
+	if end > fileSize {
+		return fmt.Errorf("filter of %d bytes does not fit in %d bytes", tm.FilterSize, fileSize)
+	}
+
+	if tm.Format == tableFormatFixed {
+		if end+int64(tm.Size)*int64(config.GetKVPairSize()) > fileSize {
+			return fmt.Errorf("%d pairs do not fit in %d bytes", tm.Size, fileSize)
+		}
+		return nil
+	}
+
+	if int64(tm.IndexOffset) < end || int64(tm.IndexOffset)+int64(tm.IndexSize) > fileSize {
+		return fmt.Errorf("block index at %d of %d bytes does not fit in %d bytes", tm.IndexOffset, tm.IndexSize, fileSize)
+	}
+	// compressed blocks may hold entries in less than their size
+	if tm.Compression == shared.CompressionNone && int64(tm.Size)*int64(layoutOf(tm.Format).minEntrySize()) > int64(tm.IndexOffset)-end {
+		return fmt.Errorf("%d pairs do not fit in %d bytes of blocks", tm.Size, int64(tm.IndexOffset)-end)
+	}
 	return nil
 }
 
-func serializePairs(pairs []KVPair) []byte {
+// serializePairs encodes pairs in the fixed width table format.
+func serializePairs(pairs []KVPair) ([]byte, error) {
 	buffer := bytes.NewBuffer(nil)
 
 	// Write pairs
 	for _, pair := range pairs {
-		buffer.Write(shared.KeyToBytes(pair.Key))
-		binary.Write(buffer, binary.LittleEndian, pair.Value.Offset)
+		key, err := shared.PadKey(pair.Key)
+		if err != nil {
+			return nil, err
+		}
+		buffer.Write(key)
+		binary.Write(buffer, binary.LittleEndian, uint32(pair.Value.Offset))
 		binary.Write(buffer, binary.LittleEndian, pair.Value.Size)
 	}
 
-	return buffer.Bytes()
+	return buffer.Bytes(), nil
 }
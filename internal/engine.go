@@ -1,122 +1,456 @@
 package internal
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/hasssanezzz/goldb/shared"
 )
 
+const (
+	WALFileName  = "wal.log.bin"
+	DataFileName = "data.bin"
+)
+
+// Engine is safe for concurrent use by multiple goroutines: writes are
+// serialized, reads, scans and iterators run alongside them and alongside
+// flushes and compactions. Operations racing Close fail with an error.
 type Engine struct {
 	Config         shared.EngineConfig
 	indexManager   *IndexManager
 	storageManager DataManager
 	wal            WAL
+	scrubber       *scrubber
+	retry          *retrier
+	io             *ioScheduler
+	rows           *RowCache
+	tenants        *tenantTracker // Nil without configured tenants.
+	dedup          *valueDedup    // Nil unless values are deduplicated.
+	space          *dataSpace     // Nil unless the garbage of the data file is counted.
+	state          atomic.Uint32  // EngineState
+	seq            atomic.Uint64  // Sequence number of the latest write, see LastSequence.
+	purges         sync.WaitGroup
+	warming        sync.WaitGroup // Background warmup of the page cache, see Config.PrimeBlocks.
+	primed         atomic.Uint64  // Blocks read by Warmup since open.
+	closing        chan struct{}
+	queues         map[string]*Queue
+	feed           *changefeed
+	cache          *cacheTier     // Nil unless the engine runs in cache mode.
+	sizer          *memtableSizer // Nil unless the memtable size adapts.
+	hot            *hotKeys       // Nil unless hot keys are tracked.
+	indexes        jsonIndexes    // Nil without configured JSON indexes.
+	tracing        atomic.Bool    // Every lookup is traced and logged, see SetTracing.
+	queuesMu       sync.Mutex
+	collectionsMu  sync.Mutex // Serializes set and hash updates, see SAdd and HSet.
+	latches        keyLatches // Serializes the updates of a key, see Update.
 
 	mu sync.Mutex
 }
 
+// internalKeyPrefixes start the keys the engine writes for its own data types,
+// they are reserved by any KeyPolicy.
+var internalKeyPrefixes = []string{QueueKeyPrefix, SetKeyPrefix, HashKeyPrefix, IndexKeyPrefix}
+
 func NewEngine(homepath string, configs ...shared.EngineConfig) (*Engine, error) {
-	e := &Engine{}
+	e := &Engine{closing: make(chan struct{}), feed: newChangefeed()}
 
 	config := shared.DefaultConfig
 	if len(configs) > 0 {
 		config = configs[0]
 	}
 	config.Homepath = homepath
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	// users can never write to the engine's own key namespaces
+	if config.KeyPolicy != nil {
+		policy := *config.KeyPolicy
+		policy.ReservedPrefixes = append(slices.Clip(policy.ReservedPrefixes), internalKeyPrefixes...)
+		config.KeyPolicy = &policy
+	}
 	e.Config = config
-
-	wal, err := NewDiskWAL(filepath.Join(homepath, "wal.log.bin"))
+	e.retry = newRetrier(int(config.IORetryAttempts), config.IORetryBaseDelay, config.IORetryMaxDelay)
+	e.io = newIOScheduler(config.BackgroundIOMaxDelay)
+	e.rows = NewRowCache(config.RowCacheSize, config.RowCacheMaxValueSize)
+	e.hot = newHotKeys(config.HotKeys, config.HotKeySampling)
+	indexes, err := newJSONIndexes(&config)
 	if err != nil {
 		return nil, err
 	}
+	e.indexes = indexes
+	if config.MemtableMaxSize > 0 {
+		e.sizer = newMemtableSizer(config.MemtableMinSize, config.MemtableMaxSize, config.MemtableSizeThreshold)
+	}
 
-	indexManager, err := NewIndexManager(&config, wal)
+	// a read-only engine never touches the WAL, its pending entries are ignored,
+	// and a cache does without it
+	var wal WAL = nopWAL{}
+	if config.CacheMode {
+		e.cache = newCacheTier(config.CacheTTL)
+	} else if !config.ReadOnly {
+		diskWAL, err := NewDiskWAL(filepath.Join(homepath, WALFileName), config.WALCompression, config.WALSync, config.WALSyncInterval, e.retry)
+		if err != nil {
+			return nil, err
+		}
+		wal = diskWAL
+	}
+
+	indexManager, err := NewIndexManager(&config, wal, e.retry, e.io)
 	if err != nil {
 		return nil, err
 	}
 
-	storageManager, err := NewDiskDataManager(filepath.Join(homepath, "data.bin"))
+	storageManager, err := NewDiskDataManager(filepath.Join(homepath, DataFileName), config.ReadOnly, e.retry, int64(config.DataPreallocateSize))
 	if err != nil {
 		return nil, err
 	}
 
+	// values are read from the data file or the value files of the tables
+	if indexManager.values != nil {
+		indexManager.values.DataManager = storageManager
+		storageManager = indexManager.values
+	}
+
 	e.indexManager = indexManager
 	e.storageManager = storageManager
 	e.wal = wal
+	e.seq.Store(indexManager.lastSequence())
 
-	return e, e.setEntriesFromWAL()
-}
+	if config.VerifyOnOpen {
+		if err := e.CheckConsistency(int(config.VerifySpotChecks)); err != nil {
+			e.Close()
+			return nil, fmt.Errorf("engine failed the consistency check of %q: %w", homepath, err)
+		}
+	}
 
-func (e *Engine) setEntriesFromWAL() error {
-	entries, err := e.wal.Retrieve()
-	if err != nil {
-		println("error parsing the logs")
-		return err
+	// references are counted before the WAL is replayed so replayed writes share values
+	if config.DedupValues && !config.ReadOnly {
+		dedup := newValueDedup()
+		if err := dedup.load(e); err != nil {
+			return e, fmt.Errorf("engine can not count the deduplicated values: %v", err)
+		}
+		e.dedup = dedup
 	}
 
-	if e.Config.Debug {
-		log.Printf("Inserting %d entries from the WAL to the engine", len(entries))
+	// the garbage is counted before the WAL is replayed so replayed writes release values
+	if space := newDataSpace(&config, e.dataFile(), e.dedup); space != nil {
+		if err := space.load(e); err != nil {
+			return e, fmt.Errorf("engine can not count the garbage of the data file: %v", err)
+		}
+		e.space = space
+		if indexManager.values != nil {
+			indexManager.values.space = space
+		}
+	}
+
+	if err := e.setEntriesFromWAL(); err != nil {
+		return e, err
 	}
 
-	for _, entry := range entries {
-		if len(entry.Value) > 0 {
-			// TODO - make logging conditional
-			// log.Printf("[WAL:SET] %q %X\n", entry.Key, entry.Value)
-			if err := e.Set(entry.Key, entry.Value, true); err != nil {
+	// tenants are counted once the WAL is replayed so its entries count once
+	tenants := newTenantTracker(config.TenantQuotas)
+	if err := tenants.count(e); err != nil {
+		return e, fmt.Errorf("engine can not count the tenants' keys: %v", err)
+	}
+	e.tenants = tenants
+
+	if config.ScrubInterval > 0 {
+		e.scrubber = newScrubber(e)
+		go e.scrubber.run()
+	}
+
+	if config.ReadOnly {
+		e.state.Store(uint32(StateReadOnly))
+	} else if indexManager.access != nil {
+		// lookups are served while the blocks read before the restart are primed
+		e.state.Store(uint32(StateWarming))
+	} else {
+		e.state.Store(uint32(StateServing))
+	}
+	if indexManager.access != nil {
+		e.warming.Add(1)
+		go e.warmup()
+	}
+
+	return e, nil
+}
+
+// setEntriesFromWAL replays the WAL into the memtable, flushing it to SSTables
+// whenever it fills up so recovery memory stays bounded. The WAL is only
+// truncated by the next regular flush, replaying it again after a crash applies
+// the same entries on top of the tables flushed here.
+func (e *Engine) setEntriesFromWAL() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	replayed, merged, flushed := 0, false, false
+	err := e.wal.Replay(func(entry WALEntry) error {
+		replayed++
+		if entry.Merge {
+			merged = true
+			if err := e.merge(entry.Key, entry.Value, entry.Seq, true); err != nil {
+				return err
+			}
+		} else if len(entry.Value) > 0 {
+			if err := e.set(entry.Key, entry.Value, entry.Seq, true); err != nil {
 				return err
 			}
 		} else {
-			// TODO - make logging conditional
-			// log.Printf("[WAL:DEL] %q\n", entry.Key)
-			if err := e.Delete(entry.Key, true); err != nil {
+			if err := e.delete(entry.Key, entry.Seq, false); err != nil {
 				return err
 			}
 		}
+
+		if e.indexManager.memtable.Size() < e.Config.MemtableSizeThreshold {
+			return nil
+		}
+		if err := e.collapseOperands(); err != nil {
+			return err
+		}
+		if err := e.storageManager.Sync(); err != nil {
+			return fmt.Errorf("engine can not sync the data file while replaying the WAL: %v", err)
+		}
+		flushed = true
+		return e.indexManager.Flush()
+	})
+	if err != nil {
+		return fmt.Errorf("engine can not replay the WAL: %w", err)
+	}
+
+	// merge operands are not idempotent, the WAL is truncated right away so
+	// the operands already flushed are not applied twice by a later replay
+	if merged && flushed {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+
+	if e.indexManager.debug.Load() {
+		log.Printf("Inserted %d entries from the WAL to the engine", replayed)
 	}
 
 	return nil
 }
 
+// Scan returns the keys matching the given pattern in key order, a plain
+// string is treated as a key prefix and glob wildcards (e.g. "user:*:settings")
+// are matched against the whole key. An empty pattern returns all the keys.
+// Scan fails with an ErrMemoryBudget once the keys take more than
+// Config.KeysMemoryBudget, ScanKeysFunc visits any number of keys.
 func (e *Engine) Scan(pattern string) ([]string, error) {
-	keys, err := e.indexManager.Keys()
+	results := []string{}
+	budget := &keyBudget{operation: "Scan", limit: e.Config.KeysMemoryBudget}
+	err := e.ScanKeysFunc(pattern, func(key string) (bool, error) {
+		if err := budget.add(key); err != nil {
+			return true, err
+		}
+		results = append(results, key)
+		return false, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// if not pattern exists, return all the keys
-	if len(pattern) == 0 {
-		return keys, nil
+	return results, nil
+}
+
+// ScanKeysFunc calls fn with every key matching pattern (see Scan), in key
+// order, until fn returns stop or an error. Only the index is read, values
+// are never loaded. An invalid pattern is reported before fn is called.
+func (e *Engine) ScanKeysFunc(pattern string, fn func(key string) (stop bool, err error)) error {
+	return e.ScanKeysAfter(pattern, "", fn)
+}
+
+// ScanKeysAfter is like ScanKeysFunc but only visits the keys greater than
+// after, so a scan stopped at a key is resumed where it left off without
+// reading the keys before it. An empty after visits every key.
+func (e *Engine) ScanKeysAfter(pattern, after string, fn func(key string) (stop bool, err error)) error {
+	matcher, err := compilePattern(pattern)
+	if err != nil {
+		return err
 	}
 
-	results := []string{}
-	for _, key := range keys {
-		if strings.HasPrefix(key, pattern) {
-			results = append(results, key)
+	start := matcher.prefix
+	if after != "" {
+		// the smallest key greater than after
+		start = max(start, after+"\x00")
+	}
+	return e.scanFrom(matcher.prefix, start, func(pair KVPair) (bool, error) {
+		if !matcher.Match(pair.Key) || e.cache.expired(pair.Key) {
+			return false, nil
+		}
+		return fn(pair.Key)
+	})
+}
+
+// ScanFunc calls fn with every pair whose key starts with prefix, in key order,
+// until fn returns stop or an error, which is then returned. Pairs are read
+// from a snapshot taken when the scan starts and streamed block by block,
+// writes made during the scan are not seen.
+func (e *Engine) ScanFunc(prefix string, fn func(key string, value []byte) (stop bool, err error)) error {
+	return e.scan(prefix, func(pair KVPair) (bool, error) {
+		if e.cache.expired(pair.Key) {
+			return false, nil
+		}
+		value, err := e.readValue(pair.Key, pair.Value)
+		if err != nil {
+			return false, fmt.Errorf("engine can not read key (%q): %v", pair.Key, err)
 		}
+		return fn(pair.Key, value)
+	})
+}
+
+// Range calls fn with every pair whose key is in [start, end), in key order,
+// until fn returns stop or an error, which is then returned. An empty end
+// means no upper bound. Like ScanFunc pairs are read from a snapshot, and
+// tables whose key range does not overlap the bounds are never read.
+func (e *Engine) Range(start, end string, fn func(key string, value []byte) (stop bool, err error)) error {
+	return e.RangeContext(context.Background(), start, end, fn)
+}
+
+// RangeContext is like Range, it stops with the error of ctx once ctx is
+// done, checked before every table block it reads.
+func (e *Engine) RangeContext(ctx context.Context, start, end string, fn func(key string, value []byte) (stop bool, err error)) error {
+	if end != "" && end <= start {
+		return nil
 	}
 
-	return results, nil
+	snapshot := e.indexManager.snapshot()
+	defer snapshot.Release()
+
+	it, err := snapshot.rangeIterator(start, end)
+	if err != nil {
+		return fmt.Errorf("engine can not read range [%q, %q): %v", start, end, err)
+	}
+	it.pace(e.scanPacer(ctx))
+
+	for {
+		pair, ok, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("engine can not read range [%q, %q): %w", start, end, err)
+		}
+		if !ok || (end != "" && pair.Key >= end) {
+			return nil
+		}
+		if e.cache.expired(pair.Key) {
+			continue
+		}
+
+		value, err := e.readValue(pair.Key, pair.Value)
+		if err != nil {
+			return fmt.Errorf("engine can not read key (%q): %v", pair.Key, err)
+		}
+		stop, err := fn(pair.Key, value)
+		if err != nil || stop {
+			return err
+		}
+	}
+}
+
+// scan calls fn with the index entries of the live keys starting with prefix,
+// tables whose key range can not hold the prefix are skipped.
+func (e *Engine) scan(prefix string, fn func(pair KVPair) (stop bool, err error)) error {
+	return e.scanFrom(prefix, prefix, fn)
+}
+
+// scanFrom is scan starting at the first key not less than start.
+func (e *Engine) scanFrom(prefix, start string, fn func(pair KVPair) (stop bool, err error)) error {
+	snapshot := e.indexManager.snapshot()
+	defer snapshot.Release()
+
+	it, err := snapshot.rangeIterator(start, prefixEnd(prefix))
+	if err != nil {
+		return fmt.Errorf("engine can not scan prefix %q: %v", prefix, err)
+	}
+	it.pace(e.scanPacer(context.Background()))
+
+	for {
+		pair, ok, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("engine can not scan prefix %q: %v", prefix, err)
+		}
+		if !ok || !strings.HasPrefix(pair.Key, prefix) {
+			return nil
+		}
+
+		stop, err := fn(pair)
+		if err != nil || stop {
+			return err
+		}
+	}
 }
 
 func (e *Engine) Get(key string) ([]byte, error) {
+	return e.GetContext(context.Background(), key)
+}
+
+// GetContext is like Get, if ctx carries a Trace (see WithTrace) it is
+// filled with the tables probed, filter results, seeks, bytes read, and durations.
+func (e *Engine) GetContext(ctx context.Context, key string) (data []byte, err error) {
+	trace := TraceFromContext(ctx)
+	if trace == nil && e.tracing.Load() {
+		trace = &Trace{}
+		defer logTrace(trace) // runs once the trace is finished below
+	}
+	if trace != nil {
+		start := time.Now()
+		trace.Key = key
+		defer func() {
+			trace.Duration = time.Since(start)
+			if err != nil {
+				trace.Err = err.Error()
+			}
+		}()
+	}
+
 	// make sure key size is valid
 	if len([]byte(key)) > int(e.Config.KeySize) {
 		return nil, &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
 	}
+	e.hot.read(key)
+	if e.cache.expired(key) {
+		return nil, &shared.ErrKeyNotFound{Key: key}
+	}
+	delayRead()
 
-	indexNode, err := e.indexManager.Get(key)
+	defer e.io.foreground()()
+
+	// taken before the lookup so a concurrent write is never missed
+	sequence := e.rows.Sequence()
+	if value, ok := e.rows.Get(key); ok {
+		if trace != nil {
+			trace.RowCacheHit = true
+		}
+		return bytes.Clone(value), nil
+	}
+
+	indexStart := time.Now()
+	indexNode, err := e.indexManager.get(key, trace)
+	if trace != nil {
+		trace.IndexTime = time.Since(indexStart)
+	}
 	if err != nil {
 		if _, ok := err.(*shared.ErrKeyNotFound); ok {
 			return nil, err
 		}
-		return nil, fmt.Errorf("db engine can not locate key (%q): %v", key, err)
+		return nil, fmt.Errorf("db engine can not locate key (%q): %w", key, err)
 	}
 
-	data, err := e.storageManager.Retrieve(indexNode)
+	dataStart := time.Now()
+	data, err = e.readValue(key, indexNode)
+	if trace != nil {
+		trace.DataTime = time.Since(dataStart)
+		trace.Seeks++
+		trace.BytesRead += int(indexNode.stored().Size)
+	}
 	if err != nil {
 		if e, ok := err.(*shared.ErrKeyNotFound); ok {
 			e.Key = key
@@ -125,25 +459,105 @@ func (e *Engine) Get(key string) ([]byte, error) {
 		return nil, fmt.Errorf("db engine can not read key (%q): %v", key, err)
 	}
 
+	e.rows.Add(key, bytes.Clone(data), sequence)
 	return data, nil
 }
 
+// GetMulti looks up several keys at once, keys that do not exist are missing from the result.
+func (e *Engine) GetMulti(keys []string) (map[string][]byte, error) {
+	results := make(map[string][]byte, len(keys))
+	for _, key := range keys {
+		value, err := e.Get(key)
+		if err != nil {
+			if _, ok := err.(*shared.ErrKeyNotFound); ok {
+				continue
+			}
+			return nil, err
+		}
+		results[key] = value
+	}
+
+	return results, nil
+}
+
+// Has reports whether key exists, consulting the memtable, the filters and the
+// table indexes only, the value is never read from the data file.
+func (e *Engine) Has(key string) (bool, error) {
+	if len([]byte(key)) > int(e.Config.KeySize) {
+		return false, &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+	}
+	if e.cache.expired(key) {
+		return false, nil
+	}
+	if _, ok := e.rows.Get(key); ok {
+		return true, nil
+	}
+
+	defer e.io.foreground()()
+
+	if _, err := e.indexManager.Get(key); err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); ok {
+			return false, nil
+		}
+		return false, fmt.Errorf("db engine can not locate key (%q): %w", key, err)
+	}
+	return true, nil
+}
+
 func (e *Engine) Set(key string, value []byte, ignoreWAL ...bool) error {
+	if err := e.Config.KeyPolicy.Check(key); err != nil {
+		return err
+	}
+
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
+	return e.set(key, value, 0, len(ignoreWAL) != 0 && ignoreWAL[0])
+}
+
+// set writes the pair numbered seq, zero numbers a new write, e.mu must be
+// held by the caller.
+func (e *Engine) set(key string, value []byte, seq uint64, settingFromWAL bool) error {
+	if e.Config.ReadOnly {
+		return &shared.ErrReadOnly{Path: e.Config.Homepath}
+	}
+
 	if len([]byte(key)) > int(e.Config.KeySize) {
 		return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
 	}
 
-	settingFromWAL := len(ignoreWAL) != 0 && ignoreWAL[0]
+	// entries go first, a crash before the value leaves them stale and
+	// QueryIndex removes them
 	if !settingFromWAL {
-		if err := e.wal.Append(WALEntry{key, value}); err != nil {
+		for _, entry := range e.indexes.entries(key, value) {
+			if err := e.set(entry, indexEntryValue, 0, false); err != nil {
+				return fmt.Errorf("engine can not index %q: %w", key, err)
+			}
+		}
+	}
+
+	end := e.io.foreground()
+	defer end()
+
+	tenant := e.tenants.of(key)
+	var old Position
+	if tenant != nil || e.dedup != nil || e.space != nil {
+		old, _ = e.indexManager.Get(key)
+	}
+	if tenant != nil {
+		if err := tenant.reserve(key, old, len(value)); err != nil {
 			return err
 		}
 	}
 
-	position, err := e.storageManager.Store(value)
+	seq = e.nextSeq(seq)
+	if !settingFromWAL {
+		if err := e.wal.Append(WALEntry{Key: key, Value: value, Seq: seq}); err != nil {
+			return err
+		}
+	}
+
+	position, err := e.storeValue(key, value)
 	if err != nil {
 		return fmt.Errorf("engine failed to write (%q, %x): %v", key, value, err)
 	}
@@ -151,52 +565,226 @@ func (e *Engine) Set(key string, value []byte, ignoreWAL ...bool) error {
 	e.indexManager.Set(KVPair{
 		Key:   key,
 		Value: position,
+		Seq:   seq,
 	})
+	if !settingFromWAL {
+		e.indexManager.writeAmp.ingested(key, len(key)+len(value))
+	}
+	e.dedup.release(old)
+	e.discard(key, old)
+	e.cache.written(key)
+	e.rows.Invalidate(key)
+	if !settingFromWAL {
+		e.feed.publish(Change{Seq: seq, Key: key, Value: value, Deleted: len(value) == 0})
+	}
 
 	// Flush if the memtable exceeds its threshold
-	if e.indexManager.memtable.Size() >= e.Config.MemtableSizeThreshold && !settingFromWAL {
-		if e.indexManager.memtable.Size() != e.Config.MemtableSizeThreshold {
-			panic("threshold violation")
+	if e.indexManager.memtable.Size() >= e.flushThreshold() && !settingFromWAL {
+		end()
+		if err := e.expireCached(); err != nil {
+			return fmt.Errorf("engine can not expire cached keys: %v", err)
 		}
-
-		// TEMP: for debugging
-		if err := e.indexManager.flush(); err != nil {
-			panic(err)
+		if err := e.flush(); err != nil {
+			return err
 		}
+	}
+
+	return nil
+}
 
-		e.wal.Clear()
+// flush writes the memtable to a new SSTable and truncates the WAL only once
+// the table and the values it references are durable, e.mu must be held by the caller.
+// If the flush fails the WAL is kept so the entries are replayed on the next start.
+func (e *Engine) flush() error {
+	if err := e.collapseOperands(); err != nil {
+		return fmt.Errorf("engine can not collapse the merge operands before flushing: %v", err)
+	}
+
+	if err := e.storageManager.Sync(); err != nil {
+		return fmt.Errorf("engine can not sync the data file before flushing: %v", err)
+	}
+
+	entries := e.indexManager.memtable.Size()
+	if err := e.indexManager.Flush(); err != nil {
+		return fmt.Errorf("engine failed to flush the memtable, the WAL is kept: %v", err)
+	}
+	e.sizer.flushed(entries, e.indexManager.compactionBacklog())
+
+	if err := e.wal.Clear(); err != nil {
+		return fmt.Errorf("engine flushed the memtable but can not truncate the WAL: %v", err)
+	}
+	// a count left stale is detected on open, the flush succeeded
+	if err := e.space.save(e); err != nil {
+		log.Printf("engine can not save the garbage of the data file: %v", err)
 	}
 
 	return nil
 }
 
+// Delete removes key, ignoreWAL leaves the deletion out of the WAL.
 func (e *Engine) Delete(key string, ignoreWAL ...bool) error {
+	if err := e.Config.KeyPolicy.Check(key); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.delete(key, 0, len(ignoreWAL) == 0)
+}
+
+// delete removes key without checking the key policy, logging the deletion
+// in the WAL if logged is set. The deletion is numbered seq, zero numbers a
+// new write. e.mu must be held by the caller so a flush does not drop it.
+func (e *Engine) delete(key string, seq uint64, logged bool) error {
+	if e.Config.ReadOnly {
+		return &shared.ErrReadOnly{Path: e.Config.Homepath}
+	}
+
 	// make sure key size is valid
 	if len([]byte(key)) > int(e.Config.KeySize) {
 		return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
 	}
 
+	defer e.io.foreground()()
+
 	// first of all after validating the key size
 	// write the pair (with empty value) to the WAL if not ingored.
-	if len(ignoreWAL) == 0 {
+	seq = e.nextSeq(seq)
+	if logged {
 		// when would I ignore writing to the WAL?
 		// when the I am setting KV pairs from the WAL I don't want to rewrite
 		// the pairs coming from the WAL to the WAL again.
-		if err := e.wal.Append(WALEntry{key, []byte{}}); err != nil {
+		if err := e.wal.Append(WALEntry{Key: key, Value: []byte{}, Seq: seq}); err != nil {
 			return err
 		}
+		if e.Config.WALSyncDeletes {
+			if err := e.wal.Sync(); err != nil {
+				return fmt.Errorf("engine can not sync the deletion of %q: %v", key, err)
+			}
+		}
 	}
 
-	e.indexManager.Delete(key)
+	tenant := e.tenants.of(key)
+	var old Position
+	if tenant != nil || e.dedup != nil || e.space != nil {
+		old, _ = e.indexManager.Get(key)
+	}
+	if tenant != nil {
+		tenant.release(key, old)
+	}
+
+	e.indexManager.Delete(key, seq)
+	if logged {
+		e.indexManager.writeAmp.ingested(key, len(key))
+	}
+	e.dedup.release(old)
+	e.discard(key, old)
+	e.cache.forget(key)
+	e.rows.Invalidate(key)
+	if logged {
+		e.feed.publish(Change{Seq: seq, Key: key, Deleted: true})
+	}
 	return nil
 }
 
+// DeleteResult describes a deletion made durable by DeleteDurable.
+type DeleteResult struct {
+	// StaleTables is the number of tables still holding an older value of the
+	// key, the value stays on disk until they are compacted.
+	StaleTables int
+}
+
+// DeleteDurable deletes key and returns once the tombstone is durable, whatever
+// the WAL sync policy, reporting how many tables still hold older values.
+func (e *Engine) DeleteDurable(key string) (DeleteResult, error) {
+	if err := e.Delete(key); err != nil {
+		return DeleteResult{}, err
+	}
+	if err := e.Sync(); err != nil {
+		return DeleteResult{}, fmt.Errorf("engine can not sync the deletion of %q: %v", key, err)
+	}
+
+	stale, err := e.indexManager.tablesHolding(key)
+	if err != nil {
+		return DeleteResult{}, fmt.Errorf("engine deleted %q but can not count its stale tables: %v", key, err)
+	}
+	return DeleteResult{StaleTables: stale}, nil
+}
+
+// Sync makes every write acknowledged so far durable by fsyncing the WAL,
+// regardless of the configured sync policy.
+func (e *Engine) Sync() error {
+	if e.cache != nil {
+		return &shared.ErrBestEffort{Path: e.Config.Homepath}
+	}
+	return e.wal.Sync()
+}
+
+// OnDurable calls done once every write acknowledged before the call is
+// durable, with the error of the sync if it failed. Under shared.SyncNever this
+// only happens on the next memtable flush or call to Sync. done is called from
+// the goroutine making the writes durable and must not block or write to the engine.
+// In cache mode done is called right away with an ErrBestEffort.
+func (e *Engine) OnDurable(done func(error)) {
+	if e.cache != nil {
+		done(&shared.ErrBestEffort{Path: e.Config.Homepath})
+		return
+	}
+	e.wal.OnDurable(done)
+}
+
+// Durable is OnDurable as a channel receiving a single value.
+func (e *Engine) Durable() <-chan error {
+	ch := make(chan error, 1)
+	e.OnDurable(func(err error) { ch <- err })
+	return ch
+}
+
+// ScrubReport returns the corrupt tables found by the last background scrub,
+// it is empty if scrubbing is disabled.
+func (e *Engine) ScrubReport() []ScrubResult {
+	if e.scrubber == nil {
+		return nil
+	}
+	return e.scrubber.Report()
+}
+
 func (e *Engine) Close() error {
+	// stop background purges before the files they write to are closed
+	if e.state.Swap(uint32(StateClosed)) != uint32(StateClosed) {
+		close(e.closing)
+	}
+	e.purges.Wait()
+	e.warming.Wait()
+	e.feed.close()
+
+	// without a WAL the memtable only persists if flushed
+	if e.cache != nil && e.indexManager.memtable.Size() > 0 {
+		e.mu.Lock()
+		err := e.flush()
+		e.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	if e.scrubber != nil {
+		e.scrubber.Close()
+	}
+	if !e.Config.ReadOnly {
+		if err := e.indexManager.access.save(); err != nil {
+			log.Printf("engine can not save the access log: %v", err)
+		}
+	}
 	if err := e.indexManager.Close(); err != nil {
 		return err
 	}
 	if err := e.storageManager.Close(); err != nil {
 		return err
 	}
+	if err := e.wal.Close(); err != nil {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestDeleteDurable(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithWALSync(shared.SyncNever, 0).WithSmallTableMergeSize(0))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	// two flushed versions of the key and one in the memtable
+	for _, value := range []string{"v1", "v2", "v3"} {
+		engine.Set("key", []byte(value))
+		engine.Set("other", []byte(value))
+		if value != "v3" {
+			engine.indexManager.Flush()
+		}
+	}
+
+	result, err := engine.DeleteDurable("key")
+	if err != nil {
+		t.Fatalf("DeleteDurable() error: %v", err)
+	}
+	if result.StaleTables != 2 {
+		t.Errorf("DeleteDurable() stale tables = %d, want 2", result.StaleTables)
+	}
+	if unsynced := engine.wal.Unsynced(); unsynced != 0 {
+		t.Errorf("%d WAL records are not durable after DeleteDurable", unsynced)
+	}
+	if _, err := engine.Get("key"); err == nil {
+		t.Errorf("Get() found the deleted key")
+	}
+}
+
+// unreadableData fails every value read.
+type unreadableData struct{ DataManager }
+
+func (unreadableData) Retrieve(Position) ([]byte, error) {
+	return nil, errors.New("value read")
+}
+
+func TestHas(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithSmallTableMergeSize(0))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	engine.Set("flushed", []byte("value"))
+	engine.Set("gone", []byte("value"))
+	engine.indexManager.Flush()
+	engine.Set("memtable", []byte("value"))
+	engine.Delete("gone")
+
+	data := engine.storageManager
+	engine.storageManager = unreadableData{data}
+	defer func() { engine.storageManager = data }()
+
+	want := map[string]bool{"flushed": true, "memtable": true, "gone": false, "missing": false}
+	for key, exists := range want {
+		if ok, err := engine.Has(key); err != nil || ok != exists {
+			t.Errorf("Has(%q) = %v, %v, want %v", key, ok, err, exists)
+		}
+	}
+}
+
+// uncleared is a WAL failing to truncate.
+type uncleared struct{ WAL }
+
+func (uncleared) Clear() error {
+	return errors.New("WAL truncation")
+}
+
+func TestFailedFlushKeepsTheWAL(t *testing.T) {
+	dir := t.TempDir()
+	config := *shared.NewEngineConfig().WithSmallTableMergeSize(0)
+	engine, err := NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	if err := engine.Set("key", []byte("value")); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+
+	// a directory in place of the next table fails its write
+	blocked := filepath.Join(dir, fmt.Sprintf(config.SSTableNamePrefix+"%d", engine.indexManager.currSerial))
+	if err := os.Mkdir(blocked, 0755); err != nil {
+		t.Fatalf("Mkdir() error: %v", err)
+	}
+	engine.mu.Lock()
+	err = engine.flush()
+	engine.mu.Unlock()
+	if err == nil {
+		t.Fatalf("flush() succeeded without writing its table")
+	}
+	if info, err := os.Stat(engine.wal.(*DiskWAL).source); err != nil || info.Size() == 0 {
+		t.Fatalf("WAL = %v, %v after a failed flush, want it kept", info, err)
+	}
+
+	// the WAL is truncated only once a flush succeeds
+	engine.wal = uncleared{engine.wal}
+	if err := os.Remove(blocked); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	engine.Set("other", []byte("value"))
+	engine.mu.Lock()
+	err = engine.flush()
+	engine.mu.Unlock()
+	if err == nil || !strings.Contains(err.Error(), "WAL truncation") {
+		t.Errorf("flush() error = %v, want the WAL truncation error", err)
+	}
+	engine.wal = engine.wal.(uncleared).WAL
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	engine, err = NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+	for _, key := range []string{"key", "other"} {
+		if value, err := engine.Get(key); err != nil || string(value) != "value" {
+			t.Errorf("Get(%q) = %q, %v after reopening, want value", key, value, err)
+		}
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithSmallTableMergeSize(0))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	engine.Set("flushed", []byte("v1"))
+	engine.Set("gone", []byte("v1"))
+	engine.indexManager.Flush()
+	engine.Set("memtable", []byte("v2"))
+	engine.Delete("gone")
+
+	values, err := engine.GetMulti([]string{"flushed", "memtable", "gone", "missing"})
+	if err != nil {
+		t.Fatalf("GetMulti() error: %v", err)
+	}
+	want := map[string]string{"flushed": "v1", "memtable": "v2"}
+	if len(values) != len(want) {
+		t.Errorf("GetMulti() = %q, want %q", values, want)
+	}
+	for key, value := range want {
+		if string(values[key]) != value {
+			t.Errorf("GetMulti()[%q] = %q, want %q", key, values[key], value)
+		}
+	}
+
+	// a failed read fails the lookup instead of reporting the key missing
+	data := engine.storageManager
+	engine.storageManager = unreadableData{data}
+	defer func() { engine.storageManager = data }()
+	if _, err := engine.GetMulti([]string{"flushed"}); err == nil {
+		t.Errorf("GetMulti() succeeded without reading the value")
+	}
+}
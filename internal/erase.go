@@ -0,0 +1,254 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// PurgedFileName is the file, inside the home path, listing the erased values
+// that tables may still reference.
+const PurgedFileName = "purged.json"
+
+// PurgeReport tells how much of a purged key's history is still on disk.
+type PurgeReport struct {
+	ErasedValues int `json:"erased_values"` // Values overwritten in the data file by this purge.
+	StaleTables  int `json:"stale_tables"`  // Tables still holding the key and the position of an erased value.
+}
+
+// Done reports whether the key's old values are physically gone. Only the
+// tombstone, which holds the key but no value, is left.
+func (r PurgeReport) Done() bool {
+	return r.StaleTables == 0
+}
+
+// purgedPositions remembers, per purged key, the positions of its erased
+// values. Merges drop table entries pointing at them, once no table holds
+// any the key is forgotten. The set is saved to disk on every change.
+type purgedPositions struct {
+	path string
+	keys map[string][]Position
+	mu   sync.Mutex
+}
+
+func loadPurgedPositions(homepath string, readOnly bool) (*purgedPositions, error) {
+	p := &purgedPositions{keys: map[string][]Position{}}
+	if !readOnly {
+		p.path = filepath.Join(homepath, PurgedFileName)
+	}
+
+	data, err := os.ReadFile(filepath.Join(homepath, PurgedFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not read the purged positions: %v", err)
+	}
+	if err := json.Unmarshal(data, &p.keys); err != nil {
+		return nil, fmt.Errorf("can not parse the purged positions: %v", err)
+	}
+	return p, nil
+}
+
+// erased reports whether the pair points at an erased value.
+func (p *purgedPositions) erased(pair KVPair) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, position := range p.keys[pair.Key] {
+		if position == pair.Value {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *purgedPositions) positions(key string) []Position {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.keys[key]
+}
+
+// set replaces the erased positions of key, none forgets the key.
+func (p *purgedPositions) set(key string, positions []Position) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(positions) == 0 {
+		if _, ok := p.keys[key]; !ok {
+			return nil
+		}
+		delete(p.keys, key)
+	} else {
+		p.keys[key] = positions
+	}
+	return p.save()
+}
+
+// save atomically rewrites the file, p.mu must be held by the caller.
+func (p *purgedPositions) save() error {
+	if len(p.path) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(p.keys)
+	if err != nil {
+		return err
+	}
+
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("can not write the purged positions: %v", err)
+	}
+	if err := os.Rename(tmp, p.path); err != nil {
+		return fmt.Errorf("can not replace the purged positions: %v", err)
+	}
+	return syncDir(filepath.Dir(p.path))
+}
+
+// Purge hard deletes key: beyond writing a tombstone it overwrites every value
+// of the key still referenced by the index in the data file and flushes the
+// memtable so the WAL holding the latest value is truncated. Tables keep the
+// key and the positions of the erased values until their next merge, which
+// drops them; PurgeStatus reports when that happened.
+func (e *Engine) Purge(key string) (PurgeReport, error) {
+	if e.Config.ReadOnly {
+		return PurgeReport{}, &shared.ErrReadOnly{Path: e.Config.Homepath}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	positions, err := e.indexManager.valuePositions(key)
+	if err != nil {
+		return PurgeReport{}, fmt.Errorf("engine can not find the values of %q: %v", key, err)
+	}
+
+	if err := e.delete(key, 0, true); err != nil {
+		return PurgeReport{}, err
+	}
+	if err := e.flush(); err != nil {
+		return PurgeReport{}, err
+	}
+
+	// remember the positions first so an interrupted purge is still cleaned up by merges
+	purged := e.indexManager.purged
+	if err := purged.set(key, append(purged.positions(key), positions...)); err != nil {
+		return PurgeReport{}, fmt.Errorf("engine can not record the purge of %q: %v", key, err)
+	}
+
+	// merge operands are erased with the values they were written over,
+	// which tables may reference as well
+	erasable, seen := []Position{}, map[Position]bool{}
+	for _, position := range positions {
+		chain, base, err := e.mergeChain(position)
+		if err != nil {
+			return PurgeReport{}, fmt.Errorf("engine can not find the merge operands of %q: %v", key, err)
+		}
+		for _, position := range append(chain, base) {
+			if position.Size > 0 && !seen[position] {
+				seen[position] = true
+				erasable = append(erasable, position)
+			}
+		}
+	}
+
+	erased := 0
+	for _, position := range erasable {
+		// a deduplicated value still referenced by another key is kept
+		if e.dedup.referenced(position) {
+			continue
+		}
+		if err := e.storageManager.Erase(key, position); err != nil {
+			return PurgeReport{}, fmt.Errorf("engine can not erase a value of %q: %v", key, err)
+		}
+		erased++
+	}
+
+	report, err := e.PurgeStatus(key)
+	report.ErasedValues = erased
+	return report, err
+}
+
+// PurgeStatus reports how many tables still hold erased values of a purged key.
+func (e *Engine) PurgeStatus(key string) (PurgeReport, error) {
+	purged := e.indexManager.purged
+
+	stale, remaining, err := e.indexManager.tablesReferencing(key, purged.positions(key))
+	if err != nil {
+		return PurgeReport{}, fmt.Errorf("engine can not check the purge of %q: %v", key, err)
+	}
+	if stale == 0 {
+		if err := purged.set(key, nil); err != nil {
+			return PurgeReport{}, err
+		}
+	} else if err := purged.set(key, remaining); err != nil {
+		return PurgeReport{}, err
+	}
+
+	return PurgeReport{StaleTables: stale}, nil
+}
+
+// valuePositions returns the positions of every value of key in the memtable and the tables.
+func (im *IndexManager) valuePositions(key string) ([]Position, error) {
+	positions := []Position{}
+	if im.memtable.Contains(key) {
+		if position := im.memtable.Get(key); position.Size > 0 {
+			positions = append(positions, position)
+		}
+	}
+
+	err := im.searchTables(key, func(position Position) {
+		positions = append(positions, position)
+	})
+	return positions, err
+}
+
+// tablesReferencing returns the number of tables holding one of the given
+// positions for key and the positions still referenced.
+func (im *IndexManager) tablesReferencing(key string, positions []Position) (int, []Position, error) {
+	if len(positions) == 0 {
+		return 0, nil, nil
+	}
+
+	tables, remaining := 0, []Position{}
+	err := im.searchTables(key, func(position Position) {
+		for _, erased := range positions {
+			if erased == position {
+				tables++
+				remaining = append(remaining, position)
+				return
+			}
+		}
+	})
+	return tables, remaining, err
+}
+
+// searchTables calls fn with the position of the value of key in every table holding one.
+func (im *IndexManager) searchTables(key string, fn func(Position)) error {
+	im.mu.RLock()
+	tables := im.acquireTables()
+	im.mu.RUnlock()
+	defer releaseTables(tables)
+
+	for _, table := range tables {
+		position, _, err := table.search(key, false)
+		if err == nil {
+			fn(position)
+			continue
+		}
+
+		var errKeyRemoved *shared.ErrKeyRemoved
+		var errKeyNotFound *shared.ErrKeyNotFound
+		if !errors.As(err, &errKeyRemoved) && !errors.As(err, &errKeyNotFound) {
+			return err
+		}
+	}
+	return nil
+}
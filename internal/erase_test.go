@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestPurge(t *testing.T) {
+	home := t.TempDir()
+	config := *shared.NewEngineConfig().WithSmallTableMergeSize(0)
+	engine, err := NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	engine.Set("user:1", []byte("secret-v1"))
+	engine.Set("user:2", []byte("kept"))
+	engine.indexManager.Flush()
+	engine.Set("user:1", []byte("secret-v2"))
+
+	report, err := engine.Purge("user:1")
+	if err != nil {
+		t.Fatalf("Purge() error: %v", err)
+	}
+	if report.ErasedValues != 2 || report.StaleTables != 1 || report.Done() {
+		t.Errorf("Purge() = %+v, want 2 erased values and 1 stale table", report)
+	}
+
+	data, _ := os.ReadFile(filepath.Join(home, DataFileName))
+	if bytes.Contains(data, []byte("secret")) || bytes.Contains(data, []byte("user:1")) {
+		t.Errorf("the data file still holds the purged key or its values")
+	}
+	if err := ScanDataFile(filepath.Join(home, DataFileName), func(DataRecord) error { return nil }); err != nil {
+		t.Errorf("ScanDataFile() after erasing error: %v", err)
+	}
+	if _, err := engine.Get("user:1"); err == nil {
+		t.Errorf("Get() found the purged key")
+	}
+	engine.Close()
+
+	// the pending purge survives a restart and is finished by the next merge
+	engine, err = NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	im := engine.indexManager
+	im.mu.Lock()
+	err = im.mergeTables(im.sstables[1:])
+	im.mu.Unlock()
+	if err != nil {
+		t.Fatalf("mergeTables() error: %v", err)
+	}
+
+	if report, err := engine.PurgeStatus("user:1"); err != nil || !report.Done() {
+		t.Errorf("PurgeStatus() after merging = %+v, %v, want done", report, err)
+	}
+	if value, err := engine.Get("user:2"); err != nil || string(value) != "kept" {
+		t.Errorf("Get(user:2) = %q, %v, want \"kept\"", value, err)
+	}
+}
+
+func TestPurgeColocated(t *testing.T) {
+	// the default config merges the small tables flushed by the purge,
+	// dropping the value file of the first one, the other erases it in place
+	for _, merge := range []uint32{shared.DefaultConfig.SmallTableMergeSize, 0} {
+		home, backup := t.TempDir(), filepath.Join(t.TempDir(), "backup")
+		config := *shared.NewEngineConfig().WithColocatedValues("secret:").WithSmallTableMergeSize(merge)
+		engine, err := NewEngine(home, config)
+		if err != nil {
+			t.Fatalf("NewEngine() error: %v", err)
+		}
+
+		if err := engine.Set("secret:a", []byte("colocated secret")); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+		if err := engine.indexManager.Flush(); err != nil {
+			t.Fatalf("Flush() error: %v", err)
+		}
+		if err := engine.Checkpoint(backup); err != nil {
+			t.Fatalf("Checkpoint() error: %v", err)
+		}
+
+		if _, err := engine.Purge("secret:a"); err != nil {
+			t.Fatalf("Purge() with small tables merged under %d error: %v", merge, err)
+		}
+		if _, err := engine.Get("secret:a"); err == nil {
+			t.Errorf("Get() found the purged key")
+		}
+		for _, name := range valueFiles(t, home) {
+			if data, _ := os.ReadFile(filepath.Join(home, name)); bytes.Contains(data, []byte("secret")) {
+				t.Errorf("value file %q still holds the purged value", name)
+			}
+		}
+		engine.Close()
+
+		// checkpoints taken before the purge still hold the value
+		checkpoint, err := OpenCheckpoint(backup, config)
+		if err != nil {
+			t.Fatalf("OpenCheckpoint() error: %v", err)
+		}
+		if value, err := checkpoint.Get("secret:a"); err != nil || string(value) != "colocated secret" {
+			t.Errorf("checkpoint Get() = %q, %v, want the value before the purge", value, err)
+		}
+		checkpoint.Close()
+	}
+}
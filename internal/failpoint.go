@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrFailpointsDisabled is returned when setting failpoints in a binary built
+// without the failpoints build tag.
+var ErrFailpointsDisabled = errors.New("failpoints are only available in builds with the failpoints tag")
+
+// Failpoints inject faults into every engine of the process for chaos testing
+// applications built on goldb. They are compiled in by the failpoints build
+// tag only, so regular builds pay nothing for the checks.
+type Failpoints struct {
+	FailNextFlush  bool  `json:"fail_next_flush"`  // The next memtable flush fails without writing a table.
+	ReadDelayMs    int64 `json:"read_delay_ms"`    // Delay added to every lookup.
+	CorruptNextWAL bool  `json:"corrupt_next_wal"` // The next WAL record is written with its last byte flipped.
+}
+
+var failpoints struct {
+	mu     sync.Mutex
+	active Failpoints
+}
+
+// SetFailpoints replaces the active failpoints, the one-shot ones are cleared
+// once they fired.
+func SetFailpoints(fp Failpoints) error {
+	if !failpointsEnabled {
+		return ErrFailpointsDisabled
+	}
+
+	failpoints.mu.Lock()
+	defer failpoints.mu.Unlock()
+	failpoints.active = fp
+	return nil
+}
+
+// ActiveFailpoints returns the failpoints that did not fire yet.
+func ActiveFailpoints() Failpoints {
+	failpoints.mu.Lock()
+	defer failpoints.mu.Unlock()
+	return failpoints.active
+}
+
+// failFlush returns the error of a failing flush if FailNextFlush is set.
+func failFlush() error {
+	if !failpointsEnabled {
+		return nil
+	}
+
+	failpoints.mu.Lock()
+	defer failpoints.mu.Unlock()
+	if !failpoints.active.FailNextFlush {
+		return nil
+	}
+	failpoints.active.FailNextFlush = false
+	return errors.New("failpoint: flush failed")
+}
+
+// delayRead sleeps for the configured read delay.
+func delayRead() {
+	if !failpointsEnabled {
+		return
+	}
+
+	if delay := ActiveFailpoints().ReadDelayMs; delay > 0 {
+		time.Sleep(time.Duration(delay) * time.Millisecond)
+	}
+}
+
+// corruptWALRecord flips the last byte of an encoded WAL record if
+// CorruptNextWAL is set.
+func corruptWALRecord(record []byte) {
+	if !failpointsEnabled {
+		return
+	}
+
+	failpoints.mu.Lock()
+	defer failpoints.mu.Unlock()
+	if !failpoints.active.CorruptNextWAL || len(record) == 0 {
+		return
+	}
+	failpoints.active.CorruptNextWAL = false
+	record[len(record)-1] ^= 0xFF
+}
@@ -0,0 +1,5 @@
+//go:build failpoints
+
+package internal
+
+const failpointsEnabled = true
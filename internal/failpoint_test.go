@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// Run with -tags failpoints to exercise the faults.
+func TestFailpoints(t *testing.T) {
+	if !failpointsEnabled {
+		if err := SetFailpoints(Failpoints{FailNextFlush: true}); !errors.Is(err, ErrFailpointsDisabled) {
+			t.Fatalf("SetFailpoints() = %v, want ErrFailpointsDisabled", err)
+		}
+		t.Skip("built without the failpoints tag")
+	}
+	defer SetFailpoints(Failpoints{})
+
+	dir := t.TempDir()
+	engine, err := NewEngine(dir, *shared.NewEngineConfig().WithSmallTableMergeSize(0))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	SetFailpoints(Failpoints{FailNextFlush: true, ReadDelayMs: 20})
+	engine.Set("key", []byte("value"))
+	if err := engine.indexManager.Flush(); err == nil {
+		t.Errorf("Flush() succeeded with FailNextFlush set")
+	}
+	if ActiveFailpoints().FailNextFlush {
+		t.Errorf("FailNextFlush is still set once it fired")
+	}
+
+	start := time.Now()
+	if value, err := engine.Get("key"); err != nil || string(value) != "value" {
+		t.Errorf("Get(key) = %q, %v, want value", value, err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("Get(key) took %v, want the 20ms read delay", elapsed)
+	}
+
+	// the corrupted record fails its checksum and stops the replay
+	SetFailpoints(Failpoints{CorruptNextWAL: true})
+	engine.Set("corrupt", []byte("value"))
+	engine.Set("intact", []byte("value"))
+	engine.wal.Close()
+	engine.wal, err = NewDiskWAL(engine.wal.(*DiskWAL).source, false, shared.SyncNever, 0, nil)
+	if err != nil {
+		t.Fatalf("NewDiskWAL() error: %v", err)
+	}
+
+	replayed := map[string]string{}
+	err = engine.wal.Replay(func(entry WALEntry) error {
+		replayed[entry.Key] = string(entry.Value)
+		return nil
+	})
+	var corruption *shared.ErrCorruption
+	if !errors.As(err, &corruption) || corruption.Path != engine.wal.(*DiskWAL).source {
+		t.Errorf("Replay() error = %v, want an ErrCorruption", err)
+	}
+	if _, ok := replayed["corrupt"]; ok {
+		t.Errorf("replayed the corrupted record: %q", replayed)
+	}
+	engine.Close()
+}
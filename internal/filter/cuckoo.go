@@ -0,0 +1,213 @@
+package filter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/hasssanezzz/goldb/internal/bloom"
+)
+
+const (
+	// bucketSize is the number of fingerprints a bucket holds.
+	bucketSize = 4
+	// cuckooLoad is the share of the slots filled when building, cuckoo
+	// filters of four slot buckets reliably fill up to 95%.
+	cuckooLoad = 0.9
+	// maxKicks bounds the fingerprints relocated by an insertion.
+	maxKicks = 500
+	// maxFingerprintBits is the width of the stored fingerprints.
+	maxFingerprintBits = 16
+	// maxCuckooBuckets bounds the bucket count read from disk.
+	maxCuckooBuckets = 1 << 28
+)
+
+// CuckooFilter is a cuckoo filter of four slot buckets and fingerprints of
+// up to 16 bits, unlike a bloom filter keys can be removed from it. Insert
+// and Delete are not safe for concurrent use, Test is safe once the filter
+// is built.
+type CuckooFilter struct {
+	fingerprints []uint16 // bucketSize slots per bucket, zero is an empty slot.
+	mask         uint64   // Bucket count minus one, the count is a power of two.
+	bits         uint32   // Width of the fingerprints.
+	seed         uint64
+	kick         uint64 // State of the victim choice of Insert.
+}
+
+// NewCuckoo creates a cuckoo filter sized for capacity items at the given
+// false positive rate.
+func NewCuckoo(capacity int, falsePositiveRate float64) *CuckooFilter {
+	return newCuckoo(capacity, falsePositiveRate, bloom.DefaultSeed)
+}
+
+func newCuckoo(capacity int, falsePositiveRate float64, seed uint64) *CuckooFilter {
+	buckets := uint64(math.Ceil(float64(max(capacity, 1)) / (bucketSize * cuckooLoad)))
+	buckets = 1 << bits.Len64(buckets-1)
+
+	// a lookup compares 2*bucketSize fingerprints
+	fingerprintBits := int(math.Ceil(math.Log2(2 * bucketSize / falsePositiveRate)))
+	fingerprintBits = min(max(fingerprintBits, 4), maxFingerprintBits)
+
+	return &CuckooFilter{
+		fingerprints: make([]uint16, buckets*bucketSize),
+		mask:         buckets - 1,
+		bits:         uint32(fingerprintBits),
+		seed:         seed,
+		kick:         seed | 1,
+	}
+}
+
+// buildCuckoo builds a filter of keys, doubling its buckets until every key
+// fits. Keys the filter already reports are not inserted again, a fingerprint
+// can only be stored so many times in its two buckets.
+func buildCuckoo(keys [][]byte, falsePositiveRate float64) *CuckooFilter {
+	for capacity := max(len(keys), 1); ; capacity *= 2 {
+		f := NewCuckoo(capacity, falsePositiveRate)
+		full := false
+		for _, key := range keys {
+			if !f.Test(key) && !f.Insert(key) {
+				full = true
+				break
+			}
+		}
+		if !full {
+			return f
+		}
+	}
+}
+
+// locate returns the fingerprint of item and its two candidate buckets.
+func (f *CuckooFilter) locate(item []byte) (uint16, uint64, uint64) {
+	h := bloom.Hash64(item, f.seed)
+	fingerprint := uint16(h & (1<<f.bits - 1))
+	if fingerprint == 0 {
+		fingerprint = 1
+	}
+	i1 := (h >> 32) & f.mask
+	return fingerprint, i1, f.alternate(i1, fingerprint)
+}
+
+// alternate returns the other bucket of a fingerprint stored in bucket i.
+func (f *CuckooFilter) alternate(i uint64, fingerprint uint16) uint64 {
+	return (i ^ uint64(fingerprint)*0x5bd1e995) & f.mask
+}
+
+func (f *CuckooFilter) bucket(i uint64) []uint16 {
+	return f.fingerprints[i*bucketSize : (i+1)*bucketSize]
+}
+
+// Insert adds item to the filter, it reports false if the filter is too
+// full to take it, in which case an earlier item may have been evicted.
+func (f *CuckooFilter) Insert(item []byte) bool {
+	fingerprint, i1, i2 := f.locate(item)
+	if f.place(i1, fingerprint) || f.place(i2, fingerprint) {
+		return true
+	}
+
+	i := i1
+	for range maxKicks {
+		// xorshift picks the victim among the slots of the bucket
+		f.kick ^= f.kick << 13
+		f.kick ^= f.kick >> 7
+		f.kick ^= f.kick << 17
+		bucket := f.bucket(i)
+		slot := f.kick % bucketSize
+		fingerprint, bucket[slot] = bucket[slot], fingerprint
+
+		i = f.alternate(i, fingerprint)
+		if f.place(i, fingerprint) {
+			return true
+		}
+	}
+	return false
+}
+
+// place stores fingerprint in a free slot of bucket i.
+func (f *CuckooFilter) place(i uint64, fingerprint uint16) bool {
+	bucket := f.bucket(i)
+	for slot, stored := range bucket {
+		if stored == 0 {
+			bucket[slot] = fingerprint
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes an item that was inserted, it reports whether its
+// fingerprint was found. Deleting an item never inserted may remove another
+// one sharing its fingerprint.
+func (f *CuckooFilter) Delete(item []byte) bool {
+	fingerprint, i1, i2 := f.locate(item)
+	for _, i := range []uint64{i1, i2} {
+		bucket := f.bucket(i)
+		for slot, stored := range bucket {
+			if stored == fingerprint {
+				bucket[slot] = 0
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Test reports whether item might have been inserted.
+func (f *CuckooFilter) Test(item []byte) bool {
+	fingerprint, i1, i2 := f.locate(item)
+	for _, i := range []uint64{i1, i2} {
+		for _, stored := range f.bucket(i) {
+			if stored == fingerprint {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MemoryUsage returns the approximate number of bytes held by the filter.
+func (f *CuckooFilter) MemoryUsage() uint64 {
+	return uint64(2 * len(f.fingerprints))
+}
+
+// ToBytes serializes the filter: the header holding the fingerprint width
+// and the bucket count, then the fingerprints.
+func (f *CuckooFilter) ToBytes() []byte {
+	buf := make([]byte, 0, headerSize+2*len(f.fingerprints))
+	buf = appendHeader(buf, formatCuckoo, f.bits, uint32(f.mask+1), f.seed)
+	for _, fingerprint := range f.fingerprints {
+		buf = binary.LittleEndian.AppendUint16(buf, fingerprint)
+	}
+	return buf
+}
+
+func decodeCuckoo(data []byte) (*CuckooFilter, error) {
+	fingerprintBits, buckets, seed, err := readHeader(data, "cuckoo")
+	if err != nil {
+		return nil, err
+	}
+	if fingerprintBits == 0 || fingerprintBits > maxFingerprintBits {
+		return nil, fmt.Errorf("cuckoo filter has fingerprints of %d bits, at most %d are supported", fingerprintBits, maxFingerprintBits)
+	}
+	if buckets == 0 || buckets > maxCuckooBuckets || buckets&(buckets-1) != 0 {
+		return nil, fmt.Errorf("cuckoo filter has %d buckets, not a power of two up to %d", buckets, maxCuckooBuckets)
+	}
+
+	// the length is checked before allocating
+	data = data[headerSize:]
+	slots := uint64(buckets) * bucketSize
+	if 2*slots > uint64(len(data)) {
+		return nil, fmt.Errorf("cuckoo filter of %d buckets is cut short at %d bytes", buckets, len(data))
+	}
+	f := &CuckooFilter{
+		fingerprints: make([]uint16, slots),
+		mask:         uint64(buckets) - 1,
+		bits:         fingerprintBits,
+		seed:         seed,
+		kick:         seed | 1,
+	}
+	for i := range f.fingerprints {
+		f.fingerprints[i] = binary.LittleEndian.Uint16(data[2*i:]) & (1<<fingerprintBits - 1)
+	}
+	return f, nil
+}
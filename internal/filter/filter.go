@@ -0,0 +1,146 @@
+// Package filter abstracts the probabilistic structures the SSTables are
+// built with to rule out the keys they do not hold.
+//
+// Every serialized filter starts with a little endian uint32 holding its
+// format in its high 16 bits. Formats 0 and 1 are bloom filters, see package
+// bloom, 2 is a cuckoo filter and 3 a ribbon filter. Decode reads any of them
+// so a table can be read whatever the policy it was built with.
+package filter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/hasssanezzz/goldb/internal/bloom"
+)
+
+const (
+	formatCuckoo = 2
+	formatRibbon = 3
+
+	headerSize = 16 // format word, size word and seed
+)
+
+// Filter answers whether a key may belong to the set it was built from.
+type Filter interface {
+	// Test reports whether item might be in the set, false means it
+	// definitely is not.
+	Test(item []byte) bool
+	// MemoryUsage returns the approximate number of bytes held by the filter.
+	MemoryUsage() uint64
+	// ToBytes serializes the filter, Decode reads it back.
+	ToBytes() []byte
+}
+
+// Policy builds the filter of a table from all of its keys at once, some
+// structures can not be filled one key at a time.
+type Policy interface {
+	Name() string
+	Build(keys [][]byte, falsePositiveRate float64) Filter
+	// Rate returns the false positive rate of the filters the policy builds
+	// with bitsPerKey bits per key.
+	Rate(bitsPerKey float64) float64
+}
+
+var (
+	Bloom  Policy = bloomPolicy{}
+	Cuckoo Policy = cuckooPolicy{}
+	Ribbon Policy = ribbonPolicy{}
+)
+
+type bloomPolicy struct{}
+
+func (bloomPolicy) Name() string { return "bloom" }
+
+func (bloomPolicy) Build(keys [][]byte, falsePositiveRate float64) Filter {
+	f := bloom.New(len(keys), falsePositiveRate)
+	for _, key := range keys {
+		f.Add(key)
+	}
+	return f
+}
+
+// Rate of a bloom filter with the optimal number of hashes.
+func (bloomPolicy) Rate(bitsPerKey float64) float64 {
+	return math.Pow(0.5, bitsPerKey*math.Ln2)
+}
+
+type cuckooPolicy struct{}
+
+func (cuckooPolicy) Name() string { return "cuckoo" }
+
+func (cuckooPolicy) Build(keys [][]byte, falsePositiveRate float64) Filter {
+	return buildCuckoo(keys, falsePositiveRate)
+}
+
+// Rate of a filter whose fingerprints take the bits of a key at the load
+// the filters are built for, each lookup compares two buckets of them.
+func (cuckooPolicy) Rate(bitsPerKey float64) float64 {
+	return 2 * bucketSize / math.Exp2(bitsPerKey*cuckooLoad)
+}
+
+type ribbonPolicy struct{}
+
+func (ribbonPolicy) Name() string { return "ribbon" }
+
+func (ribbonPolicy) Build(keys [][]byte, falsePositiveRate float64) Filter {
+	return buildRibbon(keys, falsePositiveRate)
+}
+
+// Rate of a filter whose result bits take the bits of a key, spread over
+// the spare slots.
+func (ribbonPolicy) Rate(bitsPerKey float64) float64 {
+	return math.Exp2(-bitsPerKey / ribbonOverhead)
+}
+
+// PolicyOf returns the policy that builds filters like f.
+func PolicyOf(f Filter) Policy {
+	switch f.(type) {
+	case *CuckooFilter:
+		return Cuckoo
+	case *RibbonFilter:
+		return Ribbon
+	}
+	return Bloom
+}
+
+// Decode deserializes a filter of any policy.
+func Decode(data []byte) (Filter, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("filter of %d bytes has no header", len(data))
+	}
+
+	var f Filter
+	var err error
+	switch binary.LittleEndian.Uint32(data) >> 16 {
+	case formatCuckoo:
+		f, err = decodeCuckoo(data)
+	case formatRibbon:
+		f, err = decodeRibbon(data)
+	default:
+		f, err = bloom.FromBytes(data)
+	}
+	// a nil filter of a concrete type is not a nil Filter
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// appendHeader appends the header shared by the cuckoo and ribbon filters:
+// the format word holding param in its low bits, the size word and the seed.
+func appendHeader(dst []byte, format, param, size uint32, seed uint64) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, param|format<<16)
+	dst = binary.LittleEndian.AppendUint32(dst, size)
+	return binary.LittleEndian.AppendUint64(dst, seed)
+}
+
+// readHeader reads the header written by appendHeader, returning the param,
+// size and seed.
+func readHeader(data []byte, kind string) (uint32, uint32, uint64, error) {
+	if len(data) < headerSize {
+		return 0, 0, 0, fmt.Errorf("%s filter of %d bytes has no header", kind, len(data))
+	}
+	return binary.LittleEndian.Uint32(data) & 0xFFFF, binary.LittleEndian.Uint32(data[4:]), binary.LittleEndian.Uint64(data[8:]), nil
+}
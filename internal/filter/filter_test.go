@@ -0,0 +1,143 @@
+package filter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+)
+
+func testKeys(prefix string, n int) [][]byte {
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%s%d", prefix, i))
+	}
+	return keys
+}
+
+func TestPolicies(t *testing.T) {
+	keys := testKeys("key", 10000)
+	for _, policy := range []Policy{Bloom, Cuckoo, Ribbon} {
+		f := policy.Build(keys, 0.01)
+
+		decoded, err := Decode(f.ToBytes())
+		if err != nil {
+			t.Fatalf("%s: Decode() error: %v", policy.Name(), err)
+		}
+		if PolicyOf(decoded) != policy {
+			t.Errorf("%s: Decode() returned a %s filter", policy.Name(), PolicyOf(decoded).Name())
+		}
+
+		for _, key := range keys {
+			if !decoded.Test(key) {
+				t.Fatalf("%s: Test(%q) missed a key", policy.Name(), key)
+			}
+		}
+		positives := 0
+		for _, key := range testKeys("absent", len(keys)) {
+			if decoded.Test(key) {
+				positives++
+			}
+		}
+		if rate := float64(positives) / float64(len(keys)); rate > 0.02 {
+			t.Errorf("%s: false positive rate is %.4f, want about 0.01", policy.Name(), rate)
+		}
+	}
+
+	// the ribbon filter is the smallest for the same rate
+	if ribbon, bloom := Ribbon.Build(keys, 0.01).MemoryUsage(), Bloom.Build(keys, 0.01).MemoryUsage(); ribbon >= bloom {
+		t.Errorf("ribbon filter takes %d bytes, not fewer than the %d of the bloom filter", ribbon, bloom)
+	}
+}
+
+func TestRate(t *testing.T) {
+	keys := testKeys("key", 10000)
+	for _, policy := range []Policy{Bloom, Cuckoo, Ribbon} {
+		if policy.Rate(12) >= policy.Rate(8) {
+			t.Errorf("%s: Rate(12) = %v is not below Rate(8) = %v", policy.Name(), policy.Rate(12), policy.Rate(8))
+		}
+	}
+
+	// a bloom filter of a given rate spends the bits per key it was asked for
+	for _, bits := range []float64{6, 10, 16} {
+		f := Bloom.Build(keys, Bloom.Rate(bits))
+		if got := float64(8*f.MemoryUsage()) / float64(len(keys)); got < bits*0.95 || got > bits*1.05+1 {
+			t.Errorf("bloom filter of Rate(%v) takes %.2f bits per key", bits, got)
+		}
+	}
+}
+
+func TestCuckooDelete(t *testing.T) {
+	f := NewCuckoo(100, 0.01)
+	for _, key := range testKeys("key", 100) {
+		if !f.Insert(key) {
+			t.Fatalf("Insert(%q) found the filter full", key)
+		}
+	}
+	if !f.Delete([]byte("key42")) || f.Test([]byte("key42")) {
+		t.Errorf("Delete(key42) left the key in the filter")
+	}
+	if !f.Test([]byte("key43")) {
+		t.Errorf("Delete(key42) removed key43")
+	}
+
+	// keys repeated more often than two buckets hold still build
+	repeated := make([][]byte, 100)
+	for i := range repeated {
+		repeated[i] = []byte("key")
+	}
+	if !Cuckoo.Build(repeated, 0.01).Test([]byte("key")) {
+		t.Errorf("Build() of a repeated key missed it")
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	header := func(format, param, size uint32) []byte {
+		return appendHeader(nil, format, param, size, 0)
+	}
+	tests := map[string][]byte{
+		"short header":          {1},
+		"cuckoo without seed":   binary.LittleEndian.AppendUint32(nil, formatCuckoo<<16|8),
+		"cuckoo fingerprints":   header(formatCuckoo, 17, 1),
+		"cuckoo buckets":        header(formatCuckoo, 8, 3),
+		"cuckoo cut short":      header(formatCuckoo, 8, 1<<20),
+		"ribbon result bits":    header(formatRibbon, 9, 64),
+		"ribbon too few slots":  header(formatRibbon, 7, 10),
+		"ribbon cut short":      header(formatRibbon, 7, 1<<20),
+		"unknown bloom formats": header(9, 1, 8),
+	}
+	for name, data := range tests {
+		if _, err := Decode(data); err == nil {
+			t.Errorf("Decode() of %s succeeded", name)
+		}
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	keys := testKeys("key", 100)
+	for _, policy := range []Policy{Bloom, Cuckoo, Ribbon} {
+		f.Add(policy.Build(keys, 0.01).ToBytes())
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		filter, err := Decode(data)
+		if err != nil {
+			return
+		}
+		filter.Test([]byte("key"))
+		if _, err := Decode(filter.ToBytes()); err != nil {
+			t.Fatalf("Decode() of a serialized filter error: %v", err)
+		}
+	})
+}
+
+func benchmarkBuild(b *testing.B, policy Policy) {
+	keys := testKeys("key", 100000)
+	b.ResetTimer()
+	for range b.N {
+		policy.Build(keys, 0.01)
+	}
+}
+
+func BenchmarkBuildBloom(b *testing.B)  { benchmarkBuild(b, Bloom) }
+func BenchmarkBuildCuckoo(b *testing.B) { benchmarkBuild(b, Cuckoo) }
+func BenchmarkBuildRibbon(b *testing.B) { benchmarkBuild(b, Ribbon) }
@@ -0,0 +1,137 @@
+package filter
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+
+	"github.com/hasssanezzz/goldb/internal/bloom"
+)
+
+const (
+	// ribbonWidth is the number of consecutive slots an item's equation
+	// spans, the bits of a uint64 coefficient.
+	ribbonWidth = 64
+	// maxResultBits is the width of the slots, each holding a byte.
+	maxResultBits = 8
+	// maxRibbonSlots bounds the slot count read from disk.
+	maxRibbonSlots = 1 << 31
+	// ribbonOverhead is the slots per key, a few percent are spare.
+	ribbonOverhead = 1.05
+)
+
+// RibbonFilter is a homogeneous ribbon filter: every item maps to a window
+// of ribbonWidth slots and a coefficient selecting some of them, and the
+// slots are solved so the selected ones of every item XOR to zero. Any other
+// item passes with a probability of 2^-r for r result bits per slot. It is
+// smaller than a bloom filter of the same rate but has to be built from all
+// of its items at once.
+type RibbonFilter struct {
+	slots []uint8
+	r     uint32 // Result bits per slot.
+	seed  uint64
+}
+
+// buildRibbon solves a ribbon filter of keys for the given false positive
+// rate. Homogeneous ribbons never fail to build, a few percent of spare
+// slots keep the rate close to the target.
+func buildRibbon(keys [][]byte, falsePositiveRate float64) *RibbonFilter {
+	r := int(math.Ceil(-math.Log2(falsePositiveRate)))
+	r = min(max(r, 1), maxResultBits)
+	n := len(keys)
+	f := &RibbonFilter{
+		slots: make([]uint8, n+n/20+ribbonWidth),
+		r:     uint32(r),
+		seed:  bloom.DefaultSeed,
+	}
+
+	// Gaussian elimination into rows of the banded matrix, row i holds the
+	// coefficient of an equation whose first slot is i. An equation reduced
+	// to nothing is implied by the others, duplicate keys are.
+	rows := make([]uint64, len(f.slots))
+	for _, key := range keys {
+		start, coefficient := f.equation(key)
+		for coefficient != 0 {
+			if rows[start] == 0 {
+				rows[start] = coefficient
+				break
+			}
+			coefficient ^= rows[start]
+			shift := bits.TrailingZeros64(coefficient)
+			start += uint64(shift)
+			coefficient >>= shift
+		}
+	}
+
+	// back substitution, the slots of no equation are free and take random
+	// values so other items do not pass on them
+	mask := uint8(1<<r - 1)
+	for i := len(rows) - 1; i >= 0; i-- {
+		if rows[i] == 0 {
+			f.slots[i] = uint8(bloom.Hash64([]byte{byte(i), byte(i >> 8), byte(i >> 16), byte(i >> 24)}, f.seed)) & mask
+			continue
+		}
+		var value uint8
+		for rest := rows[i] &^ 1; rest != 0; rest &= rest - 1 {
+			value ^= f.slots[i+bits.TrailingZeros64(rest)]
+		}
+		f.slots[i] = value
+	}
+	return f
+}
+
+// equation returns the first slot and the coefficient of item, whose lowest
+// bit is set.
+func (f *RibbonFilter) equation(item []byte) (uint64, uint64) {
+	h := bloom.Hash64(item, f.seed)
+	starts := uint64(len(f.slots) - ribbonWidth + 1)
+	start, _ := bits.Mul64(h, starts)
+	coefficient := (h*0x9E3779B97F4A7C15 ^ h>>29) | 1
+	return start, coefficient
+}
+
+// Test reports whether item might have been one of the keys the filter was
+// built from.
+func (f *RibbonFilter) Test(item []byte) bool {
+	start, coefficient := f.equation(item)
+	var value uint8
+	for ; coefficient != 0; coefficient &= coefficient - 1 {
+		value ^= f.slots[start+uint64(bits.TrailingZeros64(coefficient))]
+	}
+	return value == 0
+}
+
+// MemoryUsage returns the approximate number of bytes held by the filter.
+func (f *RibbonFilter) MemoryUsage() uint64 {
+	return uint64(len(f.slots))
+}
+
+// ToBytes serializes the filter: the header holding the result bits and the
+// slot count, then a byte per slot.
+func (f *RibbonFilter) ToBytes() []byte {
+	buf := make([]byte, 0, headerSize+len(f.slots))
+	buf = appendHeader(buf, formatRibbon, f.r, uint32(len(f.slots)), f.seed)
+	return append(buf, f.slots...)
+}
+
+func decodeRibbon(data []byte) (*RibbonFilter, error) {
+	r, slots, seed, err := readHeader(data, "ribbon")
+	if err != nil {
+		return nil, err
+	}
+	if r == 0 || r > maxResultBits {
+		return nil, fmt.Errorf("ribbon filter has %d result bits, at most %d are supported", r, maxResultBits)
+	}
+	if slots < ribbonWidth || slots > maxRibbonSlots {
+		return nil, fmt.Errorf("ribbon filter has %d slots, not between %d and %d", slots, ribbonWidth, maxRibbonSlots)
+	}
+
+	// the length is checked before allocating
+	data = data[headerSize:]
+	if uint64(slots) > uint64(len(data)) {
+		return nil, fmt.Errorf("ribbon filter of %d slots is cut short at %d bytes", slots, len(data))
+	}
+	f := &RibbonFilter{slots: make([]uint8, slots), r: r, seed: seed}
+	copy(f.slots, data)
+	return f, nil
+}
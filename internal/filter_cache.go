@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"container/list"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hasssanezzz/goldb/internal/filter"
+)
+
+type filterCacheEntry struct {
+	table  *SSTable
+	filter filter.Filter
+	size   uint64
+}
+
+// filterLoad is a filter being read from disk, lookups of its table wait for
+// it instead of reading it again.
+type filterLoad struct {
+	done   chan struct{}
+	filter filter.Filter
+}
+
+// FilterCache keeps the bloom filters of open SSTables in memory under a
+// byte budget. Filters are evicted in least-recently-used order when the
+// budget is exceeded and reloaded from their table on the next lookup.
+// A budget of zero means filters are never evicted. Filters are read from disk
+// without holding the lock, so a miss only delays lookups of its own table.
+type FilterCache struct {
+	budget  uint64
+	used    uint64
+	lru     *list.List
+	entries map[*SSTable]*list.Element
+	loads   map[*SSTable]*filterLoad
+	load    func(*SSTable) (filter.Filter, error) // Reads a filter, SSTable.loadFilter unless replaced by tests.
+	debug   atomic.Bool                           // Logs filter load failures, see Engine.SetDebug.
+
+	mu sync.Mutex
+}
+
+func NewFilterCache(budget uint64, debug bool) *FilterCache {
+	fc := &FilterCache{
+		budget:  budget,
+		lru:     list.New(),
+		entries: make(map[*SSTable]*list.Element),
+		loads:   make(map[*SSTable]*filterLoad),
+		load:    (*SSTable).loadFilter,
+	}
+	fc.debug.Store(debug)
+	return fc
+}
+
+// Get returns the filter of the given table, loading it from disk if it was
+// evicted. A nil filter means it does not fit in the budget, in which case
+// the caller should fall back to the table's key range. Concurrent misses of
+// the same table share a single read.
+func (fc *FilterCache) Get(table *SSTable) filter.Filter {
+	fc.mu.Lock()
+	if elem, ok := fc.entries[table]; ok {
+		fc.lru.MoveToFront(elem)
+		fc.mu.Unlock()
+		return elem.Value.(*filterCacheEntry).filter
+	}
+	if load, ok := fc.loads[table]; ok {
+		fc.mu.Unlock()
+		<-load.done
+		return load.filter
+	}
+
+	if !fc.fits(uint64(table.metadata.FilterSize)) {
+		fc.mu.Unlock()
+		return nil
+	}
+	load := &filterLoad{done: make(chan struct{})}
+	fc.loads[table] = load
+	fc.mu.Unlock()
+
+	filter, err := fc.load(table)
+	if err != nil && fc.debug.Load() {
+		log.Printf("filter cache: can not load filter of table %d: %v", table.metadata.Serial, err)
+	}
+
+	fc.mu.Lock()
+	// The table was removed while loading, or its filter replaced by Put
+	if fc.loads[table] == load {
+		delete(fc.loads, table)
+		if _, ok := fc.entries[table]; err == nil && !ok {
+			fc.put(table, filter)
+		}
+	}
+	fc.mu.Unlock()
+
+	if err != nil {
+		filter = nil
+	}
+	load.filter = filter
+	close(load.done)
+	return filter
+}
+
+// Put caches a freshly built or read filter, evicting older filters if needed.
+// The filter is dropped if it alone exceeds the budget.
+func (fc *FilterCache) Put(table *SSTable, filter filter.Filter) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if _, ok := fc.entries[table]; ok {
+		fc.remove(table)
+	}
+	fc.put(table, filter)
+}
+
+// Remove drops the filter of the given table, it must be called when the
+// table is closed.
+func (fc *FilterCache) Remove(table *SSTable) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.remove(table)
+	delete(fc.loads, table)
+}
+
+// Usage returns the number of bytes held by the loaded filters.
+func (fc *FilterCache) Usage() uint64 {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return fc.used
+}
+
+// Len returns the number of loaded filters.
+func (fc *FilterCache) Len() int {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	return len(fc.entries)
+}
+
+func (fc *FilterCache) fits(size uint64) bool {
+	return fc.budget == 0 || size <= fc.budget
+}
+
+func (fc *FilterCache) put(table *SSTable, filter filter.Filter) {
+	size := filter.MemoryUsage()
+	if !fc.fits(size) {
+		return
+	}
+
+	// Evict the least recently used filters until the new one fits
+	for fc.budget > 0 && fc.used+size > fc.budget {
+		oldest := fc.lru.Back()
+		if oldest == nil {
+			break
+		}
+		fc.remove(oldest.Value.(*filterCacheEntry).table)
+	}
+
+	entry := &filterCacheEntry{table: table, filter: filter, size: size}
+	fc.entries[table] = fc.lru.PushFront(entry)
+	fc.used += size
+}
+
+func (fc *FilterCache) remove(table *SSTable) {
+	elem, ok := fc.entries[table]
+	if !ok {
+		return
+	}
+
+	fc.used -= elem.Value.(*filterCacheEntry).size
+	fc.lru.Remove(elem)
+	delete(fc.entries, table)
+}
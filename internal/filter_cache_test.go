@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal/filter"
+)
+
+// sizedFilter is a filter holding its size in bytes and matching every key.
+type sizedFilter uint64
+
+func (f sizedFilter) Test([]byte) bool    { return true }
+func (f sizedFilter) MemoryUsage() uint64 { return uint64(f) }
+func (f sizedFilter) ToBytes() []byte     { return nil }
+
+func TestFilterCacheBudget(t *testing.T) {
+	tables := make([]*SSTable, 4)
+	for i := range tables {
+		tables[i] = &SSTable{metadata: TableMetadata{Serial: uint32(i), FilterSize: 10}}
+	}
+	fc := NewFilterCache(250, false)
+	loads := map[*SSTable]int{}
+	fc.load = func(table *SSTable) (filter.Filter, error) {
+		loads[table]++
+		return sizedFilter(100), nil
+	}
+
+	// a filter over the budget is dropped
+	fc.Put(tables[0], sizedFilter(300))
+	if fc.Len() != 0 || fc.Usage() != 0 {
+		t.Errorf("cache holds %d filters of %d bytes after putting one over the budget, want none", fc.Len(), fc.Usage())
+	}
+
+	// the least recently used filter is evicted first
+	fc.Put(tables[0], sizedFilter(100))
+	fc.Put(tables[1], sizedFilter(100))
+	fc.Get(tables[0])
+	fc.Put(tables[2], sizedFilter(100))
+	if fc.Len() != 2 || fc.Usage() != 200 {
+		t.Errorf("cache holds %d filters of %d bytes, want 2 of 200", fc.Len(), fc.Usage())
+	}
+	if _, ok := fc.entries[tables[1]]; ok {
+		t.Errorf("the least recently used filter was kept")
+	}
+	if len(loads) != 0 {
+		t.Errorf("cached filters were loaded %d times, want none", len(loads))
+	}
+
+	// an evicted filter is loaded again, evicting another one
+	if got := fc.Get(tables[1]); got != sizedFilter(100) || loads[tables[1]] != 1 {
+		t.Errorf("Get() = %v after %d loads, want the evicted filter loaded once", got, loads[tables[1]])
+	}
+	if _, ok := fc.entries[tables[0]]; ok || fc.Len() != 2 || fc.Usage() != 200 {
+		t.Errorf("cache holds %d filters of %d bytes after reloading, want 2 of 200 without the oldest", fc.Len(), fc.Usage())
+	}
+
+	// a filter whose serialized size does not fit is not loaded, loaded
+	// filters take about their serialized size
+	tables[3].metadata.FilterSize = 300
+	if got := fc.Get(tables[3]); got != nil || loads[tables[3]] != 0 {
+		t.Errorf("Get() = %v after %d loads, want no filter loaded", got, loads[tables[3]])
+	}
+	tables[3].metadata.FilterSize = 200
+	if got := fc.Get(tables[3]); got != sizedFilter(100) || loads[tables[3]] != 1 {
+		t.Errorf("Get() = %v after %d loads, want a filter within the budget loaded", got, loads[tables[3]])
+	}
+
+	// a failed load is not cached
+	fc.load = func(*SSTable) (filter.Filter, error) { return nil, errors.New("unreadable") }
+	fc.Remove(tables[1])
+	if got := fc.Get(tables[1]); got != nil || fc.Len() != 1 || fc.Usage() != 100 {
+		t.Errorf("Get() = %v with %d filters of %d bytes cached after a failed load, want nil and 1 of 100", got, fc.Len(), fc.Usage())
+	}
+}
+
+func TestFilterCacheLoadsOutsideTheLock(t *testing.T) {
+	slow := &SSTable{metadata: TableMetadata{Serial: 1}}
+	cached := &SSTable{metadata: TableMetadata{Serial: 2}}
+	fc := NewFilterCache(0, false)
+	fc.Put(cached, sizedFilter(1))
+
+	var loads atomic.Int32
+	release := make(chan struct{})
+	fc.load = func(*SSTable) (filter.Filter, error) {
+		loads.Add(1)
+		<-release
+		return sizedFilter(2), nil
+	}
+
+	var wg sync.WaitGroup
+	got := make([]filter.Filter, 4)
+	for i := range got {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i] = fc.Get(slow)
+		}()
+	}
+
+	// lookups of other tables are served while the filter is read
+	done := make(chan struct{})
+	go func() {
+		fc.Get(cached)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("a cached filter waited for another table's load")
+	}
+
+	close(release)
+	wg.Wait()
+	if loads.Load() != 1 {
+		t.Errorf("filter loaded %d times by concurrent misses, want once", loads.Load())
+	}
+	for i, filter := range got {
+		if filter != sizedFilter(2) {
+			t.Errorf("Get() #%d = %v, want the loaded filter", i, filter)
+		}
+	}
+}
+
+func TestFilterCacheRemoveWhileLoading(t *testing.T) {
+	table := &SSTable{metadata: TableMetadata{Serial: 1}}
+	fc := NewFilterCache(0, false)
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	fc.load = func(*SSTable) (filter.Filter, error) {
+		close(loading)
+		<-release
+		return sizedFilter(1), nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		fc.Get(table)
+		close(done)
+	}()
+	<-loading
+	fc.Remove(table)
+	close(release)
+	<-done
+
+	if fc.Len() != 0 {
+		t.Errorf("cache holds %d filters, want the filter of a removed table dropped", fc.Len())
+	}
+}
@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// filterFeedbackMinLookups is the number of lookups of absent keys a table
+// must see before its observed false positive rate is trusted.
+const filterFeedbackMinLookups = 1000
+
+// minFilterFalsePositiveRate bounds how tight a rebuilt filter gets.
+const minFilterFalsePositiveRate = 0.0001
+
+// filterObservations counts the lookups of keys a table does not hold by the
+// answer of its filter.
+type filterObservations struct {
+	negatives      atomic.Uint64 // The filter ruled the key out.
+	falsePositives atomic.Uint64 // The filter let the key through.
+}
+
+// observe records the filter's answer to the lookup of a key the table does
+// not hold.
+func (o *filterObservations) observe(probe TableProbe) {
+	switch {
+	case !probe.FilterLoaded:
+	case probe.FilterPassed:
+		o.falsePositives.Add(1)
+	default:
+		o.negatives.Add(1)
+	}
+}
+
+// rate returns the share of absent keys the filter let through, and whether
+// enough lookups were seen to tell.
+func (o *filterObservations) rate() (float64, bool) {
+	falsePositives, negatives := o.falsePositives.Load(), o.negatives.Load()
+	total := falsePositives + negatives
+	if total < filterFeedbackMinLookups {
+		return 0, false
+	}
+	return float64(falsePositives) / float64(total), true
+}
+
+func (o *filterObservations) reset() {
+	o.negatives.Store(0)
+	o.falsePositives.Store(0)
+}
+
+// tightenFilters rebuilds the filters whose observed false positive rate
+// exceeds Config.FilterRebuildRate, see tightenFilter.
+func (im *IndexManager) tightenFilters(tables []*SSTable) {
+	for _, table := range tables {
+		if _, err := im.tightenFilter(table); err != nil {
+			log.Printf("index manager: %v", err)
+		}
+	}
+}
+
+// tightenFilter rebuilds the table's filter into its sidecar if its observed
+// false positive rate exceeds Config.FilterRebuildRate, for instance because
+// it was sized by an older configuration. The new filter asks for a rate as
+// many times lower than the target as the observed one is over it. It
+// reports whether the filter was rebuilt.
+func (im *IndexManager) tightenFilter(table *SSTable) (bool, error) {
+	threshold := im.config.FilterRebuildRate
+	if threshold == 0 || im.config.ReadOnly {
+		return false, nil
+	}
+	observed, ok := table.observed.rate()
+	if !ok || observed <= threshold {
+		return false, nil
+	}
+
+	// the filters hold the deleted keys too
+	pairs, err := table.Items()
+	if err != nil {
+		return false, fmt.Errorf("can not read the keys of table %q to rebuild its filter: %v", table.metadata.Path, err)
+	}
+	defer putPairs(pairs)
+
+	target := filterRate(im.config)
+	rate := max(target*target/observed, min(minFilterFalsePositiveRate, target))
+	keys := make([]string, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.Key
+	}
+	bf := buildFilter(im.config, keys, rate)
+	if err := writeSidecar(table.filterSidecarPath(), bf.ToBytes()); err != nil {
+		return false, fmt.Errorf("can not rebuild the filter of table %q: %v", table.metadata.Path, err)
+	}
+
+	table.filterSidecar.Store(true)
+	im.filters.Put(table, bf)
+	table.observed.reset()
+	log.Printf("index manager: rebuilt the filter of table %q for a %.4f false positive rate, %.4f was observed", table.metadata.Path, rate, observed)
+	return true, nil
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/internal/bloom"
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestFilterFeedback(t *testing.T) {
+	config := *shared.NewEngineConfig().WithSmallTableMergeSize(0).WithFilterRebuildRate(0.2)
+	engine, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for i := range 1000 {
+		engine.Set(fmt.Sprintf("key%04d", i), []byte("value"))
+	}
+	engine.indexManager.Flush()
+	table := engine.indexManager.sstables[0]
+
+	// an undersized filter, as an older configuration may have left behind
+	undersized := bloom.New(2, 0.5)
+	for i := range 1000 {
+		undersized.Add(filterKey(fmt.Sprintf("key%04d", i)))
+	}
+	engine.indexManager.filters.Put(table, undersized)
+
+	// absent keys within the table's key range, never the same twice so
+	// the negative cache does not answer them
+	pass := 0
+	missAll := func() {
+		pass++
+		for i := range filterFeedbackMinLookups {
+			engine.Get(fmt.Sprintf("key%04d-%d-%d", i%999, pass, i))
+		}
+	}
+	missAll()
+	if rate, ok := table.observed.rate(); !ok || rate <= 0.2 {
+		t.Fatalf("observed false positive rate = %f, %v, want it over 0.2", rate, ok)
+	}
+
+	newScrubber(engine).scrub()
+	if _, err := os.Stat(table.filterSidecarPath()); err != nil || !table.filterSidecar.Load() {
+		t.Fatalf("scrub did not rebuild the filter into its sidecar: %v", err)
+	}
+	if reason := table.filterProblem([]string{"key0000", "key0999"}); reason != "" {
+		t.Errorf("rebuilt filter is unsound: %s", reason)
+	}
+
+	missAll()
+	if rate, ok := table.observed.rate(); !ok || rate > 0.2 {
+		t.Errorf("observed false positive rate after the rebuild = %f, %v", rate, ok)
+	}
+	if rebuilt, err := engine.indexManager.tightenFilter(table); rebuilt || err != nil {
+		t.Errorf("tightenFilter() = %v, %v, want the rebuilt filter kept", rebuilt, err)
+	}
+	if value, err := engine.Get("key0500"); err != nil || string(value) != "value" {
+		t.Errorf("Get(key0500) = %q, %v", value, err)
+	}
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/hasssanezzz/goldb/internal/bloom"
+	"github.com/hasssanezzz/goldb/internal/filter"
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// defaultFalsePositiveRate is the false positive rate table filters are built
+// for when the configuration sets none.
+const defaultFalsePositiveRate = 0.01
+
+// filterKey returns the bytes a key is hashed by in the table filters, the
+// key null padded to KeySize as the filters were always fed. Keys longer than
+// KeySize never reach a table, they are hashed whole rather than truncated.
+func filterKey(key string) []byte {
+	if padded, err := shared.PadKey(key); err == nil {
+		return padded
+	}
+	return []byte(key)
+}
+
+// filterPolicy returns the policy the filters of new tables are built with.
+func filterPolicy(config *shared.EngineConfig) filter.Policy {
+	switch config.FilterPolicy {
+	case shared.FilterCuckoo:
+		return filter.Cuckoo
+	case shared.FilterRibbon:
+		return filter.Ribbon
+	}
+	return filter.Bloom
+}
+
+// filterRate returns the false positive rate the filters of new tables are
+// built for, the one BitsPerKey gives the policy when it is set.
+func filterRate(config *shared.EngineConfig) float64 {
+	if config.BitsPerKey > 0 {
+		return filterPolicy(config).Rate(config.BitsPerKey)
+	}
+	if config.FalsePositiveRate > 0 {
+		return config.FalsePositiveRate
+	}
+	return defaultFalsePositiveRate
+}
+
+// buildFilter builds a filter of keys with the configured policy.
+func buildFilter(config *shared.EngineConfig, keys []string, falsePositiveRate float64) filter.Filter {
+	hashed := make([][]byte, len(keys))
+	for i, key := range keys {
+		hashed[i] = filterKey(key)
+	}
+	return filterPolicy(config).Build(hashed, falsePositiveRate)
+}
+
+// rebuildFilters checks the filter of every table and rebuilds the broken or
+// outdated ones into sidecar files, returning the number rebuilt. im.mu must
+// be held by the caller.
+func (im *IndexManager) rebuildFilters() (int, error) {
+	rebuilt := 0
+	for _, tables := range [][]*SSTable{im.sstables, im.levels} {
+		for _, table := range tables {
+			// the filters hold the deleted keys too
+			pairs, err := table.Items()
+			if err != nil {
+				return rebuilt, fmt.Errorf("index manager can not read the keys of table %q: %v", table.metadata.Path, err)
+			}
+			keys := make([]string, len(pairs))
+			for i, pair := range pairs {
+				keys[i] = pair.Key
+			}
+			putPairs(pairs)
+
+			reason := table.filterProblem(keys)
+			if reason == "" {
+				continue
+			}
+
+			bf := buildFilter(im.config, keys, filterRate(im.config))
+			if err := writeSidecar(table.filterSidecarPath(), bf.ToBytes()); err != nil {
+				return rebuilt, fmt.Errorf("index manager can not rebuild the filter of table %q: %v", table.metadata.Path, err)
+			}
+
+			table.filterSidecar.Store(true)
+			im.filters.Put(table, bf)
+			rebuilt++
+			log.Printf("index manager: rebuilt the filter of table %q, %s", table.metadata.Path, reason)
+		}
+	}
+	return rebuilt, nil
+}
+
+// filterProblem tells why the table's filter must be rebuilt, an empty
+// reason means it is sound: it can be read, is built with the configured
+// policy and the current hashing, is at least as large as the current
+// parameters make it and reports every key of the table, deleted ones
+// included.
+func (s *SSTable) filterProblem(keys []string) string {
+	// a broken sidecar is rebuilt even if the embedded filter stands in for it
+	var bf filter.Filter
+	var err error
+	if s.filterSidecar.Load() {
+		bf, err = readFilterSidecar(s.filterSidecarPath())
+	} else {
+		bf, err = s.loadFilter()
+	}
+	if err != nil {
+		return fmt.Sprintf("it can not be read: %v", err)
+	}
+
+	if policy := filterPolicy(s.config); filter.PolicyOf(bf) != policy {
+		return fmt.Sprintf("it is a %s filter, new tables use %s filters", filter.PolicyOf(bf).Name(), policy.Name())
+	}
+	if bloomFilter, ok := bf.(*bloom.Filter); ok {
+		if bloomFilter.Hashes() == 0 {
+			return "it has no hashes"
+		}
+		if bloomFilter.Legacy() {
+			return "it uses the legacy hashing whose probes all hit the same bit"
+		}
+	}
+
+	// filters tightened after too many false positives are larger, see tightenFilter
+	expected := buildFilter(s.config, keys, filterRate(s.config))
+	if bf.MemoryUsage() < expected.MemoryUsage() {
+		return fmt.Sprintf("it takes %d bytes, fewer than the %d expected", bf.MemoryUsage(), expected.MemoryUsage())
+	}
+
+	for _, key := range keys {
+		if !bf.Test(filterKey(key)) {
+			return fmt.Sprintf("it misses key %q", key)
+		}
+	}
+	return ""
+}
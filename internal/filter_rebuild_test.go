@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/internal/filter"
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestRebuildFilters(t *testing.T) {
+	dir := t.TempDir()
+	config := *shared.NewEngineConfig().WithSmallTableMergeSize(0)
+	engine, err := NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	for i := range 20 {
+		engine.Set(fmt.Sprintf("key%02d", i), []byte("value"))
+	}
+	engine.Delete("key00")
+	engine.indexManager.Flush()
+	table := engine.indexManager.sstables[0].metadata
+	engine.Close()
+
+	// break the embedded filter's header, the table can no longer be opened
+	file, err := os.OpenFile(table.Path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() error: %v", err)
+	}
+	file.WriteAt([]byte{0xFF, 0xFF, 0xFF, 0xFF}, int64(table.SerializedSize(&config)))
+	file.Close()
+
+	engine, err = NewEngine(dir, *config.WithRebuildFilters(true))
+	if err != nil {
+		t.Fatalf("NewEngine() with filter rebuilding error: %v", err)
+	}
+	if _, err := os.Stat(table.Path + FilterSidecarSuffix); err != nil {
+		t.Fatalf("rebuilt filter sidecar is missing: %v", err)
+	}
+	if reason := engine.indexManager.sstables[0].filterProblem([]string{"key00", "key19"}); reason != "" {
+		t.Errorf("rebuilt filter is unsound: %s", reason)
+	}
+	engine.Close()
+
+	// the sidecar replaces the broken filter without rebuilding it again
+	engine, err = NewEngine(dir, *config.WithRebuildFilters(false))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	if len(engine.indexManager.sstables) != 1 || !engine.indexManager.sstables[0].filterSidecar.Load() {
+		t.Fatalf("table was not opened with its rebuilt filter")
+	}
+	if value, err := engine.Get("key07"); err != nil || string(value) != "value" {
+		t.Errorf("Get(key07) = %q, %v, want value", value, err)
+	}
+	if _, err := engine.Get("key00"); err == nil {
+		t.Errorf("Get(key00) found a deleted key")
+	}
+}
+
+func TestFilterPolicies(t *testing.T) {
+	for _, policy := range []shared.FilterPolicy{shared.FilterCuckoo, shared.FilterRibbon} {
+		dir := t.TempDir()
+		config := *shared.NewEngineConfig().WithSmallTableMergeSize(0).WithFilterPolicy(policy)
+		engine, err := NewEngine(dir, config)
+		if err != nil {
+			t.Fatalf("NewEngine() error: %v", err)
+		}
+		for i := range 200 {
+			engine.Set(fmt.Sprintf("key%03d", i), []byte("value"))
+		}
+		engine.indexManager.Flush()
+
+		table := engine.indexManager.sstables[0]
+		if got := filter.PolicyOf(engine.indexManager.filters.Get(table)); got != filterPolicy(&config) {
+			t.Errorf("policy %d: table was built with a %s filter", policy, got.Name())
+		}
+		for i := range 200 {
+			if _, err := engine.Get(fmt.Sprintf("key%03d", i)); err != nil {
+				t.Fatalf("policy %d: Get(key%03d) error: %v", policy, i, err)
+			}
+		}
+		engine.Close()
+
+		// tables keep their policy until they are rebuilt with the configured one
+		engine, err = NewEngine(dir, *config.WithFilterPolicy(shared.FilterBloom).WithRebuildFilters(true))
+		if err != nil {
+			t.Fatalf("NewEngine() error: %v", err)
+		}
+		table = engine.indexManager.sstables[0]
+		if got := filter.PolicyOf(engine.indexManager.filters.Get(table)); got != filter.Bloom || !table.filterSidecar.Load() {
+			t.Errorf("policy %d: table was not rebuilt with a bloom filter, it has a %s filter", policy, got.Name())
+		}
+		if value, err := engine.Get("key042"); err != nil || string(value) != "value" {
+			t.Errorf("policy %d: Get(key042) = %q, %v, want value", policy, value, err)
+		}
+		engine.Close()
+	}
+}
+
+func TestFilterSizing(t *testing.T) {
+	sizes := map[string]uint64{}
+	for name, config := range map[string]*shared.EngineConfig{
+		"default":    shared.NewEngineConfig(),
+		"rate":       shared.NewEngineConfig().WithFalsePositiveRate(0.001),
+		"bitsPerKey": shared.NewEngineConfig().WithBitsPerKey(20),
+	} {
+		engine, err := NewEngine(t.TempDir(), *config.WithSmallTableMergeSize(0))
+		if err != nil {
+			t.Fatalf("%s: NewEngine() error: %v", name, err)
+		}
+		for i := range 1000 {
+			engine.Set(fmt.Sprintf("key%04d", i), []byte("value"))
+		}
+		engine.indexManager.Flush()
+		engine.Get("key0042")
+
+		stats := engine.Stats()
+		if stats.FiltersLoaded != 1 || stats.FilterMemory == 0 {
+			t.Errorf("%s: Stats() reports %d filters of %d bytes, want 1", name, stats.FiltersLoaded, stats.FilterMemory)
+		}
+		sizes[name] = stats.FilterMemory
+		engine.Close()
+	}
+
+	if !(sizes["default"] < sizes["rate"] && sizes["rate"] < sizes["bitsPerKey"]) {
+		t.Errorf("filter sizes = %v, want them to grow with a lower rate and more bits per key", sizes)
+	}
+	if got := float64(8*sizes["bitsPerKey"]) / 1000; got < 19 || got > 22 {
+		t.Errorf("filter of 20 bits per key takes %.2f bits per key", got)
+	}
+}
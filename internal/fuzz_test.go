@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func FuzzWALDecode(f *testing.F) {
+	path := filepath.Join(f.TempDir(), WALFileName)
+	wal, err := NewDiskWAL(path, true, shared.SyncNever, 0, nil)
+	if err != nil {
+		f.Fatalf("NewDiskWAL() error: %v", err)
+	}
+	wal.Append(WALEntry{Key: "key", Value: []byte("value")})
+	wal.Append(WALEntry{Key: "compressed", Value: bytes.Repeat([]byte("abc"), 1024)})
+	wal.Append(WALEntry{Key: "deleted"})
+	wal.AppendBatch([]WALEntry{{Key: "a", Value: []byte("1")}, {Key: "b"}})
+	wal.Close()
+
+	log, err := os.ReadFile(path)
+	if err != nil {
+		f.Fatalf("ReadFile() error: %v", err)
+	}
+	f.Add(log)
+	f.Add(log[:len(log)/2])
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// hostile logs may fail to decode but must not panic or over-allocate
+		decodeWAL(bytes.NewReader(data), int64(len(data)), func(entry WALEntry) error {
+			if len(entry.Value) > walSizeMask {
+				t.Fatalf("decoded a value of %d bytes", len(entry.Value))
+			}
+			return nil
+		})
+	})
+}
+
+func FuzzTableMetadataDeserialize(f *testing.F) {
+	fixed := TableMetadata{Format: tableFormatFixed, Serial: 1, Size: 10, FilterSize: 20, MinKey: "a", MaxKey: "z"}
+	blocks := TableMetadata{Format: tableFormatBlocks, IsLevel: true, Serial: 7, Size: 3, FilterSize: 8, MinKey: "key", MaxKey: "key9", IndexOffset: 900, IndexSize: 40}
+	sequenced := TableMetadata{Format: tableFormatSequenced, Serial: 9, Size: 3, FilterSize: 8, MinKey: "key", MaxKey: "key9", IndexOffset: 900, IndexSize: 40, MaxSeq: 1 << 40}
+	for _, metadata := range []TableMetadata{fixed, blocks, sequenced} {
+		data, err := metadata.Serialize()
+		if err != nil {
+			f.Fatalf("Serialize() error: %v", err)
+		}
+		f.Add(data)
+		f.Add(data[:100])
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var metadata TableMetadata
+		if err := metadata.Deserialize(bytes.NewReader(data)); err != nil {
+			return
+		}
+
+		// whatever was accepted must survive a round trip
+		serialized, err := metadata.Serialize()
+		if err != nil {
+			t.Fatalf("Serialize() of deserialized %+v error: %v", metadata, err)
+		}
+		var again TableMetadata
+		if err := again.Deserialize(bytes.NewReader(serialized)); err != nil {
+			t.Fatalf("Deserialize() of serialized %+v error: %v", metadata, err)
+		}
+		if !reflect.DeepEqual(metadata, again) {
+			t.Fatalf("round trip = %+v, want %+v", again, metadata)
+		}
+
+		// the bounds check must reject sections past the end of the file
+		config := shared.NewEngineConfig()
+		if err := metadata.checkBounds(config, int64(len(data))); err == nil {
+			end := int64(metadata.SerializedSize(config)) + int64(metadata.FilterSize)
+			if end > int64(len(data)) || int64(metadata.IndexOffset)+int64(metadata.IndexSize) > int64(len(data)) {
+				t.Fatalf("checkBounds() accepted %+v in %d bytes", metadata, len(data))
+			}
+		}
+	})
+}
+
+func FuzzBlockCompression(f *testing.F) {
+	f.Add([]byte(""))
+	f.Add([]byte("abcabcabcabcabcabcabcabcabcabc"))
+	f.Add(bytes.Repeat([]byte("user:0001:profile"), 100))
+	f.Add(snappyEncode(nil, bytes.Repeat([]byte{'a'}, 200)))
+	f.Add(lz4Encode(nil, bytes.Repeat([]byte{'a'}, 200)))
+	f.Add(zstdEncode(nil, bytes.Repeat([]byte("user:0001:profile"), 100), nil))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// arbitrary input must be rejected, not crash the decoders
+		snappyDecode(data, maxEncodedBlockSize)
+		lz4Decode(data, maxEncodedBlockSize)
+		zstdDecode(data, []byte("user:"), maxEncodedBlockSize)
+
+		for _, codec := range []shared.Compression{shared.CompressionSnappy, shared.CompressionLZ4, shared.CompressionZstd} {
+			stored := compressBlock(bytes.Clone(data), codec, []byte("user:0000:profile"))
+			block, err := decompressBlock(stored, codec, []byte("user:0000:profile"))
+			if err != nil || !bytes.Equal(block, data) {
+				t.Fatalf("codec %d round trip = %q, %v, want %q", codec, block, err, data)
+			}
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// HashKeyPrefix starts the keys holding hash fields, a hash's fields are
+// stored under HashKeyPrefix + name + ":" followed by the field.
+const HashKeyPrefix = "__hash:"
+
+// HSet sets the fields of the hash name and returns how many were not in it
+// already. The fields are written atomically in a single batch, values can
+// not be empty.
+func (e *Engine) HSet(name string, fields map[string][]byte) (int, error) {
+	prefix, err := hashPrefix(name)
+	if err != nil {
+		return 0, err
+	}
+
+	e.collectionsMu.Lock()
+	defer e.collectionsMu.Unlock()
+
+	batch, added := &Batch{engine: e, internal: true}, 0
+	for field, value := range fields {
+		if len(value) == 0 {
+			return 0, fmt.Errorf("hash %q can not hold an empty value for field %q", name, field)
+		}
+
+		key := prefix + field
+		if len(key) > int(e.Config.KeySize) {
+			return 0, &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+		}
+		exists, err := e.Has(key)
+		if err != nil {
+			return 0, err
+		}
+		if !exists {
+			added++
+		}
+		batch.Set(key, value)
+	}
+
+	if err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	return added, nil
+}
+
+// HGet returns the value of a field of the hash name, a missing field is
+// reported as shared.ErrKeyNotFound.
+func (e *Engine) HGet(name, field string) ([]byte, error) {
+	prefix, err := hashPrefix(name)
+	if err != nil {
+		return nil, err
+	}
+	return e.Get(prefix + field)
+}
+
+// HGetAll returns the fields of the hash name, read from a snapshot taken
+// when it is called.
+func (e *Engine) HGetAll(name string) (map[string][]byte, error) {
+	prefix, err := hashPrefix(name)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string][]byte{}
+	err = e.ScanFunc(prefix, func(key string, value []byte) (bool, error) {
+		fields[strings.TrimPrefix(key, prefix)] = value
+		return false, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("engine can not read hash %q: %v", name, err)
+	}
+	return fields, nil
+}
+
+// HDel removes the fields from the hash name and returns how many were in it.
+// The fields are removed atomically in a single batch.
+func (e *Engine) HDel(name string, fields ...string) (int, error) {
+	prefix, err := hashPrefix(name)
+	if err != nil {
+		return 0, err
+	}
+
+	e.collectionsMu.Lock()
+	defer e.collectionsMu.Unlock()
+
+	batch, seen := &Batch{engine: e, internal: true}, map[string]bool{}
+	for _, field := range fields {
+		if seen[field] {
+			continue
+		}
+		seen[field] = true
+
+		key := prefix + field
+		if len(key) > int(e.Config.KeySize) {
+			return 0, &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+		}
+		exists, err := e.Has(key)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			batch.Delete(key)
+		}
+	}
+
+	if err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	return batch.Len(), nil
+}
+
+func hashPrefix(name string) (string, error) {
+	return collectionPrefix("hash", HashKeyPrefix, name)
+}
@@ -0,0 +1,41 @@
+package internal
+
+import (
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestHashes(t *testing.T) {
+	e, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	if added, err := e.HSet("user", map[string][]byte{"name": []byte("ada"), "lang": []byte("go")}); err != nil || added != 2 {
+		t.Fatalf("HSet() = %d, %v, want 2", added, err)
+	}
+	if added, err := e.HSet("user", map[string][]byte{"lang": []byte("c"), "age": []byte("36")}); err != nil || added != 1 {
+		t.Fatalf("HSet() = %d, %v, want 1", added, err)
+	}
+	if value, err := e.HGet("user", "lang"); err != nil || string(value) != "c" {
+		t.Errorf("HGet() = %q, %v, want c", value, err)
+	}
+
+	if removed, err := e.HDel("user", "age", "missing"); err != nil || removed != 1 {
+		t.Fatalf("HDel() = %d, %v, want 1", removed, err)
+	}
+	var errNotFound *shared.ErrKeyNotFound
+	if _, err := e.HGet("user", "age"); !errors.As(err, &errNotFound) {
+		t.Errorf("HGet() of a deleted field error = %v, want ErrKeyNotFound", err)
+	}
+
+	fields, err := e.HGetAll("user")
+	want := map[string][]byte{"name": []byte("ada"), "lang": []byte("c")}
+	if err != nil || !maps.EqualFunc(fields, want, func(a, b []byte) bool { return string(a) == string(b) }) {
+		t.Errorf("HGetAll() = %q, %v, want %q", fields, err, want)
+	}
+}
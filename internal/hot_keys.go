@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hasssanezzz/goldb/internal/bloom"
+)
+
+const (
+	// sketchDepth and sketchWidth size the count-min sketch, an estimate is
+	// off by at most 2/sketchWidth of the samples with a probability of
+	// 1-2^-sketchDepth.
+	sketchDepth = 4
+	sketchWidth = 2048
+	// sketchDecay is the number of samples after which every count is halved,
+	// so keys that stopped being read fall out of the top.
+	sketchDecay = 64 * sketchWidth
+	// defaultHotKeySampling is used when Config.HotKeySampling is zero.
+	defaultHotKeySampling = 16
+)
+
+// HotKey is a frequently read key with an estimate of its reads.
+type HotKey struct {
+	Key   string `json:"key"`
+	Reads uint64 `json:"reads"` // Estimated reads since it became hot, decayed over time.
+}
+
+// hotKeys finds the most read keys from a sample of the reads: a count-min
+// sketch estimates the reads of every sampled key and the keys with the
+// highest estimates are kept as candidates. A nil *hotKeys samples nothing.
+type hotKeys struct {
+	top      int
+	sampling uint64
+	reads    atomic.Uint64
+
+	mu         sync.Mutex
+	sketch     [sketchDepth][sketchWidth]uint32
+	candidates map[string]uint32 // Estimated samples of the hottest keys.
+	samples    int               // Samples since the counts were last halved.
+}
+
+// newHotKeys tracks the top most read keys, sampling one read in sampling.
+// It returns nil if top is zero.
+func newHotKeys(top, sampling uint32) *hotKeys {
+	if top == 0 {
+		return nil
+	}
+	if sampling == 0 {
+		sampling = defaultHotKeySampling
+	}
+	return &hotKeys{top: int(top), sampling: uint64(sampling), candidates: map[string]uint32{}}
+}
+
+// read records a read of key if it is sampled.
+func (h *hotKeys) read(key string) {
+	if h == nil || h.reads.Add(1)%h.sampling != 0 {
+		return
+	}
+
+	// the rows are indexed by double hashing a single hash
+	hash := bloom.Hash64([]byte(key), bloom.DefaultSeed)
+	h1, h2 := uint32(hash), uint32(hash>>32)|1
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	estimate := ^uint32(0)
+	for row := range h.sketch {
+		counter := &h.sketch[row][(h1+uint32(row)*h2)%sketchWidth]
+		if *counter < ^uint32(0) {
+			*counter++
+		}
+		estimate = min(estimate, *counter)
+	}
+
+	if _, ok := h.candidates[key]; ok || len(h.candidates) < h.top {
+		h.candidates[key] = estimate
+	} else {
+		// a key hotter than the coldest candidate takes its place
+		coldest, coldestCount := "", ^uint32(0)
+		for candidate, count := range h.candidates {
+			if count < coldestCount {
+				coldest, coldestCount = candidate, count
+			}
+		}
+		if estimate > coldestCount {
+			delete(h.candidates, coldest)
+			h.candidates[key] = estimate
+		}
+	}
+
+	h.samples++
+	if h.samples >= sketchDecay {
+		h.decay()
+	}
+}
+
+// decay halves every count, h.mu must be held by the caller.
+func (h *hotKeys) decay() {
+	for row := range h.sketch {
+		for i := range h.sketch[row] {
+			h.sketch[row][i] /= 2
+		}
+	}
+	for key, count := range h.candidates {
+		if count /= 2; count == 0 {
+			delete(h.candidates, key)
+			continue
+		}
+		h.candidates[key] = count
+	}
+	h.samples = 0
+}
+
+// hottest returns the tracked keys, most read first.
+func (h *hotKeys) hottest() []HotKey {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	keys := make([]HotKey, 0, len(h.candidates))
+	for key, count := range h.candidates {
+		keys = append(keys, HotKey{Key: key, Reads: uint64(count) * h.sampling})
+	}
+	h.mu.Unlock()
+
+	slices.SortFunc(keys, func(a, b HotKey) int {
+		if a.Reads != b.Reads {
+			return cmp.Compare(b.Reads, a.Reads)
+		}
+		return strings.Compare(a.Key, b.Key)
+	})
+	return keys
+}
+
+// HotKeys returns the most read keys, most read first. It is empty unless
+// Config.HotKeys is set.
+func (e *Engine) HotKeys() []HotKey {
+	return e.hot.hottest()
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestHotKeys(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithHotKeys(3, 1))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	// three skewed keys among many read a few times each, absent keys count too
+	for i := range 200 {
+		for range 5 {
+			engine.Get(fmt.Sprintf("cold%03d", i))
+		}
+	}
+	for key, reads := range map[string]int{"hot0": 900, "hot1": 600, "hot2": 300} {
+		for range reads {
+			engine.Get(key)
+		}
+	}
+
+	hot := engine.Stats().HotKeys
+	if len(hot) != 3 {
+		t.Fatalf("Stats().HotKeys = %+v, want 3 keys", hot)
+	}
+	for i, want := range []uint64{900, 600, 300} {
+		if key := fmt.Sprintf("hot%d", i); hot[i].Key != key || hot[i].Reads < want || hot[i].Reads > want+100 {
+			t.Errorf("hot key %d = %+v, want %s read about %d times", i, hot[i], key, want)
+		}
+	}
+
+	engine.hot.mu.Lock()
+	engine.hot.decay()
+	engine.hot.mu.Unlock()
+	if decayed := engine.HotKeys(); decayed[0].Reads != hot[0].Reads/2 {
+		t.Errorf("HotKeys() after decaying = %+v, want the reads of %+v halved", decayed, hot)
+	}
+}
+
+func TestHotKeysDisabled(t *testing.T) {
+	engine, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	engine.Get("key")
+	if hot := engine.HotKeys(); hot != nil {
+		t.Errorf("HotKeys() = %+v, want nothing tracked", hot)
+	}
+}
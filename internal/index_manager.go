@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -9,6 +10,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/hasssanezzz/goldb/shared"
 )
@@ -21,9 +23,28 @@ type IndexManager struct {
 	currSerial int        // Current serial number for SSTables.
 	lvlSerial  int        // Current serial number for levels.
 	sstables   []*SSTable // List of SSTables on disk.
-	levels     []*SSTable // List of levels (merged SSTables).
+	levels     []*SSTable // Tables of levels 1 and deeper, by level then newest first.
+	filters    *FilterCache
+	misses     *NegativeCache
+	purged     *purgedPositions
+	schedule   *compactionScheduler
+	retry      *retrier
+	repair     *blockRepairer
+	writeAmp   *writeAmp    // Nil unless writes are tracked per keyspace.
+	values     *tableValues // Nil unless values are colocated with their tables.
+	access     *accessLog   // Nil unless the most read blocks are logged.
+	io         *ioScheduler
 	wal        WAL
 
+	compactPointers [maxLevels]string // Max key of the last table compacted out of each level, see compactLevel.
+	compacting      bool              // A compaction releasing mu between the blocks it reads is running, see compactInto.
+	closed          context.Context   // Done once Close is called, stopping the compaction running.
+	close           context.CancelFunc
+
+	missingTables atomic.Uint64 // Tables dropped because their file disappeared.
+	moves         atomic.Uint64 // Level tables moved down without being rewritten, see moveTable.
+	debug         atomic.Bool   // Debug logging, see Engine.SetDebug.
+
 	mu             sync.RWMutex
 	flushRequested chan struct{}
 }
@@ -31,31 +52,82 @@ type IndexManager struct {
 // NewIndexManager initializes a new IndexManager with the given homepath.
 // It reads existing SSTables and levels from disk and prepares the memtable for writes.
 // Returns an error if the directory cannot be accessed or if SSTables cannot be parsed.
-func NewIndexManager(config *shared.EngineConfig, wal WAL) (*IndexManager, error) {
+func NewIndexManager(config *shared.EngineConfig, wal WAL, retry *retrier, io *ioScheduler) (*IndexManager, error) {
+	schedule, err := newCompactionScheduler(config)
+	if err != nil {
+		return nil, err
+	}
+
 	im := &IndexManager{
 		memtable:       NewAVLMemtable(),
 		config:         config,
 		currSerial:     1, // starting from one to reserve number zero
 		lvlSerial:      1, // level 0 for SSTables only
+		filters:        NewFilterCache(config.FilterMemoryBudget, config.Debug),
+		misses:         NewNegativeCache(int(config.NegativeCacheSize)),
+		schedule:       schedule,
+		retry:          retry,
+		repair:         newBlockRepairer(config.RepairSources),
+		writeAmp:       newWriteAmp(config.WriteAmpSeparator),
+		access:         newAccessLog(config.Homepath, config.PrimeBlocks),
+		io:             io,
 		wal:            wal,
 		flushRequested: make(chan struct{}),
 	}
+	im.debug.Store(config.Debug)
+	im.closed, im.close = context.WithCancel(context.Background())
+
+	im.purged, err = loadPurgedPositions(config.Homepath, config.ReadOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	// value files are opened first so the tables find theirs
+	im.values, err = newTableValues(config, retry)
+	if err != nil {
+		return nil, err
+	}
 
 	if err := im.parseHomeDir(); err != nil {
 		return nil, err
 	}
 
+	if config.RebuildFilters && !config.ReadOnly {
+		im.mu.Lock()
+		rebuilt, err := im.rebuildFilters()
+		im.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		if rebuilt > 0 {
+			log.Printf("index manager: rebuilt %d bloom filters", rebuilt)
+		}
+	}
+
 	go im.backgroundFlusher()
 
 	return im, nil
 }
 
 // Get retrieves the IndexNode for the given key.
-// It searches the memtable, SSTables, and levels in order of recency.
+// It searches the memtable, SSTables, and levels in order of recency, tables
+// are created in the order of the sequence numbers they hold so the first one
+// holding the key has its latest write.
 // Returns ErrKeyNotFound if the key does not exist.
 func (im *IndexManager) Get(key string) (Position, error) {
+	return im.get(key, nil)
+}
+
+// get is Get recording every table it consults in the given trace, which may be nil.
+func (im *IndexManager) get(key string, trace *Trace) (Position, error) {
+	// taken before the memtable lookup so a concurrent write is never missed
+	sequence := im.misses.Sequence()
+
 	// 1. search in the memtable
 	if im.memtable.Contains(key) {
+		if trace != nil {
+			trace.MemtableHit = true
+		}
 		indexNode := im.memtable.Get(key)
 		if indexNode.Size == 0 {
 			return Position{}, &shared.ErrKeyNotFound{Key: key}
@@ -63,18 +135,35 @@ func (im *IndexManager) Get(key string) (Position, error) {
 		return indexNode, nil
 	}
 
-	// Acquire read lock for accessing sstables/levels
+	// Recently missed keys don't need to probe the tables again
+	if im.misses.Contains(key) {
+		return Position{}, &shared.ErrKeyNotFound{Key: key}
+	}
+
+	// Take references to the tables so they are not closed under the lookup
+	// by a concurrent compaction, without blocking flushes while probing
 	im.mu.RLock()
-	defer im.mu.RUnlock()
+	tables := im.acquireTables()
+	sstables, levels := tables[:len(im.sstables)], tables[len(im.sstables):]
+	im.mu.RUnlock()
+	defer releaseTables(tables)
 
 	// 2. Search in the SSTables
-	for _, table := range im.sstables {
-		result, err := table.Search(key)
+	for _, table := range sstables {
+		result, probe, err := table.search(key, trace != nil)
+		trace.addProbe(probe)
 		if err != nil {
 			var errKeyRemoved *shared.ErrKeyRemoved
 			if errors.As(err, &errKeyRemoved) {
+				im.misses.Add(key, sequence)
 				return Position{}, &shared.ErrKeyNotFound{Key: key}
 			}
+			// a table that can not be read may hide a newer value than the
+			// older tables hold, only a table gone missing is skipped
+			var errKeyNotFound *shared.ErrKeyNotFound
+			if !errors.As(err, &errKeyNotFound) && !im.dropMissingTable(table, err) {
+				return Position{}, fmt.Errorf("index manager can not read key %q from sstable %d: %w", key, table.metadata.Serial, err)
+			}
 			continue
 		}
 
@@ -82,18 +171,20 @@ func (im *IndexManager) Get(key string) (Position, error) {
 	}
 
 	// 3. Search in the levels
-	for _, table := range im.levels {
+	for _, table := range levels {
 		if table.metadata.MinKey > key || table.metadata.MaxKey < key {
 			continue
 		}
 
-		result, err := table.Search(key)
+		result, probe, err := table.search(key, trace != nil)
+		trace.addProbe(probe)
 		if err != nil {
 			if _, ok := err.(*shared.ErrKeyRemoved); ok {
+				im.misses.Add(key, sequence)
 				return Position{}, &shared.ErrKeyNotFound{Key: key}
 			}
-			if _, ok := err.(*shared.ErrKeyNotFound); !ok {
-				return Position{}, fmt.Errorf("index manager can not read key %q from sstable %d: %v", key, table.metadata.Serial, err)
+			if _, ok := err.(*shared.ErrKeyNotFound); !ok && !im.dropMissingTable(table, err) {
+				return Position{}, fmt.Errorf("index manager can not read key %q from sstable %d: %w", key, table.metadata.Serial, err)
 			}
 			continue
 		}
@@ -101,83 +192,47 @@ func (im *IndexManager) Get(key string) (Position, error) {
 		return result, nil
 	}
 
+	im.misses.Add(key, sequence)
 	return Position{}, &shared.ErrKeyNotFound{Key: key}
 }
 
 // Delete marks the given key as deleted in the memtable.
 // The key will be removed during the next flush or compaction.
-func (im *IndexManager) Delete(key string) {
-	im.memtable.Set(KVPair{Key: key})
+func (im *IndexManager) Delete(key string, seq uint64) {
+	im.memtable.Set(KVPair{Key: key, Seq: seq})
+	im.misses.Invalidate(key)
 }
 
 func (im *IndexManager) Set(pair KVPair) {
 	im.memtable.Set(pair)
+	im.misses.Invalidate(pair.Key)
+}
+
+// SetBatch applies the pairs to the memtable at once, tombstones included.
+func (im *IndexManager) SetBatch(pairs []KVPair) {
+	im.memtable.SetBatch(pairs)
+	for _, pair := range pairs {
+		im.misses.Invalidate(pair.Key)
+	}
 }
 
 // Keys returns a list of all keys in the database.
-// It includes keys from the memtable, SSTables, and levels.
+// It includes keys from the memtable, SSTables, and levels, read from a pinned
+// snapshot so concurrent flushes and compactions do not block or disturb it.
 // Returns an error if any SSTable or level cannot be read.
 func (im *IndexManager) Keys() ([]string, error) {
-	im.mu.RLock()
-	defer im.mu.RUnlock()
-
-	// Use a map to store unique keys
-	final := make(map[string]struct{})
-	var finalMu sync.Mutex // Protects access to 'final'
-	var wg sync.WaitGroup  // Waits for all goroutines to finish
-	var firstError error   // Captures the first error encountered
-	var errMu sync.Mutex   // Protects access to 'firstError'
-
-	tables := append(im.sstables, im.levels...) // Combine SSTables and Levels
-
-	for _, table := range tables {
-		wg.Add(1)
-		go func(t *SSTable) {
-			defer wg.Done()
-			keys, err := t.Keys()
-			if err != nil {
-				errMu.Lock()
-				if firstError == nil {
-					firstError = err
-				}
-				errMu.Unlock()
-				return
-			}
-			finalMu.Lock()
-			for _, key := range keys {
-				final[key] = struct{}{}
-			}
-			finalMu.Unlock()
-		}(table)
-	}
+	snapshot := im.snapshot()
+	defer snapshot.Release()
 
-	// Wait for all goroutines to complete
-	wg.Wait()
-
-	errMu.Lock()
-	if firstError != nil {
-		errMu.Unlock()
-		return nil, firstError
-	}
-	errMu.Unlock()
-
-	// Add keys from the memtable (in-memory, likely fast, can be sequential)
-	memtablePairs := im.memtable.Items()
-	for _, pair := range memtablePairs {
-		if pair.Value.Size == 0 {
-			delete(final, pair.Key)
-			continue
-		}
-		final[pair.Key] = struct{}{}
-	}
-
-	// Convert the map keys to a slice for the final result
-	results := make([]string, 0, len(final))
-	for key := range final {
-		results = append(results, key)
-	}
+	return snapshot.Keys()
+}
 
-	return results, nil
+// tablesHolding returns the number of tables holding a value of key,
+// tables where it is deleted are not counted.
+func (im *IndexManager) tablesHolding(key string) (int, error) {
+	holding := 0
+	err := im.searchTables(key, func(Position) { holding++ })
+	return holding, err
 }
 
 func (im *IndexManager) Flush() error {
@@ -187,33 +242,31 @@ func (im *IndexManager) Flush() error {
 	return im.flush()
 }
 
-// Close closes all open SSTables and levels.
+// Close releases the index's references to all SSTables and levels,
+// tables still held by readers are closed once those are released.
 func (im *IndexManager) Close() error {
-	for _, table := range im.sstables {
-		if err := table.Close(); err != nil {
-			return err
-		}
-	}
+	im.close()
+	im.mu.Lock()
+	defer im.mu.Unlock()
 
-	for _, level := range im.levels {
-		if err := level.Close(); err != nil {
-			return err
-		}
-	}
+	releaseTables(im.sstables)
+	releaseTables(im.levels)
+	im.sstables, im.levels = nil, nil
 
 	return nil
 }
 
 func (im *IndexManager) backgroundFlusher() {
 	for range im.flushRequested {
+		im.io.background()
 		im.mu.Lock()
 
 		if err := im.flush(); err != nil {
-			if im.config.Debug {
+			if im.debug.Load() {
 				log.Printf("IndexManager background flush failed: %v", err)
 			}
 		} else {
-			if im.config.Debug {
+			if im.debug.Load() {
 				log.Printf("IndexManager background flush completed successfully.")
 			}
 		}
@@ -225,164 +278,182 @@ func (im *IndexManager) backgroundFlusher() {
 // It resets the memtable and updates the list of SSTables.
 // Returns an error if the SSTable cannot be created or written.
 func (im *IndexManager) flush() error {
+	if err := failFlush(); err != nil {
+		return err
+	}
+
 	// Get all memtable items
 	pairs := im.memtable.Items()
+	if len(pairs) == 0 {
+		return nil
+	}
+
+	if err := im.addTable(pairs); err != nil {
+		return err
+	}
+
+	// Reset the memtable after successfully serializing it
+	im.memtable.Reset()
+
+	log.Printf("IndexManager flushed new SSTable %d with %d pairs", im.currSerial-1, len(pairs))
+
+	if err := im.mergeSmallTables(); err != nil {
+		// the flushed table is durable, a failed merge only leaves the small tables in place
+		log.Printf("IndexManager failed to merge small tables: %v", err)
+	}
+
+	if err := im.compactionCheck(); err != nil {
+		// the flushed table is durable, the compaction is retried on the next flush
+		log.Printf("IndexManager failed to compact: %v", err)
+	}
+	return nil
+}
 
+// addTable writes the sorted pairs to a new SSTable newer than all the others,
+// im.mu must be held by the caller.
+func (im *IndexManager) addTable(pairs []KVPair) error {
 	// Initialize the new table's metadata
 	metadata := TableMetadata{
 		Path:    filepath.Join(im.config.Homepath, fmt.Sprintf(im.config.SSTableNamePrefix+"%d", im.currSerial)),
 		IsLevel: false,
+		Format:  currentTableFormat,
 		Size:    uint32(len(pairs)),
 		Serial:  uint32(im.currSerial),
 		MinKey:  pairs[0].Key,
 		MaxKey:  pairs[len(pairs)-1].Key,
 	}
 
+	if im.config.Paranoid {
+		if err := checkNewSerial(metadata, im.sstables); err != nil {
+			return err
+		}
+	}
+
 	// Create a new SSTable after successfully creating the physical one
-	newSSTable, err := serializeSSTable(metadata, im.config, pairs)
+	newSSTable, err := im.writeTable(metadata, pairs)
 	if err != nil {
-		return fmt.Errorf("IndexManager.readTable failed to serialize table %q: %v", metadata.Path, err)
+		return fmt.Errorf("IndexManager.addTable failed to serialize table %q: %v", metadata.Path, err)
 	}
+	im.writeAmp.written(pairs, true)
 
 	im.sstables = append(im.sstables, newSSTable)
 	im.sortTablesBySerial()
 	im.currSerial++
 
-	// Reset the memtable after successfully serializing it
-	im.memtable.Reset()
-
-	log.Printf("IndexManager flushed new SSTable %d with %d pairs", im.currSerial-1, len(pairs))
-
-	// TEMP disabling table compaction
-	// return im.compactionCheck()
 	return nil
 }
 
-// compactionCheck checks if the number of SSTables exceeds the threshold.
-// If so, it triggers compaction to merge SSTables into a single level.
+// compactionCheck compacts the oldest SSTables into level 1 while there are
+// more than CompactionThreshold of them, see createLevel, then every level
+// holding more than its target into the next one, see compactLevel. Nothing
+// is done while a compaction waits between blocks, it goes on with the tables
+// flushed meanwhile.
 // Returns an error if compaction fails.
 func (im *IndexManager) compactionCheck() error {
-	if len(im.sstables) <= int(im.config.CompactionThreshold) {
+	if im.compacting {
+		return nil
+	}
+	level0 := len(im.sstables) > int(im.config.CompactionThreshold)
+	if !level0 && im.overfullLevel() == 0 {
 		return nil
 	}
 
-	return im.createLevel()
+	// outside the off-peak windows the compaction runs on a later flush
+	if !im.schedule.begin() {
+		if im.debug.Load() {
+			log.Printf("IndexManager deferred compaction of %d sstables to an off-peak window", len(im.sstables))
+		}
+		return nil
+	}
+	defer im.schedule.end()
+
+	for len(im.sstables) > int(im.config.CompactionThreshold) {
+		if err := im.createLevel(); err != nil {
+			return err
+		}
+	}
+	// every compaction moves pairs down, filling the next level in turn
+	for level := im.overfullLevel(); level > 0; level = im.overfullLevel() {
+		if err := im.compactLevel(level); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (im *IndexManager) readTable(filename string) error {
 	// 1. create a new sstable
 	fullPath := filepath.Join(im.config.Homepath, filename)
-	table, err := deserializeSSTable(TableMetadata{Path: fullPath}, im.config)
+	table, err := deserializeSSTable(TableMetadata{Path: fullPath}, im.config, im.filters, im.retry)
 	if err != nil {
 		return fmt.Errorf("IndexManager.readTable failed to deserialize table %q: %v", filename, err)
 	}
+	table.repair = im.repair
+	table.values = im.values.of(fullPath)
+	table.access = im.access
+	if table.metadata.IsLevel {
+		table.metadata.Level = im.levelOf(filename)
+	}
+
+	if im.config.Paranoid {
+		pairs, err := table.Items()
+		if err == nil {
+			err = checkSortedPairs(table.metadata, pairs)
+		}
+		if err != nil {
+			table.Close()
+			return fmt.Errorf("IndexManager.readTable found table %q broken: %w", filename, err)
+		}
+	}
 
-	// 2. add the table to the list
+	// 2. add the table to the list, the serials point to the next table to create
 	if table.metadata.IsLevel {
 		im.levels = append(im.levels, table)
-		im.lvlSerial = max(im.lvlSerial, int(table.metadata.Serial))
+		im.lvlSerial = max(im.lvlSerial, int(table.metadata.Serial)+1)
 	} else {
 		im.sstables = append(im.sstables, table)
-		im.currSerial = max(im.currSerial, int(table.metadata.Serial))
+		im.currSerial = max(im.currSerial, int(table.metadata.Serial)+1)
 	}
 
 	// 3. sort the tables
 	im.sortTablesBySerial()
 
 	// 4. do some logging
-	if im.config.Debug {
+	if im.debug.Load() {
 		log.Printf("index manager: read %s %d with %d pairs\n", filename, table.metadata.Serial, table.metadata.Size)
 	}
 
 	return nil
 }
 
-// createLevel merges all SSTables into a single level and deletes the original SSTables.
+// createLevel compacts the oldest SSTable, with the SSTables overlapping it,
+// into level 1 and deletes the original SSTables once no reader holds them
+// anymore. The SSTables left overlap none of them, so none holds newer data
+// of their keys than the level they move to.
 // Returns an error if the level cannot be created or written.
 func (im *IndexManager) createLevel() error {
-	allPairs, err := im.allItemsFromSSTables()
-	if err != nil {
-		return err
-	}
-
-	// Initialize the new table's metadata
-	metadata := TableMetadata{
-		Path:    filepath.Join(im.config.Homepath, fmt.Sprintf(im.config.LevelFileNamePrefix+"%d", im.lvlSerial)),
-		IsLevel: true,
-		Size:    uint32(len(allPairs)),
-		Serial:  uint32(im.lvlSerial),
-		MinKey:  allPairs[0].Key,
-		MaxKey:  allPairs[len(allPairs)-1].Key,
-	}
-
-	// Create a new level
-	level, err := serializeSSTable(metadata, im.config, allPairs)
-	if err != nil {
-		return fmt.Errorf("IndexManager.createLevel failed to create new level: %v", err)
-	}
-
-	im.lvlSerial++
-	im.levels = append(im.levels, level)
-
-	// Delete all sstables (danger)
-	for _, table := range im.sstables {
-		table.Close() // TODO handle closing errors
-		err := os.Remove(table.metadata.Path)
-		if err != nil {
-			log.Printf("failed to remove sstable %d: %v", table.metadata.Serial, err)
-			continue
-		}
-	}
-
-	im.sstables = []*SSTable{}
-	im.sortTablesBySerial()
-
-	return nil
-}
-
-// allItemsFromSSTables retrieves all unique key-value pairs from SSTables.
-// It removes duplicates and deleted keys.
-// Returns an error if any SSTable cannot be read.
-func (im *IndexManager) allItemsFromSSTables() ([]KVPair, error) {
-	mp := map[string]*KVPair{}
-	for _, table := range im.sstables {
-		items, err := table.Items()
-		if err != nil {
-			return nil, fmt.Errorf("allPairsFromSSTables failed to read pairs of table %d: %v", table.metadata.Serial, err)
-		}
-		for _, pair := range items {
-			// TODO urgent - check deleted keys
-			// if pair.Value.Size == 0 {
-			// 	continue
-			// }
-			if _, ok := mp[pair.Key]; ok {
-				continue
-			}
-			mp[pair.Key] = &pair
-		}
-	}
-
-	pairs := make([]KVPair, len(mp))
-	i := 0
-	for _, pair := range mp {
-		pairs[i] = *pair
+	if len(im.sstables) == 0 || im.compacting {
+		return nil
 	}
-
-	sort.Slice(pairs, func(i, j int) bool {
-		return pairs[i].Key < pairs[j].Key
-	})
-
-	return pairs, nil
+	oldest := im.sstables[len(im.sstables)-1]
+	inputs, _, _ := overlappingTables(im.sstables, oldest.metadata.MinKey, oldest.metadata.MaxKey)
+	return im.compactInto(inputs, 1)
 }
 
-// sortTablesBySerial sorts the list of SSTables and levels by their serial numbers in descending order.
+// sortTablesBySerial sorts the SSTables by their serial numbers in descending
+// order and the level tables by level, then by descending serial, so tables
+// holding newer data come first.
 func (im *IndexManager) sortTablesBySerial() {
 	sort.Slice(im.sstables, func(i, j int) bool {
 		return im.sstables[i].metadata.Serial > im.sstables[j].metadata.Serial
 	})
 
 	sort.Slice(im.levels, func(i, j int) bool {
-		return im.levels[i].metadata.Serial > im.levels[j].metadata.Serial
+		a, b := im.levels[i].metadata, im.levels[j].metadata
+		if a.Level != b.Level {
+			return a.Level < b.Level
+		}
+		return a.Serial > b.Serial
 	})
 }
 
@@ -398,13 +469,33 @@ func (im *IndexManager) parseHomeDir() error {
 	for _, file := range files {
 		name := file.Name()
 
+		if isSidecar(name) || strings.HasSuffix(name, ValueFileSuffix) {
+			continue
+		}
 		if strings.HasPrefix(name, im.config.SSTableNamePrefix) || strings.HasPrefix(name, im.config.LevelFileNamePrefix) {
 			err := im.readTable(name)
 			if err != nil {
+				// in paranoid mode a broken table stops the engine from opening
+				if im.config.Paranoid {
+					return err
+				}
 				log.Printf("index manager: failed to parse file %q: %v\n", name, err)
 			}
 		}
 	}
 
+	im.dropReplacedTables()
+
+	if im.config.Paranoid {
+		if err := checkSerials(im.sstables); err != nil {
+			return err
+		}
+		for _, tables := range im.levelGroups()[1:] {
+			if err := checkSerials(tables); err != nil {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestLevelFilters(t *testing.T) {
+	config := shared.NewEngineConfig().WithMemtableSizeThreshold(10).WithLevels(0, 0, 100)
+	config.Homepath = t.TempDir()
+
+	im, err := NewIndexManager(config, nopWAL{}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewIndexManager() error: %v", err)
+	}
+
+	for table := range 3 {
+		for i := range 10 {
+			im.Set(KVPair{Key: fmt.Sprintf("key%02d", table*10+i), Value: Position{Offset: 1, Size: 1}})
+		}
+		if err := im.Flush(); err != nil {
+			t.Fatalf("Flush() error: %v", err)
+		}
+	}
+
+	im.mu.Lock()
+	err = im.compactInto(im.sstables, 1)
+	im.mu.Unlock()
+	if err != nil {
+		t.Fatalf("compactInto() error: %v", err)
+	}
+	im.Close()
+
+	// reopen so the level's filter has to be read back from disk
+	im, err = NewIndexManager(config, nopWAL{}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewIndexManager() error: %v", err)
+	}
+	defer im.Close()
+
+	if len(im.sstables) != 0 || len(im.levels) != 1 {
+		t.Fatalf("got %d sstables and %d levels, want 0 and 1", len(im.sstables), len(im.levels))
+	}
+	if size := im.levels[0].metadata.Size; size != 30 {
+		t.Errorf("level size = %d, want 30", size)
+	}
+
+	for i := range 30 {
+		if _, err := im.Get(fmt.Sprintf("key%02d", i)); err != nil {
+			t.Errorf("Get(key%02d) error: %v", i, err)
+		}
+	}
+
+	// a miss inside the level's key range must be answered by the filter
+	trace := &Trace{}
+	if _, err := im.get("key05x", trace); err == nil {
+		t.Fatalf("get(key05x) should not find the key")
+	}
+	if len(trace.Probes) != 1 || !trace.Probes[0].FilterLoaded || !trace.Probes[0].IsLevel {
+		t.Fatalf("get(key05x) probes = %+v, want one level probe with a loaded filter", trace.Probes)
+	}
+	if trace.FilterMisses != 1 || trace.Seeks != 0 {
+		t.Errorf("get(key05x) filter misses = %d, seeks = %d, want 1 and 0", trace.FilterMisses, trace.Seeks)
+	}
+}
+
+func TestSnapshotPinsTables(t *testing.T) {
+	config := shared.NewEngineConfig().WithMemtableSizeThreshold(10)
+	config.Homepath = t.TempDir()
+
+	im, err := NewIndexManager(config, nopWAL{}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewIndexManager() error: %v", err)
+	}
+	defer im.Close()
+
+	for table := range 2 {
+		for i := range 10 {
+			im.Set(KVPair{Key: fmt.Sprintf("key%02d", table*10+i), Value: Position{Offset: 1, Size: 1}})
+		}
+		if err := im.Flush(); err != nil {
+			t.Fatalf("Flush() error: %v", err)
+		}
+	}
+
+	snapshot := im.snapshot()
+	im.mu.Lock()
+	err = im.compactInto(im.sstables, 1)
+	im.mu.Unlock()
+	if err != nil {
+		t.Fatalf("compactInto() error: %v", err)
+	}
+
+	// the merged tables must still be readable through the snapshot
+	keys, err := snapshot.Keys()
+	if err != nil {
+		t.Fatalf("snapshot Keys() error: %v", err)
+	}
+	if len(keys) != 20 {
+		t.Errorf("snapshot Keys() returned %d keys, want 20", len(keys))
+	}
+
+	paths := []string{}
+	for _, table := range snapshot.tables {
+		paths = append(paths, table.metadata.Path)
+	}
+
+	snapshot.Release()
+	for _, path := range paths {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("table %q still exists after the snapshot was released", path)
+		}
+	}
+}
+
+func TestMergeSmallTables(t *testing.T) {
+	config := shared.NewEngineConfig().WithMemtableSizeThreshold(100).WithSmallTableMergeSize(10)
+	config.Homepath = t.TempDir()
+
+	im, err := NewIndexManager(config, nopWAL{}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewIndexManager() error: %v", err)
+	}
+
+	// overlapping tiny flushes, the newest versions must win after merging
+	for round := range 3 {
+		for i := range 5 {
+			im.Set(KVPair{Key: fmt.Sprintf("key%d", i), Value: Position{Offset: uint64(round), Size: 1}})
+		}
+		if round == 2 {
+			im.Delete("key0", 0)
+		}
+		if err := im.Flush(); err != nil {
+			t.Fatalf("Flush() error: %v", err)
+		}
+	}
+
+	if len(im.sstables) != 1 {
+		t.Fatalf("got %d sstables, want the 3 tiny ones merged into 1", len(im.sstables))
+	}
+	if serial := im.sstables[0].metadata.Serial; serial != 3 {
+		t.Errorf("merged table serial = %d, want 3", serial)
+	}
+	im.Close()
+
+	// reopen to check the merged table and that new flushes do not reuse its serial
+	im, err = NewIndexManager(config, nopWAL{}, nil, nil)
+	if err != nil {
+		t.Fatalf("NewIndexManager() error: %v", err)
+	}
+	defer im.Close()
+
+	im.Set(KVPair{Key: "other", Value: Position{Offset: 9, Size: 1}})
+	if err := im.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	if _, err := im.Get("key0"); err == nil {
+		t.Errorf("Get(key0) found a deleted key")
+	}
+	for i := 1; i < 5; i++ {
+		position, err := im.Get(fmt.Sprintf("key%d", i))
+		if err != nil || position.Offset != 2 {
+			t.Errorf("Get(key%d) = %v, %v, want offset 2", i, position, err)
+		}
+	}
+	if _, err := im.Get("other"); err != nil {
+		t.Errorf("Get(other) error: %v", err)
+	}
+}
+
+func TestParanoidChecks(t *testing.T) {
+	config := shared.NewEngineConfig().WithParanoid(true)
+	config.Homepath = t.TempDir()
+
+	unsorted := []KVPair{{Key: "b", Value: Position{Size: 1}}, {Key: "a", Value: Position{Size: 1}}}
+	metadata := TableMetadata{Path: filepath.Join(config.Homepath, "sst_1"), Format: currentTableFormat, Serial: 1, Size: 2, MinKey: "b", MaxKey: "a"}
+
+	_, err := serializeSSTable(metadata, config, NewFilterCache(0, false), nil, unsorted)
+	var violated *shared.ErrInvariantViolated
+	if !errors.As(err, &violated) || violated.Invariant != "sorted keys" {
+		t.Fatalf("serializeSSTable() error = %v, want a sorted keys violation", err)
+	}
+
+	// the same table written without checks must stop a paranoid open
+	config.Paranoid = false
+	table, err := serializeSSTable(metadata, config, NewFilterCache(0, false), nil, unsorted)
+	if err != nil {
+		t.Fatalf("serializeSSTable() error: %v", err)
+	}
+	table.Close()
+
+	config.Paranoid = true
+	if _, err := NewIndexManager(config, nopWAL{}, nil, nil); !errors.As(err, &violated) {
+		t.Errorf("NewIndexManager() error = %v, want an invariant violation", err)
+	}
+}
+
+func BenchmarkMergeTables(b *testing.B) {
+	config := shared.NewEngineConfig().WithMemtableSizeThreshold(20000).WithSmallTableMergeSize(0)
+	config.Homepath = b.TempDir()
+
+	im, err := NewIndexManager(config, nopWAL{}, nil, nil)
+	if err != nil {
+		b.Fatalf("NewIndexManager() error: %v", err)
+	}
+	defer im.Close()
+
+	for table := range 4 {
+		for i := range 5000 {
+			im.Set(KVPair{Key: fmt.Sprintf("key%06d", i*4+table), Value: Position{Offset: 1, Size: 1}})
+		}
+		if err := im.Flush(); err != nil {
+			b.Fatalf("Flush() error: %v", err)
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range b.N {
+		sources := []pairSource{}
+		for _, table := range im.sstables {
+			source, err := newTableSource(table, "")
+			if err != nil {
+				b.Fatalf("newTableSource() error: %v", err)
+			}
+			sources = append(sources, source)
+		}
+		it := newMergeIterator(sources)
+		it.keepDeleted = true
+		for {
+			_, ok, err := it.Next()
+			if err != nil {
+				b.Fatalf("Next() error: %v", err)
+			}
+			if !ok {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// ingestBatchSize is the number of pairs an Ingester sorts and writes to one SSTable.
+const ingestBatchSize = 100_000
+
+// Ingester bulk loads pairs straight into SSTables, bypassing the WAL and the
+// memtable. Values are appended to the data file as they are added, keys are
+// buffered, sorted and written as a new SSTable every ingestBatchSize pairs and
+// on Close. Each written batch is durable and visible on its own, an ingest
+// that fails midway keeps the batches written so far. Within an ingest the
+// last value added for a key wins, an empty value deletes the key.
+type Ingester struct {
+	engine  *Engine
+	pairs   []KVPair
+	written int
+	closed  bool
+}
+
+// NewIngester starts a bulk load, the ingester must be closed to write its last batch.
+func (e *Engine) NewIngester() (*Ingester, error) {
+	if e.Config.ReadOnly {
+		return nil, &shared.ErrReadOnly{Path: e.Config.Homepath}
+	}
+	return &Ingester{engine: e}, nil
+}
+
+// Add stores the value and buffers its key, writing the batch once it is full.
+func (in *Ingester) Add(key string, value []byte) error {
+	e := in.engine
+	if in.closed {
+		return fmt.Errorf("ingester is closed")
+	}
+	if len([]byte(key)) > int(e.Config.KeySize) {
+		return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+	}
+	if err := e.Config.KeyPolicy.Check(key); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	position, err := e.storeValue(key, value)
+	e.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("ingester failed to write (%q, %x): %v", key, value, err)
+	}
+
+	e.cache.written(key)
+	e.indexManager.writeAmp.ingested(key, len(key)+len(value))
+	in.pairs = append(in.pairs, KVPair{Key: key, Value: position})
+	if len(in.pairs) >= ingestBatchSize {
+		return in.commit()
+	}
+	return nil
+}
+
+// Written returns the number of pairs written to SSTables so far.
+func (in *Ingester) Written() int {
+	return in.written
+}
+
+// Close writes the buffered pairs and recounts the tenants' usage, the
+// references to deduplicated values and the garbage of the data file.
+func (in *Ingester) Close() error {
+	if in.closed {
+		return nil
+	}
+	in.closed = true
+
+	if err := in.commit(); err != nil {
+		return err
+	}
+	if in.written == 0 {
+		return nil
+	}
+	if err := in.engine.tenants.count(in.engine); err != nil {
+		return err
+	}
+	if err := in.engine.dedup.recount(in.engine); err != nil {
+		return err
+	}
+	return in.engine.space.recount(in.engine)
+}
+
+// commit sorts the buffered pairs and writes them to an SSTable newer than
+// every existing table. The memtable is flushed first so none of its older
+// entries can shadow the ingested ones.
+func (in *Ingester) commit() error {
+	if len(in.pairs) == 0 {
+		return nil
+	}
+	pairs := sortIngested(in.pairs)
+	in.pairs = in.pairs[:0]
+
+	e := in.engine
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.indexManager.memtable.Size() > 0 {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+
+	if err := e.storageManager.Sync(); err != nil {
+		return fmt.Errorf("ingester can not sync the data file: %v", err)
+	}
+
+	// the pairs are numbered when they become visible, after every earlier write
+	first := e.reserveSeqs(len(pairs))
+	for i := range pairs {
+		pairs[i].Seq = first + uint64(i)
+	}
+
+	im := e.indexManager
+	im.mu.Lock()
+	err := im.addTable(pairs)
+	im.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("ingester can not write %d pairs: %v", len(pairs), err)
+	}
+
+	for _, pair := range pairs {
+		im.misses.Invalidate(pair.Key)
+		e.rows.Invalidate(pair.Key)
+	}
+	in.written += len(pairs)
+	return nil
+}
+
+// sortIngested sorts the pairs by key keeping only the last one added for each key.
+func sortIngested(pairs []KVPair) []KVPair {
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+
+	sorted := make([]KVPair, 0, len(pairs))
+	for i, pair := range pairs {
+		if i+1 < len(pairs) && pairs[i+1].Key == pair.Key {
+			continue
+		}
+		sorted = append(sorted, pair)
+	}
+	return sorted
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestIngester(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithTenantQuota("bulk:", 0, 0))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	// older memtable entries must not shadow the ingested values
+	engine.Set("bulk:1", []byte("old"))
+	engine.Set("bulk:2", []byte("old"))
+
+	ingester, err := engine.NewIngester()
+	if err != nil {
+		t.Fatalf("NewIngester() error: %v", err)
+	}
+	for i := 9; i >= 0; i-- {
+		if err := ingester.Add(fmt.Sprintf("bulk:%d", i), []byte("first")); err != nil {
+			t.Fatalf("Add() error: %v", err)
+		}
+	}
+	ingester.Add("bulk:3", []byte("last"))
+	ingester.Add("bulk:2", nil)
+	if err := ingester.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	if written := ingester.Written(); written != 10 {
+		t.Errorf("Written() = %d, want 10", written)
+	}
+	if value, err := engine.Get("bulk:1"); err != nil || string(value) != "first" {
+		t.Errorf("Get(bulk:1) = %q, %v, want \"first\"", value, err)
+	}
+	if value, err := engine.Get("bulk:3"); err != nil || string(value) != "last" {
+		t.Errorf("Get(bulk:3) = %q, %v, want \"last\"", value, err)
+	}
+	if _, err := engine.Get("bulk:2"); err == nil {
+		t.Errorf("Get(bulk:2) found a key deleted by the ingest")
+	}
+
+	if stats := engine.TenantStats(); stats[0].Keys != 9 {
+		t.Errorf("tenant keys after the ingest = %d, want 9", stats[0].Keys)
+	}
+}
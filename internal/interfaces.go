@@ -7,10 +7,13 @@ import (
 type WALEntry struct {
 	Key   string
 	Value []byte
+	Merge bool   // Value is a merge operand, see Engine.Merge.
+	Seq   uint64 // Sequence number of the write, zero in records logged before writes were numbered.
 }
 
 type Memtable interface {
 	Set(KVPair)
+	SetBatch([]KVPair)
 	Get(string) Position
 	Contains(string) bool
 	Items() []KVPair
@@ -20,15 +23,21 @@ type Memtable interface {
 
 // DataManager is responsible for managing pair values
 type DataManager interface {
-	Store([]byte) (Position, error)
+	Store(key string, value []byte) (Position, error)
 	Retrieve(Position) ([]byte, error)
+	Erase(key string, position Position) error
+	Sync() error
 	Compact() error
 	Close() error
 }
 
 type WAL interface {
 	Append(WALEntry) error
-	Retrieve() ([]WALEntry, error)
+	AppendBatch([]WALEntry) error
+	Replay(func(WALEntry) error) error
+	Sync() error
+	OnDurable(func(error))
+	Unsynced() uint64
 	Clear() error
 	Close() error
 }
@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// The checks below run only in paranoid mode (EngineConfig.Paranoid), they
+// catch a broken invariant where it is introduced and stop the operation
+// before the damage is written to disk or spreads through compaction.
+
+// checkSortedPairs verifies that pairs are strictly sorted by key and that
+// they match the key range and size recorded in the metadata.
+func checkSortedPairs(metadata TableMetadata, pairs []KVPair) error {
+	if len(pairs) != int(metadata.Size) {
+		return &shared.ErrInvariantViolated{
+			Invariant: "table size",
+			Detail:    fmt.Sprintf("table %q records %d pairs but holds %d", metadata.Path, metadata.Size, len(pairs)),
+		}
+	}
+
+	for i := 1; i < len(pairs); i++ {
+		if pairs[i-1].Key >= pairs[i].Key {
+			return &shared.ErrInvariantViolated{
+				Invariant: "sorted keys",
+				Detail:    fmt.Sprintf("table %q has key %q at %d after %q", metadata.Path, pairs[i].Key, i, pairs[i-1].Key),
+			}
+		}
+	}
+
+	if len(pairs) > 0 && (pairs[0].Key != metadata.MinKey || pairs[len(pairs)-1].Key != metadata.MaxKey) {
+		return &shared.ErrInvariantViolated{
+			Invariant: "key range",
+			Detail: fmt.Sprintf("table %q holds keys [%q, %q] but records [%q, %q]",
+				metadata.Path, pairs[0].Key, pairs[len(pairs)-1].Key, metadata.MinKey, metadata.MaxKey),
+		}
+	}
+
+	return nil
+}
+
+// checkSerials verifies that no two tables of the same kind or level share a
+// serial, tables must be sorted by descending serial. Level files written
+// before levels were numbered may overlap each other, so only the serials of
+// a level are checked against one another.
+func checkSerials(tables []*SSTable) error {
+	for i := 1; i < len(tables); i++ {
+		if tables[i-1].metadata.Serial <= tables[i].metadata.Serial {
+			return &shared.ErrInvariantViolated{
+				Invariant: "unique serials",
+				Detail: fmt.Sprintf("tables %q and %q have serials %d and %d",
+					tables[i-1].metadata.Path, tables[i].metadata.Path, tables[i-1].metadata.Serial, tables[i].metadata.Serial),
+			}
+		}
+	}
+	return nil
+}
+
+// checkNewSerial verifies that a table being created is newer than every live table of its kind.
+func checkNewSerial(metadata TableMetadata, tables []*SSTable) error {
+	for _, table := range tables {
+		if table.metadata.Serial >= metadata.Serial {
+			return &shared.ErrInvariantViolated{
+				Invariant: "increasing serials",
+				Detail:    fmt.Sprintf("new table %q has serial %d but %q has %d", metadata.Path, metadata.Serial, table.metadata.Path, table.metadata.Serial),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ioSchedulerPoll is how often a waiting background operation checks
+// whether the foreground operations are done.
+const ioSchedulerPoll = time.Millisecond
+
+// ioScheduler gives user operations priority over background work. Foreground
+// operations (gets, writes, deletes) are tracked while they run, background
+// operations (flushes from the background flusher, compaction, scrubbing)
+// yield before each disk access while foreground operations are in flight,
+// for at most maxDelay so background work is never starved.
+// A nil scheduler or a zero maxDelay disables the prioritization.
+type ioScheduler struct {
+	maxDelay time.Duration
+	active   atomic.Int64 // Foreground operations in flight.
+
+	waits   atomic.Uint64 // Background operations and scans that had to yield.
+	delayed atomic.Int64  // Total time background operations and scans yielded, in nanoseconds.
+}
+
+func newIOScheduler(maxDelay time.Duration) *ioScheduler {
+	return &ioScheduler{maxDelay: maxDelay}
+}
+
+// foreground marks the start of a user operation, the returned function must
+// be called once it completes, later calls do nothing. An operation filling
+// the memtable ends its mark before flushing it, the flush and the
+// compactions it runs would otherwise wait for the operation itself.
+func (s *ioScheduler) foreground() func() {
+	if s == nil {
+		return func() {}
+	}
+
+	s.active.Add(1)
+	var once sync.Once
+	return func() { once.Do(func() { s.active.Add(-1) }) }
+}
+
+// background waits, up to maxDelay, until no foreground operation is in flight,
+// it is called before every disk access of a background operation.
+func (s *ioScheduler) background() {
+	if s == nil {
+		return
+	}
+	s.yield(s.maxDelay)
+}
+
+// yield waits, up to delay, until no foreground operation is in flight.
+func (s *ioScheduler) yield(delay time.Duration) {
+	if s == nil || delay == 0 || s.active.Load() == 0 {
+		return
+	}
+
+	start := time.Now()
+	deadline := start.Add(delay)
+	for s.active.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(ioSchedulerPoll)
+	}
+
+	s.waits.Add(1)
+	s.delayed.Add(int64(time.Since(start)))
+}
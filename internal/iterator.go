@@ -0,0 +1,342 @@
+package internal
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"sort"
+)
+
+// pairSource is a sorted stream of pairs read by a mergeIterator.
+type pairSource interface {
+	valid() bool
+	current() KVPair
+	advance() error
+}
+
+// sliceSource streams an in-memory sorted slice, e.g. a memtable copy.
+type sliceSource struct {
+	pairs []KVPair
+	pos   int
+}
+
+func newSliceSource(pairs []KVPair, start string) *sliceSource {
+	pos := sort.Search(len(pairs), func(i int) bool { return pairs[i].Key >= start })
+	return &sliceSource{pairs: pairs, pos: pos}
+}
+
+func (s *sliceSource) valid() bool     { return s.pos < len(s.pairs) }
+func (s *sliceSource) current() KVPair { return s.pairs[s.pos] }
+func (s *sliceSource) advance() error  { s.pos++; return nil }
+
+// tableSource streams a table one block at a time. Tables of the fixed
+// width format have no blocks and are read at once.
+type tableSource struct {
+	table *SSTable
+	pacer *pacer   // Yielded to before every block but the first, nil never yields.
+	block int      // Next block to read.
+	pairs []KVPair // Pairs of the current block.
+	pos   int
+}
+
+func newTableSource(table *SSTable, start string) (*tableSource, error) {
+	ts := &tableSource{table: table}
+
+	if table.metadata.Format == tableFormatFixed {
+		pairs, err := table.Items()
+		if err != nil {
+			return nil, err
+		}
+		ts.pairs = pairs
+		ts.pos = sort.Search(len(pairs), func(i int) bool { return pairs[i].Key >= start })
+		return ts, nil
+	}
+
+	// start from the only block that may hold the start key
+	ts.block = max(sort.Search(len(table.index), func(i int) bool { return table.index[i].firstKey > start })-1, 0)
+	if err := ts.loadBlock(); err != nil {
+		return nil, err
+	}
+
+	for ts.valid() && ts.current().Key < start {
+		if err := ts.advance(); err != nil {
+			return nil, err
+		}
+	}
+	return ts, nil
+}
+
+func (ts *tableSource) valid() bool     { return ts.pos < len(ts.pairs) }
+func (ts *tableSource) current() KVPair { return ts.pairs[ts.pos] }
+
+func (ts *tableSource) advance() error {
+	ts.pos++
+	if ts.pos < len(ts.pairs) {
+		return nil
+	}
+	return ts.loadBlock()
+}
+
+// loadBlock reads the next block, leaving the source invalid past the last one.
+func (ts *tableSource) loadBlock() error {
+	ts.pairs, ts.pos = nil, 0
+	if ts.table.metadata.Format == tableFormatFixed || ts.block >= len(ts.table.index) {
+		return nil
+	}
+
+	if err := ts.pacer.block(); err != nil {
+		return err
+	}
+	handle := ts.table.index[ts.block]
+	block, err := ts.table.bytesAt(int64(handle.offset), int(handle.size))
+	if err != nil {
+		return fmt.Errorf("sstable %q can not read block at %d: %v", ts.table.metadata.Path, handle.offset, err)
+	}
+
+	block, err = ts.table.blockData(block, handle.offset)
+	if err != nil {
+		return fmt.Errorf("sstable %q can not decompress block at %d: %w", ts.table.metadata.Path, handle.offset, err)
+	}
+	pairs, err := decodeBlock(block, ts.table.metadata.Format)
+	if err != nil {
+		return fmt.Errorf("sstable %q can not decode block at %d: %v", ts.table.metadata.Path, handle.offset, err)
+	}
+
+	ts.pairs = pairs
+	ts.block++
+	return nil
+}
+
+// mergeIterator merges sorted sources into a single sorted stream of live
+// pairs. Sources are ordered from newest to oldest, when several hold the
+// same key the pair with the highest sequence number wins, the newest source
+// breaking ties between pairs written before writes were numbered. Deleted
+// keys are skipped unless keepDeleted is set, as needed when the result does
+// not shadow every older table.
+type mergeIterator struct {
+	sources     []pairSource
+	heap        sourceHeap
+	keepDeleted bool
+}
+
+func newMergeIterator(sources []pairSource) *mergeIterator {
+	it := &mergeIterator{sources: sources, heap: sourceHeap{sources: sources}}
+	for i, source := range sources {
+		if source.valid() {
+			it.heap.indexes = append(it.heap.indexes, i)
+		}
+	}
+	heap.Init(&it.heap)
+	return it
+}
+
+// Next returns the next live pair, ok is false once the sources are exhausted.
+func (it *mergeIterator) Next() (pair KVPair, ok bool, err error) {
+	for it.heap.Len() > 0 {
+		pair = it.sources[it.heap.indexes[0]].current()
+
+		// move every source past this key, the first one popped is the newest
+		for it.heap.Len() > 0 && it.sources[it.heap.indexes[0]].current().Key == pair.Key {
+			if current := it.sources[it.heap.indexes[0]].current(); current.Seq > pair.Seq {
+				pair = current
+			}
+			i := heap.Pop(&it.heap).(int)
+			if err := it.sources[i].advance(); err != nil {
+				return KVPair{}, false, err
+			}
+			if it.sources[i].valid() {
+				heap.Push(&it.heap, i)
+			}
+		}
+
+		if pair.Value.Size == 0 && !it.keepDeleted {
+			continue // deleted key
+		}
+		return pair, true, nil
+	}
+
+	return KVPair{}, false, nil
+}
+
+// sourceHeap orders source indexes by current key, then by age.
+type sourceHeap struct {
+	sources []pairSource
+	indexes []int
+}
+
+func (h sourceHeap) Len() int { return len(h.indexes) }
+
+func (h sourceHeap) Less(i, j int) bool {
+	a, b := h.sources[h.indexes[i]].current().Key, h.sources[h.indexes[j]].current().Key
+	if a != b {
+		return a < b
+	}
+	return h.indexes[i] < h.indexes[j]
+}
+
+func (h sourceHeap) Swap(i, j int) { h.indexes[i], h.indexes[j] = h.indexes[j], h.indexes[i] }
+func (h *sourceHeap) Push(x any)   { h.indexes = append(h.indexes, x.(int)) }
+
+func (h *sourceHeap) Pop() any {
+	last := h.indexes[len(h.indexes)-1]
+	h.indexes = h.indexes[:len(h.indexes)-1]
+	return last
+}
+
+// iterator returns a mergeIterator over the snapshot starting at the first key not less than start.
+func (s *indexSnapshot) iterator(start string) (*mergeIterator, error) {
+	return s.rangeIterator(start, "")
+}
+
+// rangeIterator is like iterator but skips the tables holding no key of
+// [start, end), an empty end means no upper bound. The caller still has to
+// stop at end.
+func (s *indexSnapshot) rangeIterator(start, end string) (*mergeIterator, error) {
+	sources := []pairSource{newSliceSource(s.memtable, start)}
+	for _, table := range s.tables {
+		if table.metadata.MaxKey < start || (end != "" && table.metadata.MinKey >= end) {
+			continue
+		}
+
+		source, err := newTableSource(table, start)
+		if err != nil {
+			return nil, fmt.Errorf("can not iterate table %d: %v", table.metadata.Serial, err)
+		}
+		sources = append(sources, source)
+	}
+
+	return newMergeIterator(sources), nil
+}
+
+// prefixIterator returns a mergeIterator over the keys starting with prefix,
+// tables whose key range can not hold such a key are never read. The caller
+// still has to stop at the first key past the prefix.
+func (s *indexSnapshot) prefixIterator(prefix string) (*mergeIterator, error) {
+	return s.rangeIterator(prefix, prefixEnd(prefix))
+}
+
+// prefixEnd returns the smallest key greater than every key starting with
+// prefix, or "" when there is none, i.e. the prefix is empty or only 0xff bytes.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// Iterator is a forward cursor over the live pairs of the engine in key
+// order, read from a snapshot pinned when it was created. Writes made after
+// NewIterator are not seen. An iterator is not safe for concurrent use and
+// must be closed to unpin the snapshot's tables.
+//
+//	it := e.NewIterator()
+//	defer it.Close()
+//	for it.Seek("user:"); it.Valid(); it.Next() {
+//		value, err := it.Value()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type Iterator struct {
+	engine   *Engine
+	ctx      context.Context
+	snapshot *indexSnapshot
+	merge    *mergeIterator
+	pair     KVPair
+	valid    bool
+	err      error
+}
+
+// NewIterator returns an iterator positioned before the first key, the first
+// call to Next moves it to the first key.
+func (e *Engine) NewIterator() *Iterator {
+	return e.NewIteratorContext(context.Background())
+}
+
+// NewIteratorContext is like NewIterator, the iterator stops with the error
+// of ctx once ctx is done, checked before every table block it reads.
+func (e *Engine) NewIteratorContext(ctx context.Context) *Iterator {
+	return &Iterator{engine: e, ctx: ctx, snapshot: e.indexManager.snapshot()}
+}
+
+// Seek moves the iterator to the first key not less than key and reports
+// whether there is one.
+func (it *Iterator) Seek(key string) bool {
+	it.valid = false
+	if it.err != nil {
+		return false
+	}
+
+	it.merge, it.err = it.snapshot.iterator(key)
+	if it.err != nil {
+		it.err = fmt.Errorf("iterator can not seek %q: %v", key, it.err)
+		return false
+	}
+	it.merge.pace(it.engine.scanPacer(it.ctx))
+	return it.Next()
+}
+
+// Next moves the iterator to the next key and reports whether there is one.
+func (it *Iterator) Next() bool {
+	if it.merge == nil {
+		return it.Seek("")
+	}
+
+	it.valid = false
+	if it.err != nil {
+		return false
+	}
+
+	for {
+		pair, ok, err := it.merge.Next()
+		if err != nil {
+			it.err = fmt.Errorf("iterator can not read past %q: %w", it.pair.Key, err)
+			return false
+		}
+		if ok && it.engine.cache.expired(pair.Key) {
+			continue
+		}
+		it.pair, it.valid = pair, ok
+		return ok
+	}
+}
+
+// Valid reports whether the iterator is positioned at a key.
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+// Key returns the current key, it is only meaningful while Valid.
+func (it *Iterator) Key() string {
+	return it.pair.Key
+}
+
+// Value reads the current value from the data file.
+func (it *Iterator) Value() ([]byte, error) {
+	if !it.valid {
+		return nil, fmt.Errorf("iterator is not positioned at a key")
+	}
+
+	value, err := it.engine.readValue(it.pair.Key, it.pair.Value)
+	if err != nil {
+		return nil, fmt.Errorf("iterator can not read key (%q): %v", it.pair.Key, err)
+	}
+	return value, nil
+}
+
+// Err returns the error that stopped the iterator, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Close releases the snapshot, the iterator can not be used afterwards.
+func (it *Iterator) Close() error {
+	it.valid, it.merge = false, nil
+	it.snapshot.Release()
+	return nil
+}
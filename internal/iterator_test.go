@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestScanFunc(t *testing.T) {
+	config := *shared.NewEngineConfig().WithMemtableSizeThreshold(50).WithBlockSize(256)
+	e, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	// spread versions of the keys over several tables and the memtable
+	for i := range 120 {
+		e.Set(fmt.Sprintf("user:%03d", i), []byte("old"))
+		e.Set(fmt.Sprintf("item:%03d", i), []byte("item"))
+	}
+	for i := 0; i < 120; i += 2 {
+		e.Set(fmt.Sprintf("user:%03d", i), []byte("new"))
+	}
+	for i := 0; i < 120; i += 3 {
+		e.Delete(fmt.Sprintf("user:%03d", i))
+	}
+
+	want := []string{}
+	for i := range 120 {
+		if i%3 == 0 {
+			continue
+		}
+		value := "old"
+		if i%2 == 0 {
+			value = "new"
+		}
+		want = append(want, fmt.Sprintf("user:%03d=%s", i, value))
+	}
+
+	got := []string{}
+	err = e.ScanFunc("user:", func(key string, value []byte) (bool, error) {
+		got = append(got, key+"="+string(value))
+		return false, nil
+	})
+	if err != nil {
+		t.Fatalf("ScanFunc() error: %v", err)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("ScanFunc() returned %d pairs, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("pair %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	calls := 0
+	e.ScanFunc("", func(key string, value []byte) (bool, error) {
+		calls++
+		return calls == 5, nil
+	})
+	if calls != 5 {
+		t.Errorf("ScanFunc() called back %d times after stopping, want 5", calls)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	config := *shared.NewEngineConfig().WithMemtableSizeThreshold(20).WithBlockSize(256).WithSmallTableMergeSize(0)
+	e, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	for i := range 100 {
+		e.Set(fmt.Sprintf("key%03d", i), []byte(fmt.Sprintf("old%d", i)))
+	}
+	for i := 0; i < 100; i += 2 {
+		e.Set(fmt.Sprintf("key%03d", i), []byte(fmt.Sprintf("new%d", i)))
+	}
+	for i := 0; i < 100; i += 5 {
+		e.Delete(fmt.Sprintf("key%03d", i))
+	}
+
+	it := e.NewIterator()
+	defer it.Close()
+
+	// writes after the iterator was created are not seen
+	e.Set("key000", []byte("late"))
+
+	seen := 0
+	for it.Next() {
+		i := 0
+		fmt.Sscanf(it.Key(), "key%03d", &i)
+		want := fmt.Sprintf("old%d", i)
+		if i%2 == 0 {
+			want = fmt.Sprintf("new%d", i)
+		}
+		if i%5 == 0 {
+			t.Fatalf("iterator returned deleted key %q", it.Key())
+		}
+
+		value, err := it.Value()
+		if err != nil || string(value) != want {
+			t.Fatalf("Value() of %q = %q, %v, want %q", it.Key(), value, err, want)
+		}
+		seen++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("Err() = %v", err)
+	}
+	if seen != 80 {
+		t.Errorf("iterated %d keys, want 80", seen)
+	}
+
+	if !it.Seek("key050") || it.Key() != "key051" {
+		t.Errorf("Seek(key050) stopped at %q, want key051", it.Key())
+	}
+	if !it.Next() || it.Key() != "key052" {
+		t.Errorf("Next() after Seek stopped at %q, want key052", it.Key())
+	}
+	if it.Seek("key999") || it.Valid() {
+		t.Errorf("Seek(key999) found %q past the last key", it.Key())
+	}
+}
+
+func TestRange(t *testing.T) {
+	config := *shared.NewEngineConfig().WithMemtableSizeThreshold(10).WithSmallTableMergeSize(0)
+	e, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	// each flushed table holds its own run of keys
+	for i := range 50 {
+		e.Set(fmt.Sprintf("key%02d", i), []byte(fmt.Sprintf("value%d", i)))
+	}
+	e.Delete("key23")
+
+	got := []string{}
+	err = e.Range("key15", "key25", func(key string, value []byte) (bool, error) {
+		got = append(got, key+"="+string(value))
+		return false, nil
+	})
+	if err != nil {
+		t.Fatalf("Range() error: %v", err)
+	}
+	if len(got) != 9 || got[0] != "key15=value15" || got[8] != "key24=value24" {
+		t.Errorf("Range(key15, key25) = %v", got)
+	}
+
+	// only the tables overlapping the range are read
+	snapshot := e.indexManager.snapshot()
+	defer snapshot.Release()
+	it, err := snapshot.rangeIterator("key15", "key25")
+	if err != nil {
+		t.Fatalf("rangeIterator() error: %v", err)
+	}
+	if tables := len(it.sources) - 1; tables != 2 {
+		t.Errorf("rangeIterator() read %d of %d tables, want 2", tables, len(snapshot.tables))
+	}
+
+	count := 0
+	e.Range("key45", "", func(key string, value []byte) (bool, error) {
+		count++
+		return false, nil
+	})
+	if count != 5 {
+		t.Errorf("Range(key45, \"\") returned %d pairs, want 5", count)
+	}
+}
+
+func TestScanKeysAfter(t *testing.T) {
+	config := *shared.NewEngineConfig().WithMemtableSizeThreshold(10).WithSmallTableMergeSize(0)
+	e, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	for i := range 30 {
+		e.Set(fmt.Sprintf("a:%02d", i), []byte("value"))
+		e.Set(fmt.Sprintf("b:%02d", i), []byte("value"))
+	}
+
+	// pages of 7 keys resumed after the last key of the previous one
+	pages, keys, after := 0, []string{}, ""
+	for {
+		page := []string{}
+		err := e.ScanKeysAfter("a:*", after, func(key string) (bool, error) {
+			page = append(page, key)
+			return len(page) == 7, nil
+		})
+		if err != nil {
+			t.Fatalf("ScanKeysAfter(a:*, %q) error: %v", after, err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		pages++
+		keys = append(keys, page...)
+		after = page[len(page)-1]
+	}
+
+	if pages != 5 || len(keys) != 30 || keys[0] != "a:00" || keys[29] != "a:29" {
+		t.Errorf("paged scan returned %d keys in %d pages: %v", len(keys), pages, keys)
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i] <= keys[i-1] {
+			t.Fatalf("paged scan returned %q after %q", keys[i], keys[i-1])
+		}
+	}
+}
+
+func TestPrefixPushdown(t *testing.T) {
+	for prefix, want := range map[string]string{"user:": "user;", "a\xff": "b", "\xff\xff": "", "": ""} {
+		if end := prefixEnd(prefix); end != want {
+			t.Errorf("prefixEnd(%q) = %q, want %q", prefix, end, want)
+		}
+	}
+
+	config := *shared.NewEngineConfig().WithMemtableSizeThreshold(10).WithSmallTableMergeSize(0)
+	e, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	for _, prefix := range []string{"item:", "order:", "user:"} {
+		for i := range 10 {
+			e.Set(fmt.Sprintf("%s%d", prefix, i), []byte("value"))
+		}
+	}
+
+	snapshot := e.indexManager.snapshot()
+	defer snapshot.Release()
+	it, err := snapshot.prefixIterator("order:")
+	if err != nil {
+		t.Fatalf("prefixIterator() error: %v", err)
+	}
+	if tables := len(it.sources) - 1; tables != 1 {
+		t.Errorf("prefixIterator(order:) reads %d of %d tables, want 1", tables, len(snapshot.tables))
+	}
+
+	keys, err := e.Scan("order:")
+	if err != nil || len(keys) != 10 {
+		t.Errorf("Scan(order:) = %d keys, %v, want 10", len(keys), err)
+	}
+}
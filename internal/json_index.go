@@ -0,0 +1,216 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// IndexKeyPrefix starts the entries of the JSON indexes, an entry is stored
+// under IndexKeyPrefix + name + ":" + the hex hash of the field's value + ":"
+// followed by the key of the indexed value.
+const IndexKeyPrefix = "__index:"
+
+// indexEntryValue is the value of index entries, the key holds everything.
+var indexEntryValue = []byte{1}
+
+// jsonIndex is a field of the JSON values of the keys under a prefix, see
+// shared.JSONIndex.
+type jsonIndex struct {
+	name   string
+	prefix string
+	path   []shared.JSONPathStep
+}
+
+// jsonIndexes are the indexes of shared.EngineConfig.JSONIndexes, nil if
+// there are none. An entry is added with every indexed value written by Set
+// or a batch and is only removed once QueryIndex finds it stale, so an
+// overwrite or a deletion does not read the value it replaces. Values written
+// by Merge or an Ingester are not indexed.
+type jsonIndexes map[string]jsonIndex
+
+// newJSONIndexes parses the indexes of config.
+func newJSONIndexes(config *shared.EngineConfig) (jsonIndexes, error) {
+	if len(config.JSONIndexes) == 0 {
+		return nil, nil
+	}
+
+	indexes := jsonIndexes{}
+	for _, index := range config.JSONIndexes {
+		path, err := shared.ParseJSONPath(index.Path)
+		if err != nil {
+			return nil, err
+		}
+		indexes[index.Name] = jsonIndex{name: index.Name, prefix: index.Prefix, path: path}
+	}
+	return indexes, nil
+}
+
+// covers reports whether an index selects key, the engine's own keys are
+// never indexed.
+func (ix jsonIndexes) covers(key string) bool {
+	for _, prefix := range internalKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return false
+		}
+	}
+	for _, index := range ix {
+		if strings.HasPrefix(key, index.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// entries returns the index entries of the value of key, none if the value is
+// not JSON or lacks the indexed fields.
+func (ix jsonIndexes) entries(key string, value []byte) []string {
+	if len(value) == 0 || !ix.covers(key) {
+		return nil
+	}
+	document, ok := parseDocument(value)
+	if !ok {
+		return nil
+	}
+
+	entries := []string{}
+	for _, index := range ix {
+		if !strings.HasPrefix(key, index.prefix) {
+			continue
+		}
+		if field, ok := index.extract(document); ok {
+			entries = append(entries, index.entryPrefix(field)+key)
+		}
+	}
+	return entries
+}
+
+// parseDocument decodes a JSON value, keeping numbers as written.
+func parseDocument(value []byte) (any, bool) {
+	var document any
+	decoder := json.NewDecoder(bytes.NewReader(value))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, false
+	}
+	return document, true
+}
+
+// extract returns the indexed field of the document as its string, or the
+// JSON text of a number or a boolean. Objects, arrays and nulls are not indexed.
+func (index jsonIndex) extract(document any) (string, bool) {
+	for _, step := range index.path {
+		switch node := document.(type) {
+		case map[string]any:
+			if document = node[step.Field]; step.Field == "" || document == nil {
+				return "", false
+			}
+		case []any:
+			if step.Field != "" || step.Index >= len(node) {
+				return "", false
+			}
+			document = node[step.Index]
+		default:
+			return "", false
+		}
+	}
+
+	switch field := document.(type) {
+	case string:
+		return field, true
+	case json.Number:
+		return field.String(), true
+	case bool:
+		return fmt.Sprint(field), true
+	}
+	return "", false
+}
+
+// entryPrefix starts the entries of the values whose field is field.
+func (index jsonIndex) entryPrefix(field string) string {
+	hash := fnv.New64a()
+	hash.Write([]byte(field))
+	return fmt.Sprintf("%s%s:%016x:", IndexKeyPrefix, index.name, hash.Sum64())
+}
+
+// QueryIndex returns the keys whose JSON value holds value in the field
+// indexed by the index name, in key order. Entries left behind by overwritten
+// or deleted values are removed.
+func (e *Engine) QueryIndex(name, value string) ([]string, error) {
+	index, ok := e.indexes[name]
+	if !ok {
+		return nil, &shared.ErrIndexNotFound{Name: name}
+	}
+
+	prefix := index.entryPrefix(value)
+	keys, stale := []string{}, []string{}
+	err := e.scan(prefix, func(pair KVPair) (bool, error) {
+		key := strings.TrimPrefix(pair.Key, prefix)
+		field, ok, err := e.indexedField(index, key)
+		if err != nil {
+			return true, err
+		}
+		switch {
+		case ok && field == value:
+			keys = append(keys, key)
+		// values colliding with value share its entries
+		case !ok || index.entryPrefix(field) != prefix:
+			stale = append(stale, pair.Key)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("engine can not query index %q: %v", name, err)
+	}
+
+	if len(stale) > 0 && !e.Config.ReadOnly {
+		if err := e.removeStaleEntries(index, prefix, stale); err != nil {
+			return nil, fmt.Errorf("engine can not clean up index %q: %v", name, err)
+		}
+	}
+	return keys, nil
+}
+
+// indexedField returns the field of the index in the current value of key,
+// false if the key is gone or its value has no such field.
+func (e *Engine) indexedField(index jsonIndex, key string) (string, bool, error) {
+	value, err := e.Get(key)
+	if _, ok := err.(*shared.ErrKeyNotFound); ok {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	document, ok := parseDocument(value)
+	if !ok {
+		return "", false, nil
+	}
+	field, ok := index.extract(document)
+	return field, ok, nil
+}
+
+// removeStaleEntries deletes the entries under prefix found stale by
+// QueryIndex, holding e.mu so a write indexing their key again in between is
+// not undone.
+func (e *Engine) removeStaleEntries(index jsonIndex, prefix string, entries []string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for _, entry := range entries {
+		field, ok, err := e.indexedField(index, strings.TrimPrefix(entry, prefix))
+		if err != nil {
+			return err
+		}
+		if ok && index.entryPrefix(field) == prefix {
+			continue
+		}
+		if err := e.delete(entry, 0, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
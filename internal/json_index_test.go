@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestJSONIndex(t *testing.T) {
+	dir := t.TempDir()
+	config := shared.NewEngineConfig().WithJSONIndex("email", "user:", "$.email").WithJSONIndex("city", "user:", "address.city")
+	engine, err := NewEngine(dir, *config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	for i, email := range []string{"a@x.io", "b@x.io", "a@x.io"} {
+		value := fmt.Sprintf(`{"email": %q, "address": {"city": "c%d"}}`, email, i%2)
+		if err := engine.Set(fmt.Sprintf("user:%d", i), []byte(value)); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+	}
+	// values outside the prefix, not JSON or without the field are not indexed
+	for key, value := range map[string]string{"other:1": `{"email": "a@x.io"}`, "user:9": "a@x.io", "user:8": `{"name": "a"}`} {
+		if err := engine.Set(key, []byte(value)); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+	}
+	batch := engine.WriteBatch()
+	batch.Set("user:3", []byte(`{"email": "b@x.io"}`))
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+
+	query := func(name, value string, want ...string) {
+		t.Helper()
+		if keys, err := engine.QueryIndex(name, value); err != nil || !slices.Equal(keys, want) {
+			t.Errorf("QueryIndex(%q, %q) = %q, %v, want %q", name, value, keys, err, want)
+		}
+	}
+	query("email", "a@x.io", "user:0", "user:2")
+	query("email", "b@x.io", "user:1", "user:3")
+	query("city", "c1", "user:1")
+	query("email", "c@x.io")
+	if _, err := engine.QueryIndex("name", "a"); err == nil {
+		t.Errorf("QueryIndex() of an unknown index succeeded")
+	}
+
+	// entries of overwritten and deleted values are removed by the next query
+	if err := engine.Set("user:0", []byte(`{"email": "c@x.io"}`)); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+	if err := engine.Delete("user:1"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	query("email", "a@x.io", "user:2")
+	query("email", "b@x.io", "user:3")
+	query("email", "c@x.io", "user:0")
+	entries, err := engine.Scan(IndexKeyPrefix + "email:*")
+	if err != nil || len(entries) != 3 {
+		t.Errorf("index entries = %q, %v, want 3", entries, err)
+	}
+
+	// the entries are replayed from the WAL with their values
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	engine, err = NewEngine(dir, *config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+	query("email", "a@x.io", "user:2")
+	query("city", "c0", "user:2")
+}
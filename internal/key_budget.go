@@ -0,0 +1,27 @@
+package internal
+
+import "github.com/hasssanezzz/goldb/shared"
+
+// keyOverhead approximates the memory a collected key takes besides its
+// bytes, its string header and its share of the collection.
+const keyOverhead = 32
+
+// keyBudget accounts for the keys collected by an operation holding them all
+// in memory, see Config.KeysMemoryBudget.
+type keyBudget struct {
+	operation string
+	limit     uint64 // Zero means unlimited.
+	used      uint64
+}
+
+// add accounts for a collected key, failing once the budget is exceeded.
+func (b *keyBudget) add(key string) error {
+	if b.limit == 0 {
+		return nil
+	}
+	b.used += uint64(len(key)) + keyOverhead
+	if b.used > b.limit {
+		return &shared.ErrMemoryBudget{Operation: b.operation, Budget: b.limit}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestKeysMemoryBudget(t *testing.T) {
+	// room for about a hundred keys
+	config := *shared.NewEngineConfig().WithKeysMemoryBudget(100 * (6 + keyOverhead))
+	engine, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for i := range 150 {
+		engine.Set(fmt.Sprintf("a:%04d", i), []byte("value"))
+	}
+	engine.indexManager.Flush()
+	for i := range 50 {
+		engine.Set(fmt.Sprintf("b:%04d", i), []byte("value"))
+	}
+
+	var budget *shared.ErrMemoryBudget
+	if _, err := engine.Scan(""); !errors.As(err, &budget) {
+		t.Errorf("Scan() error = %v, want an ErrMemoryBudget", err)
+	}
+	if _, err := engine.indexManager.Keys(); !errors.As(err, &budget) {
+		t.Errorf("Keys() error = %v, want an ErrMemoryBudget", err)
+	}
+	if keys, err := engine.Scan("b:"); err != nil || len(keys) != 50 {
+		t.Errorf("Scan(b:) = %d keys, %v, want 50 within the budget", len(keys), err)
+	}
+
+	visited := 0
+	engine.ScanKeysFunc("", func(string) (bool, error) { visited++; return false, nil })
+	if visited != 200 {
+		t.Errorf("ScanKeysFunc() visited %d keys, want 200 whatever the budget", visited)
+	}
+}
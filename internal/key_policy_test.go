@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestKeyPolicy(t *testing.T) {
+	policy := shared.KeyPolicy{MaxLength: 12, AllowedChars: "abcdefghijklmnopqrstuvwxyz:_", ReservedPrefixes: []string{"meta:"}}
+	e, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithKeyPolicy(policy))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	if err := e.Set("user:ada", []byte("v")); err != nil {
+		t.Errorf("Set() of a valid key error: %v", err)
+	}
+
+	var errInvalid *shared.ErrInvalidKey
+	for _, key := range []string{"user:ada_lovelace", "User", "meta:schema", QueueKeyPrefix + "jobs", SetKeyPrefix + "x"} {
+		if err := e.Set(key, []byte("v")); !errors.As(err, &errInvalid) {
+			t.Errorf("Set(%q) error = %v, want ErrInvalidKey", key, err)
+		}
+		if err := e.Delete(key); !errors.As(err, &errInvalid) {
+			t.Errorf("Delete(%q) error = %v, want ErrInvalidKey", key, err)
+		}
+	}
+
+	// the engine still writes to its reserved namespaces
+	q, err := e.Queue("jobs")
+	if err != nil {
+		t.Fatalf("Queue() error: %v", err)
+	}
+	if _, err := q.Enqueue([]byte("job")); err != nil {
+		t.Errorf("Enqueue() error: %v", err)
+	}
+	if _, err := e.SAdd("tags", "go"); err != nil {
+		t.Errorf("SAdd() error: %v", err)
+	}
+	if len(policy.ReservedPrefixes) != 1 {
+		t.Errorf("NewEngine() modified the caller's policy: %v", policy.ReservedPrefixes)
+	}
+}
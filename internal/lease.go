@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// Lease is a time bound lock on a key. Its token grows every time the lease
+// changes owner, services pass it along with their writes to other systems
+// so a stale holder, whose lease expired while it was paused, is fenced off.
+type Lease struct {
+	Key       string    `json:"-"`
+	Owner     string    `json:"owner"`
+	Token     uint64    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// AcquireLease takes the lease on key for owner until ttl from now, or
+// renews it if owner already holds it, keeping its token. It fails with an
+// ErrLeaseHeld while another owner holds an unexpired lease. The lease is
+// stored as the key's JSON value and swapped in with CompareAndSwap, so
+// concurrent acquisitions have a single winner.
+func (e *Engine) AcquireLease(key, owner string, ttl time.Duration) (Lease, error) {
+	if owner == "" {
+		return Lease{}, fmt.Errorf("lease %q needs an owner", key)
+	}
+	if ttl <= 0 {
+		return Lease{}, fmt.Errorf("lease %q needs a positive ttl, got %s", key, ttl)
+	}
+
+	for {
+		current, held, err := e.currentLease(key)
+		if err != nil {
+			return Lease{}, err
+		}
+
+		now := time.Now()
+		lease := Lease{Key: key, Owner: owner, Token: held.Token + 1, ExpiresAt: now.Add(ttl)}
+		if now.Before(held.ExpiresAt) {
+			if held.Owner != owner {
+				return Lease{}, &shared.ErrLeaseHeld{Key: key, Owner: held.Owner, ExpiresAt: held.ExpiresAt}
+			}
+			lease.Token = held.Token
+		}
+
+		swapped, err := e.swapLease(key, current, lease)
+		if err != nil || swapped {
+			return lease, err
+		}
+	}
+}
+
+// ReleaseLease gives up the lease owner holds with token before it expires.
+// The lease is kept as expired rather than deleted so the next owner still
+// gets a greater token.
+func (e *Engine) ReleaseLease(key, owner string, token uint64) error {
+	for {
+		current, held, err := e.currentLease(key)
+		if err != nil {
+			return err
+		}
+		if held.Owner != owner || held.Token != token || !time.Now().Before(held.ExpiresAt) {
+			return &shared.ErrLeaseHeld{Key: key, Owner: held.Owner, ExpiresAt: held.ExpiresAt}
+		}
+
+		held.ExpiresAt = time.Time{}
+		swapped, err := e.swapLease(key, current, held)
+		if err != nil || swapped {
+			return err
+		}
+	}
+}
+
+// currentLease returns the raw value of key and the lease it holds, a zero
+// lease when the key does not exist.
+func (e *Engine) currentLease(key string) ([]byte, Lease, error) {
+	current, err := e.Get(key)
+	if err != nil {
+		var notFound *shared.ErrKeyNotFound
+		if errors.As(err, &notFound) {
+			return nil, Lease{Key: key}, nil
+		}
+		return nil, Lease{}, err
+	}
+
+	lease := Lease{Key: key}
+	if err := json.Unmarshal(current, &lease); err != nil {
+		return nil, Lease{}, fmt.Errorf("key %q does not hold a lease: %v", key, err)
+	}
+	return current, lease, nil
+}
+
+func (e *Engine) swapLease(key string, current []byte, lease Lease) (bool, error) {
+	value, err := json.Marshal(lease)
+	if err != nil {
+		return false, err
+	}
+	return e.CompareAndSwap(key, current, value)
+}
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestLeases(t *testing.T) {
+	e, err := NewEngine(t.TempDir(), *shared.NewEngineConfig())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	first, err := e.AcquireLease("leader", "a", 50*time.Millisecond)
+	if err != nil || first.Token != 1 {
+		t.Fatalf("AcquireLease(a) = %+v, %v, want token 1", first, err)
+	}
+
+	var held *shared.ErrLeaseHeld
+	if _, err := e.AcquireLease("leader", "b", time.Second); !errors.As(err, &held) || held.Owner != "a" {
+		t.Fatalf("AcquireLease(b) error = %v, want the lease held by a", err)
+	}
+
+	// renewing keeps the token
+	renewed, err := e.AcquireLease("leader", "a", 50*time.Millisecond)
+	if err != nil || renewed.Token != 1 || !renewed.ExpiresAt.After(first.ExpiresAt) {
+		t.Fatalf("AcquireLease(a) renewal = %+v, %v, want token 1 and a later expiry", renewed, err)
+	}
+
+	// an expired lease goes to the next owner with a greater token
+	time.Sleep(60 * time.Millisecond)
+	second, err := e.AcquireLease("leader", "b", time.Second)
+	if err != nil || second.Token != 2 {
+		t.Fatalf("AcquireLease(b) after expiry = %+v, %v, want token 2", second, err)
+	}
+	if err := e.ReleaseLease("leader", "a", 1); !errors.As(err, &held) {
+		t.Errorf("ReleaseLease() by the fenced owner error = %v, want the lease held by b", err)
+	}
+
+	if err := e.ReleaseLease("leader", "b", second.Token); err != nil {
+		t.Fatalf("ReleaseLease(b) error: %v", err)
+	}
+	third, err := e.AcquireLease("leader", "a", time.Second)
+	if err != nil || third.Token != 3 {
+		t.Errorf("AcquireLease(a) after release = %+v, %v, want token 3", third, err)
+	}
+}
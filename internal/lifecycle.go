@@ -0,0 +1,76 @@
+package internal
+
+// EngineState is the lifecycle stage of an engine.
+type EngineState uint32
+
+const (
+	StateRecovering EngineState = iota // Loading tables and replaying the WAL.
+	StateWarming                       // Open but still preparing caches, reads are slower than usual.
+	StateServing                       // Accepting reads and writes.
+	StateReadOnly                      // Accepting reads only.
+	StateClosed                        // Closed, every operation fails.
+)
+
+func (s EngineState) String() string {
+	switch s {
+	case StateRecovering:
+		return "recovering"
+	case StateWarming:
+		return "warming"
+	case StateServing:
+		return "serving"
+	case StateReadOnly:
+		return "read-only"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+func (s EngineState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// PendingWork counts the work the engine still has to do in the background.
+type PendingWork struct {
+	MemtableEntries    uint32 `json:"memtable_entries"`     // Writes waiting for the next flush.
+	UnsyncedWALRecords uint64 `json:"unsynced_wal_records"` // WAL records not fsynced yet.
+	TablesToCompact    int    `json:"tables_to_compact"`    // SSTables beyond the compaction threshold.
+	ActiveCompactions  int32  `json:"active_compactions"`   // Compactions running right now.
+}
+
+// StateReport is the lifecycle stage of an engine with its pending work.
+type StateReport struct {
+	State   EngineState `json:"state"`
+	Pending PendingWork `json:"pending"`
+}
+
+// State returns the engine's lifecycle stage.
+func (e *Engine) State() EngineState {
+	return EngineState(e.state.Load())
+}
+
+// StateReport returns the engine's lifecycle stage and pending work,
+// a closed engine reports no pending work.
+func (e *Engine) StateReport() StateReport {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	report := StateReport{State: e.State()}
+	if report.State == StateClosed {
+		return report
+	}
+
+	im := e.indexManager
+	im.mu.RLock()
+	tables := len(im.sstables)
+	im.mu.RUnlock()
+
+	report.Pending = PendingWork{
+		MemtableEntries:    im.memtable.Size(),
+		UnsyncedWALRecords: e.wal.Unsynced(),
+		TablesToCompact:    max(tables-int(e.Config.CompactionThreshold), 0),
+		ActiveCompactions:  im.schedule.active.Load(),
+	}
+	return report
+}
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// distantWindow returns an off-peak window days away from now, deferring the
+// compactions of a test so the work they leave is reported.
+func distantWindow() string {
+	return time.Now().Add(72 * time.Hour).Weekday().String()[:3] + " 00:00-24:00"
+}
+
+func TestStateReport(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithCompactionThreshold(1).WithCompactionWindows(0, distantWindow()))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	for i, key := range []string{"a", "b", "c"} {
+		engine.Set(key, []byte("value"))
+		if i < 2 {
+			engine.indexManager.Flush()
+		}
+	}
+
+	report := engine.StateReport()
+	want := PendingWork{MemtableEntries: 1, UnsyncedWALRecords: 3, TablesToCompact: 1}
+	if report.State != StateServing || report.Pending != want {
+		t.Errorf("StateReport() = %+v, want serving with %+v", report, want)
+	}
+
+	engine.Close()
+	if state := engine.StateReport().State; state != StateClosed {
+		t.Errorf("StateReport() after Close = %v, want closed", state)
+	}
+}
+
+func TestLevelStats(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithCompactionThreshold(1).WithSmallTableMergeSize(0).WithCompactionWindows(0, distantWindow()))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for _, key := range []string{"a", "b"} {
+		engine.Set(key, []byte("value"))
+		engine.indexManager.Flush()
+	}
+
+	stats := engine.Stats()
+	if len(stats.Levels) != 1 || stats.Levels[0].Tables != 2 || stats.Levels[0].Bytes == 0 {
+		t.Fatalf("Stats().Levels = %+v, want level 0 with 2 tables", stats.Levels)
+	}
+	if stats.CompactionDebt != stats.Levels[0].Bytes {
+		t.Errorf("Stats().CompactionDebt = %d, want %d", stats.CompactionDebt, stats.Levels[0].Bytes)
+	}
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// LZ4 block format, see https://github.com/lz4/lz4/blob/dev/doc/lz4_Block_format.md.
+// Every sequence is a token holding the literal length in its high nibble and
+// the match length minus 4 in its low one, lengths of 15 going on in the next
+// bytes, then the literals and a 16-bit match offset. The last sequence only
+// holds literals. The format does not store the decoded length, it is stored
+// first as a uvarint.
+const (
+	lz4MinMatch     = 4
+	lz4LastLiterals = 5  // The last bytes are always literals.
+	lz4MatchLimit   = 12 // No match starts in the last bytes.
+	lz4MaxOffset    = 1<<16 - 1
+	lz4TableBits    = 14
+)
+
+// lz4Encode appends the decoded length and the LZ4 block of src to dst.
+func lz4Encode(dst, src []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+
+	var table [1 << lz4TableBits]int32 // Position+1 of the last 4 bytes hashed to each entry.
+	anchor := 0
+	for i := 0; i < len(src)-lz4MatchLimit; {
+		value := binary.LittleEndian.Uint32(src[i:])
+		h := matchHash(value, lz4TableBits)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if candidate < 0 || i-candidate > lz4MaxOffset || binary.LittleEndian.Uint32(src[candidate:]) != value {
+			i++
+			continue
+		}
+
+		length := lz4MinMatch
+		for i+length < len(src)-lz4LastLiterals && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = lz4Sequence(dst, src[anchor:i], i-candidate, length)
+		i += length
+		anchor = i
+	}
+
+	// the last sequence only holds literals
+	literals := src[anchor:]
+	dst = append(dst, byte(min(len(literals), 15))<<4)
+	dst = lz4Length(dst, len(literals))
+	return append(dst, literals...)
+}
+
+func lz4Sequence(dst, literals []byte, offset, length int) []byte {
+	match := length - lz4MinMatch
+	dst = append(dst, byte(min(len(literals), 15))<<4|byte(min(match, 15)))
+	dst = lz4Length(dst, len(literals))
+	dst = append(dst, literals...)
+	dst = binary.LittleEndian.AppendUint16(dst, uint16(offset))
+	return lz4Length(dst, match)
+}
+
+// lz4Length appends the rest of a length over the 15 held by its token.
+func lz4Length(dst []byte, n int) []byte {
+	if n < 15 {
+		return dst
+	}
+	for n -= 15; n >= 255; n -= 255 {
+		dst = append(dst, 255)
+	}
+	return append(dst, byte(n))
+}
+
+var errLZ4Corrupt = errors.New("lz4: corrupt input")
+
+// lz4Decode decodes src, rejecting data decoding to more than maxSize bytes.
+func lz4Decode(src []byte, maxSize int) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errLZ4Corrupt
+	}
+	if size > uint64(maxSize) {
+		return nil, fmt.Errorf("lz4: decoded length %d is over %d bytes", size, maxSize)
+	}
+	src = src[n:]
+
+	dst := make([]byte, 0, size)
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+
+		literals, next, err := lz4ReadLength(src, i, int(token>>4))
+		if err != nil {
+			return nil, err
+		}
+		i = next
+		if literals > len(src)-i || len(dst)+literals > int(size) {
+			return nil, errLZ4Corrupt
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+
+		// the last sequence ends with its literals
+		if i == len(src) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, errLZ4Corrupt
+		}
+		offset := int(binary.LittleEndian.Uint16(src[i:]))
+		i += 2
+
+		length, next, err := lz4ReadLength(src, i, int(token&0x0F))
+		if err != nil {
+			return nil, err
+		}
+		i = next
+		length += lz4MinMatch
+
+		if offset == 0 || offset > len(dst) || len(dst)+length > int(size) {
+			return nil, errLZ4Corrupt
+		}
+		dst = appendMatch(dst, offset, length)
+	}
+
+	if len(dst) != int(size) {
+		return nil, errLZ4Corrupt
+	}
+	return dst, nil
+}
+
+// lz4ReadLength reads the bytes continuing a length of 15 held by a token.
+func lz4ReadLength(src []byte, i, n int) (int, int, error) {
+	if n != 15 {
+		return n, i, nil
+	}
+	for {
+		if i >= len(src) {
+			return 0, 0, errLZ4Corrupt
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, nil
+		}
+	}
+}
@@ -13,7 +13,7 @@ func populateMemtable(m Memtable, n int) {
 		// though for b.N iterations, keys will repeat within a single benchmark run's setup.
 		// The main point is to have a full memtable for Get/Contains/Items.
 		// For true isolation per benchmark *run*, creating a new memtable is better.
-		m.Set(KVPair{Key: fmt.Sprintf("key%d", i), Value: Position{Offset: uint32(i), Size: uint32(i)}})
+		m.Set(KVPair{Key: fmt.Sprintf("key%d", i), Value: Position{Offset: uint64(i), Size: uint32(i)}})
 	}
 }
 
@@ -100,7 +100,7 @@ func benchmarkMemtable(b *testing.B, newMemtable func() Memtable) {
 			// This benchmarks mixed insert/update depending on key reuse within b.N
 			// If you want pure inserts, ensure keys are globally unique or use a new memtable per 'op'.
 			// For standard bench behavior, this is common.
-			memtable.Set(KVPair{Key: fmt.Sprintf("key%d", i), Value: Position{Offset: uint32(i), Size: uint32(i)}})
+			memtable.Set(KVPair{Key: fmt.Sprintf("key%d", i), Value: Position{Offset: uint64(i), Size: uint32(i)}})
 		}
 	})
 
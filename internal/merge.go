@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+)
+
+// smallTableMinRun is the number of adjacent tiny tables merged even when
+// their key ranges do not overlap.
+const smallTableMinRun = 4
+
+// mergeSmallTables merges the first run of adjacent tiny SSTables worth
+// merging into a single SSTable, long before CompactionThreshold is reached.
+// A table is tiny if it holds at most SmallTableMergeSize pairs, a run is
+// capped at MemtableSizeThreshold pairs in total and is worth merging if its
+// tables' key ranges overlap, so lookups probe several of them, or if it is
+// at least smallTableMinRun tables long. im.mu must be held by the caller.
+func (im *IndexManager) mergeSmallTables() error {
+	limit := im.config.SmallTableMergeSize
+	if limit == 0 || im.compacting {
+		return nil
+	}
+
+	run, total := []*SSTable{}, uint32(0)
+	for i := 0; i <= len(im.sstables); i++ {
+		if i < len(im.sstables) {
+			table := im.sstables[i]
+			size := table.metadata.Size
+			if size <= limit && total+size <= im.config.MemtableSizeThreshold {
+				run, total = append(run, table), total+size
+				continue
+			}
+		}
+
+		if len(run) >= 2 && (len(run) >= smallTableMinRun || overlapping(run)) {
+			return im.mergeTables(run)
+		}
+
+		// the table ending the run may start the next one
+		run, total = []*SSTable{}, 0
+		if i < len(im.sstables) && im.sstables[i].metadata.Size <= limit {
+			run, total = append(run, im.sstables[i]), im.sstables[i].metadata.Size
+		}
+	}
+
+	return nil
+}
+
+// mergeTables replaces adjacent SSTables, newest first, by a single SSTable.
+// The merged table takes the serial of the newest one to keep its place in
+// the lookup order and is named after the range of serials it replaces.
+// Deleted keys are kept since older tables may still hold their values.
+// The tables are small and im.mu is held throughout, the merge does not yield
+// to user operations, which may be waiting on the lock.
+func (im *IndexManager) mergeTables(run []*SSTable) error {
+	sources := []pairSource{}
+	for _, table := range run {
+		source, err := newTableSource(table, "")
+		if err != nil {
+			return fmt.Errorf("IndexManager.mergeTables can not read table %d: %v", table.metadata.Serial, err)
+		}
+		sources = append(sources, source)
+	}
+
+	it := newMergeIterator(sources)
+	it.keepDeleted = true
+
+	total := 0
+	for _, table := range run {
+		total += int(table.metadata.Size)
+	}
+	pairs := getPairs(total)
+	for {
+		pair, ok, err := it.Next()
+		if err != nil {
+			return fmt.Errorf("IndexManager.mergeTables can not merge tables: %v", err)
+		}
+		if !ok {
+			break
+		}
+		if im.purged.erased(pair) {
+			continue
+		}
+		pairs = append(pairs, pair)
+	}
+
+	newest, oldest := run[0].metadata.Serial, run[len(run)-1].metadata.Serial
+	metadata := TableMetadata{
+		Path:    filepath.Join(im.config.Homepath, fmt.Sprintf(im.config.SSTableNamePrefix+"%d-%d", oldest, newest)),
+		IsLevel: false,
+		Format:  currentTableFormat,
+		Size:    uint32(len(pairs)),
+		Serial:  newest,
+		MinKey:  pairs[0].Key,
+		MaxKey:  pairs[len(pairs)-1].Key,
+	}
+
+	merged, err := im.writeTable(metadata, pairs)
+	if err == nil {
+		im.writeAmp.written(pairs, false)
+	}
+	putPairs(pairs)
+	if err != nil {
+		return fmt.Errorf("IndexManager.mergeTables failed to create merged table: %v", err)
+	}
+
+	merging := make(map[*SSTable]struct{}, len(run))
+	for _, table := range run {
+		merging[table] = struct{}{}
+	}
+
+	sstables := []*SSTable{merged}
+	for _, table := range im.sstables {
+		if _, ok := merging[table]; ok {
+			table.retire()
+			continue
+		}
+		sstables = append(sstables, table)
+	}
+	im.sstables = sstables
+	im.sortTablesBySerial()
+
+	if im.debug.Load() {
+		log.Printf("IndexManager merged %d small tables (%d-%d) into one of %d pairs", len(run), oldest, newest, len(pairs))
+	}
+
+	return nil
+}
+
+// dropReplacedTables retires the SSTables left behind by a merge interrupted
+// before they were removed, the merged table named after their serial range
+// holds all of their pairs. im.mu must be held by the caller.
+func (im *IndexManager) dropReplacedTables() {
+	replaced := map[*SSTable]struct{}{}
+	for _, merged := range im.sstables {
+		var oldest, newest uint32
+		name := filepath.Base(merged.metadata.Path)
+		if _, err := fmt.Sscanf(name, im.config.SSTableNamePrefix+"%d-%d", &oldest, &newest); err != nil {
+			continue
+		}
+
+		for _, table := range im.sstables {
+			serial := table.metadata.Serial
+			if table != merged && serial >= oldest && serial <= newest && filepath.Base(table.metadata.Path) != name {
+				replaced[table] = struct{}{}
+			}
+		}
+	}
+
+	if len(replaced) == 0 {
+		return
+	}
+
+	sstables := []*SSTable{}
+	for _, table := range im.sstables {
+		if _, ok := replaced[table]; ok {
+			log.Printf("index manager: removing table %q replaced by an interrupted merge", table.metadata.Path)
+			table.retire()
+			continue
+		}
+		sstables = append(sstables, table)
+	}
+	im.sstables = sstables
+}
+
+// overlapping reports whether the key ranges of any two tables intersect.
+func overlapping(tables []*SSTable) bool {
+	for i, a := range tables {
+		for _, b := range tables[i+1:] {
+			if a.metadata.MinKey <= b.metadata.MaxKey && b.metadata.MinKey <= a.metadata.MaxKey {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// mergeOperandFlag marks the position of a merge operand, it is stored in the
+// highest bit of the size.
+const mergeOperandFlag = 1 << 31
+
+// mergeLinkSize is the size of the previous position stored before an operand.
+const mergeLinkSize = 8 + shared.UintSize
+
+// operand reports whether the position holds a merge operand.
+func (p Position) operand() bool {
+	return p.Size&mergeOperandFlag != 0
+}
+
+// stored returns the position of the record in the data file.
+func (p Position) stored() Position {
+	return Position{Offset: p.Offset, Size: p.Size &^ mergeOperandFlag}
+}
+
+// Merge records operand for key without reading its current value, the
+// configured MergeFn combines the key's operands with the value they were
+// written over when it is read. Operands are appended to the data file as
+// delta records linking to the key's previous position and are collapsed into
+// a plain value when the memtable is flushed.
+func (e *Engine) Merge(key string, operand []byte) error {
+	if err := e.Config.KeyPolicy.Check(key); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.merge(key, operand, 0, false)
+}
+
+// merge applies the operand as a write numbered seq, zero numbers a new write,
+// e.mu must be held by the caller.
+func (e *Engine) merge(key string, operand []byte, seq uint64, settingFromWAL bool) error {
+	if e.Config.ReadOnly {
+		return &shared.ErrReadOnly{Path: e.Config.Homepath}
+	}
+	if e.Config.MergeFn == nil {
+		return fmt.Errorf("engine can not merge %q without a merge function", key)
+	}
+	if len([]byte(key)) > int(e.Config.KeySize) {
+		return &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+	}
+	if len(operand) == 0 {
+		return fmt.Errorf("engine can not merge an empty operand into %q", key)
+	}
+
+	end := e.io.foreground()
+	defer end()
+
+	previous, err := e.indexManager.Get(key)
+	if err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+			return fmt.Errorf("engine can not locate key (%q): %v", key, err)
+		}
+		previous = Position{}
+	}
+
+	record := make([]byte, 0, mergeLinkSize+len(operand))
+	record = binary.LittleEndian.AppendUint64(record, previous.Offset)
+	record = binary.LittleEndian.AppendUint32(record, previous.Size)
+	record = append(record, operand...)
+
+	tenant := e.tenants.of(key)
+	if tenant != nil {
+		if err := tenant.reserve(key, previous, len(record)); err != nil {
+			return err
+		}
+	}
+
+	seq = e.nextSeq(seq)
+	if !settingFromWAL {
+		if err := e.wal.Append(WALEntry{Key: key, Value: operand, Merge: true, Seq: seq}); err != nil {
+			return err
+		}
+	}
+
+	// the previous value stays referenced by the delta record, it is not
+	// released before the operands are collapsed
+	position, err := e.storageManager.Store(key, record)
+	if err != nil {
+		return fmt.Errorf("engine failed to write merge operand (%q, %x): %v", key, operand, err)
+	}
+	position.Size |= mergeOperandFlag
+
+	e.indexManager.Set(KVPair{Key: key, Value: position, Seq: seq})
+	if !settingFromWAL {
+		e.indexManager.writeAmp.ingested(key, len(key)+len(operand))
+	}
+	e.cache.written(key)
+	e.rows.Invalidate(key)
+	if !settingFromWAL {
+		e.feed.publish(Change{Seq: seq, Key: key, Value: operand, Operand: true})
+	}
+
+	if e.indexManager.memtable.Size() >= e.flushThreshold() && !settingFromWAL {
+		end()
+		if err := e.expireCached(); err != nil {
+			return fmt.Errorf("engine can not expire cached keys: %v", err)
+		}
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readValue reads the value of key at position, combining merge operands with
+// the value they were written over.
+func (e *Engine) readValue(key string, position Position) ([]byte, error) {
+	if !position.operand() {
+		return e.storageManager.Retrieve(position)
+	}
+	value, _, err := e.resolveOperands(key, position)
+	return value, err
+}
+
+// resolveOperands follows the delta records from position back to the value
+// the operands were written over, its position is returned as base.
+func (e *Engine) resolveOperands(key string, position Position) (value []byte, base Position, err error) {
+	var operands [][]byte
+	for position.operand() {
+		operand, previous, err := e.readOperand(position)
+		if err != nil {
+			return nil, Position{}, err
+		}
+		operands, position = append(operands, operand), previous
+	}
+	slices.Reverse(operands)
+
+	var existing []byte
+	if position.Size > 0 {
+		if existing, err = e.storageManager.Retrieve(position); err != nil {
+			return nil, Position{}, err
+		}
+	}
+
+	if e.Config.MergeFn == nil {
+		return nil, Position{}, fmt.Errorf("key %q has merge operands but the engine has no merge function", key)
+	}
+	value, err = e.Config.MergeFn(key, existing, operands)
+	if err != nil {
+		return nil, Position{}, fmt.Errorf("merge function failed for %q: %v", key, err)
+	}
+	if len(value) == 0 {
+		return nil, Position{}, fmt.Errorf("merge function returned an empty value for %q", key)
+	}
+	return value, position, nil
+}
+
+// readOperand reads the delta record at position.
+func (e *Engine) readOperand(position Position) (operand []byte, previous Position, err error) {
+	record, err := e.storageManager.Retrieve(position.stored())
+	if err != nil {
+		return nil, Position{}, err
+	}
+	if len(record) < mergeLinkSize {
+		return nil, Position{}, &shared.ErrCorruptRecord{Offset: int64(position.Offset), Reason: "merge operand has no previous position"}
+	}
+
+	previous = Position{
+		Offset: binary.LittleEndian.Uint64(record),
+		Size:   binary.LittleEndian.Uint32(record[8:]),
+	}
+	return record[mergeLinkSize:], previous, nil
+}
+
+// mergeChain returns the positions of the delta records from position back to
+// the value they were written over, whose position is returned as base.
+func (e *Engine) mergeChain(position Position) (chain []Position, base Position, err error) {
+	for position.operand() {
+		_, previous, err := e.readOperand(position)
+		if err != nil {
+			return nil, Position{}, err
+		}
+		chain, position = append(chain, position.stored()), previous
+	}
+	return chain, position, nil
+}
+
+// collapseOperands replaces the merge operands in the memtable by the values
+// they combine into, so flushed tables hold plain values. Operands are kept
+// when no merge function is configured. e.mu must be held by the caller.
+func (e *Engine) collapseOperands() error {
+	if e.Config.MergeFn == nil {
+		return nil
+	}
+
+	for _, pair := range e.indexManager.memtable.Items() {
+		if !pair.Value.operand() {
+			continue
+		}
+
+		value, base, err := e.resolveOperands(pair.Key, pair.Value)
+		if err != nil {
+			return err
+		}
+		position, err := e.storeValue(pair.Key, value)
+		if err != nil {
+			return fmt.Errorf("engine failed to write the merged value of %q: %v", pair.Key, err)
+		}
+
+		if tenant := e.tenants.of(pair.Key); tenant != nil {
+			tenant.release(pair.Key, pair.Value)
+			tenant.unrelease(pair.Key, position)
+		}
+		e.indexManager.Set(KVPair{Key: pair.Key, Value: position, Seq: pair.Seq})
+		e.dedup.release(base)
+		e.discard(pair.Key, pair.Value)
+	}
+	return nil
+}
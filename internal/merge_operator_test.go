@@ -0,0 +1,83 @@
+package internal
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// addCounters sums decimal operands into the existing decimal value.
+func addCounters(key string, existing []byte, operands [][]byte) ([]byte, error) {
+	total := 0
+	if existing != nil {
+		n, err := strconv.Atoi(string(existing))
+		if err != nil {
+			return nil, err
+		}
+		total = n
+	}
+	for _, operand := range operands {
+		n, err := strconv.Atoi(string(operand))
+		if err != nil {
+			return nil, err
+		}
+		total += n
+	}
+	return []byte(strconv.Itoa(total)), nil
+}
+
+func TestMerge(t *testing.T) {
+	home := t.TempDir()
+	config := *shared.NewEngineConfig().WithMemtableSizeThreshold(100).WithMergeFn(addCounters)
+	e, err := NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	e.Set("hits", []byte("10"))
+	for _, operand := range []string{"1", "2", "3"} {
+		if err := e.Merge("hits", []byte(operand)); err != nil {
+			t.Fatalf("Merge() error: %v", err)
+		}
+	}
+	e.Merge("new", []byte("5"))
+
+	if value, err := e.Get("hits"); err != nil || string(value) != "16" {
+		t.Errorf("Get() = %q, %v, want 16", value, err)
+	}
+
+	// operands logged in the WAL are merged again on open
+	e.Close()
+	e, err = NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	e.Merge("hits", []byte("4"))
+	e.mu.Lock()
+	err = e.flush()
+	e.mu.Unlock()
+	if err != nil {
+		t.Fatalf("flush() error: %v", err)
+	}
+
+	want := map[string]string{"hits": "20", "new": "5"}
+	for key, value := range want {
+		position, _ := e.indexManager.Get(key)
+		got, err := e.Get(key)
+		if err != nil || string(got) != value || position.operand() {
+			t.Errorf("Get(%q) = %q, %v after flush, want %s collapsed", key, got, err, value)
+		}
+	}
+
+	plain, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer plain.Close()
+	if err := plain.Merge("k", []byte("1")); err == nil {
+		t.Errorf("Merge() without a merge function succeeded")
+	}
+}
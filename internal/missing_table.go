@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"slices"
+)
+
+// dropMissingTable checks whether a table failing a read lost its file, deleted
+// by hand or by another process, and if so drops it from the index so lookups
+// go on with the remaining tables instead of failing on it. The pairs it held
+// are lost, older versions of them in other tables show through again.
+// It reports whether the table is gone.
+func (im *IndexManager) dropMissingTable(table *SSTable, err error) bool {
+	if !errors.Is(err, fs.ErrNotExist) {
+		if _, statErr := os.Stat(table.metadata.Path); !errors.Is(statErr, fs.ErrNotExist) {
+			return false
+		}
+	}
+
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	// concurrent lookups may find it missing at the same time
+	dropped := false
+	if i := slices.Index(im.sstables, table); i >= 0 {
+		im.sstables = slices.Delete(im.sstables, i, i+1)
+		dropped = true
+	} else if i := slices.Index(im.levels, table); i >= 0 {
+		im.levels = slices.Delete(im.levels, i, i+1)
+		dropped = true
+	}
+	if !dropped {
+		return true
+	}
+
+	im.missingTables.Add(1)
+	log.Printf("index manager: dropped table %q, its file disappeared: %v", table.metadata.Path, err)
+	table.release()
+	return true
+}
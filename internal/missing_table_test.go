@@ -0,0 +1,39 @@
+package internal
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestMissingTableFallback(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithSmallTableMergeSize(0))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	engine.Set("key", []byte("old"))
+	engine.indexManager.Flush()
+	engine.Set("key", []byte("new"))
+	engine.indexManager.Flush()
+
+	// an open file stays readable once deleted, close it too so reads fail
+	newest := engine.indexManager.sstables[0]
+	if err := os.Remove(newest.metadata.Path); err != nil {
+		t.Fatalf("Remove() error: %v", err)
+	}
+	newest.file.Close()
+
+	value, err := engine.Get("key")
+	if err != nil || string(value) != "old" {
+		t.Fatalf("Get(key) = %q, %v, want the value of the remaining table", value, err)
+	}
+	if len(engine.indexManager.sstables) != 1 {
+		t.Errorf("index holds %d tables, want the missing one dropped", len(engine.indexManager.sstables))
+	}
+	if stats := engine.Stats(); stats.MissingTables != 1 {
+		t.Errorf("Stats().MissingTables = %d, want 1", stats.MissingTables)
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// mmapFile is only supported on Linux, other platforms read tables with read calls.
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	return nil, errors.New("memory mapped tables are not supported on this platform")
+}
+
+func munmapFile(data []byte) error {
+	return nil
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+// NegativeCache remembers recently missed keys so repeated lookups of absent
+// keys skip probing every table. Entries are dropped when their key is written,
+// and a miss is only recorded if no write happened while it was being looked up.
+// A capacity of zero disables the cache.
+type NegativeCache struct {
+	capacity int
+	sequence uint64 // Incremented on every write.
+	lru      *list.List
+	entries  map[string]*list.Element
+
+	hits atomic.Uint64
+
+	mu sync.Mutex
+}
+
+func NewNegativeCache(capacity int) *NegativeCache {
+	return &NegativeCache{
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Sequence returns the current write sequence, to be passed to Add.
+func (nc *NegativeCache) Sequence() uint64 {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	return nc.sequence
+}
+
+// Contains reports whether the key is known to be missing.
+func (nc *NegativeCache) Contains(key string) bool {
+	if nc.capacity == 0 {
+		return false
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	elem, ok := nc.entries[key]
+	if ok {
+		nc.lru.MoveToFront(elem)
+		nc.hits.Add(1)
+	}
+	return ok
+}
+
+// Add records a miss observed by a lookup that started at the given sequence.
+func (nc *NegativeCache) Add(key string, sequence uint64) {
+	if nc.capacity == 0 {
+		return
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	// a write since the lookup started may have created the key
+	if sequence != nc.sequence {
+		return
+	}
+
+	if elem, ok := nc.entries[key]; ok {
+		nc.lru.MoveToFront(elem)
+		return
+	}
+
+	if nc.lru.Len() >= nc.capacity {
+		oldest := nc.lru.Back()
+		nc.lru.Remove(oldest)
+		delete(nc.entries, oldest.Value.(string))
+	}
+	nc.entries[key] = nc.lru.PushFront(key)
+}
+
+// Invalidate must be called on every write to key.
+func (nc *NegativeCache) Invalidate(key string) {
+	if nc.capacity == 0 {
+		return
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.sequence++
+	if elem, ok := nc.entries[key]; ok {
+		nc.lru.Remove(elem)
+		delete(nc.entries, key)
+	}
+}
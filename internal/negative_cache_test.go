@@ -0,0 +1,47 @@
+package internal
+
+import "testing"
+
+func TestNegativeCache(t *testing.T) {
+	t.Run("misses are remembered until written", func(t *testing.T) {
+		nc := NewNegativeCache(2)
+		nc.Add("a", nc.Sequence())
+		if !nc.Contains("a") {
+			t.Fatalf("Contains(a) = false after Add")
+		}
+
+		nc.Invalidate("a")
+		if nc.Contains("a") {
+			t.Errorf("Contains(a) = true after Invalidate")
+		}
+	})
+
+	t.Run("misses racing a write are dropped", func(t *testing.T) {
+		nc := NewNegativeCache(2)
+		sequence := nc.Sequence()
+		nc.Invalidate("a")
+		nc.Add("a", sequence)
+		if nc.Contains("a") {
+			t.Errorf("Contains(a) = true for a miss observed before a write")
+		}
+	})
+
+	t.Run("least recently used entries are evicted", func(t *testing.T) {
+		nc := NewNegativeCache(2)
+		nc.Add("a", nc.Sequence())
+		nc.Add("b", nc.Sequence())
+		nc.Contains("a")
+		nc.Add("c", nc.Sequence())
+		if !nc.Contains("a") || nc.Contains("b") || !nc.Contains("c") {
+			t.Errorf("expected b to be evicted")
+		}
+	})
+
+	t.Run("zero capacity disables the cache", func(t *testing.T) {
+		nc := NewNegativeCache(0)
+		nc.Add("a", nc.Sequence())
+		if nc.Contains("a") {
+			t.Errorf("Contains(a) = true with a disabled cache")
+		}
+	})
+}
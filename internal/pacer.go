@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// pacer makes a long read yield between the table blocks it reads: it stops
+// once ctx is done and waits, up to delay, for the user operations in flight.
+// A reader holding a lock hands it to the pacer, which releases it while
+// yielding so the operations it waits for are not blocked on the lock.
+// A nil *pacer never yields.
+type pacer struct {
+	ctx   context.Context
+	io    *ioScheduler
+	delay time.Duration
+	lock  sync.Locker // Held by the reader between blocks, nil if none.
+}
+
+// block yields before the next block is read, it returns the error of ctx
+// once it is done.
+func (p *pacer) block() error {
+	if p == nil {
+		return nil
+	}
+
+	if p.lock != nil {
+		p.lock.Unlock()
+	}
+	p.io.yield(p.delay)
+	if p.lock != nil {
+		p.lock.Lock()
+	}
+	return p.ctx.Err()
+}
+
+// pace makes the table sources of the iterator yield to p between blocks.
+func (it *mergeIterator) pace(p *pacer) {
+	for _, source := range it.sources {
+		if table, ok := source.(*tableSource); ok {
+			table.pacer = p
+		}
+	}
+}
+
+// scanPacer returns the pacer of a scan cancelled with ctx, see
+// Config.ScanNiceness.
+func (e *Engine) scanPacer(ctx context.Context) *pacer {
+	return &pacer{ctx: ctx, io: e.io, delay: e.Config.ScanNiceness}
+}
@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestCompactionYields(t *testing.T) {
+	config := shared.NewEngineConfig().WithMemtableSizeThreshold(100).WithCompactionThreshold(100).WithBackgroundIOMaxDelay(time.Second)
+	config.Homepath = t.TempDir()
+
+	io := newIOScheduler(config.BackgroundIOMaxDelay)
+	im, err := NewIndexManager(config, nopWAL{}, nil, io)
+	if err != nil {
+		t.Fatalf("NewIndexManager() error: %v", err)
+	}
+	defer im.Close()
+
+	for table := range 2 {
+		for i := range 100 {
+			im.Set(KVPair{Key: fmt.Sprintf("key%03d", i), Value: Position{Offset: uint64(table + 1), Size: 1}, Seq: uint64(table*100 + i + 1)})
+		}
+		if err := im.Flush(); err != nil {
+			t.Fatalf("Flush() error: %v", err)
+		}
+	}
+
+	// with a user operation in flight the compaction waits before every
+	// block, without holding the index lock
+	done := io.foreground()
+	compacted := make(chan error)
+	go func() {
+		im.mu.Lock()
+		defer im.mu.Unlock()
+		compacted <- im.compactInto(im.sstables, 1)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if position, err := im.Get("key050"); err != nil || position.Offset != 2 {
+		t.Errorf("Get() = %v, %v during the compaction, want offset 2", position, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Get() took %v during the compaction, want it not to wait for it", elapsed)
+	}
+	select {
+	case err := <-compacted:
+		t.Fatalf("compactInto() = %v before the user operation completed", err)
+	default:
+	}
+
+	done()
+	if err := <-compacted; err != nil {
+		t.Fatalf("compactInto() error: %v", err)
+	}
+	if len(im.sstables) != 0 || len(im.levels) != 1 {
+		t.Errorf("got %d sstables and %d level tables, want 0 and 1", len(im.sstables), len(im.levels))
+	}
+	if io.waits.Load() == 0 {
+		t.Errorf("compaction never yielded")
+	}
+}
+
+func TestScanContext(t *testing.T) {
+	config := shared.NewEngineConfig().WithBlockSize(shared.MinBlockSizeBytes).WithScanNiceness(time.Millisecond)
+	engine, err := NewEngine(t.TempDir(), *config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for i := range 200 {
+		if err := engine.Set(fmt.Sprintf("key%03d", i), []byte("value")); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+	}
+	if err := engine.indexManager.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	// a cancelled scan stops at the next block
+	ctx, cancel := context.WithCancel(context.Background())
+	visited := 0
+	err = engine.RangeContext(ctx, "", "", func(key string, value []byte) (bool, error) {
+		if visited++; visited == 1 {
+			cancel()
+		}
+		return false, nil
+	})
+	if !errors.Is(err, context.Canceled) || visited == 200 {
+		t.Errorf("RangeContext() = %v after %d keys, want it cancelled before the end", err, visited)
+	}
+
+	it := engine.NewIteratorContext(ctx)
+	defer it.Close()
+	for it.Seek(""); it.Valid(); it.Next() {
+	}
+	if !errors.Is(it.Err(), context.Canceled) {
+		t.Errorf("iterator Err() = %v, want it cancelled", it.Err())
+	}
+
+	// a nice scan waits for the lookups in flight
+	done := engine.io.foreground()
+	time.AfterFunc(20*time.Millisecond, done)
+	count := 0
+	if err := engine.Range("", "", func(string, []byte) (bool, error) { count++; return false, nil }); err != nil || count != 200 {
+		t.Errorf("Range() = %d keys, %v, want 200", count, err)
+	}
+	if waits := engine.Stats().BackgroundIOWaits; waits == 0 {
+		t.Errorf("scan never yielded")
+	}
+}
+
+func TestFlushDoesNotYieldToItsWrite(t *testing.T) {
+	// the default delay, the writes flush and compact on their own
+	config := *shared.NewEngineConfig().WithMemtableSizeThreshold(100)
+	engine, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	start, slowest := time.Now(), time.Duration(0)
+	for i := range 5000 {
+		set := time.Now()
+		if err := engine.Set(fmt.Sprintf("key%05d", i), []byte("value")); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+		slowest = max(slowest, time.Since(set))
+	}
+
+	// no other operation ran, nothing had to yield
+	if waits := engine.Stats().BackgroundIOWaits; waits != 0 {
+		t.Errorf("background operations yielded %d times to the writes flushing them", waits)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second || slowest > time.Second {
+		t.Errorf("5000 writes took %v, the slowest %v", elapsed, slowest)
+	}
+}
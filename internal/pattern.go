@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// keyPattern is a glob-style key pattern compiled to a literal prefix, used to
+// narrow the keys considered, and a matcher evaluated on each candidate key.
+// Supported syntax: '*' matches any run of characters, '?' matches a single
+// character, '[...]' matches a character class ('[!...]' negates it) and
+// '\' escapes the next character. A pattern without wildcards is a plain prefix.
+type keyPattern struct {
+	prefix string
+	re     *regexp.Regexp // nil for plain prefixes
+}
+
+func compilePattern(pattern string) (*keyPattern, error) {
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		return &keyPattern{prefix: pattern}, nil
+	}
+
+	prefix := new(strings.Builder)
+	expr := new(strings.Builder)
+	expr.WriteString("^")
+	literal := true // still inside the leading literal part
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch c := runes[i]; c {
+		case '*':
+			literal = false
+			expr.WriteString(".*")
+		case '?':
+			literal = false
+			expr.WriteString(".")
+		case '[':
+			end := i + 1
+			if end < len(runes) && runes[end] == '!' {
+				end++
+			}
+			if end < len(runes) && runes[end] == ']' {
+				end++
+			}
+			for end < len(runes) && runes[end] != ']' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, &shared.ErrInvalidPattern{Pattern: pattern, Reason: "unterminated character class"}
+			}
+
+			class := string(runes[i+1 : end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			literal = false
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = end
+		case '\\':
+			if i+1 >= len(runes) {
+				return nil, &shared.ErrInvalidPattern{Pattern: pattern, Reason: "trailing escape"}
+			}
+			i++
+			fallthrough
+		default:
+			if literal {
+				prefix.WriteRune(runes[i])
+			}
+			expr.WriteString(regexp.QuoteMeta(string(runes[i])))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, &shared.ErrInvalidPattern{Pattern: pattern, Reason: err.Error()}
+	}
+
+	return &keyPattern{prefix: prefix.String(), re: re}, nil
+}
+
+// Match reports whether the key matches the pattern.
+func (p *keyPattern) Match(key string) bool {
+	if !strings.HasPrefix(key, p.prefix) {
+		return false
+	}
+	return p.re == nil || p.re.MatchString(key)
+}
@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"testing"
+)
+
+func TestKeyPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		prefix  string
+		matches []string
+		misses  []string
+	}{
+		{"user:", "user:", []string{"user:", "user:1"}, []string{"use", "admin:user:"}},
+		{"user:*:settings", "user:", []string{"user:1:settings", "user::settings"}, []string{"user:1:profile", "user:1:settings:x"}},
+		{"k?y", "k", []string{"key", "kay"}, []string{"ky", "keey"}},
+		{"log[0-9]", "log", []string{"log1"}, []string{"loga", "log10"}},
+		{"log[!0-9]", "log", []string{"loga"}, []string{"log1"}},
+		{`a\*b*`, "a*b", []string{"a*b", "a*bc"}, []string{"axb"}},
+	}
+
+	for _, tt := range tests {
+		p, err := compilePattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("compilePattern(%q) error: %v", tt.pattern, err)
+		}
+		if p.prefix != tt.prefix {
+			t.Errorf("compilePattern(%q).prefix = %q, want %q", tt.pattern, p.prefix, tt.prefix)
+		}
+		for _, key := range tt.matches {
+			if !p.Match(key) {
+				t.Errorf("pattern %q should match %q", tt.pattern, key)
+			}
+		}
+		for _, key := range tt.misses {
+			if p.Match(key) {
+				t.Errorf("pattern %q should not match %q", tt.pattern, key)
+			}
+		}
+	}
+
+	for _, pattern := range []string{"a[bc", `ab\`} {
+		if _, err := compilePattern(pattern); err == nil {
+			t.Errorf("compilePattern(%q) should fail", pattern)
+		}
+	}
+}
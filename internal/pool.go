@@ -0,0 +1,54 @@
+package internal
+
+import "sync"
+
+// Largest pair slice and encoding buffer kept for reuse, bigger ones are left
+// to the garbage collector so a single huge merge does not pin its memory.
+const (
+	maxPooledPairs  = 1 << 20
+	maxPooledBuffer = 64 << 20
+)
+
+// pairPool and bufferPool hold the pair slices and encoding buffers of
+// finished compactions and flushes, so the next one reuses them instead of
+// growing new ones.
+var (
+	pairPool   sync.Pool // *[]KVPair
+	bufferPool sync.Pool // *[]byte
+)
+
+// getPairs returns an empty pair slice able to hold capacity pairs.
+func getPairs(capacity int) []KVPair {
+	if pooled, ok := pairPool.Get().(*[]KVPair); ok && cap(*pooled) >= capacity {
+		return (*pooled)[:0]
+	}
+	return make([]KVPair, 0, capacity)
+}
+
+// putPairs hands a pair slice no longer referenced back for reuse.
+func putPairs(pairs []KVPair) {
+	if cap(pairs) > maxPooledPairs {
+		return
+	}
+	// pooled slices must not keep the keys alive
+	clear(pairs[:cap(pairs)])
+	pairs = pairs[:0]
+	pairPool.Put(&pairs)
+}
+
+// getBuffer returns an empty encoding buffer.
+func getBuffer() []byte {
+	if pooled, ok := bufferPool.Get().(*[]byte); ok {
+		return (*pooled)[:0]
+	}
+	return nil
+}
+
+// putBuffer hands an encoding buffer no longer referenced back for reuse.
+func putBuffer(buf []byte) {
+	if cap(buf) == 0 || cap(buf) > maxPooledBuffer {
+		return
+	}
+	buf = buf[:0]
+	bufferPool.Put(&buf)
+}
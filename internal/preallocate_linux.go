@@ -0,0 +1,18 @@
+//go:build linux
+
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE, reserving blocks past the end of a
+// file without changing its size.
+const fallocKeepSize = 0x01
+
+// preallocateFile reserves size bytes of disk from offset of file, leaving
+// its size, and so the appends and reads of the file, unchanged.
+func preallocateFile(file *os.File, offset, size int64) error {
+	return syscall.Fallocate(int(file.Fd()), fallocKeepSize, offset, size)
+}
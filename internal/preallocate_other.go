@@ -0,0 +1,14 @@
+//go:build !linux
+
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// preallocateFile is only supported on Linux, other platforms allocate the
+// data file as it is appended to.
+func preallocateFile(file *os.File, offset, size int64) error {
+	return errors.New("preallocation is not supported on this platform")
+}
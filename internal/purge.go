@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// errPurgeStopped ends the scan of a cancelled purge.
+var errPurgeStopped = errors.New("purge stopped")
+
+// PrefixPurge is a background sweep deleting every key under a prefix.
+type PrefixPurge struct {
+	prefix  string
+	deleted atomic.Uint64
+	stop    chan struct{}
+	stopped sync.Once // Closes stop once, however many Cancels race.
+	done    chan struct{}
+	err     error
+}
+
+// PurgePrefix deletes, in the background, every key starting with prefix that
+// exists when it is called, at most ratePerSec keys per second so foreground
+// latency is not disturbed. A rate of zero or less means unthrottled. The keys
+// are read from a snapshot, so the tables it pins are kept until the sweep ends.
+func (e *Engine) PurgePrefix(prefix string, ratePerSec int) (*PrefixPurge, error) {
+	if e.Config.ReadOnly {
+		return nil, &shared.ErrReadOnly{Path: e.Config.Homepath}
+	}
+
+	p := &PrefixPurge{prefix: prefix, stop: make(chan struct{}), done: make(chan struct{})}
+
+	e.purges.Add(1)
+	go func() {
+		defer e.purges.Done()
+		defer close(p.done)
+
+		p.err = p.run(e, ratePerSec)
+		if errors.Is(p.err, errPurgeStopped) {
+			p.err = nil
+		}
+	}()
+
+	return p, nil
+}
+
+func (p *PrefixPurge) run(e *Engine, ratePerSec int) error {
+	start := time.Now()
+	return e.scan(p.prefix, func(pair KVPair) (bool, error) {
+		// pace the deletes to stay under the rate since the sweep started
+		if ratePerSec > 0 {
+			next := start.Add(time.Duration(p.deleted.Load()) * time.Second / time.Duration(ratePerSec))
+			if wait := time.Until(next); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-p.stop:
+					timer.Stop()
+					return false, errPurgeStopped
+				case <-e.closing:
+					timer.Stop()
+					return false, errPurgeStopped
+				}
+			}
+		}
+
+		select {
+		case <-p.stop:
+			return false, errPurgeStopped
+		case <-e.closing:
+			return false, errPurgeStopped
+		default:
+		}
+
+		if err := e.purgeKey(pair.Key); err != nil {
+			return false, fmt.Errorf("purge of prefix %q can not delete %q: %v", p.prefix, pair.Key, err)
+		}
+		p.deleted.Add(1)
+		return false, nil
+	})
+}
+
+// purgeKey deletes the key, flushing the memtable when the tombstones fill it.
+func (e *Engine) purgeKey(key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.delete(key, 0, true); err != nil {
+		return err
+	}
+	if e.indexManager.memtable.Size() >= e.flushThreshold() {
+		return e.flush()
+	}
+	return nil
+}
+
+// Deleted returns the number of keys deleted so far.
+func (p *PrefixPurge) Deleted() uint64 {
+	return p.deleted.Load()
+}
+
+// Done is closed once the sweep has ended.
+func (p *PrefixPurge) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait blocks until the sweep ends and returns the error that stopped it, if any.
+func (p *PrefixPurge) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Cancel stops the sweep and waits for it, the keys deleted so far stay deleted.
+// It may be called several times, concurrently too.
+func (p *PrefixPurge) Cancel() {
+	p.stopped.Do(func() { close(p.stop) })
+	<-p.done
+}
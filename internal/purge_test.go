@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestPurgePrefix(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithMemtableSizeThreshold(10))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for i := range 25 {
+		engine.Set(fmt.Sprintf("tenant1:%02d", i), []byte("value"))
+		engine.Set(fmt.Sprintf("tenant2:%02d", i), []byte("value"))
+	}
+
+	start := time.Now()
+	purge, err := engine.PurgePrefix("tenant1:", 500)
+	if err != nil {
+		t.Fatalf("PurgePrefix() error: %v", err)
+	}
+	if err := purge.Wait(); err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("purge of 25 keys at 500/s took %v, want at least 40ms", elapsed)
+	}
+	if purge.Deleted() != 25 {
+		t.Errorf("Deleted() = %d, want 25", purge.Deleted())
+	}
+
+	keys, _ := engine.Scan("")
+	if len(keys) != 25 || keys[0] != "tenant2:00" {
+		t.Errorf("Scan() after the purge = %v, want only the tenant2 keys", keys)
+	}
+}
+
+func TestPurgePrefixCancel(t *testing.T) {
+	engine, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for i := range 10 {
+		engine.Set(fmt.Sprintf("key%d", i), []byte("value"))
+	}
+
+	// concurrent cancels stop the sweep once
+	purge, _ := engine.PurgePrefix("key", 1)
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			purge.Cancel()
+		}()
+	}
+	wg.Wait()
+	if err := purge.Wait(); err != nil || purge.Deleted() > 1 {
+		t.Errorf("cancelled purge deleted %d keys with error %v, want at most 1 and nil", purge.Deleted(), err)
+	}
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// QueueKeyPrefix starts the keys holding queued messages, a queue's messages
+// are stored under QueueKeyPrefix + name + ":" followed by their sequence.
+const QueueKeyPrefix = "__queue:"
+
+// queueSequenceSize is the width of the hex encoded sequence, fixed so
+// messages sort in enqueue order.
+const queueSequenceSize = 16
+
+// Message is a value dequeued from a Queue, it must be acknowledged by ID
+// once processed.
+type Message struct {
+	ID    uint64
+	Value []byte
+}
+
+// Queue is a durable FIFO of values stored in the engine. Messages are kept
+// until acknowledged, a dequeued message is not delivered again while the
+// engine is open but is redelivered after a restart if it was never acked,
+// giving at-least-once delivery. A queue is safe for concurrent use.
+type Queue struct {
+	engine   *Engine
+	prefix   string
+	mu       sync.Mutex
+	next     uint64              // Sequence of the next enqueued message.
+	cursor   uint64              // Sequence dequeuing resumes from.
+	inflight map[uint64]struct{} // Dequeued messages not acked yet.
+}
+
+// Queue returns the queue with the given name, creating it on first use.
+// Calls with the same name share the queue and its in-flight messages. Names
+// can not contain ':' so the keys of two queues never share a prefix.
+func (e *Engine) Queue(name string) (*Queue, error) {
+	if strings.Contains(name, ":") {
+		return nil, fmt.Errorf("queue name %q can not contain ':'", name)
+	}
+
+	e.queuesMu.Lock()
+	defer e.queuesMu.Unlock()
+
+	if q, ok := e.queues[name]; ok {
+		return q, nil
+	}
+
+	q := &Queue{engine: e, prefix: QueueKeyPrefix + name + ":", inflight: map[uint64]struct{}{}}
+	if len(q.prefix)+queueSequenceSize > int(e.Config.KeySize) {
+		return nil, &shared.ErrKeyTooLong{Key: q.key(0), KeySize: e.Config.KeySize}
+	}
+
+	// the next sequence follows the last message still stored
+	err := e.scan(q.prefix, func(pair KVPair) (bool, error) {
+		sequence, err := q.sequence(pair.Key)
+		if err != nil {
+			return true, err
+		}
+		q.next = sequence + 1
+		return false, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("engine can not open queue %q: %v", name, err)
+	}
+
+	if e.queues == nil {
+		e.queues = map[string]*Queue{}
+	}
+	e.queues[name] = q
+	return q, nil
+}
+
+// Enqueue appends value to the queue and returns its message ID.
+func (q *Queue) Enqueue(value []byte) (uint64, error) {
+	if len(value) == 0 {
+		return 0, fmt.Errorf("queue can not hold empty values")
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := q.next
+	q.engine.mu.Lock()
+	err := q.engine.set(q.key(id), value, 0, false)
+	q.engine.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	q.next++
+	return id, nil
+}
+
+// Dequeue returns the oldest message neither dequeued nor acked, ok is false
+// when there is none.
+func (q *Queue) Dequeue() (msg Message, ok bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	it := q.engine.NewIterator()
+	defer it.Close()
+
+	if !it.Seek(q.key(q.cursor)) || !strings.HasPrefix(it.Key(), q.prefix) {
+		return Message{}, false, it.Err()
+	}
+
+	id, err := q.sequence(it.Key())
+	if err != nil {
+		return Message{}, false, err
+	}
+	value, err := it.Value()
+	if err != nil {
+		return Message{}, false, err
+	}
+
+	q.cursor = id + 1
+	q.inflight[id] = struct{}{}
+	return Message{ID: id, Value: value}, true, nil
+}
+
+// Ack deletes a dequeued message so it is never delivered again.
+func (q *Queue) Ack(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.inflight[id]; !ok {
+		return fmt.Errorf("queue message %d is not in flight", id)
+	}
+	q.engine.mu.Lock()
+	err := q.engine.delete(q.key(id), 0, true)
+	q.engine.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	delete(q.inflight, id)
+	return nil
+}
+
+// Len returns the number of messages not acked yet, including in-flight ones.
+func (q *Queue) Len() (int, error) {
+	count := 0
+	err := q.engine.scan(q.prefix, func(pair KVPair) (bool, error) {
+		count++
+		return false, nil
+	})
+	return count, err
+}
+
+func (q *Queue) key(id uint64) string {
+	return fmt.Sprintf("%s%0*x", q.prefix, queueSequenceSize, id)
+}
+
+func (q *Queue) sequence(key string) (uint64, error) {
+	sequence, err := strconv.ParseUint(strings.TrimPrefix(key, q.prefix), 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("queue key %q has no valid sequence: %v", key, err)
+	}
+	return sequence, nil
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestQueue(t *testing.T) {
+	home := t.TempDir()
+	config := *shared.NewEngineConfig().WithMemtableSizeThreshold(4)
+	e, err := NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	q, err := e.Queue("jobs")
+	if err != nil {
+		t.Fatalf("Queue() error: %v", err)
+	}
+	for i := range 5 {
+		if _, err := q.Enqueue([]byte(fmt.Sprintf("job%d", i))); err != nil {
+			t.Fatalf("Enqueue() error: %v", err)
+		}
+	}
+
+	for i := range 3 {
+		msg, ok, err := q.Dequeue()
+		if err != nil || !ok || string(msg.Value) != fmt.Sprintf("job%d", i) {
+			t.Fatalf("Dequeue() = %+v, %v, %v, want job%d", msg, ok, err, i)
+		}
+		if i != 1 {
+			if err := q.Ack(msg.ID); err != nil {
+				t.Fatalf("Ack(%d) error: %v", msg.ID, err)
+			}
+		}
+	}
+	if err := q.Ack(0); err == nil {
+		t.Errorf("Ack() of an acked message succeeded")
+	}
+	if n, err := q.Len(); err != nil || n != 3 {
+		t.Errorf("Len() = %d, %v, want 3", n, err)
+	}
+	e.Close()
+
+	// the unacked message is delivered again after reopening, before the rest
+	e, err = NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	q, err = e.Queue("jobs")
+	if err != nil {
+		t.Fatalf("Queue() error: %v", err)
+	}
+	id, err := q.Enqueue([]byte("job5"))
+	if err != nil || id != 5 {
+		t.Fatalf("Enqueue() after reopening = %d, %v, want ID 5", id, err)
+	}
+
+	for _, want := range []string{"job1", "job3", "job4", "job5"} {
+		msg, ok, err := q.Dequeue()
+		if err != nil || !ok || string(msg.Value) != want {
+			t.Fatalf("Dequeue() = %+v, %v, %v, want %s", msg, ok, err, want)
+		}
+	}
+	if _, ok, err := q.Dequeue(); ok || err != nil {
+		t.Errorf("Dequeue() of a drained queue = %v, %v", ok, err)
+	}
+}
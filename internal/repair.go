@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync/atomic"
+)
+
+// blockRepairer serves the blocks failing their checksum from an intact copy
+// of their table in a checkpoint, see shared.EngineConfig.RepairSources.
+// Tables are immutable, a checkpoint holding a table of the same name, size
+// and metadata holds the same bytes. The local file is left as is, it may be
+// hard linked into the very checkpoints consulted, so a block is repaired on
+// every read until the table is compacted away.
+type blockRepairer struct {
+	sources []string
+
+	repaired atomic.Uint64 // Blocks served from a checkpoint after failing their checksum.
+	failed   atomic.Uint64 // Blocks no checkpoint had an intact copy of.
+}
+
+func newBlockRepairer(sources []string) *blockRepairer {
+	if len(sources) == 0 {
+		return nil
+	}
+	return &blockRepairer{sources: sources}
+}
+
+// block returns the stored block of size bytes at offset of the table,
+// checksum included, read from the first checkpoint holding an intact copy.
+// A nil repairer finds none.
+func (r *blockRepairer) block(s *SSTable, offset uint32, size int) ([]byte, bool) {
+	if r == nil {
+		return nil, false
+	}
+
+	for _, dir := range r.checkpoints() {
+		path := filepath.Join(dir, filepath.Base(s.metadata.Path))
+		stored, err := s.alternateBlock(path, offset, size)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("repair: %q has no intact copy of block %d of table %q: %v", path, offset, s.metadata.Path, err)
+			}
+			continue
+		}
+
+		r.repaired.Add(1)
+		log.Printf("repair: block %d of table %q failed its checksum, served from %q", offset, s.metadata.Path, path)
+		return stored, true
+	}
+
+	r.failed.Add(1)
+	log.Printf("repair: no checkpoint has an intact copy of block %d of table %q", offset, s.metadata.Path)
+	return nil, false
+}
+
+// stats returns the number of blocks repaired and of those that could not be.
+func (r *blockRepairer) stats() (uint64, uint64) {
+	if r == nil {
+		return 0, 0
+	}
+	return r.repaired.Load(), r.failed.Load()
+}
+
+// checkpoints lists the checkpoint directories of the sources, a source is
+// a checkpoint or a directory of checkpoints searched newest first.
+func (r *blockRepairer) checkpoints() []string {
+	dirs := []string{}
+	for _, source := range r.sources {
+		if _, err := ReadCheckpointManifest(source); err == nil {
+			dirs = append(dirs, source)
+			continue
+		}
+
+		entries, err := os.ReadDir(source)
+		if err != nil {
+			continue
+		}
+		type checkpoint struct {
+			path     string
+			manifest CheckpointManifest
+		}
+		found := []checkpoint{}
+		for _, entry := range entries {
+			path := filepath.Join(source, entry.Name())
+			if manifest, err := ReadCheckpointManifest(path); entry.IsDir() && err == nil {
+				found = append(found, checkpoint{path, manifest})
+			}
+		}
+		slices.SortFunc(found, func(a, b checkpoint) int { return b.manifest.CreatedAt.Compare(a.manifest.CreatedAt) })
+		for _, c := range found {
+			dirs = append(dirs, c.path)
+		}
+	}
+	return dirs
+}
+
+// alternateBlock reads the block of size bytes at offset from the copy of
+// the table at path, which must match the table's size and metadata and hold
+// the block intact.
+func (s *SSTable) alternateBlock(path string, offset uint32, size int) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if own, err := os.Stat(s.metadata.Path); err != nil || own.Size() != info.Size() {
+		return nil, fmt.Errorf("copy has %d bytes, not those of the table", info.Size())
+	}
+
+	metadataSize := int(s.metadata.SerializedSize(s.config))
+	own, err := s.bytesAt(0, metadataSize)
+	if err != nil {
+		return nil, err
+	}
+	other := make([]byte, metadataSize)
+	if _, err := io.ReadFull(file, other); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(own, other) {
+		return nil, fmt.Errorf("copy has other metadata, it is another table")
+	}
+
+	stored := make([]byte, size)
+	if _, err := file.ReadAt(stored, int64(offset)); err != nil {
+		return nil, err
+	}
+	if _, ok := checkBlock(stored, s.metadata.Format); !ok {
+		return nil, fmt.Errorf("block checksum mismatch")
+	}
+	return stored, nil
+}
@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestBlockRepair(t *testing.T) {
+	root := t.TempDir()
+	checkpoints := filepath.Join(root, "checkpoints")
+	os.Mkdir(checkpoints, 0755)
+
+	config := *shared.NewEngineConfig().WithSmallTableMergeSize(0).WithRepairSources(checkpoints)
+	engine, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for i := range 1000 {
+		engine.Set(fmt.Sprintf("key%03d", i), []byte("value"))
+	}
+	if err := engine.Checkpoint(filepath.Join(checkpoints, "1")); err != nil {
+		t.Fatalf("Checkpoint() error: %v", err)
+	}
+	table := engine.indexManager.sstables[0]
+	handle := table.index[0]
+
+	// the checkpoint hard links the table, give it its own copy before the
+	// live one rots
+	backup := filepath.Join(checkpoints, "1", filepath.Base(table.metadata.Path))
+	data, err := os.ReadFile(backup)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	os.Remove(backup)
+	os.WriteFile(backup, data, 0644)
+
+	file, err := os.OpenFile(table.metadata.Path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() error: %v", err)
+	}
+	b := make([]byte, 1)
+	file.ReadAt(b, int64(handle.offset))
+	b[0] ^= 0xFF
+	file.WriteAt(b, int64(handle.offset))
+	file.Close()
+
+	if value, err := engine.Get(handle.firstKey); err != nil || string(value) != "value" {
+		t.Errorf("Get(%q) of a corrupt block = %q, %v, want it served from the checkpoint", handle.firstKey, value, err)
+	}
+	if stats := engine.Stats(); stats.BlockRepairs != 1 || stats.BlockRepairFailures != 0 {
+		t.Errorf("Stats() counts %d repairs and %d failures, want 1 and 0", stats.BlockRepairs, stats.BlockRepairFailures)
+	}
+
+	// without an intact copy the corruption reaches the caller
+	os.RemoveAll(filepath.Join(checkpoints, "1"))
+	var corruption *shared.ErrCorruption
+	if _, err := engine.Get(handle.firstKey); !errors.As(err, &corruption) {
+		t.Errorf("Get(%q) without a checkpoint error = %v, want an ErrCorruption", handle.firstKey, err)
+	}
+	if stats := engine.Stats(); stats.BlockRepairFailures != 1 {
+		t.Errorf("Stats() counts %d repair failures, want 1", stats.BlockRepairFailures)
+	}
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// restoringSuffix names the directory a restore copies the files to before it
+// is renamed to the target.
+const restoringSuffix = ".restoring"
+
+// RestoreEngine restores the checkpoint in backupDir to targetDir, which must
+// not exist, leaving a database NewEngine can open. Every file listed in the
+// manifest is copied and checked against its recorded size and checksum. The
+// files are copied to a temporary directory renamed to targetDir once all of
+// them are verified, so an interrupted or failed restore never leaves a
+// database that looks whole. The backup is never modified.
+func RestoreEngine(backupDir, targetDir string) error {
+	manifest, err := ReadCheckpointManifest(backupDir)
+	if err != nil {
+		return err
+	}
+	if err := checkRestoreManifest(manifest); err != nil {
+		return fmt.Errorf("checkpoint %q can not be restored: %v", backupDir, err)
+	}
+
+	if _, err := os.Stat(targetDir); err == nil {
+		return fmt.Errorf("restore target %q already exists", targetDir)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("can not check restore target %q: %v", targetDir, err)
+	}
+
+	// a previous restore may have been interrupted
+	temp := filepath.Clean(targetDir) + restoringSuffix
+	if err := os.RemoveAll(temp); err != nil {
+		return fmt.Errorf("can not remove the interrupted restore %q: %v", temp, err)
+	}
+	if err := os.Mkdir(temp, 0755); err != nil {
+		return fmt.Errorf("can not create directory %q: %v", temp, err)
+	}
+
+	if err := restoreFiles(backupDir, temp, manifest); err != nil {
+		os.RemoveAll(temp)
+		return err
+	}
+
+	if err := syncDir(temp); err != nil {
+		os.RemoveAll(temp)
+		return fmt.Errorf("can not sync directory %q: %v", temp, err)
+	}
+	if err := os.Rename(temp, targetDir); err != nil {
+		os.RemoveAll(temp)
+		return fmt.Errorf("can not move the restored files to %q: %v", targetDir, err)
+	}
+	return syncDir(filepath.Dir(filepath.Clean(targetDir)))
+}
+
+// checkRestoreManifest verifies that the manifest lists the data file and
+// only plain file names, once each.
+func checkRestoreManifest(manifest CheckpointManifest) error {
+	seen := map[string]bool{}
+	for _, file := range manifest.Files {
+		if file.Name == "" || file.Name != filepath.Base(file.Name) || file.Name == "." || file.Name == ".." {
+			return fmt.Errorf("manifest lists an invalid file name %q", file.Name)
+		}
+		if seen[file.Name] {
+			return fmt.Errorf("manifest lists %q twice", file.Name)
+		}
+		if file.Size < 0 {
+			return fmt.Errorf("manifest records a negative size for %q", file.Name)
+		}
+		seen[file.Name] = true
+	}
+	if !seen[DataFileName] {
+		return fmt.Errorf("manifest does not list the data file %q", DataFileName)
+	}
+	return nil
+}
+
+// restoreFiles copies the files of the manifest from backupDir to dir,
+// checking the copies against the manifest.
+func restoreFiles(backupDir, dir string, manifest CheckpointManifest) error {
+	for _, file := range manifest.Files {
+		copied, err := copyFile(filepath.Join(backupDir, file.Name), filepath.Join(dir, file.Name))
+		if err != nil {
+			return fmt.Errorf("can not restore %q from %q: %v", file.Name, backupDir, err)
+		}
+		if copied.Size != file.Size {
+			return fmt.Errorf("checkpoint %q has %q of %d bytes, its manifest records %d", backupDir, file.Name, copied.Size, file.Size)
+		}
+
+		// checkpoints taken before checksums were recorded are checked by size only
+		if file.Checksum == "" {
+			continue
+		}
+		checksum, err := fileChecksum(filepath.Join(dir, file.Name))
+		if err != nil {
+			return fmt.Errorf("can not checksum the restored %q: %v", file.Name, err)
+		}
+		if checksum != file.Checksum {
+			return fmt.Errorf("checkpoint %q has %q with checksum %s, its manifest records %s", backupDir, file.Name, checksum, file.Checksum)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreEngine(t *testing.T) {
+	root := t.TempDir()
+	os.Mkdir(filepath.Join(root, "db"), 0755)
+	engine, err := NewEngine(filepath.Join(root, "db"))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	for i := range 100 {
+		engine.Set(fmt.Sprintf("key%03d", i), []byte(fmt.Sprintf("value%d", i)))
+	}
+	backup := filepath.Join(root, "backup")
+	if err := engine.Checkpoint(backup); err != nil {
+		t.Fatalf("Checkpoint() error: %v", err)
+	}
+	engine.Close()
+
+	manifest, err := ReadCheckpointManifest(backup)
+	if err != nil {
+		t.Fatalf("ReadCheckpointManifest() error: %v", err)
+	}
+	for _, file := range manifest.Files {
+		if file.Checksum == "" {
+			t.Errorf("manifest records no checksum for %q", file.Name)
+		}
+	}
+
+	target := filepath.Join(root, "restored")
+	if err := RestoreEngine(backup, target); err != nil {
+		t.Fatalf("RestoreEngine() error: %v", err)
+	}
+	if err := RestoreEngine(backup, target); err == nil {
+		t.Errorf("RestoreEngine() over an existing directory succeeded")
+	}
+
+	restored, err := NewEngine(target)
+	if err != nil {
+		t.Fatalf("NewEngine() of the restored database error: %v", err)
+	}
+	if value, err := restored.Get("key042"); err != nil || string(value) != "value42" {
+		t.Errorf("Get(key042) = %q, %v, want value42", value, err)
+	}
+	restored.Close()
+
+	// a backup file flipped on disk fails the restore and leaves nothing behind
+	path := filepath.Join(backup, DataFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	data[len(data)/2] ^= 0xFF
+	os.WriteFile(path, data, 0644)
+
+	target = filepath.Join(root, "corrupt")
+	if err := RestoreEngine(backup, target); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("RestoreEngine() of a corrupt backup error = %v, want a checksum mismatch", err)
+	}
+	for _, dir := range []string{target, target + restoringSuffix} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("failed restore left %q behind: %v", dir, err)
+		}
+	}
+}
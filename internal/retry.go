@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// retrier runs disk operations under the engine's retry policy, retrying
+// transient errors with exponential backoff and counting the retries.
+// A nil retrier runs each operation exactly once.
+type retrier struct {
+	attempts  int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+
+	retries   atomic.Uint64 // Number of retried attempts.
+	exhausted atomic.Uint64 // Number of operations that failed after the last attempt.
+}
+
+func newRetrier(attempts int, baseDelay, maxDelay time.Duration) *retrier {
+	return &retrier{
+		attempts:  max(attempts, 1),
+		baseDelay: baseDelay,
+		maxDelay:  maxDelay,
+	}
+}
+
+// do runs op until it succeeds, fails with a fatal error, or runs out of attempts.
+func (r *retrier) do(op func() error) error {
+	if r == nil {
+		return op()
+	}
+
+	delay := r.baseDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+
+		if attempt >= r.attempts {
+			r.exhausted.Add(1)
+			return err
+		}
+
+		r.retries.Add(1)
+		time.Sleep(delay)
+		delay = min(delay*2, r.maxDelay)
+	}
+}
+
+// isRetryable reports whether err is a transient I/O error worth retrying,
+// everything else is fatal. Syncs are never run through the retrier since a
+// failed fsync may already have dropped the dirty pages.
+func isRetryable(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+
+	switch errno {
+	case syscall.EINTR, syscall.EAGAIN, syscall.EBUSY, syscall.ETIMEDOUT, syscall.ESTALE, syscall.ECONNRESET:
+		return true
+	}
+	return false
+}
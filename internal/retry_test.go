@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestRetrier(t *testing.T) {
+	r := newRetrier(3, 0, 0)
+
+	t.Run("transient errors are retried", func(t *testing.T) {
+		calls := 0
+		err := r.do(func() error {
+			calls++
+			if calls < 3 {
+				return &os.PathError{Op: "read", Path: "x", Err: syscall.EINTR}
+			}
+			return nil
+		})
+		if err != nil || calls != 3 {
+			t.Errorf("do() = %v after %d calls, want nil after 3", err, calls)
+		}
+	})
+
+	t.Run("fatal errors are not retried", func(t *testing.T) {
+		calls := 0
+		err := r.do(func() error {
+			calls++
+			return fmt.Errorf("wrapped: %w", syscall.ENOSPC)
+		})
+		if !errors.Is(err, syscall.ENOSPC) || calls != 1 {
+			t.Errorf("do() = %v after %d calls, want ENOSPC after 1", err, calls)
+		}
+	})
+
+	t.Run("attempts are bounded", func(t *testing.T) {
+		calls := 0
+		err := r.do(func() error {
+			calls++
+			return syscall.EAGAIN
+		})
+		if err == nil || calls != 3 {
+			t.Errorf("do() = %v after %d calls, want an error after 3", err, calls)
+		}
+	})
+
+	if r.retries.Load() != 4 || r.exhausted.Load() != 1 {
+		t.Errorf("retries = %d, exhausted = %d, want 4 and 1", r.retries.Load(), r.exhausted.Load())
+	}
+}
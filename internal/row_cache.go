@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+)
+
+type rowCacheEntry struct {
+	key   string
+	value []byte
+}
+
+// RowCache keeps the values of recently read keys in memory under a byte
+// budget so hot keys are served without touching the index or the data file.
+// Like the NegativeCache, entries are dropped when their key is written and a
+// value is only cached if no write happened while it was being read.
+// A budget of zero disables the cache.
+type RowCache struct {
+	budget       uint64
+	maxValueSize uint32
+	used         uint64
+	sequence     uint64 // Incremented on every write.
+	lru          *list.List
+	entries      map[string]*list.Element
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+
+	mu sync.Mutex
+}
+
+func NewRowCache(budget uint64, maxValueSize uint32) *RowCache {
+	return &RowCache{
+		budget:       budget,
+		maxValueSize: maxValueSize,
+		lru:          list.New(),
+		entries:      make(map[string]*list.Element),
+	}
+}
+
+// Sequence returns the current write sequence, to be passed to Add.
+func (rc *RowCache) Sequence() uint64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.sequence
+}
+
+// Get returns the cached value of key. The returned slice must not be modified.
+func (rc *RowCache) Get(key string) ([]byte, bool) {
+	if rc.budget == 0 {
+		return nil, false
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	elem, ok := rc.entries[key]
+	if !ok {
+		rc.misses.Add(1)
+		return nil, false
+	}
+
+	rc.lru.MoveToFront(elem)
+	rc.hits.Add(1)
+	return elem.Value.(*rowCacheEntry).value, true
+}
+
+// Add caches a value read by a lookup that started at the given sequence.
+// Values larger than the configured maximum are not cached.
+func (rc *RowCache) Add(key string, value []byte, sequence uint64) {
+	size := rowCacheEntrySize(key, value)
+	if rc.budget == 0 || uint32(len(value)) > rc.maxValueSize || size > rc.budget {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	// a write since the lookup started may have changed the value
+	if sequence != rc.sequence {
+		return
+	}
+
+	if _, ok := rc.entries[key]; ok {
+		return
+	}
+
+	for rc.used+size > rc.budget {
+		rc.remove(rc.lru.Back())
+	}
+
+	rc.entries[key] = rc.lru.PushFront(&rowCacheEntry{key: key, value: value})
+	rc.used += size
+}
+
+// Invalidate must be called on every write to key.
+func (rc *RowCache) Invalidate(key string) {
+	if rc.budget == 0 {
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.sequence++
+	if elem, ok := rc.entries[key]; ok {
+		rc.remove(elem)
+	}
+}
+
+// Usage returns the number of bytes held by the cache.
+func (rc *RowCache) Usage() uint64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.used
+}
+
+// remove drops the given entry, rc.mu must be held by the caller.
+func (rc *RowCache) remove(elem *list.Element) {
+	entry := rc.lru.Remove(elem).(*rowCacheEntry)
+	delete(rc.entries, entry.key)
+	rc.used -= rowCacheEntrySize(entry.key, entry.value)
+}
+
+func rowCacheEntrySize(key string, value []byte) uint64 {
+	return uint64(len(key) + len(value))
+}
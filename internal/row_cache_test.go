@@ -0,0 +1,46 @@
+package internal
+
+import "testing"
+
+func TestRowCache(t *testing.T) {
+	t.Run("values are cached until written", func(t *testing.T) {
+		rc := NewRowCache(64, 16)
+		rc.Add("a", []byte("1"), rc.Sequence())
+		if value, ok := rc.Get("a"); !ok || string(value) != "1" {
+			t.Fatalf("Get(a) = %q, %v, want \"1\", true", value, ok)
+		}
+
+		rc.Invalidate("a")
+		if _, ok := rc.Get("a"); ok {
+			t.Errorf("Get(a) hit after Invalidate")
+		}
+	})
+
+	t.Run("values racing a write are dropped", func(t *testing.T) {
+		rc := NewRowCache(64, 16)
+		sequence := rc.Sequence()
+		rc.Invalidate("a")
+		rc.Add("a", []byte("1"), sequence)
+		if _, ok := rc.Get("a"); ok {
+			t.Errorf("Get(a) hit for a value read before a write")
+		}
+	})
+
+	t.Run("budget and value size are enforced", func(t *testing.T) {
+		rc := NewRowCache(8, 4)
+		rc.Add("a", []byte("12345"), rc.Sequence())
+		if _, ok := rc.Get("a"); ok {
+			t.Errorf("Get(a) hit for a value over the size limit")
+		}
+
+		rc.Add("a", []byte("123"), rc.Sequence())
+		rc.Add("b", []byte("123"), rc.Sequence())
+		rc.Add("c", []byte("123"), rc.Sequence())
+		if _, ok := rc.Get("a"); ok {
+			t.Errorf("Get(a) hit, expected it to be evicted")
+		}
+		if rc.Usage() > 8 {
+			t.Errorf("Usage() = %d, want at most 8", rc.Usage())
+		}
+	})
+}
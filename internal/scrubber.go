@@ -0,0 +1,257 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// QuarantineDirName is the directory, inside the home path, corrupt tables are moved to.
+const QuarantineDirName = "quarantine"
+
+// ScrubResult describes a corruption found by the background scrubber.
+type ScrubResult struct {
+	Serial      uint32    `json:"serial"`
+	Path        string    `json:"path"`
+	Err         string    `json:"error"`
+	Quarantined bool      `json:"quarantined"`
+	CheckedAt   time.Time `json:"checked_at"`
+}
+
+// scrubber slowly re-reads every table and the values it points to, using its
+// own file handles so it never moves the read offsets of the live tables.
+type scrubber struct {
+	engine *Engine
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu     sync.Mutex
+	report []ScrubResult
+}
+
+func newScrubber(engine *Engine) *scrubber {
+	return &scrubber{
+		engine: engine,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+func (s *scrubber) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.engine.Config.ScrubInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+
+		report := s.scrub()
+
+		// quarantined tables are no longer scrubbed, keep reporting them
+		s.mu.Lock()
+		for _, result := range s.report {
+			if result.Quarantined {
+				report = append(report, result)
+			}
+		}
+		s.report = report
+		s.mu.Unlock()
+	}
+}
+
+func (s *scrubber) Close() {
+	close(s.stop)
+	<-s.done
+}
+
+// Report returns the corruptions found by the last complete pass
+// and the tables quarantined by earlier passes.
+func (s *scrubber) Report() []ScrubResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]ScrubResult{}, s.report...)
+}
+
+// scrub checks every table once and returns the corrupt ones.
+func (s *scrubber) scrub() []ScrubResult {
+	im := s.engine.indexManager
+	config := s.engine.Config
+
+	snapshot := im.snapshot()
+	defer snapshot.Release()
+	tables := snapshot.tables
+
+	report := []ScrubResult{}
+	for _, table := range tables {
+		select {
+		case <-s.stop:
+			return report
+		default:
+		}
+
+		err := s.checkTable(table)
+		if err == nil {
+			if _, err := im.tightenFilter(table); err != nil {
+				log.Printf("scrubber: %v", err)
+			}
+			continue
+		}
+		if os.IsNotExist(err) {
+			continue // removed by compaction since the table list was taken
+		}
+
+		result := ScrubResult{
+			Serial:    table.metadata.Serial,
+			Path:      table.metadata.Path,
+			Err:       err.Error(),
+			CheckedAt: time.Now(),
+		}
+		log.Printf("scrubber: table %d is corrupt: %v", table.metadata.Serial, err)
+
+		if config.ScrubQuarantine && !config.ReadOnly {
+			if err := im.quarantine(table); err != nil {
+				log.Printf("scrubber: can not quarantine table %d: %v", table.metadata.Serial, err)
+			} else {
+				result.Quarantined = true
+			}
+		}
+
+		report = append(report, result)
+	}
+
+	if s.engine.indexManager.debug.Load() {
+		log.Printf("scrubber: checked %d tables, %d corrupt", len(tables), len(report))
+	}
+
+	return report
+}
+
+// checkTable re-reads the table from disk, compares it with the loaded metadata,
+// checks that its keys are sorted and that every value can be read back.
+func (s *scrubber) checkTable(table *SSTable) error {
+	// open an independent copy of the table with a throwaway filter cache
+	config := s.engine.Config
+	config.ReadOnly = true
+	onDisk, err := deserializeSSTable(TableMetadata{Path: table.metadata.Path}, &config, NewFilterCache(0, false), s.engine.retry)
+	if err != nil {
+		if _, statErr := os.Stat(table.metadata.Path); os.IsNotExist(statErr) {
+			return statErr
+		}
+		return err
+	}
+	defer onDisk.Close()
+
+	if onDisk.metadata.Serial != table.metadata.Serial || onDisk.metadata.Size != table.metadata.Size {
+		return fmt.Errorf("metadata changed on disk: serial %d size %d, loaded serial %d size %d",
+			onDisk.metadata.Serial, onDisk.metadata.Size, table.metadata.Serial, table.metadata.Size)
+	}
+
+	s.engine.io.background()
+	pairs, err := onDisk.Items()
+	if err != nil {
+		return err
+	}
+	s.throttle(len(pairs) * int(config.GetKVPairSize()))
+
+	if len(pairs) > 0 && (pairs[0].Key != onDisk.metadata.MinKey || pairs[len(pairs)-1].Key != onDisk.metadata.MaxKey) {
+		return fmt.Errorf("key range [%q, %q] does not match metadata [%q, %q]",
+			pairs[0].Key, pairs[len(pairs)-1].Key, onDisk.metadata.MinKey, onDisk.metadata.MaxKey)
+	}
+
+	data, err := os.Open(filepath.Join(config.Homepath, DataFileName))
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	info, err := data.Stat()
+	if err != nil {
+		return err
+	}
+
+	for i, pair := range pairs {
+		if i > 0 && pairs[i-1].Key >= pair.Key {
+			return fmt.Errorf("keys out of order at pair %d: %q after %q", i, pair.Key, pairs[i-1].Key)
+		}
+
+		if pair.Value.Size == 0 {
+			continue // deleted key
+		}
+
+		pair.Value = pair.Value.stored()
+		if pair.Value.colocated() {
+			s.engine.io.background()
+			if _, err := s.engine.storageManager.Retrieve(pair.Value); err != nil {
+				return fmt.Errorf("can not read value of key %q: %v", pair.Key, err)
+			}
+			s.throttle(int(pair.Value.Size))
+			continue
+		}
+		if int64(pair.Value.Offset)+int64(pair.Value.Size) > info.Size() {
+			return fmt.Errorf("value of key %q at (%d, %d) is beyond the data file size %d",
+				pair.Key, pair.Value.Offset, pair.Value.Size, info.Size())
+		}
+
+		s.engine.io.background()
+		buf := make([]byte, pair.Value.Size)
+		if _, err := data.ReadAt(buf, int64(pair.Value.Offset)); err != nil {
+			return fmt.Errorf("can not read value of key %q: %v", pair.Key, err)
+		}
+		s.throttle(len(buf))
+	}
+
+	return nil
+}
+
+// throttle sleeps long enough to keep the scrubber under its configured read rate.
+func (s *scrubber) throttle(bytes int) {
+	rate := s.engine.Config.ScrubBytesPerSecond
+	if rate == 0 {
+		return
+	}
+	time.Sleep(time.Duration(float64(bytes) / float64(rate) * float64(time.Second)))
+}
+
+// quarantine removes the table from the read path and moves its file to the quarantine directory.
+func (im *IndexManager) quarantine(table *SSTable) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+
+	removed := false
+	for i, t := range im.sstables {
+		if t == table {
+			im.sstables = append(im.sstables[:i], im.sstables[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	for i, t := range im.levels {
+		if t == table {
+			im.levels = append(im.levels[:i], im.levels[i+1:]...)
+			removed = true
+			break
+		}
+	}
+	if !removed {
+		return nil // already gone, e.g. compacted away
+	}
+
+	// readers still holding the table keep reading the renamed file
+	table.release()
+
+	dir := filepath.Join(im.config.Homepath, QuarantineDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(table.metadata.Path, filepath.Join(dir, filepath.Base(table.metadata.Path)))
+}
@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestScrubQuarantinesCorruptTable(t *testing.T) {
+	dir := t.TempDir()
+	// scrubs are run by hand, a zero interval starts no scrubber
+	engine, err := NewEngine(dir, *shared.NewEngineConfig().WithScrub(0, 0, true).WithSmallTableMergeSize(0))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for i := range 100 {
+		engine.Set(fmt.Sprintf("key%03d", i), []byte("value"))
+	}
+	engine.indexManager.Flush()
+	engine.Set("intact", []byte("value"))
+	engine.indexManager.Flush()
+	scrubber := newScrubber(engine)
+
+	if report := scrubber.scrub(); len(report) != 0 {
+		t.Fatalf("scrub() = %+v on intact tables, want nothing", report)
+	}
+
+	// a restart key, stored whole, in the middle of the first table is damaged
+	corrupt := engine.indexManager.sstables[1]
+	data, err := os.ReadFile(corrupt.metadata.Path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	at := bytes.Index(data, []byte("key048"))
+	if at < 0 {
+		t.Fatalf("key048 not found in the table file")
+	}
+	data[at+3] ^= 0xFF
+	if err := os.WriteFile(corrupt.metadata.Path, data, 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+
+	report := scrubber.scrub()
+	if len(report) != 1 || report[0].Serial != corrupt.metadata.Serial || !report[0].Quarantined {
+		t.Fatalf("scrub() = %+v, want table %d quarantined", report, corrupt.metadata.Serial)
+	}
+	if _, err := os.Stat(filepath.Join(dir, QuarantineDirName, filepath.Base(corrupt.metadata.Path))); err != nil {
+		t.Errorf("corrupt table not moved to the quarantine directory: %v", err)
+	}
+	if _, err := os.Stat(corrupt.metadata.Path); !os.IsNotExist(err) {
+		t.Errorf("corrupt table still in the home path: %v", err)
+	}
+	for _, table := range engine.indexManager.sstables {
+		if table == corrupt {
+			t.Errorf("corrupt table still in the read path")
+		}
+	}
+	if value, err := engine.Get("intact"); err != nil || string(value) != "value" {
+		t.Errorf("Get(intact) = %q, %v, want the intact table still read", value, err)
+	}
+	if report := scrubber.scrub(); len(report) != 0 {
+		t.Errorf("scrub() = %+v after quarantining, want nothing", report)
+	}
+}
+
+func TestScrubThrottle(t *testing.T) {
+	const rate = 100_000
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithScrub(0, rate, false))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for i := range 20 {
+		engine.Set(fmt.Sprintf("key%03d", i), make([]byte, 100))
+	}
+	engine.indexManager.Flush()
+
+	// the pairs and the values they point to are read at the configured rate
+	read := 20*int(engine.Config.GetKVPairSize()) + 20*100
+	want := time.Duration(float64(read) / rate * float64(time.Second))
+	start := time.Now()
+	if report := newScrubber(engine).scrub(); len(report) != 0 {
+		t.Fatalf("scrub() = %+v, want nothing", report)
+	}
+	if elapsed := time.Since(start); elapsed < want {
+		t.Errorf("scrub() read %d bytes in %v, want at least %v at %d bytes per second", read, elapsed, want, rate)
+	}
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"fmt"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// LastSequence returns the sequence number of the latest write. Every write
+// is numbered when it is applied, the numbers are logged in the WAL and kept
+// in the memtable and SSTables, so the most recent of two writes to a key is
+// the one with the highest number wherever they are stored.
+func (e *Engine) LastSequence() uint64 {
+	return e.seq.Load()
+}
+
+// KeySequence returns the sequence number of the latest write of key, see
+// LastSequence, or an ErrKeyNotFound if the key does not exist or expired like
+// Get. Keys written before writes were numbered have none and return zero.
+func (e *Engine) KeySequence(key string) (uint64, error) {
+	if e.cache.expired(key) {
+		return 0, &shared.ErrKeyNotFound{Key: key}
+	}
+
+	snapshot := e.indexManager.snapshot()
+	defer snapshot.Release()
+
+	// deleted keys are skipped by the iterator
+	it, err := snapshot.rangeIterator(key, key+"\x00")
+	if err != nil {
+		return 0, fmt.Errorf("engine can not find the sequence number of %q: %v", key, err)
+	}
+	pair, ok, err := it.Next()
+	if err != nil {
+		return 0, fmt.Errorf("engine can not find the sequence number of %q: %v", key, err)
+	}
+	if !ok || pair.Key != key {
+		return 0, &shared.ErrKeyNotFound{Key: key}
+	}
+	return pair.Seq, nil
+}
+
+// nextSeq returns the sequence number of a write. A write replayed from the
+// WAL keeps its logged number seq, moving the counter past it, zero numbers
+// a new write.
+func (e *Engine) nextSeq(seq uint64) uint64 {
+	if seq == 0 {
+		return e.seq.Add(1)
+	}
+
+	for {
+		last := e.seq.Load()
+		if last >= seq || e.seq.CompareAndSwap(last, seq) {
+			return seq
+		}
+	}
+}
+
+// reserveSeqs numbers n writes applied together and returns the first number.
+func (e *Engine) reserveSeqs(n int) uint64 {
+	return e.seq.Add(uint64(n)) - uint64(n) + 1
+}
+
+// lastSequence returns the highest sequence number held by the tables.
+func (im *IndexManager) lastSequence() uint64 {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	last := uint64(0)
+	for _, tables := range [][]*SSTable{im.sstables, im.levels} {
+		for _, table := range tables {
+			last = max(last, table.metadata.MaxSeq)
+		}
+	}
+	return last
+}
@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestSequenceNumbers(t *testing.T) {
+	dir := t.TempDir()
+	config := *shared.NewEngineConfig().WithSmallTableMergeSize(0)
+	engine, err := NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	engine.Set("a", []byte("1"))
+	engine.Delete("b")
+	batch := engine.WriteBatch()
+	batch.Set("c", []byte("3"))
+	batch.Set("d", []byte("4"))
+	if err := batch.Commit(); err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+	if got := engine.LastSequence(); got != 4 {
+		t.Fatalf("LastSequence() = %d, want 4", got)
+	}
+
+	// flushed writes keep their numbers, the later ones are replayed from the WAL
+	engine.mu.Lock()
+	engine.flush()
+	engine.mu.Unlock()
+	engine.Set("a", []byte("5"))
+	engine.Close()
+
+	engine, err = NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	if got := engine.LastSequence(); got != 5 {
+		t.Errorf("LastSequence() after reopening = %d, want 5", got)
+	}
+	if got := engine.indexManager.sstables[0].metadata.MaxSeq; got != 4 {
+		t.Errorf("table MaxSeq = %d, want 4", got)
+	}
+	for _, pair := range engine.indexManager.memtable.Items() {
+		if pair.Key == "a" && pair.Seq != 5 {
+			t.Errorf("replayed write of %q has sequence number %d, want 5", pair.Key, pair.Seq)
+		}
+	}
+
+	engine.Set("e", []byte("6"))
+	if got := engine.LastSequence(); got != 6 {
+		t.Errorf("LastSequence() after a new write = %d, want 6", got)
+	}
+}
+
+func TestMergeIteratorPrefersHigherSequence(t *testing.T) {
+	newer := []KVPair{{Key: "k", Value: Position{Offset: 1, Size: 1}, Seq: 3}, {Key: "legacy", Value: Position{Offset: 2, Size: 1}}}
+	older := []KVPair{{Key: "k", Value: Position{Offset: 9, Size: 1}, Seq: 7}, {Key: "legacy", Value: Position{Offset: 8, Size: 1}}}
+
+	it := newMergeIterator([]pairSource{newSliceSource(newer, ""), newSliceSource(older, "")})
+	want := []KVPair{older[0], newer[1]}
+	for _, expected := range want {
+		pair, ok, err := it.Next()
+		if err != nil || !ok || pair != expected {
+			t.Fatalf("Next() = %v, %v, %v, want %v", pair, ok, err, expected)
+		}
+	}
+}
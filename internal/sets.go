@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// SetKeyPrefix starts the keys holding set members, a set's members are
+// stored under SetKeyPrefix + name + ":" followed by the member.
+const SetKeyPrefix = "__set:"
+
+// setMemberValue is stored for every member, an empty value would be a deletion.
+var setMemberValue = []byte{1}
+
+// SAdd adds the members to the set name and returns how many were not in it
+// already. The members are written atomically in a single batch.
+func (e *Engine) SAdd(name string, members ...string) (int, error) {
+	return e.updateSet(name, members, true)
+}
+
+// SRem removes the members from the set name and returns how many were in it.
+// The members are removed atomically in a single batch.
+func (e *Engine) SRem(name string, members ...string) (int, error) {
+	return e.updateSet(name, members, false)
+}
+
+// SMembers returns the members of the set name in lexicographic order, read
+// from a snapshot taken when it is called.
+func (e *Engine) SMembers(name string) ([]string, error) {
+	prefix, err := setPrefix(name)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []string{}
+	err = e.scan(prefix, func(pair KVPair) (bool, error) {
+		if !e.cache.expired(pair.Key) {
+			members = append(members, strings.TrimPrefix(pair.Key, prefix))
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("engine can not read set %q: %v", name, err)
+	}
+	return members, nil
+}
+
+// updateSet adds or removes the members that change the set, set operations
+// are serialized so the returned counts are exact.
+func (e *Engine) updateSet(name string, members []string, add bool) (int, error) {
+	prefix, err := setPrefix(name)
+	if err != nil {
+		return 0, err
+	}
+
+	e.collectionsMu.Lock()
+	defer e.collectionsMu.Unlock()
+
+	batch, seen := &Batch{engine: e, internal: true}, map[string]bool{}
+	for _, member := range members {
+		if seen[member] {
+			continue
+		}
+		seen[member] = true
+
+		key := prefix + member
+		if len(key) > int(e.Config.KeySize) {
+			return 0, &shared.ErrKeyTooLong{Key: key, KeySize: e.Config.KeySize}
+		}
+		exists, err := e.Has(key)
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case add && !exists:
+			batch.Set(key, setMemberValue)
+		case !add && exists:
+			batch.Delete(key)
+		}
+	}
+
+	if err := batch.Commit(); err != nil {
+		return 0, err
+	}
+	return batch.Len(), nil
+}
+
+func setPrefix(name string) (string, error) {
+	return collectionPrefix("set", SetKeyPrefix, name)
+}
+
+// collectionPrefix returns the prefix of the keys of a set or hash. Names can
+// not contain ':' so the keys of two collections never share a prefix.
+func collectionPrefix(kind, base, name string) (string, error) {
+	if strings.Contains(name, ":") {
+		return "", fmt.Errorf("%s name %q can not contain ':'", kind, name)
+	}
+	return base + name + ":", nil
+}
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSets(t *testing.T) {
+	e, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	if added, err := e.SAdd("tags", "go", "db", "go"); err != nil || added != 2 {
+		t.Fatalf("SAdd() = %d, %v, want 2", added, err)
+	}
+	if added, err := e.SAdd("tags", "db", "lsm"); err != nil || added != 1 {
+		t.Fatalf("SAdd() = %d, %v, want 1", added, err)
+	}
+	e.SAdd("tagsets", "other")
+
+	if removed, err := e.SRem("tags", "db", "missing"); err != nil || removed != 1 {
+		t.Fatalf("SRem() = %d, %v, want 1", removed, err)
+	}
+
+	members, err := e.SMembers("tags")
+	if want := []string{"go", "lsm"}; err != nil || !slices.Equal(members, want) {
+		t.Errorf("SMembers() = %v, %v, want %v", members, err, want)
+	}
+
+	if _, err := e.SAdd("a:b", "x"); err == nil {
+		t.Errorf("SAdd() accepted a set name containing ':'")
+	}
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hasssanezzz/goldb/internal/filter"
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// Suffixes appended to a table's file name to name its sidecar files. A
+// sidecar holds a copy of the table's bloom filter or block index that takes
+// precedence over the one embedded in the table, so they can be rebuilt or
+// upgraded without rewriting the table, which is never modified.
+const (
+	FilterSidecarSuffix = ".filter"
+	IndexSidecarSuffix  = ".index"
+)
+
+func (s *SSTable) filterSidecarPath() string {
+	return s.metadata.Path + FilterSidecarSuffix
+}
+
+func (s *SSTable) indexSidecarPath() string {
+	return s.metadata.Path + IndexSidecarSuffix
+}
+
+// isSidecar reports whether the file is a sidecar, or one being written,
+// rather than a table.
+func isSidecar(name string) bool {
+	name = strings.TrimSuffix(name, ".tmp")
+	return strings.HasSuffix(name, FilterSidecarSuffix) || strings.HasSuffix(name, IndexSidecarSuffix)
+}
+
+// writeSidecars copies the embedded filter and, for tables made of blocks,
+// the block index of a new table to its sidecar files.
+func (s *SSTable) writeSidecars() error {
+	filter := make([]byte, s.metadata.FilterSize)
+	if err := s.readAt(filter, int64(s.metadata.SerializedSize(s.config))); err != nil {
+		return fmt.Errorf("can not read the filter: %v", err)
+	}
+	if err := writeSidecar(s.filterSidecarPath(), filter); err != nil {
+		return fmt.Errorf("can not write the filter sidecar: %v", err)
+	}
+	s.filterSidecar.Store(true)
+
+	if s.metadata.hasBlocks() {
+		index, err := encodeBlockIndex(s.index)
+		if err != nil {
+			return fmt.Errorf("can not encode the index: %v", err)
+		}
+		if err := writeSidecar(s.indexSidecarPath(), index); err != nil {
+			return fmt.Errorf("can not write the index sidecar: %v", err)
+		}
+	}
+	return nil
+}
+
+// removeSidecars deletes the sidecar files of a removed table.
+func (s *SSTable) removeSidecars() {
+	for _, path := range []string{s.filterSidecarPath(), s.indexSidecarPath()} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("failed to remove sidecar %q of table %d: %v", path, s.metadata.Serial, err)
+		}
+	}
+}
+
+// loadIndex reads the block index from the index sidecar, falling back to
+// the index embedded in the table if the sidecar is missing or broken.
+func (s *SSTable) loadIndex() ([]blockHandle, error) {
+	if data, err := readSidecar(s.indexSidecarPath()); err == nil {
+		index, err := decodeBlockIndex(data)
+		if err == nil {
+			err = s.checkIndex(index)
+		}
+		if err == nil {
+			return index, nil
+		}
+		log.Printf("sstable %q ignores its index sidecar: %v", s.metadata.Path, err)
+	} else if !os.IsNotExist(err) {
+		log.Printf("sstable %q ignores its index sidecar: %v", s.metadata.Path, err)
+	}
+
+	buf := make([]byte, s.metadata.IndexSize)
+	if err := s.readAt(buf, int64(s.metadata.IndexOffset)); err != nil {
+		return nil, fmt.Errorf("failed to read block index of %q: %v", s.metadata.Path, err)
+	}
+
+	index, err := decodeBlockIndex(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode block index of %q: %v", s.metadata.Path, err)
+	}
+	return index, s.checkIndex(index)
+}
+
+// checkIndex verifies that every block of the index lies within the table's blocks.
+func (s *SSTable) checkIndex(index []blockHandle) error {
+	dataOffset := s.metadata.SerializedSize(s.config) + s.metadata.FilterSize
+	for _, handle := range index {
+		if handle.offset < dataOffset || int64(handle.offset)+int64(handle.size) > int64(s.metadata.IndexOffset) {
+			return fmt.Errorf("block index of %q points outside the blocks at %d", s.metadata.Path, handle.offset)
+		}
+		if handle.size > maxStoredBlockSize {
+			return fmt.Errorf("block of %q at %d has %d bytes, more than a block can hold", s.metadata.Path, handle.offset, handle.size)
+		}
+	}
+	return nil
+}
+
+// writeSidecar atomically writes data to path followed by its checksum.
+func writeSidecar(path string, data []byte) error {
+	data = binary.LittleEndian.AppendUint32(data, crc32.Checksum(data, crcTable))
+
+	temp := path + ".tmp"
+	file, err := os.Create(temp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(temp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// readSidecar reads the data written by writeSidecar, checking its checksum.
+func readSidecar(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < shared.UintSize {
+		return nil, fmt.Errorf("sidecar %q of %d bytes is cut short", path, len(data))
+	}
+
+	body, checksum := data[:len(data)-shared.UintSize], binary.LittleEndian.Uint32(data[len(data)-shared.UintSize:])
+	if crc32.Checksum(body, crcTable) != checksum {
+		return nil, fmt.Errorf("sidecar %q does not match its checksum", path)
+	}
+	return body, nil
+}
+
+// readFilterSidecar reads a filter written to a sidecar.
+func readFilterSidecar(path string) (filter.Filter, error) {
+	data, err := readSidecar(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read filter: %v", err)
+	}
+	return filter.Decode(data)
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestSidecarFiles(t *testing.T) {
+	dir := t.TempDir()
+	config := *shared.NewEngineConfig().WithSmallTableMergeSize(0).WithSidecarFiles(true)
+	engine, err := NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	for i := range 50 {
+		engine.Set(fmt.Sprintf("key%02d", i), []byte("value"))
+	}
+	engine.indexManager.Flush()
+	path := engine.indexManager.sstables[0].metadata.Path
+
+	for _, suffix := range []string{FilterSidecarSuffix, IndexSidecarSuffix} {
+		if _, err := os.Stat(path + suffix); err != nil {
+			t.Fatalf("sidecar %q is missing: %v", suffix, err)
+		}
+	}
+
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+	if err := engine.Checkpoint(checkpoint); err != nil {
+		t.Fatalf("Checkpoint() error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(checkpoint, filepath.Base(path)+IndexSidecarSuffix)); err != nil {
+		t.Errorf("checkpoint is missing the index sidecar: %v", err)
+	}
+	engine.Close()
+
+	// broken sidecars are ignored in favor of the copies embedded in the table
+	for _, suffix := range []string{FilterSidecarSuffix, IndexSidecarSuffix} {
+		if err := os.WriteFile(path+suffix, []byte("garbage"), 0644); err != nil {
+			t.Fatalf("WriteFile() error: %v", err)
+		}
+	}
+
+	engine, err = NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() with broken sidecars error: %v", err)
+	}
+	defer engine.Close()
+
+	for _, key := range []string{"key00", "key25", "key49"} {
+		if value, err := engine.Get(key); err != nil || string(value) != "value" {
+			t.Errorf("Get(%q) = %q, %v, want value", key, value, err)
+		}
+	}
+}
@@ -13,6 +13,7 @@ const (
 type skipNode struct {
 	key     string
 	value   Position // Assuming Position is defined elsewhere (e.g., shared/types.go)
+	seq     uint64
 	forward []*skipNode
 }
 
@@ -60,6 +61,20 @@ func (sl *SkipList) Set(pair KVPair) { // Correct signature from Memtable interf
 	sl.mu.Lock()
 	defer sl.mu.Unlock()
 
+	sl.set(pair)
+}
+
+// SetBatch inserts the pairs in order, readers see either none or all of them.
+func (sl *SkipList) SetBatch(pairs []KVPair) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	for _, pair := range pairs {
+		sl.set(pair)
+	}
+}
+
+func (sl *SkipList) set(pair KVPair) {
 	update := make([]*skipNode, MaxLevel)
 	current := sl.header
 
@@ -73,7 +88,7 @@ func (sl *SkipList) Set(pair KVPair) { // Correct signature from Memtable interf
 	current = current.forward[0]
 
 	if current != nil && current.key == pair.Key {
-		current.value = pair.Value
+		current.value, current.seq = pair.Value, pair.Seq
 		return
 	}
 
@@ -89,6 +104,7 @@ func (sl *SkipList) Set(pair KVPair) { // Correct signature from Memtable interf
 	newNode := &skipNode{
 		key:     pair.Key,
 		value:   pair.Value,
+		seq:     pair.Seq,
 		forward: make([]*skipNode, newLevel),
 	}
 
@@ -152,7 +168,7 @@ func (sl *SkipList) Items() []KVPair { // Correct signature from Memtable interf
 
 	current := sl.header.forward[0] // Start from the first actual node
 	for current != nil {
-		items = append(items, KVPair{Key: current.key, Value: current.value})
+		items = append(items, KVPair{Key: current.key, Value: current.value, Seq: current.seq})
 		current = current.forward[0]
 	}
 	return items
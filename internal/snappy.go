@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Snappy block format, see https://github.com/google/snappy/blob/main/format_description.txt.
+// The decoded length is stored first as a uvarint, followed by elements whose
+// tag byte tells their kind in its two lowest bits.
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy1   = 0x01 // 4 to 11 bytes at an 11-bit offset.
+	snappyTagCopy2   = 0x02 // 1 to 64 bytes at a 16-bit offset.
+	snappyTagCopy4   = 0x03 // 1 to 64 bytes at a 32-bit offset.
+
+	// matches are found by hashing 4 bytes into a table of 1<<snappyTableBits positions
+	snappyTableBits = 14
+)
+
+// snappyEncode appends the snappy encoding of src to dst.
+func snappyEncode(dst, src []byte) []byte {
+	dst = binary.AppendUvarint(dst, uint64(len(src)))
+
+	var table [1 << snappyTableBits]int32 // Position+1 of the last 4 bytes hashed to each entry.
+	literal := 0
+	for i := 0; i+4 <= len(src); {
+		value := binary.LittleEndian.Uint32(src[i:])
+		h := matchHash(value, snappyTableBits)
+		candidate := int(table[h]) - 1
+		table[h] = int32(i + 1)
+
+		if candidate < 0 || binary.LittleEndian.Uint32(src[candidate:]) != value {
+			i++
+			continue
+		}
+
+		length := 4
+		for i+length < len(src) && src[candidate+length] == src[i+length] {
+			length++
+		}
+		dst = snappyLiteral(dst, src[literal:i])
+		dst = snappyCopy(dst, i-candidate, length)
+		i += length
+		literal = i
+	}
+
+	return snappyLiteral(dst, src[literal:])
+}
+
+func snappyLiteral(dst, literal []byte) []byte {
+	if len(literal) == 0 {
+		return dst
+	}
+
+	n := uint32(len(literal) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n)<<2|snappyTagLiteral)
+	case n < 1<<8:
+		dst = append(dst, 60<<2|snappyTagLiteral, byte(n))
+	case n < 1<<16:
+		dst = append(dst, 61<<2|snappyTagLiteral, byte(n), byte(n>>8))
+	case n < 1<<24:
+		dst = append(dst, 62<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		dst = append(dst, 63<<2|snappyTagLiteral, byte(n), byte(n>>8), byte(n>>16), byte(n>>24))
+	}
+	return append(dst, literal...)
+}
+
+// snappyCopy appends copies of length bytes found offset bytes back, split
+// into elements of at most 64 bytes.
+func snappyCopy(dst []byte, offset, length int) []byte {
+	for length > 0 {
+		if offset < 1<<11 && length >= 4 && length <= 11 {
+			return append(dst, byte(offset>>8)<<5|byte(length-4)<<2|snappyTagCopy1, byte(offset))
+		}
+
+		chunk := min(length, 64)
+		if offset < 1<<16 {
+			dst = append(dst, byte(chunk-1)<<2|snappyTagCopy2)
+			dst = binary.LittleEndian.AppendUint16(dst, uint16(offset))
+		} else {
+			dst = append(dst, byte(chunk-1)<<2|snappyTagCopy4)
+			dst = binary.LittleEndian.AppendUint32(dst, uint32(offset))
+		}
+		length -= chunk
+	}
+	return dst
+}
+
+var errSnappyCorrupt = errors.New("snappy: corrupt input")
+
+// snappyDecode decodes src, rejecting data decoding to more than maxSize bytes.
+func snappyDecode(src []byte, maxSize int) ([]byte, error) {
+	size, n := binary.Uvarint(src)
+	if n <= 0 {
+		return nil, errSnappyCorrupt
+	}
+	if size > uint64(maxSize) {
+		return nil, fmt.Errorf("snappy: decoded length %d is over %d bytes", size, maxSize)
+	}
+	src = src[n:]
+
+	dst := make([]byte, 0, size)
+	for len(src) > 0 {
+		tag := src[0]
+		var offset, length int
+		switch tag & 0x03 {
+		case snappyTagLiteral:
+			length = int(tag >> 2)
+			src = src[1:]
+			if length >= 60 {
+				extra := length - 59
+				if len(src) < extra {
+					return nil, errSnappyCorrupt
+				}
+				length = 0
+				for i := extra - 1; i >= 0; i-- {
+					length = length<<8 | int(src[i])
+				}
+				src = src[extra:]
+			}
+			length++
+
+			if length > len(src) || len(dst)+length > int(size) {
+				return nil, errSnappyCorrupt
+			}
+			dst = append(dst, src[:length]...)
+			src = src[length:]
+			continue
+
+		case snappyTagCopy1:
+			if len(src) < 2 {
+				return nil, errSnappyCorrupt
+			}
+			length = 4 + int(tag>>2&0x07)
+			offset = int(tag>>5)<<8 | int(src[1])
+			src = src[2:]
+
+		case snappyTagCopy2:
+			if len(src) < 3 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint16(src[1:]))
+			src = src[3:]
+
+		case snappyTagCopy4:
+			if len(src) < 5 {
+				return nil, errSnappyCorrupt
+			}
+			length = 1 + int(tag>>2)
+			offset = int(binary.LittleEndian.Uint32(src[1:]))
+			src = src[5:]
+		}
+
+		if offset <= 0 || offset > len(dst) || len(dst)+length > int(size) {
+			return nil, errSnappyCorrupt
+		}
+		dst = appendMatch(dst, offset, length)
+	}
+
+	if len(dst) != int(size) {
+		return nil, errSnappyCorrupt
+	}
+	return dst, nil
+}
+
+// matchHash hashes 4 bytes into a table of 1<<bits entries.
+func matchHash(value uint32, bits uint) uint32 {
+	return value * 0x1e35a7bd >> (32 - bits)
+}
+
+// appendMatch appends the length bytes found offset bytes back, which may
+// overlap the bytes being appended.
+func appendMatch(dst []byte, offset, length int) []byte {
+	start := len(dst) - offset
+	for i := range length {
+		dst = append(dst, dst[start+i])
+	}
+	return dst
+}
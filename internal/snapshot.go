@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"fmt"
+	"sync"
+)
+
+// indexSnapshot is a consistent view of the index: a copy of the memtable and
+// the tables that were live when it was taken. The snapshot holds a reference
+// to each of its tables, tables replaced by a flush or compaction in the
+// meantime are only closed and removed once the snapshot is released.
+type indexSnapshot struct {
+	memtable []KVPair
+	tables   []*SSTable // SSTables then levels, newest first.
+	budget   uint64     // Bytes of keys Keys collects, see Config.KeysMemoryBudget.
+	once     sync.Once
+}
+
+// snapshot pins the current index, the snapshot must be released once read.
+func (im *IndexManager) snapshot() *indexSnapshot {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	return &indexSnapshot{
+		memtable: im.memtable.Items(),
+		tables:   im.acquireTables(),
+		budget:   im.config.KeysMemoryBudget,
+	}
+}
+
+// Release drops the snapshot's table references, releasing twice has no effect.
+func (s *indexSnapshot) Release() {
+	s.once.Do(func() {
+		releaseTables(s.tables)
+	})
+}
+
+// acquireTables returns the live SSTables followed by the levels, newest first,
+// taking a reference to each. im.mu must be held by the caller.
+func (im *IndexManager) acquireTables() []*SSTable {
+	tables := make([]*SSTable, 0, len(im.sstables)+len(im.levels))
+	tables = append(tables, im.sstables...)
+	tables = append(tables, im.levels...)
+
+	for _, table := range tables {
+		table.acquire()
+	}
+	return tables
+}
+
+func releaseTables(tables []*SSTable) {
+	for _, table := range tables {
+		table.release()
+	}
+}
+
+// Keys returns the live keys of the snapshot, failing with an
+// ErrMemoryBudget once they take more than its budget.
+func (s *indexSnapshot) Keys() ([]string, error) {
+	// Use a map to store unique keys
+	final := make(map[string]struct{})
+	budget := &keyBudget{operation: "Keys", limit: s.budget}
+	var finalMu sync.Mutex // Protects access to 'final'
+	var wg sync.WaitGroup  // Waits for all goroutines to finish
+	var firstError error   // Captures the first error encountered
+	var errMu sync.Mutex   // Protects access to 'firstError'
+
+	for _, table := range s.tables {
+		wg.Add(1)
+		go func(t *SSTable) {
+			defer wg.Done()
+			keys, err := t.Keys()
+			if err != nil {
+				errMu.Lock()
+				if firstError == nil {
+					firstError = fmt.Errorf("can not read keys of table %d: %v", t.metadata.Serial, err)
+				}
+				errMu.Unlock()
+				return
+			}
+			finalMu.Lock()
+			defer finalMu.Unlock()
+			for _, key := range keys {
+				if _, ok := final[key]; ok {
+					continue
+				}
+				if err := budget.add(key); err != nil {
+					errMu.Lock()
+					if firstError == nil {
+						firstError = err
+					}
+					errMu.Unlock()
+					return
+				}
+				final[key] = struct{}{}
+			}
+		}(table)
+	}
+
+	// Wait for all goroutines to complete
+	wg.Wait()
+
+	if firstError != nil {
+		return nil, firstError
+	}
+
+	// Add keys from the memtable
+	for _, pair := range s.memtable {
+		if pair.Value.Size == 0 {
+			delete(final, pair.Key)
+			continue
+		}
+		if _, ok := final[pair.Key]; !ok {
+			if err := budget.add(pair.Key); err != nil {
+				return nil, err
+			}
+		}
+		final[pair.Key] = struct{}{}
+	}
+
+	// Convert the map keys to a slice for the final result
+	results := make([]string, 0, len(final))
+	for key := range final {
+		results = append(results, key)
+	}
+
+	return results, nil
+}
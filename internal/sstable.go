@@ -4,8 +4,14 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
 
+	"github.com/hasssanezzz/goldb/internal/filter"
 	"github.com/hasssanezzz/goldb/shared"
 )
 
@@ -16,28 +22,84 @@ type ReadWriteSeekCloser interface {
 	io.Closer
 }
 
+// Table formats, the format is stored in the table's metadata.
+const (
+	tableFormatFixed       uint8 = 0 // Pairs of fixed width, null padded keys.
+	tableFormatBlocks      uint8 = 1 // Prefix compressed blocks, see blockBuilder.
+	tableFormatSequenced   uint8 = 2 // Blocks whose entries hold their sequence number.
+	tableFormatWide        uint8 = 3 // Sequenced blocks with 64-bit value offsets.
+	tableFormatDelta       uint8 = 4 // Wide blocks with varint delta encoded positions.
+	tableFormatChecksummed uint8 = 5 // Delta blocks followed by the CRC32C of their stored bytes.
+
+	currentTableFormat = tableFormatChecksummed
+)
+
+// fenceInterval is the number of pairs of a fixed format table between two
+// keys held in memory, a lookup reads the pairs between two fences at once.
+const fenceInterval = 64
+
 type TableMetadata struct {
-	Path       string
-	IsLevel    bool
-	Serial     uint32
-	Size       uint32
-	FilterSize uint32
-	MinKey     string
-	MaxKey     string
+	Path        string
+	IsLevel     bool
+	Format      uint8
+	Serial      uint32
+	Size        uint32
+	FilterSize  uint32
+	MinKey      string
+	MaxKey      string
+	IndexOffset uint32 // Location of the block index, blocks formats only.
+	IndexSize   uint32
+	MaxSeq      uint64 // Highest sequence number of the table's pairs, sequenced format only.
+	Level       int    // Level of a level table, from its file name rather than the metadata on disk.
+
+	Compression shared.Compression // Codec of the blocks, blocks formats only.
+}
+
+// hasBlocks reports whether the table is made of blocks with a block index.
+func (tm *TableMetadata) hasBlocks() bool {
+	return tm.Format != tableFormatFixed
 }
 
+// sequenced reports whether the table's pairs hold their sequence number.
+func (tm *TableMetadata) sequenced() bool {
+	return tm.Format >= tableFormatSequenced
+}
+
+// SSTable is a reference counted handle to a table file. The index holds one
+// reference for as long as the table is live, readers and snapshots take their
+// own. A table retired by compaction is only closed and removed from disk once
+// the last reference is released.
 type SSTable struct {
 	metadata TableMetadata
 	config   *shared.EngineConfig
-	bf       *BloomFilter
+	filters  *FilterCache
+	retry    *retrier
+	repair   *blockRepairer // Serves blocks failing their checksum from a checkpoint, nil disables it.
+	values   *valueFile     // Holds the values colocated with the table, nil if there are none.
+	access   *accessLog     // Counts the blocks read by lookups, nil disables it.
 	file     ReadWriteSeekCloser
+	index    []blockHandle // Block index, blocks formats only.
+	fences   []string      // Every fenceInterval-th key, fixed format only.
+	mapped   []byte        // The table file mapped in memory, see Config.MmapTables.
+	// Dictionary of the zstd blocks, stored after the block index up to the
+	// end of the file, empty if the blocks were compressed without one.
+	dictionary []byte
+
+	filterSidecar atomic.Bool // The filter is read from its sidecar file.
+	observed      filterObservations
+
+	refs     atomic.Int32
+	obsolete atomic.Bool // Remove the file when the last reference is released.
 }
 
-func NewSSTable(metadata TableMetadata, config *shared.EngineConfig) (*SSTable, error) {
+func NewSSTable(metadata TableMetadata, config *shared.EngineConfig, filters *FilterCache, retry *retrier) (*SSTable, error) {
 	table := &SSTable{
 		config:   config,
 		metadata: metadata,
+		filters:  filters,
+		retry:    retry,
 	}
+	table.refs.Store(1)
 
 	if err := table.open(); err != nil {
 		return nil, fmt.Errorf("failed to open SST: %v", err)
@@ -47,52 +109,81 @@ func NewSSTable(metadata TableMetadata, config *shared.EngineConfig) (*SSTable,
 }
 
 func (s *SSTable) Keys() ([]string, error) {
-	results := make([]string, 0, s.metadata.Size)
+	pairs, err := s.Items()
+	if err != nil {
+		return nil, err
+	}
 
-	pairSize := int(s.config.GetKVPairSize())
-	if _, err := s.file.Seek(int64(s.config.GetMetadataSize())+int64(s.metadata.FilterSize), io.SeekStart); err != nil {
-		return nil, fmt.Errorf("failed to seek at GetMetadataSize+FilterSize: %v", err)
+	results := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		if pair.Value.Size > 0 {
+			results = append(results, pair.Key)
+		}
 	}
 
-	buffer := make([]byte, pairSize*int(s.metadata.Size))
-	if _, err := s.file.Read(buffer); err != nil {
-		return nil, fmt.Errorf("failed to read from file: %v", err)
+	return results, nil
+}
+
+func (s *SSTable) Items() ([]KVPair, error) {
+	if s.metadata.Format == tableFormatFixed {
+		return s.fixedItems()
 	}
 
-	for i := 0; i < int(s.metadata.Size); i++ {
-		window := buffer[i*pairSize : (i*pairSize)+pairSize]
-		key := window[:shared.KeySize]
-		size := binary.LittleEndian.Uint32(window[shared.KeySize+4 : shared.KeySize+8])
+	if len(s.index) == 0 {
+		return []KVPair{}, nil
+	}
 
-		if size > 0 {
-			results = append(results, shared.TrimPaddedKey(string(key)))
+	// blocks are contiguous, read them all at once, decoded keys are copies
+	// so the buffer is reused once done
+	start := s.index[0].offset
+	var buffer []byte
+	if s.mapped != nil {
+		buffer = s.mapped[start:s.metadata.IndexOffset]
+	} else {
+		buffer = getBuffer()
+		if size := int(s.metadata.IndexOffset - start); cap(buffer) >= size {
+			buffer = buffer[:size]
+		} else {
+			buffer = make([]byte, size)
+		}
+		defer putBuffer(buffer)
+		if err := s.readAt(buffer, int64(start)); err != nil {
+			return nil, fmt.Errorf("failed to read blocks: %v", err)
 		}
 	}
 
+	results := getPairs(int(s.metadata.Size))
+	for _, handle := range s.index {
+		block, err := s.blockData(buffer[handle.offset-start:handle.offset-start+handle.size], handle.offset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress block at %d: %w", handle.offset, err)
+		}
+		pairs, err := decodeBlock(block, s.metadata.Format)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode block at %d: %v", handle.offset, err)
+		}
+		results = append(results, pairs...)
+	}
+
 	return results, nil
 }
 
-func (s *SSTable) Items() ([]KVPair, error) {
+// fixedItems reads the pairs of a table in the fixed width format.
+func (s *SSTable) fixedItems() ([]KVPair, error) {
 	results := make([]KVPair, s.metadata.Size)
 
 	pairSize := s.config.GetKVPairSize()
-	if _, err := s.file.Seek(int64(s.config.GetMetadataSize())+int64(s.metadata.FilterSize), io.SeekStart); err != nil {
-		return nil, fmt.Errorf("failed to seek at GetMetadataSize+FilterSize: %v", err)
-	}
-
 	buffer := make([]byte, pairSize*s.metadata.Size)
-	if _, err := s.file.Read(buffer); err != nil {
-		return nil, fmt.Errorf("failed to read from file: %v", err)
+	if err := s.readAt(buffer, int64(s.config.GetMetadataSize())+int64(s.metadata.FilterSize)); err != nil {
+		return nil, fmt.Errorf("failed to read pairs: %v", err)
 	}
 
 	for i := range s.metadata.Size {
 		window := buffer[i*pairSize : (i*pairSize)+pairSize]
 		key := window[:shared.KeySize]
-		offset := binary.LittleEndian.Uint32(window[shared.KeySize : shared.KeySize+4])
+		offset := uint64(binary.LittleEndian.Uint32(window[shared.KeySize : shared.KeySize+4]))
 		size := binary.LittleEndian.Uint32(window[shared.KeySize+4 : shared.KeySize+8])
 
-		// TODO: should I skip deleted keys?
-
 		results[i] = KVPair{
 			Key:   shared.TrimPaddedKey(string(key)),
 			Value: Position{offset, size},
@@ -103,59 +194,185 @@ func (s *SSTable) Items() ([]KVPair, error) {
 }
 
 func (s *SSTable) Search(key string) (Position, error) {
-	// Range & filter lookup
-	if s.metadata.MinKey > key || s.metadata.MaxKey < key || !s.bf.Test(shared.KeyToBytes(key)) {
-		return Position{}, &shared.ErrKeyNotFound{Key: key}
-	}
+	position, _, err := s.search(key, false)
+	return position, err
+}
 
-	// Binary search
-	left, right := 0, int(s.metadata.Size-1)
-	for left <= right {
-		mid := left + (right-left)/2
-		pair, err := s.nthKey(mid)
-		if err != nil {
-			return Position{}, fmt.Errorf("sstable %q can not perform bsearch gettting the %dth key: %v", s.metadata.Path, mid, err)
+// search looks up the key and reports how the table was consulted, the probe
+// is timed only if timed is set, for the lookups being traced.
+func (s *SSTable) search(key string, timed bool) (_ Position, probe TableProbe, err error) {
+	var start time.Time
+	if timed {
+		start = time.Now()
+	}
+	probe = TableProbe{Serial: s.metadata.Serial, IsLevel: s.metadata.IsLevel}
+	defer func() {
+		if timed {
+			probe.Duration = time.Since(start)
 		}
+		if _, ok := err.(*shared.ErrKeyNotFound); ok {
+			s.observed.observe(probe)
+		}
+	}()
 
-		if pair.Key < key {
-			left = mid + 1
-		} else if pair.Key > key {
-			right = mid - 1
-		} else {
-			if pair.Value.Size == 0 {
-				return Position{}, &shared.ErrKeyRemoved{Key: key}
-			} else {
-				return pair.Value, nil
-			}
+	// Range lookup
+	if s.metadata.MinKey > key || s.metadata.MaxKey < key {
+		probe.OutOfRange = true
+		return Position{}, probe, &shared.ErrKeyNotFound{Key: key}
+	}
+
+	// Filter lookup, skipped if the filter does not fit in the memory budget
+	if bf := s.filters.Get(s); bf != nil {
+		probe.FilterLoaded = true
+		probe.FilterPassed = bf.Test(filterKey(key))
+		if !probe.FilterPassed {
+			return Position{}, probe, &shared.ErrKeyNotFound{Key: key}
 		}
 	}
 
-	return Position{}, &shared.ErrKeyNotFound{Key: key}
+	if s.metadata.hasBlocks() {
+		return s.searchBlocks(key, probe)
+	}
+
+	return s.searchFixed(key, probe)
 }
 
-func (s *SSTable) Serialize(pairs []KVPair) error {
-	// Create the filter
-	s.bf = NewBloomFilter(int(s.metadata.Size), 0.01)
+// searchFixed reads the pairs between the two fences around the key and
+// binary searches them in memory.
+func (s *SSTable) searchFixed(key string, probe TableProbe) (Position, TableProbe, error) {
+	i := sort.Search(len(s.fences), func(i int) bool { return s.fences[i] > key }) - 1
+	if i < 0 {
+		return Position{}, probe, &shared.ErrKeyNotFound{Key: key}
+	}
 
-	// Feed the filter
-	for _, pair := range pairs {
-		s.bf.Add(shared.KeyToBytes(pair.Key))
+	start, end := i*fenceInterval, min((i+1)*fenceInterval, int(s.metadata.Size))
+	pairSize := int(s.config.GetKVPairSize())
+	probe.Seeks++
+	probe.BytesRead += (end - start) * pairSize
+	s.access.read(s.metadata.Path, s.fixedPairOffset(start), (end-start)*pairSize)
+	buffer, err := s.bytesAt(s.fixedPairOffset(start), (end-start)*pairSize)
+	if err != nil {
+		return Position{}, probe, fmt.Errorf("sstable %q can not read pairs %d to %d: %v", s.metadata.Path, start, end, err)
+	}
+
+	j := sort.Search(end-start, func(j int) bool {
+		return s.decodeFixedPair(buffer[j*pairSize:]).Key >= key
+	})
+	if j == end-start {
+		return Position{}, probe, &shared.ErrKeyNotFound{Key: key}
+	}
+	pair := s.decodeFixedPair(buffer[j*pairSize:])
+	if pair.Key != key {
+		return Position{}, probe, &shared.ErrKeyNotFound{Key: key}
 	}
-	filterBytes := s.bf.ToBytes()
+
+	probe.Found = true
+	if pair.Value.Size == 0 {
+		return Position{}, probe, &shared.ErrKeyRemoved{Key: key}
+	}
+	return pair.Value, probe, nil
+}
+
+// searchBlocks finds the only block that may hold the key and searches it.
+func (s *SSTable) searchBlocks(key string, probe TableProbe) (Position, TableProbe, error) {
+	i := sort.Search(len(s.index), func(i int) bool { return s.index[i].firstKey > key }) - 1
+	if i < 0 {
+		return Position{}, probe, &shared.ErrKeyNotFound{Key: key}
+	}
+
+	handle := s.index[i]
+	probe.Seeks++
+	probe.BytesRead += int(handle.size)
+	s.access.read(s.metadata.Path, int64(handle.offset), int(handle.size))
+	block, err := s.bytesAt(int64(handle.offset), int(handle.size))
+	if err != nil {
+		return Position{}, probe, fmt.Errorf("sstable %q can not read block at %d: %v", s.metadata.Path, handle.offset, err)
+	}
+
+	block, err = s.blockData(block, handle.offset)
+	if err != nil {
+		return Position{}, probe, fmt.Errorf("sstable %q can not decompress block at %d: %w", s.metadata.Path, handle.offset, err)
+	}
+	position, found, err := searchBlock(block, key, s.metadata.Format)
+	if err != nil {
+		return Position{}, probe, fmt.Errorf("sstable %q can not search block at %d: %v", s.metadata.Path, handle.offset, err)
+	}
+	if !found {
+		return Position{}, probe, &shared.ErrKeyNotFound{Key: key}
+	}
+
+	probe.Found = true
+	if position.Size == 0 {
+		return Position{}, probe, &shared.ErrKeyRemoved{Key: key}
+	}
+	return position, probe, nil
+}
+
+func (s *SSTable) Serialize(pairs []KVPair) error {
+	// Build the filter
+	keys := make([]string, len(pairs))
+	for i, pair := range pairs {
+		keys[i] = pair.Key
+	}
+	bf := buildFilter(s.config, keys, filterRate(s.config))
+	filterBytes := bf.ToBytes()
 
 	// Update the metadata with the filter's size
 	s.metadata.FilterSize = uint32(len(filterBytes))
 
+	if s.metadata.sequenced() {
+		for _, pair := range pairs {
+			s.metadata.MaxSeq = max(s.metadata.MaxSeq, pair.Seq)
+		}
+	}
+
+	// Encode the pairs, the blocks start right after the filter
+	var data []byte
+	if s.metadata.hasBlocks() {
+		s.metadata.Compression = s.config.BlockCompression
+		// levels are large enough to pay for a dictionary
+		if size := int(s.config.BlockDictionarySize); s.metadata.IsLevel && s.metadata.Compression == shared.CompressionZstd && size > 0 {
+			s.dictionary = trainDictionary(dictionarySamples(pairs, size), size)
+		}
+
+		dataOffset := s.metadata.SerializedSize(s.config) + s.metadata.FilterSize
+		blocks, index := buildBlocks(getBuffer(), pairs, dataOffset, int(s.config.BlockSizeBytes), int(s.config.RestartInterval), s.metadata.Format, s.metadata.Compression, s.dictionary)
+		indexBytes, err := encodeBlockIndex(index)
+		if err != nil {
+			putBuffer(blocks)
+			return fmt.Errorf("SSTable[%d] failed to encode its block index: %v", s.metadata.Serial, err)
+		}
+
+		s.metadata.IndexOffset = dataOffset + uint32(len(blocks))
+		s.metadata.IndexSize = uint32(len(indexBytes))
+		s.index = index
+		data = append(append(blocks, indexBytes...), s.dictionary...)
+		defer func() { putBuffer(data) }()
+	} else {
+		var err error
+		if data, err = serializePairs(pairs); err != nil {
+			return fmt.Errorf("SSTable[%d] failed to encode its pairs: %v", s.metadata.Serial, err)
+		}
+		for i := 0; i < len(pairs); i += fenceInterval {
+			s.fences = append(s.fences, pairs[i].Key)
+		}
+	}
+
 	// Write serialized metadata & filter bytes
-	if _, err := s.file.Write(append(s.metadata.Serialize(), filterBytes...)); err != nil {
+	metadata, err := s.metadata.Serialize()
+	if err != nil {
+		return fmt.Errorf("SSTable[%d] failed to encode its metadata: %v", s.metadata.Serial, err)
+	}
+	if _, err := s.file.Write(append(metadata, filterBytes...)); err != nil {
 		return fmt.Errorf("SSTable[%d] failed to write metadata & filter: %v", s.metadata.Serial, err)
 	}
 
 	// Write the serialized pairs
-	if _, err := s.file.Write(serializePairs(pairs)); err != nil {
+	if _, err := s.file.Write(data); err != nil {
 		return fmt.Errorf("SSTable[%d] failed to write pairs of length %d: %v", s.metadata.Serial, len(pairs), err)
 	}
 
+	s.filters.Put(s, bf)
 	return nil
 }
 
@@ -165,61 +382,248 @@ func (s *SSTable) Deserialize() error {
 		return fmt.Errorf("failed to open SST %q: %v", s.metadata.Path, err)
 	}
 
-	// Create a filter
-	s.bf = NewBloomFilter(int(s.metadata.Size), 0.01)
+	// lengths read from disk are checked against the file before allocating
+	fileSize, err := s.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to open SST %q: %v", s.metadata.Path, err)
+	}
+	if err := s.metadata.checkBounds(s.config, fileSize); err != nil {
+		return fmt.Errorf("failed to open SST %q: %v", s.metadata.Path, err)
+	}
+
+	if s.metadata.Compression == shared.CompressionZstd {
+		if err := s.loadDictionary(fileSize); err != nil {
+			return fmt.Errorf("failed to open SST %q: %v", s.metadata.Path, err)
+		}
+	}
 
-	// Read the filter
-	buf := make([]byte, s.metadata.FilterSize)
-	if _, err := s.file.Read(buf); err != nil {
+	// Read the filter, a rebuilt filter replaces the one embedded in the table.
+	// A broken filter is rebuilt once the tables are loaded if asked to, the
+	// table is searched without it meanwhile
+	if _, err := os.Stat(s.filterSidecarPath()); err == nil {
+		s.filterSidecar.Store(true)
+	}
+	bf, err := s.loadFilter()
+	if err != nil && !s.config.RebuildFilters {
+		return err
+	}
+
+	// Read the block index, from its sidecar if there is one, or the fences
+	if s.metadata.hasBlocks() {
+		index, err := s.loadIndex()
+		if err != nil {
+			return err
+		}
+		s.index = index
+	} else if err := s.loadFences(); err != nil {
 		return err
 	}
 
-	return s.bf.FromBytes(buf)
+	if bf != nil {
+		s.filters.Put(s, bf)
+	}
+	s.mapFile()
+	return nil
+}
+
+// loadDictionary reads the dictionary following the block index.
+func (s *SSTable) loadDictionary(fileSize int64) error {
+	start := int64(s.metadata.IndexOffset) + int64(s.metadata.IndexSize)
+	if fileSize-start > shared.MaxDictionarySize {
+		return fmt.Errorf("dictionary of %d bytes is over %d bytes", fileSize-start, shared.MaxDictionarySize)
+	}
+	if fileSize == start {
+		return nil
+	}
+
+	s.dictionary = make([]byte, fileSize-start)
+	if err := s.readAt(s.dictionary, start); err != nil {
+		return fmt.Errorf("failed to read the dictionary: %v", err)
+	}
+	return nil
 }
 
 func (s *SSTable) Close() error {
+	s.filters.Remove(s)
+	if s.mapped != nil {
+		if err := munmapFile(s.mapped); err != nil {
+			log.Printf("failed to unmap table %d: %v", s.metadata.Serial, err)
+		}
+		s.mapped = nil
+	}
 	return s.file.Close()
 }
 
-func (s *SSTable) nthKey(n int) (KVPair, error) {
-	position := int64(int(s.config.GetMetadataSize()) + int(s.metadata.FilterSize) + n*int(s.config.GetKVPairSize()))
-	_, err := s.file.Seek(position, io.SeekStart)
-	if err != nil {
-		return KVPair{}, fmt.Errorf("sstable %q can not seek position %d: %v", s.metadata.Path, position, err)
+// acquire takes a reference to the table, it must be paired with release.
+func (s *SSTable) acquire() {
+	s.refs.Add(1)
+}
+
+// release drops a reference, closing the table once none are left and
+// removing its file if it was retired.
+func (s *SSTable) release() {
+	if s.refs.Add(-1) != 0 {
+		return
 	}
 
-	keyBuffer := make([]byte, s.config.KeySize)
-	numberBuffer := make([]byte, shared.UintSize)
+	s.Close() // TODO handle closing errors
+	if s.obsolete.Load() && !s.config.ReadOnly {
+		if err := os.Remove(s.metadata.Path); err != nil {
+			log.Printf("failed to remove table %d: %v", s.metadata.Serial, err)
+		}
+		s.removeSidecars()
+		s.values.drop()
+	}
+}
 
-	// read key string
-	_, err = s.file.Read(keyBuffer)
-	if err != nil {
-		return KVPair{}, err
+// retire drops the index's reference to a table that is no longer live,
+// its file is removed once the readers still holding it are done.
+func (s *SSTable) retire() {
+	s.obsolete.Store(true)
+	s.release()
+}
+
+// sync makes the table file and its directory entry durable.
+func (s *SSTable) sync() error {
+	if file, ok := s.file.(*os.File); ok {
+		if err := file.Sync(); err != nil {
+			return err
+		}
 	}
+	return syncDir(filepath.Dir(s.metadata.Path))
+}
 
-	_, err = s.file.Read(numberBuffer)
-	if err != nil {
-		return KVPair{}, err
+// loadFilter reads the table's filter from disk, used when
+// the filter was evicted from the filter cache.
+func (s *SSTable) loadFilter() (filter.Filter, error) {
+	if s.filterSidecar.Load() {
+		bf, err := readFilterSidecar(s.filterSidecarPath())
+		if err == nil {
+			return bf, nil
+		}
+		log.Printf("sstable %q falls back to its embedded filter: %v", s.metadata.Path, err)
 	}
-	offset := binary.LittleEndian.Uint32(numberBuffer)
 
-	_, err = s.file.Read(numberBuffer)
-	if err != nil {
-		return KVPair{}, err
+	buf := make([]byte, s.metadata.FilterSize)
+	if err := s.readAt(buf, int64(s.metadata.SerializedSize(s.config))); err != nil {
+		return nil, fmt.Errorf("sstable %q can not read filter: %v", s.metadata.Path, err)
+	}
+
+	return filter.Decode(buf)
+}
+
+// loadFences reads every fenceInterval-th key of a fixed format table.
+func (s *SSTable) loadFences() error {
+	fences := make([]string, 0, (int(s.metadata.Size)+fenceInterval-1)/fenceInterval)
+	for n := 0; n < int(s.metadata.Size); n += fenceInterval {
+		pair, err := s.nthKey(n)
+		if err != nil {
+			return fmt.Errorf("failed to read the fences of %q: %v", s.metadata.Path, err)
+		}
+		fences = append(fences, pair.Key)
+	}
+	s.fences = fences
+	return nil
+}
+
+func (s *SSTable) nthKey(n int) (KVPair, error) {
+	position := s.fixedPairOffset(n)
+
+	buffer := make([]byte, s.config.GetKVPairSize())
+	if err := s.readAt(buffer, position); err != nil {
+		return KVPair{}, fmt.Errorf("sstable %q can not read position %d: %v", s.metadata.Path, position, err)
 	}
-	size := binary.LittleEndian.Uint32(numberBuffer)
+	return s.decodeFixedPair(buffer), nil
+}
+
+// fixedPairOffset returns the offset of the nth pair of a fixed format table.
+func (s *SSTable) fixedPairOffset(n int) int64 {
+	return int64(int(s.config.GetMetadataSize()) + int(s.metadata.FilterSize) + n*int(s.config.GetKVPairSize()))
+}
 
+// decodeFixedPair decodes the pair of a fixed format table at the start of buffer.
+func (s *SSTable) decodeFixedPair(buffer []byte) KVPair {
+	keySize := shared.KeySize
 	return KVPair{
-		Key: shared.TrimPaddedKey(string(keyBuffer)),
+		Key: shared.TrimPaddedKey(string(buffer[:keySize])),
 		Value: Position{
-			Offset: offset,
-			Size:   size,
+			Offset: uint64(binary.LittleEndian.Uint32(buffer[keySize : keySize+shared.UintSize])),
+			Size:   binary.LittleEndian.Uint32(buffer[keySize+shared.UintSize:]),
 		},
-	}, nil
+	}
+}
+
+// readAt fills buf from the given file offset, retrying transient errors.
+// Table files are read with positional reads so lookups and snapshot
+// enumerations can read the same table concurrently.
+func (s *SSTable) readAt(buf []byte, offset int64) error {
+	if s.mapped != nil {
+		if offset < 0 || offset+int64(len(buf)) > int64(len(s.mapped)) {
+			return fmt.Errorf("read of %d bytes at %d past the end of the table: %w", len(buf), offset, io.ErrUnexpectedEOF)
+		}
+		copy(buf, s.mapped[offset:])
+		return nil
+	}
+
+	return s.retry.do(func() error {
+		if reader, ok := s.file.(io.ReaderAt); ok {
+			_, err := reader.ReadAt(buf, offset)
+			return err
+		}
+
+		if _, err := s.file.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.ReadFull(s.file, buf)
+		return err
+	})
+}
+
+// bytesAt returns size bytes of the table from the given offset, a slice of
+// the mapping when the table is mapped, which must not be modified.
+func (s *SSTable) bytesAt(offset int64, size int) ([]byte, error) {
+	if s.mapped != nil && offset >= 0 && offset+int64(size) <= int64(len(s.mapped)) {
+		return s.mapped[offset : offset+int64(size)], nil
+	}
+
+	buf := make([]byte, size)
+	if err := s.readAt(buf, offset); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// mapFile maps the complete table file in memory if Config.MmapTables is
+// set, the table keeps being read with read calls if it can not be mapped.
+func (s *SSTable) mapFile() {
+	file, ok := s.file.(*os.File)
+	if !s.config.MmapTables || !ok {
+		return
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return
+	}
+	mapped, err := mmapFile(file, info.Size())
+	if err != nil {
+		log.Printf("sstable %q is read without a memory mapping: %v", s.metadata.Path, err)
+		return
+	}
+	s.mapped = mapped
 }
 
 func (s *SSTable) open() error {
-	file, err := os.OpenFile(s.metadata.Path, os.O_RDWR|os.O_CREATE, 0644)
+	flag := os.O_RDWR | os.O_CREATE
+	if s.config.ReadOnly {
+		flag = os.O_RDONLY
+	}
+
+	var file *os.File
+	err := s.retry.do(func() (err error) {
+		file, err = os.OpenFile(s.metadata.Path, flag, 0644)
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("can not open sstable %q: %v", s.metadata.Path, err)
 	}
@@ -228,21 +632,47 @@ func (s *SSTable) open() error {
 	return nil
 }
 
-func serializeSSTable(metadata TableMetadata, config *shared.EngineConfig, pairs []KVPair) (*SSTable, error) {
-	table, err := NewSSTable(metadata, config)
+func serializeSSTable(metadata TableMetadata, config *shared.EngineConfig, filters *FilterCache, retry *retrier, pairs []KVPair) (*SSTable, error) {
+	if config.Paranoid {
+		if err := checkSortedPairs(metadata, pairs); err != nil {
+			return nil, err
+		}
+	}
+
+	table, err := NewSSTable(metadata, config, filters, retry)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open table %q: %v", metadata.Path, err)
 	}
 
+	// a partially written table must not be picked up on the next start
 	if err := table.Serialize(pairs); err != nil {
-		return nil, fmt.Errorf("failed to deserialize table %q: %v", metadata.Path, err)
+		table.Close()
+		os.Remove(metadata.Path)
+		return nil, fmt.Errorf("failed to serialize table %q: %v", metadata.Path, err)
+	}
+
+	if err := table.sync(); err != nil {
+		table.Close()
+		os.Remove(metadata.Path)
+		return nil, fmt.Errorf("failed to sync table %q: %v", metadata.Path, err)
+	}
+	table.mapFile()
+
+	// the table embeds its filter and index anyway, it is complete without sidecars
+	if config.SidecarFiles {
+		if err := table.writeSidecars(); err != nil {
+			table.Close()
+			os.Remove(metadata.Path)
+			table.removeSidecars()
+			return nil, fmt.Errorf("failed to write sidecars of table %q: %v", metadata.Path, err)
+		}
 	}
 
 	return table, nil
 }
 
-func deserializeSSTable(metadata TableMetadata, config *shared.EngineConfig) (*SSTable, error) {
-	table, err := NewSSTable(metadata, config)
+func deserializeSSTable(metadata TableMetadata, config *shared.EngineConfig, filters *FilterCache, retry *retrier) (*SSTable, error) {
+	table, err := NewSSTable(metadata, config, filters, retry)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open table %q: %v", metadata.Path, err)
 	}
@@ -253,3 +683,13 @@ func deserializeSSTable(metadata TableMetadata, config *shared.EngineConfig) (*S
 
 	return table, nil
 }
+
+// syncDir fsyncs a directory so newly created or renamed entries survive a crash.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
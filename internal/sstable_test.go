@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestDeserializeChecksLengths(t *testing.T) {
+	config := shared.NewEngineConfig()
+	config.Homepath = t.TempDir()
+
+	pairs := []KVPair{}
+	for i := range 50 {
+		pairs = append(pairs, KVPair{Key: fmt.Sprintf("key%02d", i), Value: Position{Offset: uint64(i), Size: 1}})
+	}
+	metadata := TableMetadata{Path: filepath.Join(config.Homepath, "sst_1"), Format: currentTableFormat, Serial: 1, Size: 50, MinKey: "key00", MaxKey: "key49"}
+	table, err := serializeSSTable(metadata, config, NewFilterCache(0, false), nil, pairs)
+	if err != nil {
+		t.Fatalf("serializeSSTable() error: %v", err)
+	}
+	table.Close()
+
+	table, err = deserializeSSTable(TableMetadata{Path: metadata.Path}, config, NewFilterCache(0, false), nil)
+	if err != nil {
+		t.Fatalf("deserializeSSTable() error: %v", err)
+	}
+	table.Close()
+
+	valid, err := os.ReadFile(metadata.Path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+
+	// the table size, filter size and index location as written by a corrupt disk
+	for _, offset := range []int{5, 9, int(config.GetMetadataSize()), int(config.GetMetadataSize()) + 4} {
+		corrupt := append([]byte{}, valid...)
+		binary.LittleEndian.PutUint32(corrupt[offset:], 0xFFFFFFF0)
+		if err := os.WriteFile(metadata.Path, corrupt, 0644); err != nil {
+			t.Fatalf("WriteFile() error: %v", err)
+		}
+
+		if table, err := deserializeSSTable(TableMetadata{Path: metadata.Path}, config, NewFilterCache(0, false), nil); err == nil {
+			table.Close()
+			t.Errorf("deserializeSSTable() accepted a length of 0xFFFFFFF0 at offset %d", offset)
+		}
+	}
+}
+
+func TestTableFormatsRoundTrip(t *testing.T) {
+	config := shared.NewEngineConfig()
+	config.Homepath = t.TempDir()
+
+	for _, format := range []uint8{tableFormatBlocks, tableFormatSequenced, tableFormatWide, tableFormatDelta, tableFormatChecksummed} {
+		offset := uint64(1) << 40
+		if format < tableFormatWide {
+			offset = 1 << 30 // older formats only address 4GB
+		}
+		pairs := []KVPair{{Key: "a", Value: Position{Offset: offset, Size: 3}, Seq: 7}, {Key: "b", Value: Position{Offset: offset + 3, Size: 5}, Seq: 9}}
+
+		path := filepath.Join(config.Homepath, fmt.Sprintf("sst_%d", format+1))
+		metadata := TableMetadata{Path: path, Format: format, Serial: uint32(format) + 1, Size: 2, MinKey: "a", MaxKey: "b"}
+		table, err := serializeSSTable(metadata, config, NewFilterCache(0, false), nil, pairs)
+		if err != nil {
+			t.Fatalf("format %d: serializeSSTable() error: %v", format, err)
+		}
+		table.Close()
+
+		table, err = deserializeSSTable(TableMetadata{Path: path}, config, NewFilterCache(0, false), nil)
+		if err != nil {
+			t.Fatalf("format %d: deserializeSSTable() error: %v", format, err)
+		}
+		defer table.Close()
+
+		items, err := table.Items()
+		if err != nil || len(items) != 2 {
+			t.Fatalf("format %d: Items() = %v, %v", format, items, err)
+		}
+		for i, pair := range items {
+			want := pairs[i]
+			if format < tableFormatSequenced {
+				want.Seq = 0
+			}
+			if pair != want {
+				t.Errorf("format %d: pair %d = %+v, want %+v", format, i, pair, want)
+			}
+		}
+		if position, err := table.Search("b"); err != nil || position != pairs[1].Value {
+			t.Errorf("format %d: Search(b) = %+v, %v, want %+v", format, position, err, pairs[1].Value)
+		}
+	}
+}
+
+func TestBlockChecksums(t *testing.T) {
+	config := *shared.NewEngineConfig().WithSmallTableMergeSize(0)
+	engine, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for i := range 1000 {
+		engine.Set(fmt.Sprintf("key%03d", i), []byte("value"))
+	}
+	engine.indexManager.Flush()
+	table := engine.indexManager.sstables[0]
+	if len(table.index) < 2 {
+		t.Fatalf("table has %d blocks, want several", len(table.index))
+	}
+
+	// flip a byte of the first block as bit rot would
+	handle := table.index[0]
+	file, err := os.OpenFile(table.metadata.Path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatalf("OpenFile() error: %v", err)
+	}
+	b := make([]byte, 1)
+	file.ReadAt(b, int64(handle.offset))
+	b[0] ^= 0xFF
+	file.WriteAt(b, int64(handle.offset))
+	file.Close()
+
+	var corruption *shared.ErrCorruption
+	if _, err := engine.Get(handle.firstKey); !errors.As(err, &corruption) || corruption.Offset != int64(handle.offset) {
+		t.Errorf("Get(%q) error = %v, want an ErrCorruption at %d", handle.firstKey, err, handle.offset)
+	}
+	if _, err := table.Items(); !errors.As(err, &corruption) {
+		t.Errorf("Items() error = %v, want an ErrCorruption", err)
+	}
+	if value, err := engine.Get("key999"); err != nil || string(value) != "value" {
+		t.Errorf("Get(key999) from an intact block = %q, %v", value, err)
+	}
+}
+
+func TestMmapTables(t *testing.T) {
+	config := *shared.NewEngineConfig().WithSmallTableMergeSize(0).WithMmapTables(true)
+	engine, err := NewEngine(t.TempDir(), config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	for i := range 1000 {
+		engine.Set(fmt.Sprintf("key%03d", i), []byte(fmt.Sprintf("value%d", i)))
+	}
+	engine.indexManager.Flush()
+	table := engine.indexManager.sstables[0]
+	if runtime.GOOS == "linux" && table.mapped == nil {
+		t.Fatalf("table was not mapped")
+	}
+
+	if value, err := engine.Get("key500"); err != nil || string(value) != "value500" {
+		t.Errorf("Get(key500) = %q, %v, want value500", value, err)
+	}
+	if items, err := table.Items(); err != nil || len(items) != 1000 {
+		t.Errorf("Items() returned %d pairs, %v, want 1000", len(items), err)
+	}
+	keys := 0
+	engine.ScanFunc("key", func(string, []byte) (bool, error) { keys++; return false, nil })
+	if keys != 1000 {
+		t.Errorf("ScanFunc() visited %d keys, want 1000", keys)
+	}
+}
+
+func TestRetiredTableOutlivesReaders(t *testing.T) {
+	engine, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	engine.Set("a", []byte("v1"))
+	engine.Set("b", []byte("v1"))
+	engine.indexManager.Flush()
+	path := engine.indexManager.sstables[0].metadata.Path
+
+	snapshot := engine.indexManager.snapshot()
+	it := engine.NewIterator()
+	if !it.Next() || it.Key() != "a" {
+		t.Fatalf("iterator at %q, %v, want the first key", it.Key(), it.Err())
+	}
+
+	// an overlapping flush merges the table away
+	engine.Set("a", []byte("v2"))
+	engine.Set("c", []byte("v2"))
+	engine.indexManager.Flush()
+	if tables := engine.indexManager.sstables; len(tables) != 1 || tables[0].metadata.Path == path {
+		t.Fatalf("tables = %d after an overlapping flush, want the flushed table merged", len(tables))
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("table removed while a snapshot and an iterator hold it: %v", err)
+	}
+	keys, err := snapshot.Keys()
+	slices.Sort(keys)
+	if err != nil || !slices.Equal(keys, []string{"a", "b"}) {
+		t.Errorf("snapshot keys = %q, %v, want the retired table's a and b", keys, err)
+	}
+	snapshot.Release()
+
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("table removed while an iterator holds it: %v", err)
+	}
+	keys = []string{it.Key()}
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+	if err := it.Err(); err != nil || len(keys) != 2 || keys[1] != "b" {
+		t.Errorf("iterator read %q, %v from the retired table, want a and b", keys, err)
+	}
+	it.Close()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("table file = %v after its last release, want it removed", err)
+	}
+}
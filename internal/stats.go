@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"os"
+	"time"
+)
+
+// Stats is a point-in-time snapshot of the engine's counters.
+type Stats struct {
+	IORetries        uint64 `json:"io_retries"`         // Disk operations retried after a transient error.
+	IORetryExhausted uint64 `json:"io_retry_exhausted"` // Disk operations that kept failing after the last attempt.
+	NegativeHits     uint64 `json:"negative_hits"`      // Lookups answered by the negative cache.
+	RowCacheHits     uint64 `json:"row_cache_hits"`     // Lookups answered by the row cache.
+	RowCacheMisses   uint64 `json:"row_cache_misses"`   // Lookups the row cache could not answer.
+	RowCacheBytes    uint64 `json:"row_cache_bytes"`    // Bytes held by the row cache.
+
+	BackgroundIOWaits uint64        `json:"background_io_waits"` // Background disk accesses and scan blocks that yielded to user operations, see shared.EngineConfig.ScanNiceness.
+	BackgroundIODelay time.Duration `json:"background_io_delay"` // Total time background disk accesses and scans yielded.
+
+	CompactionsDeferred uint64 `json:"compactions_deferred"` // Compactions postponed to an off-peak window.
+	MissingTables       uint64 `json:"missing_tables"`       // Tables dropped because their file disappeared from disk.
+	TablesMoved         uint64 `json:"tables_moved"`         // Level tables moved down a level without rewriting their pairs.
+
+	FilterMemory  uint64 `json:"filter_memory"`  // Bytes held by the loaded table filters, see shared.EngineConfig.FilterMemoryBudget.
+	FiltersLoaded int    `json:"filters_loaded"` // Table filters held in memory, the others are read back on their next lookup.
+
+	BlockRepairs        uint64 `json:"block_repairs"`         // Blocks failing their checksum served from a checkpoint, see shared.EngineConfig.RepairSources.
+	BlockRepairFailures uint64 `json:"block_repair_failures"` // Blocks failing their checksum no checkpoint had an intact copy of.
+
+	BestEffort bool `json:"best_effort"` // Writes are not logged and only persist once flushed, see shared.EngineConfig.CacheMode.
+
+	DedupValues     int    `json:"dedup_values"`      // Stored values tracked for deduplication.
+	DedupSavedBytes uint64 `json:"dedup_saved_bytes"` // Value bytes not written since open because an identical value was stored.
+
+	DataLiveBytes     int64 `json:"data_live_bytes"`     // Bytes of the data file held by values still referenced, see shared.EngineConfig.DataGarbagePercent.
+	DataGarbageBytes  int64 `json:"data_garbage_bytes"`  // Bytes of the data file held by overwritten and deleted values.
+	DataCompactionDue bool  `json:"data_compaction_due"` // The garbage reached shared.EngineConfig.DataGarbagePercent of the data file.
+
+	DataPreallocated uint64 `json:"data_preallocated"` // Bytes of disk reserved ahead of the data file since open, see shared.EngineConfig.DataPreallocateSize.
+	ValueFiles       int    `json:"value_files"`       // Files of values colocated with their table, see shared.EngineConfig.ColocatedValuePrefixes.
+	PrimedBlocks     uint64 `json:"primed_blocks"`     // Table blocks read into the page cache by Warmup since open, see shared.EngineConfig.PrimeBlocks.
+
+	HotKeys []HotKey `json:"hot_keys,omitempty"` // Most read keys, see shared.EngineConfig.HotKeys.
+
+	MemtableThreshold uint32 `json:"memtable_threshold"` // Memtable size triggering a flush, see shared.EngineConfig.MemtableMaxSize.
+
+	Levels         []LevelStats `json:"levels"`          // Level 0 holds the flushed SSTables, the following levels the compacted ones.
+	CompactionDebt int64        `json:"compaction_debt"` // Bytes of level 0 past the compaction threshold, rewritten by the next compaction.
+}
+
+// LevelStats is the on-disk footprint of a level.
+type LevelStats struct {
+	Level  int   `json:"level"`
+	Tables int   `json:"tables"`
+	Bytes  int64 `json:"bytes"`
+}
+
+// Stats returns the current engine statistics.
+func (e *Engine) Stats() Stats {
+	dedupValues, dedupSaved := e.dedup.stats()
+	levels, debt := e.indexManager.levelStats()
+	live, garbage, due := e.space.stats()
+	repairs, repairFailures := e.indexManager.repair.stats()
+	return Stats{
+		IORetries:        e.retry.retries.Load(),
+		IORetryExhausted: e.retry.exhausted.Load(),
+		NegativeHits:     e.indexManager.misses.hits.Load(),
+		RowCacheHits:     e.rows.hits.Load(),
+		RowCacheMisses:   e.rows.misses.Load(),
+		RowCacheBytes:    e.rows.Usage(),
+
+		BackgroundIOWaits: e.io.waits.Load(),
+		BackgroundIODelay: time.Duration(e.io.delayed.Load()),
+
+		CompactionsDeferred: e.indexManager.schedule.deferred.Load(),
+		MissingTables:       e.indexManager.missingTables.Load(),
+		TablesMoved:         e.indexManager.moves.Load(),
+
+		FilterMemory:  e.indexManager.filters.Usage(),
+		FiltersLoaded: e.indexManager.filters.Len(),
+
+		BlockRepairs:        repairs,
+		BlockRepairFailures: repairFailures,
+
+		BestEffort: e.cache != nil,
+
+		DedupValues:     dedupValues,
+		DedupSavedBytes: dedupSaved,
+
+		DataLiveBytes:     live,
+		DataGarbageBytes:  garbage,
+		DataCompactionDue: due,
+
+		DataPreallocated: e.dataPreallocated(),
+		ValueFiles:       e.indexManager.values.count(),
+		PrimedBlocks:     e.primed.Load(),
+
+		HotKeys: e.HotKeys(),
+
+		MemtableThreshold: e.flushThreshold(),
+
+		Levels:         levels,
+		CompactionDebt: debt,
+	}
+}
+
+// levelStats returns the size of every level and the bytes of level 0 waiting
+// to be compacted. Tables removed while being measured count as empty.
+func (im *IndexManager) levelStats() ([]LevelStats, int64) {
+	im.mu.RLock()
+	defer im.mu.RUnlock()
+
+	groups := im.levelGroups()
+	levels := make([]LevelStats, 0, len(groups))
+	for level, tables := range groups {
+		levels = append(levels, LevelStats{Level: level, Tables: len(tables), Bytes: tablesSize(tables)})
+	}
+
+	var debt int64
+	if len(im.sstables) > int(im.config.CompactionThreshold) {
+		debt = levels[0].Bytes
+	}
+	return levels, debt
+}
+
+func tablesSize(tables []*SSTable) int64 {
+	var size int64
+	for _, table := range tables {
+		if info, err := os.Stat(table.metadata.Path); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// dataPreallocated returns the bytes reserved ahead of the data file since open.
+func (e *Engine) dataPreallocated() uint64 {
+	if dm := e.dataFile(); dm != nil {
+		return dm.preallocated.Load()
+	}
+	return 0
+}
+
+// dataFile returns the manager of the data file, nil if values are stored elsewhere.
+func (e *Engine) dataFile() *DiskDataManager {
+	storage := e.storageManager
+	if values, ok := storage.(*tableValues); ok {
+		storage = values.DataManager
+	}
+	dm, _ := storage.(*DiskDataManager)
+	return dm
+}
@@ -0,0 +1,371 @@
+package internal
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// The values of keys under Config.ColocatedValuePrefixes leave the data file
+// when their memtable is flushed: they are copied to a value file written with
+// the new table and named after it. Compactions copy them again into the value
+// files of the tables they write, and a value file is removed along with its
+// table, so the space of overwritten and deleted values is reclaimed without
+// scanning the data file, at the cost of rewriting the values on every
+// compaction. The copies in the data file are left behind.
+
+// ValueFileSuffix ends the names of value files, <table file>.<number>.values.
+const ValueFileSuffix = ".values"
+
+const (
+	// tableValueFlag marks the position of a value in a value file, the bits
+	// of the offset below it number the file and locate the value in it.
+	tableValueFlag  = 1 << 63
+	valueOffsetBits = 40
+	maxValueFiles   = 1 << (63 - valueOffsetBits)
+	// valueFileGracePeriod is how long a removed value file stays readable by
+	// lookups that found one of its positions just before its table went away.
+	valueFileGracePeriod = 30 * time.Second
+)
+
+// colocated reports whether the value is in a value file.
+func (p Position) colocated() bool {
+	return p.Offset&tableValueFlag != 0
+}
+
+// valueFile is the file holding the values colocated with a table.
+type valueFile struct {
+	number  uint32
+	path    string
+	data    *DiskDataManager
+	owner   *tableValues
+	dropped atomic.Bool // The file was removed with its table, it is only open for the lookups still reading it.
+}
+
+// valuesPath returns the path of the table's value file, empty if it has none.
+func (s *SSTable) valuesPath() string {
+	if s.values == nil {
+		return ""
+	}
+	return s.values.path
+}
+
+// drop removes the file of a table that was removed, nil-safe.
+func (f *valueFile) drop() {
+	if f == nil {
+		return
+	}
+
+	f.dropped.Store(true)
+	if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to remove value file %q: %v", f.path, err)
+	}
+	time.AfterFunc(valueFileGracePeriod, func() {
+		f.owner.mu.Lock()
+		delete(f.owner.files, f.number)
+		f.owner.mu.Unlock()
+		f.data.Close()
+	})
+}
+
+// tableValues is the data file extended with the value files of the tables,
+// it stores new values in the data file and reads colocated ones from their
+// value file. It is nil when no key is colocated and there is no value file.
+type tableValues struct {
+	DataManager // The data file, new values are stored there until flushed.
+
+	prefixes []string   // Keys whose values are colocated, see Config.ColocatedValuePrefixes.
+	space    *dataSpace // Counts the copies left in the data file, nil unless the garbage is counted.
+	retry    *retrier
+
+	mu    sync.RWMutex
+	files map[uint32]*valueFile
+	next  uint32 // Number of the next value file.
+}
+
+// newTableValues opens the value files of homepath, removing the ones whose
+// table is gone. It returns nil if there are none and no key is colocated.
+func newTableValues(config *shared.EngineConfig, retry *retrier) (*tableValues, error) {
+	entries, err := os.ReadDir(config.Homepath)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ValueFileSuffix) {
+			paths = append(paths, filepath.Join(config.Homepath, entry.Name()))
+		}
+	}
+	if len(paths) == 0 && len(config.ColocatedValuePrefixes) == 0 {
+		return nil, nil
+	}
+
+	v := &tableValues{prefixes: config.ColocatedValuePrefixes, retry: retry, files: map[uint32]*valueFile{}}
+	for _, path := range paths {
+		table, number, ok := parseValueFileName(path)
+		if !ok {
+			continue
+		}
+		// the table was removed, or never written, after its value file
+		if _, err := os.Stat(table); os.IsNotExist(err) {
+			if !config.ReadOnly {
+				log.Printf("index manager: removing value file %q without its table", path)
+				os.Remove(path)
+			}
+			continue
+		}
+
+		data, err := NewDiskDataManager(path, config.ReadOnly, retry, 0)
+		if err != nil {
+			v.Close()
+			return nil, err
+		}
+		v.files[number] = &valueFile{number: number, path: path, data: data.(*DiskDataManager), owner: v}
+		v.next = max(v.next, (number+1)%maxValueFiles)
+	}
+	return v, nil
+}
+
+// parseValueFileName returns the table and the number of a value file.
+func parseValueFileName(path string) (string, uint32, bool) {
+	name, ok := strings.CutSuffix(path, ValueFileSuffix)
+	dot := strings.LastIndexByte(name, '.')
+	if !ok || dot < 0 {
+		return "", 0, false
+	}
+	number, err := strconv.ParseUint(name[dot+1:], 10, 32)
+	if err != nil || number >= maxValueFiles {
+		return "", 0, false
+	}
+	return name[:dot], uint32(number), true
+}
+
+// of returns the value file of the table at path, nil-safe.
+func (v *tableValues) of(path string) *valueFile {
+	if v == nil {
+		return nil
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	for _, file := range v.files {
+		if table, _, _ := parseValueFileName(file.path); table == path {
+			return file
+		}
+	}
+	return nil
+}
+
+// selected reports whether the value of key is colocated with its table.
+func (v *tableValues) selected(key string) bool {
+	for _, prefix := range v.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// colocate copies the values of the selected keys to a new value file of the
+// table at path and points their pairs at the copies. Values of keys no
+// longer selected are moved back to the data file. It returns nil if no value
+// was copied, nil-safe.
+func (v *tableValues) colocate(path string, pairs []KVPair) (*valueFile, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	var file *valueFile
+	fail := func(err error) (*valueFile, error) {
+		if file != nil {
+			file.drop()
+		}
+		return nil, fmt.Errorf("index manager can not colocate the values of table %q: %v", path, err)
+	}
+
+	moved := false
+	for i := range pairs {
+		pair := &pairs[i]
+		// operands are collapsed before their memtable is flushed
+		if pair.Value.Size == 0 || pair.Value.operand() {
+			continue
+		}
+		selected := v.selected(pair.Key)
+		if !selected && !pair.Value.colocated() {
+			continue
+		}
+
+		value, err := v.Retrieve(pair.Value)
+		if err != nil {
+			return fail(err)
+		}
+		if !selected {
+			if pair.Value, err = v.DataManager.Store(pair.Key, value); err != nil {
+				return fail(err)
+			}
+			moved = true
+			continue
+		}
+
+		if file == nil {
+			if file, err = v.create(path); err != nil {
+				return fail(err)
+			}
+		}
+		position, err := file.data.Store(pair.Key, value)
+		if err != nil {
+			return fail(err)
+		}
+		if position.Offset >= 1<<valueOffsetBits {
+			return fail(fmt.Errorf("value file %q is full", file.path))
+		}
+		v.space.discard(pair.Key, pair.Value)
+		pair.Value = Position{Offset: tableValueFlag | uint64(file.number)<<valueOffsetBits | position.Offset, Size: position.Size}
+	}
+
+	if moved {
+		if err := v.DataManager.Sync(); err != nil {
+			return fail(err)
+		}
+	}
+	if file != nil {
+		if err := file.data.Sync(); err != nil {
+			return fail(err)
+		}
+	}
+	return file, nil
+}
+
+// create opens a new value file for the table at path under the next free number.
+func (v *tableValues) create(path string) (*valueFile, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.files) >= maxValueFiles {
+		return nil, fmt.Errorf("every one of the %d value file numbers is taken", maxValueFiles)
+	}
+	for {
+		if _, ok := v.files[v.next]; !ok {
+			break
+		}
+		v.next = (v.next + 1) % maxValueFiles
+	}
+
+	file := &valueFile{number: v.next, path: fmt.Sprintf("%s.%d%s", path, v.next, ValueFileSuffix), owner: v}
+	data, err := NewDiskDataManager(file.path, false, v.retry, 0)
+	if err != nil {
+		return nil, err
+	}
+	file.data = data.(*DiskDataManager)
+	v.files[file.number] = file
+	v.next = (v.next + 1) % maxValueFiles
+	return file, nil
+}
+
+// renamed records the new path of a value file, once its table was moved.
+func (v *tableValues) renamed(file *valueFile, path string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	file.path = path
+}
+
+// locate returns the value file of a colocated position and the position of
+// the value within it.
+func (v *tableValues) locate(position Position) (*valueFile, Position, error) {
+	number := uint32((position.Offset &^ tableValueFlag) >> valueOffsetBits)
+
+	v.mu.RLock()
+	file, ok := v.files[number]
+	v.mu.RUnlock()
+	if !ok {
+		return nil, Position{}, fmt.Errorf("storage manager has no value file %d", number)
+	}
+	return file, Position{Offset: position.Offset & (1<<valueOffsetBits - 1), Size: position.Size}, nil
+}
+
+func (v *tableValues) Retrieve(position Position) ([]byte, error) {
+	if !position.colocated() {
+		return v.DataManager.Retrieve(position)
+	}
+	file, position, err := v.locate(position)
+	if err != nil {
+		return nil, err
+	}
+	return file.data.Retrieve(position)
+}
+
+func (v *tableValues) Erase(key string, position Position) error {
+	if !position.colocated() {
+		return v.DataManager.Erase(key, position)
+	}
+	file, position, err := v.locate(position)
+	if err != nil {
+		return err
+	}
+	// the value went away with the file of its table, possibly while erasing
+	if err := file.data.Erase(key, position); err != nil && !file.dropped.Load() {
+		return err
+	}
+	return nil
+}
+
+// check verifies a colocated position lies within its value file.
+func (v *tableValues) check(position Position) error {
+	file, position, err := v.locate(position)
+	if err != nil {
+		return err
+	}
+	return file.data.checkPosition(position)
+}
+
+// count returns the number of value files.
+func (v *tableValues) count() int {
+	if v == nil {
+		return 0
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return len(v.files)
+}
+
+func (v *tableValues) Close() error {
+	v.mu.Lock()
+	for _, file := range v.files {
+		file.data.Close()
+	}
+	v.mu.Unlock()
+
+	if v.DataManager == nil {
+		return nil
+	}
+	return v.DataManager.Close()
+}
+
+// writeTable colocates the values of the pairs, see tableValues.colocate, and
+// writes the pairs to the new table described by metadata.
+func (im *IndexManager) writeTable(metadata TableMetadata, pairs []KVPair) (*SSTable, error) {
+	values, err := im.values.colocate(metadata.Path, pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := serializeSSTable(metadata, im.config, im.filters, im.retry, pairs)
+	if err != nil {
+		values.drop()
+		return nil, err
+	}
+	table.repair = im.repair
+	table.values = values
+	table.access = im.access
+	return table, nil
+}
@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// valueFiles returns the names of the value files in dir.
+func valueFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir() error: %v", err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ValueFileSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	return names
+}
+
+func TestColocatedValues(t *testing.T) {
+	dir := t.TempDir()
+	config := shared.NewEngineConfig().WithColocatedValues("c:")
+	config.MemtableSizeThreshold = 10
+	config.CompactionThreshold = 2
+	engine, err := NewEngine(dir, *config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	// overwrites keep compacting the colocated keys into new tables
+	for round := range 10 {
+		for i := range 5 {
+			if err := engine.Set(fmt.Sprintf("c:%d", i), []byte(fmt.Sprintf("colocated %d-%d", i, round))); err != nil {
+				t.Fatalf("Set() error: %v", err)
+			}
+			if err := engine.Set(fmt.Sprintf("p:%d", i), []byte(fmt.Sprintf("plain %d-%d", i, round))); err != nil {
+				t.Fatalf("Set() error: %v", err)
+			}
+		}
+	}
+	if err := engine.Delete("c:4"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if err := engine.indexManager.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	check := func(engine *Engine) {
+		t.Helper()
+		for i := range 4 {
+			for key, want := range map[string]string{fmt.Sprintf("c:%d", i): fmt.Sprintf("colocated %d-9", i), fmt.Sprintf("p:%d", i): fmt.Sprintf("plain %d-9", i)} {
+				if value, err := engine.Get(key); err != nil || string(value) != want {
+					t.Errorf("Get(%q) = %q, %v, want %q", key, value, err, want)
+				}
+			}
+		}
+		if _, err := engine.Get("c:4"); err == nil {
+			t.Errorf("Get(\"c:4\") found the deleted key")
+		}
+	}
+	check(engine)
+
+	// every table holding colocated keys has a value file, the value files
+	// of the compacted tables are gone
+	snapshot := engine.indexManager.snapshot()
+	withValues := 0
+	for _, table := range snapshot.tables {
+		if table.values != nil {
+			withValues++
+		}
+		pairs, err := table.Items()
+		if err != nil {
+			t.Fatalf("Items() error: %v", err)
+		}
+		for _, pair := range pairs {
+			if colocated := strings.HasPrefix(pair.Key, "c:") && pair.Value.Size > 0; pair.Value.colocated() != colocated {
+				t.Errorf("pair %q of table %q colocated = %t, want %t", pair.Key, table.metadata.Path, pair.Value.colocated(), colocated)
+			}
+		}
+	}
+	snapshot.Release()
+	if files := valueFiles(t, dir); len(files) != withValues || withValues == 0 {
+		t.Errorf("value files = %v, want one per table with colocated values (%d)", files, withValues)
+	}
+	if err := engine.CheckConsistency(100); err != nil {
+		t.Errorf("CheckConsistency() error: %v", err)
+	}
+
+	// a value file left without its table is removed on open
+	orphan := filepath.Join(dir, "sst_999.7"+ValueFileSuffix)
+	if err := os.WriteFile(orphan, dataFileMagic, 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	engine, err = NewEngine(dir, *config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	check(engine)
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphan value file still exists: %v", err)
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	// without the prefix the values move back to the data file as they are compacted
+	config.ColocatedValuePrefixes = nil
+	engine, err = NewEngine(dir, *config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+	check(engine)
+
+	engine.indexManager.mu.Lock()
+	for level := 0; err == nil && level < maxLevels-1; level++ {
+		if tables := engine.indexManager.levelTables(level); len(tables) > 0 {
+			err = engine.indexManager.compactInto(tables, level+1)
+		}
+	}
+	engine.indexManager.mu.Unlock()
+	if err != nil {
+		t.Fatalf("compactInto() error: %v", err)
+	}
+	check(engine)
+	if files := valueFiles(t, dir); len(files) != 0 {
+		t.Errorf("value files = %v after compacting without colocated keys, want none", files)
+	}
+}
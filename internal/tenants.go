@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// TenantStats is the approximate usage of a tenant and its quota.
+type TenantStats struct {
+	Prefix   string `json:"prefix"`
+	Keys     uint64 `json:"keys"`
+	Bytes    uint64 `json:"bytes"`
+	MaxKeys  uint64 `json:"max_keys,omitempty"`
+	MaxBytes uint64 `json:"max_bytes,omitempty"`
+	Rejected uint64 `json:"rejected"` // Writes refused for exceeding the quota.
+}
+
+type tenant struct {
+	quota    shared.TenantQuota
+	keys     atomic.Int64
+	bytes    atomic.Int64
+	rejected atomic.Uint64
+}
+
+// tenantTracker counts the live keys and bytes of every configured tenant.
+// Counts are kept approximate: they are counted from the index when the engine
+// opens and adjusted on writes using the size of the value being replaced.
+type tenantTracker struct {
+	tenants []*tenant // Sorted by descending prefix length so the longest prefix matches first.
+}
+
+func newTenantTracker(quotas []shared.TenantQuota) *tenantTracker {
+	if len(quotas) == 0 {
+		return nil
+	}
+
+	t := &tenantTracker{}
+	for _, quota := range quotas {
+		t.tenants = append(t.tenants, &tenant{quota: quota})
+	}
+	sort.Slice(t.tenants, func(i, j int) bool {
+		return len(t.tenants[i].quota.Prefix) > len(t.tenants[j].quota.Prefix)
+	})
+	return t
+}
+
+// of returns the tenant owning key, nil if there is none.
+func (t *tenantTracker) of(key string) *tenant {
+	if t == nil {
+		return nil
+	}
+	for _, tenant := range t.tenants {
+		if strings.HasPrefix(key, tenant.quota.Prefix) {
+			return tenant
+		}
+	}
+	return nil
+}
+
+// count sets the tenants' usage to the live keys found in the index, writes
+// racing with the count may be missed.
+func (t *tenantTracker) count(e *Engine) error {
+	if t == nil {
+		return nil
+	}
+
+	keys, bytes := make(map[*tenant]int64), make(map[*tenant]int64)
+	err := e.scan("", func(pair KVPair) (bool, error) {
+		if tenant := t.of(pair.Key); tenant != nil {
+			keys[tenant]++
+			bytes[tenant] += int64(len(pair.Key)) + int64(pair.Value.stored().Size)
+		}
+		return false, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, tenant := range t.tenants {
+		tenant.keys.Store(keys[tenant])
+		tenant.bytes.Store(bytes[tenant])
+	}
+	return nil
+}
+
+// reserve checks that replacing the key's value of size old (a zero size for a
+// new key) by one of size value fits in the quota and records the change.
+func (tenant *tenant) reserve(key string, old Position, value int) error {
+	keys, bytes := usageDelta(key, old, value)
+
+	quota := tenant.quota
+	if quota.MaxKeys > 0 && keys > 0 && tenant.keys.Load()+keys > int64(quota.MaxKeys) {
+		tenant.rejected.Add(1)
+		return &shared.ErrQuotaExceeded{Tenant: quota.Prefix, Resource: "keys", Limit: quota.MaxKeys}
+	}
+	if quota.MaxBytes > 0 && bytes > 0 && tenant.bytes.Load()+bytes > int64(quota.MaxBytes) {
+		tenant.rejected.Add(1)
+		return &shared.ErrQuotaExceeded{Tenant: quota.Prefix, Resource: "bytes", Limit: quota.MaxBytes}
+	}
+
+	tenant.keys.Add(keys)
+	tenant.bytes.Add(bytes)
+	return nil
+}
+
+// unreserve reverts a reserve whose write did not happen.
+func (tenant *tenant) unreserve(key string, old Position, value int) {
+	keys, bytes := usageDelta(key, old, value)
+	tenant.keys.Add(-keys)
+	tenant.bytes.Add(-bytes)
+}
+
+func usageDelta(key string, old Position, value int) (keys, bytes int64) {
+	keys, bytes = 0, int64(value)-int64(old.stored().Size)
+	if old.Size == 0 {
+		keys, bytes = 1, bytes+int64(len(key))
+	}
+	return keys, bytes
+}
+
+// release records the deletion of a key whose value had the given position.
+func (tenant *tenant) release(key string, old Position) {
+	if old.Size == 0 {
+		return
+	}
+	tenant.keys.Add(-1)
+	tenant.bytes.Add(-int64(len(key)) - int64(old.stored().Size))
+}
+
+// unrelease reverts a release whose deletion did not happen.
+func (tenant *tenant) unrelease(key string, old Position) {
+	if old.Size == 0 {
+		return
+	}
+	tenant.keys.Add(1)
+	tenant.bytes.Add(int64(len(key)) + int64(old.stored().Size))
+}
+
+// TenantStats returns the usage of every configured tenant, ordered by prefix.
+func (e *Engine) TenantStats() []TenantStats {
+	stats := []TenantStats{}
+	if e.tenants == nil {
+		return stats
+	}
+
+	for _, tenant := range e.tenants.tenants {
+		stats = append(stats, TenantStats{
+			Prefix:   tenant.quota.Prefix,
+			Keys:     uint64(max(tenant.keys.Load(), 0)),
+			Bytes:    uint64(max(tenant.bytes.Load(), 0)),
+			MaxKeys:  tenant.quota.MaxKeys,
+			MaxBytes: tenant.quota.MaxBytes,
+			Rejected: tenant.rejected.Load(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Prefix < stats[j].Prefix })
+	return stats
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestTenantQuotas(t *testing.T) {
+	home := t.TempDir()
+	config := *shared.NewEngineConfig().
+		WithTenantQuota("app:", 2, 0).
+		WithTenantQuota("app:big:", 0, 20)
+
+	engine, err := NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	engine.Set("app:a", []byte("1"))
+	engine.Set("app:b", []byte("1"))
+	engine.Set("app:a", []byte("22")) // overwrites do not add keys
+
+	var exceeded *shared.ErrQuotaExceeded
+	if err := engine.Set("app:c", []byte("1")); !errors.As(err, &exceeded) || exceeded.Resource != "keys" {
+		t.Fatalf("Set(app:c) error = %v, want a keys quota error", err)
+	}
+
+	// the longest prefix owns the key
+	if err := engine.Set("app:big:x", []byte("0123456789")); err != nil {
+		t.Fatalf("Set(app:big:x) error: %v", err)
+	}
+	if err := engine.Set("app:big:y", []byte("0123456789")); !errors.As(err, &exceeded) || exceeded.Resource != "bytes" {
+		t.Fatalf("Set(app:big:y) error = %v, want a bytes quota error", err)
+	}
+
+	engine.Delete("app:b")
+	if err := engine.Set("app:c", []byte("1")); err != nil {
+		t.Errorf("Set(app:c) after a delete error: %v", err)
+	}
+	engine.Close()
+
+	// usage is counted again from the index and the WAL on open
+	engine, err = NewEngine(home, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	want := []TenantStats{
+		{Prefix: "app:", Keys: 2, Bytes: 13, MaxKeys: 2},
+		{Prefix: "app:big:", Keys: 1, Bytes: 19, MaxBytes: 20},
+	}
+	stats := engine.TenantStats()
+	if len(stats) != 2 || stats[0] != want[0] || stats[1] != want[1] {
+		t.Errorf("TenantStats() = %+v, want %+v", stats, want)
+	}
+}
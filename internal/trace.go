@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+type traceContextKey struct{}
+
+// TableProbe describes how a single SSTable or level was consulted during a lookup.
+type TableProbe struct {
+	Serial       uint32        `json:"serial"`
+	IsLevel      bool          `json:"is_level"`
+	OutOfRange   bool          `json:"out_of_range"`  // Skipped using the table's min/max keys.
+	FilterLoaded bool          `json:"filter_loaded"` // False when the filter did not fit in the memory budget.
+	FilterPassed bool          `json:"filter_passed"` // The filter reported the key as possibly present.
+	Seeks        int           `json:"seeks"`
+	BytesRead    int           `json:"bytes_read"`
+	Found        bool          `json:"found"`
+	Duration     time.Duration `json:"duration"`
+}
+
+// Trace collects what the engine did to serve a single point lookup,
+// it is attached to a context with WithTrace and filled by Engine.GetContext.
+type Trace struct {
+	Key          string        `json:"key"`
+	RowCacheHit  bool          `json:"row_cache_hit"`
+	MemtableHit  bool          `json:"memtable_hit"`
+	Probes       []TableProbe  `json:"probes"`
+	TablesProbed int           `json:"tables_probed"`
+	FilterHits   int           `json:"filter_hits"`
+	FilterMisses int           `json:"filter_misses"`
+	Seeks        int           `json:"seeks"`
+	BytesRead    int           `json:"bytes_read"`
+	IndexTime    time.Duration `json:"index_time"`
+	DataTime     time.Duration `json:"data_time"`
+	Duration     time.Duration `json:"duration"`
+	Err          string        `json:"error,omitempty"`
+}
+
+// WithTrace returns a copy of ctx carrying a new Trace.
+func WithTrace(ctx context.Context) (context.Context, *Trace) {
+	trace := &Trace{}
+	return context.WithValue(ctx, traceContextKey{}, trace), trace
+}
+
+// TraceFromContext returns the Trace attached to ctx, or nil if there is none.
+func TraceFromContext(ctx context.Context) *Trace {
+	trace, _ := ctx.Value(traceContextKey{}).(*Trace)
+	return trace
+}
+
+// addProbe records a table probe and updates the totals, it is a no-op on a nil trace.
+func (t *Trace) addProbe(probe TableProbe) {
+	if t == nil {
+		return
+	}
+
+	t.Probes = append(t.Probes, probe)
+	if probe.OutOfRange {
+		return
+	}
+
+	t.TablesProbed++
+	if probe.FilterLoaded {
+		if probe.FilterPassed {
+			t.FilterHits++
+		} else {
+			t.FilterMisses++
+		}
+	}
+	t.Seeks += probe.Seeks
+	t.BytesRead += probe.BytesRead
+}
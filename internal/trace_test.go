@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestGetContextTrace(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig().WithSmallTableMergeSize(0))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	engine.Set("a", []byte("value"))
+	engine.indexManager.Flush()
+	engine.Set("m", []byte("value"))
+	engine.indexManager.Flush()
+	engine.Set("z", []byte("value"))
+
+	// the newest table is skipped by its key range, the oldest holds the key
+	ctx, trace := WithTrace(context.Background())
+	if value, err := engine.GetContext(ctx, "a"); err != nil || string(value) != "value" {
+		t.Fatalf("GetContext() = %q, %v, want value", value, err)
+	}
+	if trace.Key != "a" || trace.MemtableHit || len(trace.Probes) != 2 || trace.TablesProbed != 1 || trace.Duration <= 0 {
+		t.Fatalf("trace = %+v, want two probes of a table lookup", trace)
+	}
+	if probe := trace.Probes[0]; !probe.OutOfRange {
+		t.Errorf("probe of the newest table = %+v, want it out of range", probe)
+	}
+	if probe := trace.Probes[1]; !probe.Found || probe.Seeks == 0 || probe.BytesRead == 0 || probe.Duration <= 0 {
+		t.Errorf("probe of the oldest table = %+v, want a timed read finding the key", probe)
+	}
+
+	ctx, trace = WithTrace(context.Background())
+	engine.GetContext(ctx, "z")
+	if !trace.MemtableHit || len(trace.Probes) != 0 {
+		t.Errorf("trace = %+v, want a memtable hit without probes", trace)
+	}
+
+	ctx, trace = WithTrace(context.Background())
+	if _, err := engine.GetContext(ctx, "b"); err == nil || trace.Err != err.Error() {
+		t.Errorf("trace error = %q, want the lookup's %v", trace.Err, err)
+	}
+
+	// untraced lookups are not timed
+	if _, probe, err := engine.indexManager.sstables[1].search("a", false); err != nil || probe.Duration != 0 {
+		t.Errorf("search() = %+v, %v untraced, want no duration", probe, err)
+	}
+}
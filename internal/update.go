@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"sync"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// keyLatchStripes is the number of latches keys are spread over.
+const keyLatchStripes = 256
+
+// keyLatches serializes the read-modify-write operations of a key, keys are
+// hashed to a fixed set of mutexes so operations on different keys proceed in
+// parallel but for the odd collision, without tracking the keys in flight.
+type keyLatches [keyLatchStripes]sync.Mutex
+
+// lock latches key and returns the function releasing it.
+func (l *keyLatches) lock(key string) func() {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	latch := &l[h.Sum32()%keyLatchStripes]
+	latch.Lock()
+	return latch.Unlock
+}
+
+// Update atomically replaces the value of key by the one fn returns from the
+// current value, found tells whether the key exists. fn deletes the key by
+// setting del, and aborts the update by returning an error, which Update
+// returns as is.
+//
+// Read-modify-write operations of the same key are serialized by a latch on
+// the key, see keyLatches, fn runs without holding the engine lock so other
+// keys are written meanwhile. A plain write racing the update makes fn run
+// again on the new value, fn may thus be called more than once and must not
+// have side effects.
+func (e *Engine) Update(key string, fn func(old []byte, found bool) (new []byte, del bool, err error)) error {
+	if e.Config.ReadOnly {
+		return &shared.ErrReadOnly{Path: e.Config.Homepath}
+	}
+	if err := e.Config.KeyPolicy.Check(key); err != nil {
+		return err
+	}
+
+	unlock := e.latches.lock(key)
+	defer unlock()
+
+	for {
+		// the position read first tells whether a write landed since
+		before, _ := e.indexManager.Get(key)
+		old, err := e.Get(key)
+		found := err == nil
+		if err != nil {
+			if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+				return err
+			}
+		}
+
+		value, del, err := fn(old, found)
+		if err != nil {
+			return err
+		}
+
+		e.mu.Lock()
+		if current, _ := e.indexManager.Get(key); current != before {
+			e.mu.Unlock()
+			continue
+		}
+
+		switch {
+		case del && !found:
+		case del:
+			err = e.delete(key, 0, true)
+		default:
+			err = e.set(key, value, 0, false)
+		}
+		e.mu.Unlock()
+		return err
+	}
+}
+
+// errSwapMismatch aborts the update of CompareAndSwap.
+var errSwapMismatch = errors.New("current value does not match")
+
+// CompareAndSwap sets key to value only if its current value equals old,
+// an empty old value means the key must not exist. It reports whether the swap happened.
+func (e *Engine) CompareAndSwap(key string, old, value []byte) (bool, error) {
+	err := e.Update(key, func(current []byte, found bool) ([]byte, bool, error) {
+		if !bytes.Equal(current, old) {
+			return nil, false, errSwapMismatch
+		}
+		return value, false, nil
+	})
+	if err == errSwapMismatch {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CompareAndSwapSeq sets key to value only if its latest write is numbered
+// seq, see KeySequence, zero means the key must not exist. Unlike
+// CompareAndSwap it tells a value written again apart from the one read. It
+// reports whether the swap happened. A key written before writes were
+// numbered can not be told from a missing one by its number, it fails with an
+// ErrUnsequenced until written again.
+func (e *Engine) CompareAndSwapSeq(key string, seq uint64, value []byte) (bool, error) {
+	if e.Config.ReadOnly {
+		return false, &shared.ErrReadOnly{Path: e.Config.Homepath}
+	}
+	if err := e.Config.KeyPolicy.Check(key); err != nil {
+		return false, err
+	}
+
+	unlock := e.latches.lock(key)
+	defer unlock()
+
+	// the number is compared under the engine lock, no write can land in between
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	current, err := e.KeySequence(key)
+	found := err == nil
+	if err != nil {
+		if _, ok := err.(*shared.ErrKeyNotFound); !ok {
+			return false, err
+		}
+	}
+	if found && current == 0 {
+		return false, &shared.ErrUnsequenced{Key: key}
+	}
+	if found != (seq != 0) || current != seq {
+		return false, nil
+	}
+	if err := e.set(key, value, 0, false); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Increment adds delta to the integer stored as decimal text at key, a
+// missing key counts as zero, and returns the new value.
+func (e *Engine) Increment(key string, delta int64) (int64, error) {
+	var n int64
+	err := e.Update(key, func(old []byte, found bool) ([]byte, bool, error) {
+		n = 0
+		if found {
+			var err error
+			if n, err = strconv.ParseInt(string(old), 10, 64); err != nil {
+				return nil, false, fmt.Errorf("value of %q is not an integer: %v", key, err)
+			}
+		}
+		n += delta
+		return strconv.AppendInt(nil, n, 10), false, nil
+	})
+	return n, err
+}
+
+// Append appends suffix to the value of key, a missing key starts empty.
+func (e *Engine) Append(key string, suffix []byte) error {
+	return e.Update(key, func(old []byte, found bool) ([]byte, bool, error) {
+		return append(old[:len(old):len(old)], suffix...), false, nil
+	})
+}
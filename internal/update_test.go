@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestUpdate(t *testing.T) {
+	e, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	increment := func(old []byte, found bool) ([]byte, bool, error) {
+		n := 0
+		if found {
+			n, _ = strconv.Atoi(string(old))
+		}
+		return []byte(strconv.Itoa(n + 1)), false, nil
+	}
+
+	// concurrent updates of a key lose none of the increments
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if err := e.Update("counter", increment); err != nil {
+					t.Errorf("Update() error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if value, err := e.Get("counter"); err != nil || string(value) != "400" {
+		t.Errorf("Get(counter) = %q, %v, want 400", value, err)
+	}
+
+	aborted := errors.New("aborted")
+	err = e.Update("counter", func([]byte, bool) ([]byte, bool, error) { return []byte("0"), false, aborted })
+	if err != aborted {
+		t.Errorf("Update() error = %v, want the error of fn", err)
+	}
+
+	if err := e.Update("counter", func([]byte, bool) ([]byte, bool, error) { return nil, true, nil }); err != nil {
+		t.Fatalf("Update() deleting error: %v", err)
+	}
+	if _, err := e.Get("counter"); !errors.As(err, new(*shared.ErrKeyNotFound)) {
+		t.Errorf("Get(counter) after the deleting update error = %v, want ErrKeyNotFound", err)
+	}
+}
+
+func TestIncrementAndAppend(t *testing.T) {
+	e, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				e.Increment("hits", 2)
+				e.Append("log", []byte{byte('a' + i)})
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n, err := e.Increment("hits", -100); err != nil || n != 700 {
+		t.Errorf("Increment(hits) = %d, %v, want 700", n, err)
+	}
+	if value, err := e.Get("log"); err != nil || len(value) != 400 {
+		t.Errorf("Get(log) has %d bytes, %v, want 400", len(value), err)
+	}
+	if _, err := e.Increment("log", 1); err == nil {
+		t.Errorf("Increment() of a value that is no integer succeeded")
+	}
+}
+
+func TestCompareAndSwapSeq(t *testing.T) {
+	e, err := NewEngine(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	// zero swaps in a missing key only
+	if swapped, err := e.CompareAndSwapSeq("k", 0, []byte("v1")); err != nil || !swapped {
+		t.Fatalf("CompareAndSwapSeq(0) = %t, %v on a missing key, want swapped", swapped, err)
+	}
+	if swapped, err := e.CompareAndSwapSeq("k", 0, []byte("v2")); err != nil || swapped {
+		t.Errorf("CompareAndSwapSeq(0) = %t, %v on an existing key, want not swapped", swapped, err)
+	}
+
+	seq, err := e.KeySequence("k")
+	if err != nil || seq != e.LastSequence() {
+		t.Fatalf("KeySequence() = %d, %v, want %d", seq, err, e.LastSequence())
+	}
+
+	// writing the same value again is a new write
+	if err := e.Set("k", []byte("v1")); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+	if swapped, err := e.CompareAndSwapSeq("k", seq, []byte("v2")); err != nil || swapped {
+		t.Errorf("CompareAndSwapSeq() = %t, %v with the number of an older write, want not swapped", swapped, err)
+	}
+
+	// the numbers are kept by flushed tables
+	if err := e.indexManager.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	if seq, err = e.KeySequence("k"); err != nil || seq != e.LastSequence() {
+		t.Fatalf("KeySequence() = %d, %v after a flush, want %d", seq, err, e.LastSequence())
+	}
+	if swapped, err := e.CompareAndSwapSeq("k", seq, []byte("v2")); err != nil || !swapped {
+		t.Errorf("CompareAndSwapSeq() = %t, %v with the latest number, want swapped", swapped, err)
+	}
+	if value, err := e.Get("k"); err != nil || string(value) != "v2" {
+		t.Errorf("Get() = %q, %v, want \"v2\"", value, err)
+	}
+
+	// a deleted key is missing again
+	if err := e.Delete("k"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if _, err := e.KeySequence("k"); !errors.As(err, new(*shared.ErrKeyNotFound)) {
+		t.Errorf("KeySequence() error = %v on a deleted key, want ErrKeyNotFound", err)
+	}
+	if swapped, err := e.CompareAndSwapSeq("k", 0, []byte("v3")); err != nil || !swapped {
+		t.Errorf("CompareAndSwapSeq(0) = %t, %v on a deleted key, want swapped", swapped, err)
+	}
+
+	// a key written before writes were numbered can not be swapped by number
+	e.Set("old", []byte("v1"))
+	for _, pair := range e.indexManager.memtable.Items() {
+		if pair.Key == "old" {
+			pair.Seq = 0
+			e.indexManager.memtable.Set(pair)
+		}
+	}
+	for _, seq := range []uint64{0, e.LastSequence()} {
+		if swapped, err := e.CompareAndSwapSeq("old", seq, []byte("v2")); swapped || !errors.As(err, new(*shared.ErrUnsequenced)) {
+			t.Errorf("CompareAndSwapSeq(%d) = %t, %v on an unnumbered key, want ErrUnsequenced", seq, swapped, err)
+		}
+	}
+	if err := e.Set("old", []byte("v2")); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+	if swapped, err := e.CompareAndSwapSeq("old", e.LastSequence(), []byte("v3")); err != nil || !swapped {
+		t.Errorf("CompareAndSwapSeq() = %t, %v once the key is written again, want swapped", swapped, err)
+	}
+}
+
+func TestCompareAndSwapSeqExpired(t *testing.T) {
+	e, err := NewEngine(t.TempDir(), *shared.NewCacheConfig().WithCacheMode(true, 20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer e.Close()
+
+	e.Set("k", []byte("v1"))
+	time.Sleep(30 * time.Millisecond)
+
+	// an expired key is missing, as Get reports it
+	if _, err := e.KeySequence("k"); !errors.As(err, new(*shared.ErrKeyNotFound)) {
+		t.Errorf("KeySequence() error = %v on an expired key, want ErrKeyNotFound", err)
+	}
+	if swapped, err := e.CompareAndSwapSeq("k", 0, []byte("v2")); err != nil || !swapped {
+		t.Errorf("CompareAndSwapSeq(0) = %t, %v on an expired key, want swapped", swapped, err)
+	}
+	if value, err := e.Get("k"); err != nil || string(value) != "v2" {
+		t.Errorf("Get() = %q, %v, want \"v2\"", value, err)
+	}
+}
@@ -1,25 +1,86 @@
 package internal
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"log"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/hasssanezzz/goldb/shared"
 )
 
+// walCompressedFlag marks a record whose value is flate compressed,
+// it is stored in the highest bit of the value size.
+const walCompressedFlag = 1 << 31
+
+// walBatchFlag marks a record holding several entries written by AppendBatch,
+// it is stored in the second highest bit of the value size.
+const walBatchFlag = 1 << 30
+
+// walMergeFlag marks a record holding a merge operand written by Engine.Merge,
+// it is stored in the third highest bit of the value size.
+const walMergeFlag = 1 << 29
+
+// walSeqFlag marks a record whose sequence number follows the size field,
+// it is stored in the fourth highest bit of the value size.
+const walSeqFlag = 1 << 28
+
+// walChecksumFlag marks a record followed by the CRC32C of its bytes,
+// it is stored in the fifth highest bit of the value size.
+const walChecksumFlag = 1 << 27
+
+// walSizeMask extracts the value size from the size field.
+const walSizeMask = 1<<27 - 1
+
+// walCompressionMinSize is the smallest value worth compressing.
+const walCompressionMinSize = 64
+
+// walWaiter is a callback waiting for the records up to sequence to be durable.
+type walWaiter struct {
+	sequence uint64
+	done     func(error)
+}
+
 type DiskWAL struct {
-	source string
-	writer io.WriteCloser
-	mu     sync.Mutex
+	source   string
+	writer   *os.File
+	compress bool
+	policy   shared.SyncPolicy
+	retry    *retrier
+	mu       sync.Mutex
+
+	appended uint64 // Number of records appended since the WAL was opened.
+	durable  uint64 // Number of those records known to be durable.
+	waiters  []walWaiter
+
+	stop chan struct{}
+	done chan struct{}
 }
 
-func NewDiskWAL(source string) (WAL, error) {
-	w := &DiskWAL{source: source}
-	return w, w.Open()
+// NewDiskWAL opens the WAL at source, if compress is set large values are
+// flate compressed per record. Compressed and plain records can be mixed
+// in one log so the option can be toggled between restarts. Appends are
+// fsynced according to policy, under shared.SyncInterval every interval.
+func NewDiskWAL(source string, compress bool, policy shared.SyncPolicy, interval time.Duration, retry *retrier) (WAL, error) {
+	w := &DiskWAL{source: source, compress: compress, policy: policy, retry: retry}
+	if err := w.Open(); err != nil {
+		return w, err
+	}
+
+	if policy == shared.SyncInterval && interval > 0 {
+		w.stop, w.done = make(chan struct{}), make(chan struct{})
+		go w.syncPeriodically(interval)
+	}
+
+	return w, nil
 }
 
 func (w *DiskWAL) Open() error {
@@ -32,100 +93,432 @@ func (w *DiskWAL) Open() error {
 }
 
 func (w *DiskWAL) Append(entry WALEntry) error {
+	var flags uint32
+	if entry.Merge {
+		flags = walMergeFlag
+	}
+	return w.append(entry.Key, entry.Value, entry.Seq, flags)
+}
+
+// AppendBatch logs the entries as a single record, a replay applies all of
+// them or, if the record was cut short by a crash, none. The entries must
+// have consecutive sequence numbers, only the first one is logged.
+func (w *DiskWAL) AppendBatch(entries []WALEntry) error {
+	var seq uint64
+	if len(entries) > 0 {
+		seq = entries[0].Seq
+	}
+	return w.append("", encodeWALBatch(entries), seq, walBatchFlag)
+}
+
+func (w *DiskWAL) append(key string, value []byte, seq uint64, flags uint32) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	buffer := make([]byte, 0, shared.KeySize+shared.UintSize+len(entry.Value))
+	if len(value) > walSizeMask {
+		return fmt.Errorf("WAL %q can not log a value of %d bytes, at most %d fit", w.source, len(value), walSizeMask)
+	}
+	flags |= walChecksumFlag
+	if seq > 0 {
+		flags |= walSeqFlag
+	}
+	sizeField := uint32(len(value)) | flags
+	if w.compress && len(value) >= walCompressionMinSize {
+		compressed, err := compressValue(value)
+		if err != nil {
+			return fmt.Errorf("WAL %q can not compress value: %v", w.source, err)
+		}
+		// keep the plain value when compression does not pay off
+		if len(compressed) < len(value) {
+			value, sizeField = compressed, uint32(len(compressed))|flags|walCompressedFlag
+		}
+	}
+
+	paddedKey, err := shared.PadKey(key)
+	if err != nil {
+		return fmt.Errorf("WAL %q can not log key: %w", w.source, err)
+	}
+	buffer := make([]byte, 0, shared.KeySize+shared.UintSize+8+len(value)+shared.UintSize)
 
 	// Key (256 bytes)
-	buffer = append(buffer, shared.KeyToBytes(entry.Key)...)
+	buffer = append(buffer, paddedKey...)
 
-	// Value size (4 bytes) - FIX: Assign the returned slice
-	buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(entry.Value)))
+	// Value size (4 bytes), the highest bits flag compression, batches, merge operands, sequence numbers and checksums
+	buffer = binary.LittleEndian.AppendUint32(buffer, sizeField)
+
+	// Sequence number (8 bytes)
+	if seq > 0 {
+		buffer = binary.LittleEndian.AppendUint64(buffer, seq)
+	}
 
 	// Value (variable length)
-	if len(entry.Value) > 0 {
-		buffer = append(buffer, entry.Value...)
+	if len(value) > 0 {
+		buffer = append(buffer, value...)
 	}
 
-	_, err := w.writer.Write(buffer)
+	// Checksum of the record (4 bytes)
+	buffer = binary.LittleEndian.AppendUint32(buffer, crc32.Checksum(buffer, crcTable))
+
+	corruptWALRecord(buffer)
+
+	// only a write that did not reach the log can be retried,
+	// repeating a partially written record would corrupt the log
+	err = w.retry.do(func() error {
+		n, err := w.writer.Write(buffer)
+		if err != nil && n > 0 {
+			return fmt.Errorf("partial write of %d bytes: %v", n, err)
+		}
+		return err
+	})
 	if err != nil {
 		return fmt.Errorf("WAL %q can not write log: %v", w.source, err)
 	}
+	w.appended++
+
+	if w.policy == shared.SyncAlways {
+		if err := w.sync(); err != nil {
+			return fmt.Errorf("WAL %q can not sync log: %v", w.source, err)
+		}
+	}
 	return nil
 }
 
-func (w *DiskWAL) Retrieve() ([]WALEntry, error) {
+// Sync fsyncs the log, making every appended record durable.
+func (w *DiskWAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.sync()
+}
+
+// OnDurable calls done once every record appended so far is durable, either
+// fsynced in the log or flushed to an SSTable and removed from it by Clear.
+// done receives the error if a sync fails and must not call back into the WAL.
+func (w *DiskWAL) OnDurable(done func(error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.durable == w.appended {
+		done(nil)
+		return
+	}
+	w.waiters = append(w.waiters, walWaiter{sequence: w.appended, done: done})
+}
+
+// Unsynced returns the number of appended records that are not durable yet.
+func (w *DiskWAL) Unsynced() uint64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.appended - w.durable
+}
+
+// sync fsyncs the log and notifies the waiters, w.mu must be held by the caller.
+// A failed fsync is not retried since the dirty pages may already be dropped.
+func (w *DiskWAL) sync() error {
+	if w.durable == w.appended {
+		return nil
+	}
+
+	if err := w.writer.Sync(); err != nil {
+		w.notify(w.appended, err)
+		return err
+	}
+
+	w.markDurable()
+	return nil
+}
+
+// markDurable marks every appended record durable, w.mu must be held by the caller.
+func (w *DiskWAL) markDurable() {
+	w.durable = w.appended
+	w.notify(w.durable, nil)
+}
+
+// notify calls and drops the waiters up to sequence, w.mu must be held by the caller.
+func (w *DiskWAL) notify(sequence uint64, err error) {
+	pending := w.waiters[:0]
+	for _, waiter := range w.waiters {
+		if waiter.sequence <= sequence {
+			waiter.done(err)
+		} else {
+			pending = append(pending, waiter)
+		}
+	}
+	w.waiters = pending
+}
+
+func (w *DiskWAL) syncPeriodically(interval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := w.Sync(); err != nil {
+			log.Printf("WAL %q background sync failed: %v", w.source, err)
+		}
+	}
+}
+
+// Replay calls fn with the logged entries one at a time in the order they
+// were appended, so recovering a large log does not hold it in memory.
+// A record cut short by a crash ends the replay.
+func (w *DiskWAL) Replay(fn func(WALEntry) error) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	// TODO: seperate decoding binary objects logic to a specialized component
-	rfile, err := os.Open(w.source)
+	var rfile *os.File
+	err := w.retry.do(func() (err error) {
+		rfile, err = os.Open(w.source)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("WAL %q can not be opened: %v", w.source, err)
+		return fmt.Errorf("WAL %q can not be opened: %v", w.source, err)
 	}
 	defer rfile.Close()
 
-	buf := bytes.NewBuffer(nil)
-	_, err = io.Copy(buf, rfile)
+	info, err := rfile.Stat()
 	if err != nil {
-		return nil, fmt.Errorf("WAL %q can not be read: %v", w.source, err)
+		return fmt.Errorf("WAL %q can not be opened: %v", w.source, err)
 	}
 
-	pairs := []WALEntry{}
-	mp := map[string][]byte{} // to get the latest values of duplicate keys
+	if err := decodeWAL(bufio.NewReader(rfile), info.Size(), fn); err != nil {
+		var corruption *shared.ErrCorruption
+		if errors.As(err, &corruption) {
+			corruption.Path = w.source
+		}
+		return fmt.Errorf("WAL %q can not be replayed: %w", w.source, err)
+	}
+	return nil
+}
 
+// decodeWAL streams the records of a log of size bytes to fn. A record cut
+// short or claiming more bytes than are left is a torn tail and ends the
+// log, value lengths are checked against the remaining size before they are
+// allocated. So does a last record not matching its checksum, one before it
+// is reported as an ErrCorruption.
+func decodeWAL(r io.Reader, size int64, fn func(WALEntry) error) error {
+	header := make([]byte, shared.KeySize+shared.UintSize)
+	seqBuffer := make([]byte, 8)
+	checksumBuffer := make([]byte, shared.UintSize)
+	logSize := size
 	for {
-		keyBytes, vlength := make([]byte, shared.KeySize), make([]byte, 4)
-
-		// Read key
-		_, err = buf.Read(keyBytes)
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return nil, fmt.Errorf("WAL %q can not be parsed: %v", w.source, err)
+		offset := logSize - size
+		// Read key and value length
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
 			}
+			return err
 		}
+		size -= int64(len(header))
 
-		// Read value length
-		_, err = buf.Read(vlength)
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return nil, fmt.Errorf("WAL %q can not be parsed: %v", w.source, err)
+		// Read sequence number
+		sizeField := binary.LittleEndian.Uint32(header[shared.KeySize:])
+		var seq uint64
+		if sizeField&walSeqFlag != 0 {
+			if _, err := io.ReadFull(r, seqBuffer); err != nil {
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					return nil
+				}
+				return err
 			}
+			size -= int64(len(seqBuffer))
+			seq = binary.LittleEndian.Uint64(seqBuffer)
 		}
 
 		// Read value
-		value := make([]byte, binary.LittleEndian.Uint32(vlength))
-		_, err = buf.Read(value)
-		if err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return nil, fmt.Errorf("WAL %q can not be parsed: %v", w.source, err)
+		valueSize := int64(sizeField & walSizeMask)
+		if valueSize > size {
+			return nil
+		}
+		value := make([]byte, valueSize)
+		if _, err := io.ReadFull(r, value); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
 			}
+			return err
 		}
+		size -= valueSize
 
-		mp[shared.TrimPaddedKey(string(keyBytes))] = value
-	}
+		// Read checksum
+		if sizeField&walChecksumFlag != 0 {
+			if _, err := io.ReadFull(r, checksumBuffer); err != nil {
+				if err == io.EOF || err == io.ErrUnexpectedEOF {
+					return nil
+				}
+				return err
+			}
+			size -= int64(len(checksumBuffer))
 
-	for key, value := range mp {
-		pairs = append(pairs, WALEntry{Key: key, Value: value})
-	}
+			checksum := crc32.Checksum(header, crcTable)
+			if sizeField&walSeqFlag != 0 {
+				checksum = crc32.Update(checksum, crcTable, seqBuffer)
+			}
+			if crc32.Update(checksum, crcTable, value) != binary.LittleEndian.Uint32(checksumBuffer) {
+				if size == 0 {
+					return nil
+				}
+				return &shared.ErrCorruption{Offset: offset, Reason: "WAL record checksum mismatch"}
+			}
+		}
+
+		if sizeField&walCompressedFlag != 0 {
+			var err error
+			value, err = decompressValue(value)
+			if err != nil {
+				return fmt.Errorf("can not decompress value: %v", err)
+			}
+		}
+
+		if sizeField&walBatchFlag != 0 {
+			entries, err := decodeWALBatch(value)
+			if err != nil {
+				return fmt.Errorf("can not decode batch: %v", err)
+			}
+			for i, entry := range entries {
+				if seq > 0 {
+					entry.Seq = seq + uint64(i)
+				}
+				if err := fn(entry); err != nil {
+					return err
+				}
+			}
+			continue
+		}
 
-	return pairs, nil
+		entry := WALEntry{Key: shared.TrimPaddedKey(string(header[:shared.KeySize])), Value: value, Merge: sizeField&walMergeFlag != 0, Seq: seq}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
 }
 
+// Clear truncates the log once its records were flushed to a durable SSTable.
 func (w *DiskWAL) Clear() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
-	return os.Truncate(w.source, 0)
+	if err := os.Truncate(w.source, 0); err != nil {
+		return err
+	}
+
+	w.markDurable()
+	return nil
 }
 
+// Close syncs pending records, under every policy, and closes the log.
 func (w *DiskWAL) Close() error {
+	if w.stop != nil {
+		close(w.stop)
+		<-w.done
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.sync(); err != nil {
+		w.writer.Close()
+		return fmt.Errorf("WAL %q can not sync log before closing: %v", w.source, err)
+	}
 	return w.writer.Close()
 }
+
+// encodeWALBatch packs the entries as
+// <count uint32>{<key length uint32><value length uint32><key><value>}.
+func encodeWALBatch(entries []WALEntry) []byte {
+	size := shared.UintSize
+	for _, entry := range entries {
+		size += 2*shared.UintSize + len(entry.Key) + len(entry.Value)
+	}
+
+	buffer := make([]byte, 0, size)
+	buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(entries)))
+	for _, entry := range entries {
+		buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(entry.Key)))
+		buffer = binary.LittleEndian.AppendUint32(buffer, uint32(len(entry.Value)))
+		buffer = append(buffer, entry.Key...)
+		buffer = append(buffer, entry.Value...)
+	}
+	return buffer
+}
+
+func decodeWALBatch(data []byte) ([]WALEntry, error) {
+	if len(data) < shared.UintSize {
+		return nil, fmt.Errorf("batch of %d bytes has no entry count", len(data))
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[shared.UintSize:]
+
+	// every entry takes at least its two lengths, a larger count is corrupt
+	if uint64(count)*2*shared.UintSize > uint64(len(data)) {
+		return nil, fmt.Errorf("batch of %d entries does not fit in %d bytes", count, len(data))
+	}
+
+	entries := make([]WALEntry, 0, count)
+	for range count {
+		if len(data) < 2*shared.UintSize {
+			return nil, fmt.Errorf("batch entry %d is cut short", len(entries))
+		}
+		keySize := int(binary.LittleEndian.Uint32(data))
+		valueSize := int(binary.LittleEndian.Uint32(data[shared.UintSize:]))
+		data = data[2*shared.UintSize:]
+		if keySize > len(data) || valueSize > len(data)-keySize {
+			return nil, fmt.Errorf("batch entry %d is cut short", len(entries))
+		}
+
+		entries = append(entries, WALEntry{Key: string(data[:keySize]), Value: data[keySize : keySize+valueSize]})
+		data = data[keySize+valueSize:]
+	}
+	return entries, nil
+}
+
+func compressValue(value []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	writer, err := flate.NewWriter(buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := writer.Write(value); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressValue inflates a value, refusing outputs larger than a record
+// could hold.
+func decompressValue(value []byte) ([]byte, error) {
+	reader := flate.NewReader(bytes.NewReader(value))
+	defer reader.Close()
+
+	inflated, err := io.ReadAll(io.LimitReader(reader, walSizeMask+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(inflated) > walSizeMask {
+		return nil, fmt.Errorf("value inflates past %d bytes", walSizeMask)
+	}
+	return inflated, nil
+}
+
+// nopWAL discards every entry, it is used when the engine is opened read-only.
+type nopWAL struct{}
+
+func (nopWAL) Append(WALEntry) error             { return nil }
+func (nopWAL) AppendBatch([]WALEntry) error      { return nil }
+func (nopWAL) Replay(func(WALEntry) error) error { return nil }
+func (nopWAL) Sync() error                       { return nil }
+func (nopWAL) OnDurable(done func(error))        { done(nil) }
+func (nopWAL) Unsynced() uint64                  { return 0 }
+func (nopWAL) Clear() error                      { return nil }
+func (nopWAL) Close() error                      { return nil }
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestWALOnDurable(t *testing.T) {
+	wal, err := NewDiskWAL(filepath.Join(t.TempDir(), WALFileName), false, shared.SyncNever, 0, nil)
+	if err != nil {
+		t.Fatalf("NewDiskWAL() error: %v", err)
+	}
+	defer wal.Close()
+
+	called := 0
+	wal.OnDurable(func(err error) { called++ })
+	if called != 1 {
+		t.Fatalf("OnDurable() on an empty log called back %d times, want 1", called)
+	}
+
+	if err := wal.Append(WALEntry{Key: "key", Value: []byte("value")}); err != nil {
+		t.Fatalf("Append() error: %v", err)
+	}
+
+	var got error
+	wal.OnDurable(func(err error) { called++; got = err })
+	if called != 1 {
+		t.Fatalf("OnDurable() called back before the record was synced")
+	}
+
+	if err := wal.Sync(); err != nil {
+		t.Fatalf("Sync() error: %v", err)
+	}
+	if called != 2 || got != nil {
+		t.Errorf("OnDurable() called back %d times with %v after Sync, want 2 and nil", called, got)
+	}
+}
+
+func TestWALReplayBounded(t *testing.T) {
+	home := t.TempDir()
+	wal, err := NewDiskWAL(filepath.Join(home, WALFileName), true, shared.SyncNever, 0, nil)
+	if err != nil {
+		t.Fatalf("NewDiskWAL() error: %v", err)
+	}
+	for i := range 25 {
+		if err := wal.Append(WALEntry{Key: fmt.Sprintf("key%02d", i), Value: bytes.Repeat([]byte{'v'}, 100)}); err != nil {
+			t.Fatalf("Append() error: %v", err)
+		}
+	}
+	wal.Append(WALEntry{Key: "key00"})
+	wal.Close()
+
+	// a torn record at the tail must not fail the replay
+	file, _ := os.OpenFile(filepath.Join(home, WALFileName), os.O_APPEND|os.O_WRONLY, 0644)
+	file.Write([]byte("torn"))
+	file.Close()
+
+	engine, err := NewEngine(home, *shared.NewEngineConfig().WithMemtableSizeThreshold(10))
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	if tables := len(engine.indexManager.sstables); tables != 2 {
+		t.Errorf("replay flushed %d tables, want 2", tables)
+	}
+	if _, err := engine.Get("key00"); err == nil {
+		t.Errorf("Get(key00) found a key deleted in the WAL")
+	}
+	for i := 1; i < 25; i++ {
+		if _, err := engine.Get(fmt.Sprintf("key%02d", i)); err != nil {
+			t.Errorf("Get(key%02d) error: %v", i, err)
+		}
+	}
+}
+
+func TestWALChecksums(t *testing.T) {
+	path := filepath.Join(t.TempDir(), WALFileName)
+	wal, err := NewDiskWAL(path, false, shared.SyncNever, 0, nil)
+	if err != nil {
+		t.Fatalf("NewDiskWAL() error: %v", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		wal.Append(WALEntry{Key: key, Value: []byte("value")})
+	}
+	wal.Close()
+
+	log, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	recordSize := len(log) / 3
+
+	replay := func(data []byte) ([]string, error) {
+		keys := []string{}
+		err := decodeWAL(bytes.NewReader(data), int64(len(data)), func(entry WALEntry) error {
+			keys = append(keys, entry.Key)
+			return nil
+		})
+		return keys, err
+	}
+
+	// a record in the middle of the log is corrupt
+	corrupted := bytes.Clone(log)
+	corrupted[2*recordSize-2] ^= 0xFF
+	keys, err := replay(corrupted)
+	var corruption *shared.ErrCorruption
+	if !errors.As(err, &corruption) || corruption.Offset != int64(recordSize) || len(keys) != 1 {
+		t.Errorf("replay of a corrupt record = %q, %v, want a, ErrCorruption at %d", keys, err, recordSize)
+	}
+
+	// the last record was torn by a crash
+	corrupted = bytes.Clone(log)
+	corrupted[len(log)-2] ^= 0xFF
+	if keys, err := replay(corrupted); err != nil || len(keys) != 2 {
+		t.Errorf("replay of a torn tail = %q, %v, want a and b", keys, err)
+	}
+
+	// records written before checksums are still replayed
+	legacy, _ := shared.PadKey("old")
+	legacy = binary.LittleEndian.AppendUint32(legacy, 5)
+	legacy = append(legacy, "value"...)
+	if keys, err := replay(append(legacy, log...)); err != nil || len(keys) != 4 || keys[0] != "old" {
+		t.Errorf("replay of a legacy record = %q, %v", keys, err)
+	}
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// AccessLogName is the file listing the table blocks read the most before the
+// engine was closed, read back by Warmup.
+const AccessLogName = "access.json"
+
+// accessSampling is the share of the block reads counted, one in this many.
+const accessSampling = 8
+
+// BlockRef is a region of a table file read by lookups, a block or the pairs
+// between two fences.
+type BlockRef struct {
+	Table  string `json:"table"` // File name of the table.
+	Offset int64  `json:"offset"`
+	Size   int    `json:"size"`
+}
+
+// accessLog counts the reads of a sample of the table blocks read by lookups
+// so the most read ones can be read back into the page cache after a restart.
+// A nil *accessLog counts nothing.
+type accessLog struct {
+	path     string
+	capacity int // Blocks written to the log, twice as many are counted.
+	reads    atomic.Uint64
+
+	mu     sync.Mutex
+	blocks map[BlockRef]uint32
+}
+
+// newAccessLog logs the capacity most read blocks to homepath, it returns nil
+// if capacity is zero.
+func newAccessLog(homepath string, capacity uint32) *accessLog {
+	if capacity == 0 {
+		return nil
+	}
+	return &accessLog{path: filepath.Join(homepath, AccessLogName), capacity: int(capacity), blocks: map[BlockRef]uint32{}}
+}
+
+// read counts a read of size bytes at offset of the table file at path if it
+// is sampled.
+func (a *accessLog) read(path string, offset int64, size int) {
+	if a == nil || a.reads.Add(1)%accessSampling != 0 {
+		return
+	}
+
+	block := BlockRef{Table: filepath.Base(path), Offset: offset, Size: size}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if _, ok := a.blocks[block]; !ok && len(a.blocks) >= 2*a.capacity {
+		// blocks read once since the last halving make room for new ones
+		for block, count := range a.blocks {
+			if count /= 2; count == 0 {
+				delete(a.blocks, block)
+				continue
+			}
+			a.blocks[block] = count
+		}
+		if len(a.blocks) >= 2*a.capacity {
+			return
+		}
+	}
+	a.blocks[block]++
+}
+
+// save writes the most read blocks to the log, most read first. The previous
+// log is kept if no block was read since open.
+func (a *accessLog) save() error {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	blocks := make([]BlockRef, 0, len(a.blocks))
+	for block := range a.blocks {
+		blocks = append(blocks, block)
+	}
+	slices.SortFunc(blocks, func(x, y BlockRef) int {
+		if c := cmp.Compare(a.blocks[y], a.blocks[x]); c != 0 {
+			return c
+		}
+		return cmp.Or(cmp.Compare(x.Table, y.Table), cmp.Compare(x.Offset, y.Offset))
+	})
+	a.mu.Unlock()
+
+	if len(blocks) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(blocks[:min(len(blocks), a.capacity)])
+	if err != nil {
+		return err
+	}
+
+	tmp := a.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("can not write the access log: %v", err)
+	}
+	if err := os.Rename(tmp, a.path); err != nil {
+		return fmt.Errorf("can not replace the access log: %v", err)
+	}
+	return syncDir(filepath.Dir(a.path))
+}
+
+// readAccessLog returns the blocks of the access log in homepath, none if
+// there is no log.
+func readAccessLog(homepath string) ([]BlockRef, error) {
+	data, err := os.ReadFile(filepath.Join(homepath, AccessLogName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	blocks := []BlockRef{}
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return nil, fmt.Errorf("can not parse the access log: %v", err)
+	}
+	return blocks, nil
+}
+
+// Warmup reads the blocks of the access log, see Config.PrimeBlocks, so the
+// page cache holds them before lookups need them. Blocks of tables compacted
+// away since the log was written are skipped. It returns the number of blocks
+// read, stopping early if the engine is closed.
+func (e *Engine) Warmup() (int, error) {
+	blocks, err := readAccessLog(e.Config.Homepath)
+	if err != nil || len(blocks) == 0 {
+		return 0, err
+	}
+
+	im := e.indexManager
+	im.mu.RLock()
+	tables := im.acquireTables()
+	im.mu.RUnlock()
+	defer releaseTables(tables)
+
+	byName := make(map[string]*SSTable, len(tables))
+	for _, table := range tables {
+		byName[filepath.Base(table.metadata.Path)] = table
+	}
+
+	primed, buf := 0, []byte{}
+	for _, block := range blocks {
+		select {
+		case <-e.closing:
+			return primed, nil
+		default:
+		}
+
+		table, ok := byName[block.Table]
+		if !ok || block.Offset < 0 || block.Size <= 0 {
+			continue
+		}
+		e.io.background()
+		buf = slices.Grow(buf[:0], block.Size)[:block.Size]
+		if err := table.readAt(buf, block.Offset); err != nil {
+			log.Printf("engine can not warm up block (%d, %d) of %q: %v", block.Offset, block.Size, block.Table, err)
+			continue
+		}
+		primed++
+	}
+	e.primed.Add(uint64(primed))
+	return primed, nil
+}
+
+// warmup runs Warmup in the background while the engine reports StateWarming.
+func (e *Engine) warmup() {
+	defer e.warming.Done()
+
+	primed, err := e.Warmup()
+	if err != nil {
+		log.Printf("engine can not warm up the page cache: %v", err)
+	} else if e.Debug() {
+		log.Printf("engine warmed up %d blocks", primed)
+	}
+	e.state.CompareAndSwap(uint32(StateWarming), uint32(StateServing))
+}
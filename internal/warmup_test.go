@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestWarmup(t *testing.T) {
+	dir := t.TempDir()
+	config := *shared.NewEngineConfig().WithPrimeBlocks(4)
+	engine, err := NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+
+	for i := range 200 {
+		if err := engine.Set(fmt.Sprintf("key%03d", i), []byte("value")); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+	}
+	if err := engine.indexManager.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+	// the row cache would answer the repeated lookups without reading a block
+	for range 100 {
+		for _, key := range []string{"key000", "key150"} {
+			engine.rows.Invalidate(key)
+			if _, err := engine.Get(key); err != nil {
+				t.Fatalf("Get(%q) error: %v", key, err)
+			}
+		}
+	}
+	if err := engine.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	blocks, err := readAccessLog(dir)
+	if err != nil || len(blocks) == 0 || len(blocks) > 4 {
+		t.Fatalf("readAccessLog() = %+v, %v, want 1 to 4 blocks", blocks, err)
+	}
+
+	// the logged blocks are read back in the background on open
+	engine, err = NewEngine(dir, config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+	for deadline := time.Now().Add(5 * time.Second); engine.State() == StateWarming; {
+		if time.Now().After(deadline) {
+			t.Fatalf("engine still warming after 5s")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if state, primed := engine.State(), engine.Stats().PrimedBlocks; state != StateServing || primed != uint64(len(blocks)) {
+		t.Errorf("state = %v with %d blocks primed, want serving with %d", state, primed, len(blocks))
+	}
+
+	// blocks of tables gone since the log was written are skipped
+	if err := os.WriteFile(filepath.Join(dir, AccessLogName), []byte(`[{"table":"sst_999","offset":0,"size":10}]`), 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+	if primed, err := engine.Warmup(); err != nil || primed != 0 {
+		t.Errorf("Warmup() = %d, %v, want nothing read", primed, err)
+	}
+}
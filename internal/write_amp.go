@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	// tableEntryOverhead is the approximate size of a table entry besides
+	// its key: the position of the value and the sequence number.
+	tableEntryOverhead = 16
+	// maxKeyspaces bounds the keyspaces tracked, the keys of the others
+	// count under the empty keyspace.
+	maxKeyspaces = 1024
+)
+
+// KeyspaceWrites is the write amplification of the keys of a keyspace since
+// the engine was opened.
+type KeyspaceWrites struct {
+	Keyspace       string  `json:"keyspace"`
+	IngestedBytes  uint64  `json:"ingested_bytes"`  // Keys and values written by users.
+	FlushedBytes   uint64  `json:"flushed_bytes"`   // Table entries written by memtable flushes.
+	CompactedBytes uint64  `json:"compacted_bytes"` // Table entries rewritten by merges and compactions.
+	Amplification  float64 `json:"amplification"`   // Bytes written to the data file and tables per byte ingested.
+}
+
+// writeAmp counts the bytes written per keyspace, the part of the keys up to
+// and including the first separator. Keys without it count under the empty
+// keyspace. A nil *writeAmp counts nothing.
+type writeAmp struct {
+	separator string
+
+	mu        sync.Mutex
+	keyspaces map[string]*KeyspaceWrites
+}
+
+// newWriteAmp returns a tracker of the keyspaces split by separator, nil if
+// separator is empty.
+func newWriteAmp(separator string) *writeAmp {
+	if separator == "" {
+		return nil
+	}
+	return &writeAmp{separator: separator, keyspaces: map[string]*KeyspaceWrites{}}
+}
+
+// keyspace returns the counts of the keyspace of key, w.mu must be held by
+// the caller.
+func (w *writeAmp) keyspace(key string) *KeyspaceWrites {
+	name := ""
+	if i := strings.Index(key, w.separator); i >= 0 {
+		name = key[:i+len(w.separator)]
+	}
+
+	counts, ok := w.keyspaces[name]
+	if !ok {
+		if len(w.keyspaces) >= maxKeyspaces {
+			return w.keyspace("")
+		}
+		counts = &KeyspaceWrites{Keyspace: strings.Clone(name)}
+		w.keyspaces[counts.Keyspace] = counts
+	}
+	return counts
+}
+
+// ingested counts a write of key by a user, size bytes long with its value.
+func (w *writeAmp) ingested(key string, size int) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.keyspace(key).IngestedBytes += uint64(size)
+}
+
+// written counts the pairs written to a table by a flush, or rewritten by a
+// merge or compaction if flushed is false.
+func (w *writeAmp) written(pairs []KVPair, flushed bool) {
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, pair := range pairs {
+		counts := w.keyspace(pair.Key)
+		if flushed {
+			counts.FlushedBytes += uint64(len(pair.Key) + tableEntryOverhead)
+		} else {
+			counts.CompactedBytes += uint64(len(pair.Key) + tableEntryOverhead)
+		}
+	}
+}
+
+// report returns the counts of every keyspace, the most rewritten first.
+func (w *writeAmp) report() []KeyspaceWrites {
+	if w == nil {
+		return nil
+	}
+
+	w.mu.Lock()
+	report := make([]KeyspaceWrites, 0, len(w.keyspaces))
+	for _, counts := range w.keyspaces {
+		keyspace := *counts
+		if keyspace.IngestedBytes > 0 {
+			keyspace.Amplification = float64(keyspace.IngestedBytes+keyspace.FlushedBytes+keyspace.CompactedBytes) / float64(keyspace.IngestedBytes)
+		}
+		report = append(report, keyspace)
+	}
+	w.mu.Unlock()
+
+	slices.SortFunc(report, func(a, b KeyspaceWrites) int {
+		if c := cmp.Compare(b.FlushedBytes+b.CompactedBytes, a.FlushedBytes+a.CompactedBytes); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Keyspace, b.Keyspace)
+	})
+	return report
+}
+
+// WriteAmplification returns the bytes ingested and rewritten per keyspace
+// since open, the most rewritten first, to find the keys driving the write
+// amplification. It is empty unless Config.WriteAmpSeparator is set.
+func (e *Engine) WriteAmplification() []KeyspaceWrites {
+	return e.indexManager.writeAmp.report()
+}
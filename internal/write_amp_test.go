@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+func TestWriteAmplification(t *testing.T) {
+	config := shared.NewEngineConfig().WithWriteAmpSeparator(":")
+	config.MemtableSizeThreshold = 10
+	config.CompactionThreshold = 2
+	engine, err := NewEngine(t.TempDir(), *config)
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	// the same ten keys of "hot:" are overwritten while "cold:" keys are written once
+	for i := range 100 {
+		if err := engine.Set(fmt.Sprintf("hot:%d", i%10), []byte("value")); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+	}
+	for i := range 20 {
+		if err := engine.Set(fmt.Sprintf("cold:%03d", i), []byte("value")); err != nil {
+			t.Fatalf("Set() error: %v", err)
+		}
+	}
+	if err := engine.Set("plain", []byte("value")); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+
+	report := engine.WriteAmplification()
+	keyspaces := map[string]KeyspaceWrites{}
+	for _, keyspace := range report {
+		keyspaces[keyspace.Keyspace] = keyspace
+	}
+	if len(keyspaces) != 3 {
+		t.Fatalf("WriteAmplification() = %+v, want the keyspaces \"hot:\", \"cold:\" and \"\"", report)
+	}
+
+	hot, cold := keyspaces["hot:"], keyspaces["cold:"]
+	if want := uint64(100 * len("hot:0value")); hot.IngestedBytes != want {
+		t.Errorf("hot:.IngestedBytes = %d, want %d", hot.IngestedBytes, want)
+	}
+	if hot.FlushedBytes == 0 || hot.CompactedBytes == 0 {
+		t.Errorf("hot: = %+v, want bytes flushed and compacted", hot)
+	}
+	if cold.Amplification <= 1 {
+		t.Errorf("cold:.Amplification = %v, want above 1", cold.Amplification)
+	}
+	if report[0].Keyspace != "hot:" {
+		t.Errorf("WriteAmplification()[0] = %+v, want the most rewritten keyspace \"hot:\" first", report[0])
+	}
+	if plain := keyspaces[""]; plain.IngestedBytes != uint64(len("plainvalue")) {
+		t.Errorf("\"\".IngestedBytes = %d, want %d", plain.IngestedBytes, len("plainvalue"))
+	}
+}
+
+func TestWriteAmplificationDisabled(t *testing.T) {
+	engine, err := NewEngine(t.TempDir(), *shared.NewEngineConfig())
+	if err != nil {
+		t.Fatalf("NewEngine() error: %v", err)
+	}
+	defer engine.Close()
+
+	if err := engine.Set("a:b", []byte("value")); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+	if report := engine.WriteAmplification(); len(report) != 0 {
+		t.Errorf("WriteAmplification() = %+v, want nothing without a separator", report)
+	}
+}
@@ -0,0 +1,764 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Zstandard frame format, see RFC 8878. zstdEncode writes a subset of it: a
+// single segment frame without checksum whose compressed blocks store their
+// literals raw and code their sequences with the predefined distributions, so
+// the frame holds neither Huffman nor FSE tables. zstdDecode reads that subset,
+// RLE literals and sequence codes included. Both take an optional raw content
+// dictionary, data preceding the frame that matches may refer to.
+const (
+	zstdMagic        = 0xFD2FB528
+	zstdMaxBlockSize = 128 << 10
+	zstdMinMatch     = 4 // The format allows 3, matches are found by hashing 4 bytes.
+	zstdMaxOffset    = 1<<28 - 1
+	zstdTableBits    = 14
+
+	zstdBlockRaw        = 0
+	zstdBlockRLE        = 1
+	zstdBlockCompressed = 2
+
+	zstdLiteralsRaw = 0
+	zstdLiteralsRLE = 1
+
+	zstdModePredefined = 0
+	zstdModeRLE        = 1
+	zstdModeRepeat     = 3
+)
+
+// zstdCode is a literals or match length code, the length is baseline plus
+// the bits extra bits following the code.
+type zstdCode struct {
+	baseline uint32
+	bits     uint8
+}
+
+var (
+	zstdLiteralsLengthCodes = zstdCodes(16, 0, []zstdCode{
+		{16, 1}, {18, 1}, {20, 1}, {22, 1}, {24, 2}, {28, 2}, {32, 3}, {40, 3},
+		{48, 4}, {64, 6}, {128, 7}, {256, 8}, {512, 9}, {1024, 10}, {2048, 11},
+		{4096, 12}, {8192, 13}, {16384, 14}, {32768, 15}, {65536, 16},
+	})
+	zstdMatchLengthCodes = zstdCodes(32, 3, []zstdCode{
+		{35, 1}, {37, 1}, {39, 1}, {41, 1}, {43, 2}, {47, 2}, {51, 3}, {59, 3},
+		{67, 4}, {83, 4}, {99, 5}, {131, 7}, {259, 8}, {515, 9}, {1027, 10},
+		{2051, 11}, {4099, 12}, {8195, 13}, {16387, 14}, {32771, 15}, {65539, 16},
+	})
+)
+
+// zstdCodes returns direct codes standing for their value plus base, followed by rest.
+func zstdCodes(direct int, base uint32, rest []zstdCode) []zstdCode {
+	codes := make([]zstdCode, 0, direct+len(rest))
+	for i := range direct {
+		codes = append(codes, zstdCode{uint32(i) + base, 0})
+	}
+	return append(codes, rest...)
+}
+
+// zstdEncodeLength returns the code of a length and its extra bits.
+func zstdEncodeLength(codes []zstdCode, length uint32) (uint8, uint32) {
+	code := len(codes) - 1
+	for codes[code].baseline > length {
+		code--
+	}
+	return uint8(code), length - codes[code].baseline
+}
+
+// Predefined distributions of the sequence codes, -1 stands for a probability
+// lower than 1.
+var (
+	zstdLiteralsLengthTable = newFSETable([]int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1, 2, 2, 2, 2, 2, 2, 2, 2,
+		2, 3, 2, 1, 1, 1, 1, 1, -1, -1, -1, -1,
+	}, 6)
+	zstdMatchLengthTable = newFSETable([]int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}, 6)
+	zstdOffsetTable = newFSETable([]int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1, -1,
+	}, 5)
+)
+
+// fseState is a state of an FSE table: it decodes symbol, then the next
+// state is baseline plus the next bits bits.
+type fseState struct {
+	symbol   uint8
+	bits     uint8
+	baseline uint16
+}
+
+type fseTable struct {
+	accuracyLog uint8
+	states      []fseState
+	// encode[symbol][next] is the state decoding symbol whose range holds
+	// the state next, the states of a symbol split the table between them.
+	encode [][]uint16
+}
+
+// newFSETable builds the table of a distribution over 1<<accuracyLog states.
+func newFSETable(distribution []int16, accuracyLog uint8) *fseTable {
+	size := 1 << accuracyLog
+	t := &fseTable{accuracyLog: accuracyLog, states: make([]fseState, size), encode: make([][]uint16, len(distribution))}
+
+	// symbols less probable than 1 take the last states, the others are spread
+	next := make([]int, len(distribution))
+	high := size - 1
+	for symbol, count := range distribution {
+		if count == -1 {
+			t.states[high].symbol = uint8(symbol)
+			high--
+			next[symbol] = 1
+		} else {
+			next[symbol] = int(count)
+		}
+	}
+	position, step := 0, size>>1+size>>3+3
+	for symbol, count := range distribution {
+		for range max(count, 0) {
+			t.states[position].symbol = uint8(symbol)
+			for position = (position + step) & (size - 1); position > high; {
+				position = (position + step) & (size - 1)
+			}
+		}
+	}
+
+	for i := range t.states {
+		state := &t.states[i]
+		n := next[state.symbol]
+		next[state.symbol]++
+		state.bits = accuracyLog - uint8(bits.Len(uint(n))-1)
+		state.baseline = uint16(n<<state.bits - size)
+
+		if t.encode[state.symbol] == nil {
+			t.encode[state.symbol] = make([]uint16, size)
+		}
+		for j := range 1 << state.bits {
+			t.encode[state.symbol][int(state.baseline)+j] = uint16(i)
+		}
+	}
+	return t
+}
+
+// rleFSETable returns the table of a single symbol.
+func rleFSETable(symbol uint8) *fseTable {
+	return &fseTable{states: []fseState{{symbol: symbol}}}
+}
+
+// zstdSequence copies litLen literals then matchLen bytes found offset bytes back.
+type zstdSequence struct {
+	litLen, matchLen, offset uint32
+}
+
+// zstdEncode appends the zstd frame of src to dst, matches may refer to dict.
+func zstdEncode(dst, src, dict []byte) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, zstdMagic)
+
+	// a single segment frame, the content size stands for the window size
+	switch {
+	case len(src) < 256:
+		dst = append(dst, 1<<5, byte(len(src)))
+	case len(src) < 1<<16+256:
+		dst = append(dst, 1<<6|1<<5)
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(len(src)-256))
+	default:
+		dst = append(dst, 2<<6|1<<5)
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(src)))
+	}
+
+	m := newZstdMatcher(src, dict)
+
+	repeats := zstdInitialRepeats
+	for start := 0; ; start += zstdMaxBlockSize {
+		end := min(start+zstdMaxBlockSize, len(src))
+		last := end == len(src)
+		// raw blocks leave the repeated offsets as they were
+		next := repeats
+		body := m.compressBlock(&next, len(dict)+start, len(dict)+end)
+		if len(body) < end-start {
+			dst = zstdBlockHeader(dst, last, zstdBlockCompressed, len(body))
+			dst = append(dst, body...)
+			repeats = next
+		} else {
+			dst = zstdBlockHeader(dst, last, zstdBlockRaw, end-start)
+			dst = append(dst, src[start:end]...)
+		}
+		if last {
+			return dst
+		}
+	}
+}
+
+func zstdBlockHeader(dst []byte, last bool, kind, size int) []byte {
+	header := uint32(size)<<3 | uint32(kind)<<1
+	if last {
+		header |= 1
+	}
+	return append(dst, byte(header), byte(header>>8), byte(header>>16))
+}
+
+// zstdMatcher finds matches in the data and in the dictionary preceding it,
+// which are hashed apart so the dictionary never hides a closer match.
+type zstdMatcher struct {
+	history []byte // The dictionary followed by the data.
+	// Position+1 of the last 4 bytes hashed to each entry.
+	table, dictTable [1 << zstdTableBits]int32
+}
+
+func newZstdMatcher(src, dict []byte) *zstdMatcher {
+	m := &zstdMatcher{history: make([]byte, 0, len(dict)+len(src))}
+	m.history = append(append(m.history, dict...), src...)
+	for i := 0; i+zstdMinMatch <= len(dict); i++ {
+		m.dictTable[m.hash(i)] = int32(i + 1)
+	}
+	return m
+}
+
+func (m *zstdMatcher) hash(i int) uint32 {
+	return matchHash(binary.LittleEndian.Uint32(m.history[i:]), zstdTableBits)
+}
+
+// find returns the longest match of the bytes at i ending before end, trying
+// the last offset first as repeating it is the cheapest, and hashes i.
+func (m *zstdMatcher) find(i, end, last int) (offset, length int) {
+	h := m.hash(i)
+	for _, candidate := range []int{i - last, int(m.table[h]) - 1, int(m.dictTable[h]) - 1} {
+		if candidate < 0 || candidate >= i || i-candidate > zstdMaxOffset {
+			continue
+		}
+		n := 0
+		for i+n < end && m.history[candidate+n] == m.history[i+n] {
+			n++
+		}
+		if n >= zstdMinMatch && n > length {
+			offset, length = i-candidate, n
+		}
+	}
+	m.table[h] = int32(i + 1)
+	return offset, length
+}
+
+// compressBlock returns the body of a compressed block holding
+// history[start:end], matches are taken lazily: a match is dropped for a
+// longer one starting at the next byte. The repeated offsets are updated as
+// the decoder will once it reads the block.
+func (m *zstdMatcher) compressBlock(repeats *[3]uint32, start, end int) []byte {
+	literals := []byte{}
+	sequences := []zstdSequence{}
+	anchor, last := start, int(repeats[0])
+	for i := start; i+zstdMinMatch <= end; {
+		offset, length := m.find(i, end, last)
+		if length == 0 {
+			i++
+			continue
+		}
+		if i+1+zstdMinMatch <= end {
+			if nextOffset, nextLength := m.find(i+1, end, last); nextLength > length {
+				i, offset, length = i+1, nextOffset, nextLength
+			}
+		}
+
+		literals = append(literals, m.history[anchor:i]...)
+		sequences = append(sequences, zstdSequence{uint32(i - anchor), uint32(length), uint32(offset)})
+		last = offset
+
+		// the matched bytes are hashed too, later matches are found closer
+		for j := i + 1; j < i+length && j+zstdMinMatch <= end; j++ {
+			m.table[m.hash(j)] = int32(j + 1)
+		}
+		i += length
+		anchor = i
+	}
+	literals = append(literals, m.history[anchor:end]...)
+
+	// raw literals, their size on 5, 12 or 20 bits
+	n := len(literals)
+	var body []byte
+	switch {
+	case n < 1<<5:
+		body = append(body, byte(n<<3)|zstdLiteralsRaw)
+	case n < 1<<12:
+		body = append(body, byte(n<<4)|1<<2|zstdLiteralsRaw, byte(n>>4))
+	default:
+		body = append(body, byte(n<<4)|3<<2|zstdLiteralsRaw, byte(n>>4), byte(n>>12))
+	}
+	body = append(body, literals...)
+
+	n = len(sequences)
+	switch {
+	case n < 128:
+		body = append(body, byte(n))
+	case n < 0x7F00:
+		body = append(body, byte(n>>8)+128, byte(n))
+	default:
+		body = append(body, 255)
+		body = binary.LittleEndian.AppendUint16(body, uint16(n-0x7F00))
+	}
+	if n == 0 {
+		return body
+	}
+	body = append(body, zstdModePredefined<<6|zstdModePredefined<<4|zstdModePredefined<<2)
+	return zstdEncodeSequences(body, sequences, repeats)
+}
+
+// zstdEncodeSequences appends the bitstream of the sequences, read backwards
+// by the decoder so it is written from the last sequence to the first.
+func zstdEncodeSequences(dst []byte, sequences []zstdSequence, repeats *[3]uint32) []byte {
+	type codes struct {
+		ll, ml, of                uint8
+		llExtra, mlExtra, ofExtra uint32
+	}
+	coded := make([]codes, len(sequences))
+	for i, seq := range sequences {
+		c := &coded[i]
+		c.ll, c.llExtra = zstdEncodeLength(zstdLiteralsLengthCodes, seq.litLen)
+		c.ml, c.mlExtra = zstdEncodeLength(zstdMatchLengthCodes, seq.matchLen)
+		value := zstdOffsetValue(seq.offset, seq.litLen, repeats)
+		c.of = uint8(bits.Len32(value) - 1)
+		c.ofExtra = value - 1<<c.of
+	}
+
+	ll, ml, of := zstdLiteralsLengthTable, zstdMatchLengthTable, zstdOffsetTable
+	last := coded[len(coded)-1]
+	llState, mlState, ofState := ll.encode[last.ll][0], ml.encode[last.ml][0], of.encode[last.of][0]
+
+	w := bitWriter{out: dst}
+	for i := len(coded) - 1; i >= 0; i-- {
+		c := coded[i]
+		if i < len(coded)-1 {
+			// the states of this sequence lead to those of the next one
+			ofState = w.transition(of, c.of, ofState)
+			mlState = w.transition(ml, c.ml, mlState)
+			llState = w.transition(ll, c.ll, llState)
+		}
+		w.add(uint64(c.llExtra), zstdLiteralsLengthCodes[c.ll].bits)
+		w.add(uint64(c.mlExtra), zstdMatchLengthCodes[c.ml].bits)
+		w.add(uint64(c.ofExtra), c.of)
+	}
+	w.add(uint64(mlState), ml.accuracyLog)
+	w.add(uint64(ofState), of.accuracyLog)
+	w.add(uint64(llState), ll.accuracyLog)
+	return w.close()
+}
+
+// bitWriter writes a bitstream read backwards by backwardReader.
+type bitWriter struct {
+	out   []byte
+	acc   uint64
+	count uint8
+}
+
+func (w *bitWriter) add(value uint64, n uint8) {
+	w.acc |= (value & (1<<n - 1)) << w.count
+	for w.count += n; w.count >= 8; w.count -= 8 {
+		w.out = append(w.out, byte(w.acc))
+		w.acc >>= 8
+	}
+}
+
+// transition writes the bits leading from the state decoding symbol to the
+// state next, and returns that state.
+func (w *bitWriter) transition(t *fseTable, symbol uint8, next uint16) uint16 {
+	state := t.encode[symbol][next]
+	w.add(uint64(next-t.states[state].baseline), t.states[state].bits)
+	return state
+}
+
+// close ends the stream with a set bit marking where the reader starts.
+func (w *bitWriter) close() []byte {
+	w.add(1, 1)
+	if w.count > 0 {
+		w.out = append(w.out, byte(w.acc))
+	}
+	return w.out
+}
+
+var errZstdCorrupt = errors.New("zstd: corrupt input")
+
+// backwardReader reads a bitstream from its end, after the marker bit.
+type backwardReader struct {
+	data []byte
+	left int // Bits left to read.
+}
+
+func newBackwardReader(data []byte) (*backwardReader, error) {
+	if len(data) == 0 || data[len(data)-1] == 0 {
+		return nil, errZstdCorrupt
+	}
+	return &backwardReader{data: data, left: (len(data)-1)*8 + bits.Len8(data[len(data)-1]) - 1}, nil
+}
+
+func (r *backwardReader) read(n uint8) (uint32, error) {
+	if int(n) > r.left {
+		return 0, errZstdCorrupt
+	}
+	r.left -= int(n)
+	first := r.left / 8
+	var word uint64
+	for i := min(first+8, len(r.data)) - 1; i >= first; i-- {
+		word = word<<8 | uint64(r.data[i])
+	}
+	return uint32(word >> (r.left % 8) & (1<<n - 1)), nil
+}
+
+// next reads the state following state.
+func (r *backwardReader) next(state fseState) (uint32, error) {
+	bits, err := r.read(state.bits)
+	return uint32(state.baseline) + bits, err
+}
+
+// zstdDecode decodes the frame in src whose matches may refer to dict,
+// rejecting frames decoding to more than maxSize bytes.
+func zstdDecode(src, dict []byte, maxSize int) ([]byte, error) {
+	if len(src) < 5 || binary.LittleEndian.Uint32(src) != zstdMagic {
+		return nil, errZstdCorrupt
+	}
+	descriptor := src[4]
+	src = src[5:]
+	if descriptor&(1<<3|1<<2) != 0 {
+		return nil, fmt.Errorf("zstd: frame descriptor %#x is not supported", descriptor)
+	}
+
+	singleSegment := descriptor&(1<<5) != 0
+	if !singleSegment {
+		src = src[min(1, len(src)):] // the window is bounded by maxSize anyway
+	}
+	src = src[min([]int{0, 1, 2, 4}[descriptor&3], len(src)):] // raw content dictionaries have no ID
+
+	sizeBytes := []int{0, 2, 4, 8}[descriptor>>6]
+	if sizeBytes == 0 && singleSegment {
+		sizeBytes = 1
+	}
+	if len(src) < sizeBytes {
+		return nil, errZstdCorrupt
+	}
+	size := uint64(maxSize)
+	switch sizeBytes {
+	case 1:
+		size = uint64(src[0])
+	case 2:
+		size = uint64(binary.LittleEndian.Uint16(src)) + 256
+	case 4:
+		size = uint64(binary.LittleEndian.Uint32(src))
+	case 8:
+		size = binary.LittleEndian.Uint64(src)
+	}
+	src = src[sizeBytes:]
+	if size > uint64(maxSize) {
+		return nil, fmt.Errorf("zstd: decoded length %d is over %d bytes", size, maxSize)
+	}
+
+	d := zstdDecoder{dict: dict, size: int(size), repeats: zstdInitialRepeats}
+	if sizeBytes > 0 {
+		d.out = make([]byte, 0, size)
+	}
+	for last := false; !last; {
+		if len(src) < 3 {
+			return nil, errZstdCorrupt
+		}
+		header := uint32(src[0]) | uint32(src[1])<<8 | uint32(src[2])<<16
+		kind, blockSize := int(header>>1&3), int(header>>3)
+		last = header&1 != 0
+		src = src[3:]
+		if blockSize > zstdMaxBlockSize {
+			return nil, errZstdCorrupt
+		}
+
+		var err error
+		switch kind {
+		case zstdBlockRaw:
+			if blockSize > len(src) || len(d.out)+blockSize > d.size {
+				return nil, errZstdCorrupt
+			}
+			d.out = append(d.out, src[:blockSize]...)
+			src = src[blockSize:]
+		case zstdBlockRLE:
+			if len(src) < 1 || len(d.out)+blockSize > d.size {
+				return nil, errZstdCorrupt
+			}
+			for range blockSize {
+				d.out = append(d.out, src[0])
+			}
+			src = src[1:]
+		case zstdBlockCompressed:
+			if blockSize > len(src) {
+				return nil, errZstdCorrupt
+			}
+			err = d.block(src[:blockSize])
+			src = src[blockSize:]
+		default:
+			err = errZstdCorrupt
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(src) != 0 || (sizeBytes > 0 && len(d.out) != d.size) {
+		return nil, errZstdCorrupt
+	}
+	return d.out, nil
+}
+
+// zstdDecoder holds the state of a frame carried from block to block.
+type zstdDecoder struct {
+	dict    []byte
+	out     []byte
+	size    int // Bound of the decoded length.
+	repeats [3]uint32
+
+	ll, ml, of *fseTable // Tables of the last block, reused by repeat modes.
+}
+
+// block decodes the body of a compressed block.
+func (d *zstdDecoder) block(src []byte) error {
+	literals, src, err := zstdReadLiterals(src)
+	if err != nil {
+		return err
+	}
+
+	if len(src) < 1 {
+		return errZstdCorrupt
+	}
+	count := int(src[0])
+	switch {
+	case count == 255:
+		if len(src) < 3 {
+			return errZstdCorrupt
+		}
+		count = int(binary.LittleEndian.Uint16(src[1:])) + 0x7F00
+		src = src[3:]
+	case count >= 128:
+		if len(src) < 2 {
+			return errZstdCorrupt
+		}
+		count = (count-128)<<8 | int(src[1])
+		src = src[2:]
+	default:
+		src = src[1:]
+	}
+	if count == 0 {
+		if len(src) != 0 {
+			return errZstdCorrupt
+		}
+		return d.literals(literals)
+	}
+
+	if len(src) < 1 || src[0]&3 != 0 {
+		return errZstdCorrupt
+	}
+	modes := src[0]
+	src = src[1:]
+	if d.ll, src, err = zstdReadTable(src, modes>>6, zstdLiteralsLengthTable, d.ll, len(zstdLiteralsLengthCodes)); err != nil {
+		return err
+	}
+	if d.of, src, err = zstdReadTable(src, modes>>4&3, zstdOffsetTable, d.of, 32); err != nil {
+		return err
+	}
+	if d.ml, src, err = zstdReadTable(src, modes>>2&3, zstdMatchLengthTable, d.ml, len(zstdMatchLengthCodes)); err != nil {
+		return err
+	}
+
+	r, err := newBackwardReader(src)
+	if err != nil {
+		return err
+	}
+	llState, err := r.read(d.ll.accuracyLog)
+	if err != nil {
+		return err
+	}
+	ofState, err := r.read(d.of.accuracyLog)
+	if err != nil {
+		return err
+	}
+	mlState, err := r.read(d.ml.accuracyLog)
+	if err != nil {
+		return err
+	}
+
+	for i := range count {
+		ll, ml, of := d.ll.states[llState], d.ml.states[mlState], d.of.states[ofState]
+
+		ofExtra, err := r.read(of.symbol)
+		if err != nil {
+			return err
+		}
+		mlCode, llCode := zstdMatchLengthCodes[ml.symbol], zstdLiteralsLengthCodes[ll.symbol]
+		mlExtra, err := r.read(mlCode.bits)
+		if err != nil {
+			return err
+		}
+		llExtra, err := r.read(llCode.bits)
+		if err != nil {
+			return err
+		}
+		litLen := llCode.baseline + llExtra
+		seq := zstdSequence{litLen, mlCode.baseline + mlExtra, zstdRepeat(&d.repeats, 1<<of.symbol+ofExtra, litLen)}
+
+		if int(seq.litLen) > len(literals) {
+			return errZstdCorrupt
+		}
+		if err := d.literals(literals[:seq.litLen]); err != nil {
+			return err
+		}
+		literals = literals[seq.litLen:]
+		if err := d.match(seq.offset, seq.matchLen); err != nil {
+			return err
+		}
+
+		if i < count-1 {
+			if llState, err = r.next(ll); err != nil {
+				return err
+			}
+			if mlState, err = r.next(ml); err != nil {
+				return err
+			}
+			if ofState, err = r.next(of); err != nil {
+				return err
+			}
+		}
+	}
+	if r.left != 0 {
+		return errZstdCorrupt
+	}
+	return d.literals(literals)
+}
+
+// zstdInitialRepeats are the repeated offsets a frame starts with.
+var zstdInitialRepeats = [3]uint32{1, 4, 8}
+
+// zstdRepeat returns the offset of a sequence from its offset value, the
+// values up to 3 repeat recent offsets, and updates the repeated offsets.
+func zstdRepeat(repeats *[3]uint32, value, litLen uint32) uint32 {
+	if value > 3 {
+		*repeats = [3]uint32{value - 3, repeats[0], repeats[1]}
+		return repeats[0]
+	}
+
+	// without literals the repeats are shifted by one
+	index := value - 1
+	if litLen == 0 {
+		index++
+	}
+	switch index {
+	case 0:
+		return repeats[0]
+	case 1:
+		*repeats = [3]uint32{repeats[1], repeats[0], repeats[2]}
+	case 2:
+		*repeats = [3]uint32{repeats[2], repeats[0], repeats[1]}
+	default:
+		*repeats = [3]uint32{repeats[0] - 1, repeats[0], repeats[1]}
+	}
+	return repeats[0]
+}
+
+// zstdOffsetValue returns the value coding an offset, repeating a recent
+// offset when it can, and updates the repeated offsets.
+func zstdOffsetValue(offset, litLen uint32, repeats *[3]uint32) uint32 {
+	for value := uint32(1); value <= 3; value++ {
+		if next := *repeats; zstdRepeat(&next, value, litLen) == offset {
+			*repeats = next
+			return value
+		}
+	}
+	zstdRepeat(repeats, offset+3, litLen)
+	return offset + 3
+}
+
+func (d *zstdDecoder) literals(literals []byte) error {
+	if len(d.out)+len(literals) > d.size {
+		return errZstdCorrupt
+	}
+	d.out = append(d.out, literals...)
+	return nil
+}
+
+// match appends the length bytes found offset bytes back, in the dictionary
+// for offsets reaching before the frame.
+func (d *zstdDecoder) match(offset, length uint32) error {
+	if offset == 0 || int(offset) > len(d.out)+len(d.dict) || len(d.out)+int(length) > d.size {
+		return errZstdCorrupt
+	}
+	for range length {
+		if from := len(d.out) - int(offset); from >= 0 {
+			d.out = append(d.out, d.out[from])
+		} else {
+			d.out = append(d.out, d.dict[len(d.dict)+from])
+		}
+	}
+	return nil
+}
+
+// zstdReadLiterals reads the literals section of a compressed block.
+func zstdReadLiterals(src []byte) ([]byte, []byte, error) {
+	if len(src) < 1 {
+		return nil, nil, errZstdCorrupt
+	}
+	kind := src[0] & 3
+	if kind != zstdLiteralsRaw && kind != zstdLiteralsRLE {
+		return nil, nil, fmt.Errorf("zstd: Huffman coded literals are not supported")
+	}
+
+	var size, header int
+	switch src[0] >> 2 & 3 {
+	case 0, 2:
+		size, header = int(src[0]>>3), 1
+	case 1:
+		if len(src) < 2 {
+			return nil, nil, errZstdCorrupt
+		}
+		size, header = int(src[0]>>4)|int(src[1])<<4, 2
+	case 3:
+		if len(src) < 3 {
+			return nil, nil, errZstdCorrupt
+		}
+		size, header = int(src[0]>>4)|int(src[1])<<4|int(src[2])<<12, 3
+	}
+	src = src[header:]
+	if size > zstdMaxBlockSize {
+		return nil, nil, errZstdCorrupt
+	}
+
+	if kind == zstdLiteralsRLE {
+		if len(src) < 1 {
+			return nil, nil, errZstdCorrupt
+		}
+		literals := make([]byte, size)
+		for i := range literals {
+			literals[i] = src[0]
+		}
+		return literals, src[1:], nil
+	}
+	if size > len(src) {
+		return nil, nil, errZstdCorrupt
+	}
+	return src[:size], src[size:], nil
+}
+
+// zstdReadTable returns the table of a sequence code given its mode.
+func zstdReadTable(src []byte, mode uint8, predefined, previous *fseTable, symbols int) (*fseTable, []byte, error) {
+	switch mode {
+	case zstdModePredefined:
+		return predefined, src, nil
+	case zstdModeRLE:
+		if len(src) < 1 || int(src[0]) >= symbols {
+			return nil, nil, errZstdCorrupt
+		}
+		return rleFSETable(src[0]), src[1:], nil
+	case zstdModeRepeat:
+		if previous == nil {
+			return nil, nil, errZstdCorrupt
+		}
+		return previous, src, nil
+	}
+	return nil, nil, fmt.Errorf("zstd: FSE coded sequences are not supported")
+}
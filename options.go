@@ -0,0 +1,143 @@
+package goldb
+
+import (
+	"time"
+
+	"github.com/hasssanezzz/goldb/internal"
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// Options configures an engine opened with Open. Every field is a pointer so
+// an unset field, nil, keeps the default of shared.DefaultConfig while a zero
+// value is applied as given; Value builds the pointers. A nil *Options opens
+// the engine with the defaults.
+type Options struct {
+	MemtableSize        *uint32             // Pairs the memtable holds before it is flushed.
+	CompactionThreshold *uint32             // SSTables that trigger a compaction when exceeded.
+	SmallTableMergeSize *uint32             // SSTables with at most this many pairs are merged early, zero disables it.
+	BlockSize           *uint32             // Target size of SSTable blocks in bytes.
+	BlockCompression    *shared.Compression // Codec of the blocks of new tables.
+	FilterMemoryBudget  *uint64             // Bytes of loaded bloom filters, zero means unlimited.
+	NegativeCacheSize   *uint32             // Recently missed keys remembered, zero disables the cache.
+	RowCacheSize        *uint64             // Bytes of cached keys and values, zero disables the cache.
+	KeysMemoryBudget    *uint64             // Bytes of keys Keys and Scan collect, zero means unlimited.
+	IORetryAttempts     *uint32             // Attempts made for disk operations failing with transient errors.
+
+	FilterPolicy      *shared.FilterPolicy // Filter built into new tables.
+	FalsePositiveRate *float64             // Share of absent keys the filters of new tables let through.
+	BitsPerKey        *float64             // Bits per key of the filters of new tables, sizing them in place of FalsePositiveRate.
+
+	WALSync         *shared.SyncPolicy // When WAL appends are fsynced.
+	WALSyncInterval *time.Duration     // Pause between background WAL syncs under shared.SyncInterval.
+
+	ReadOnly   *bool          // Never modify the files of the database.
+	DisableWAL *bool          // Run without a WAL as a best effort cache, writes persist once flushed.
+	CacheTTL   *time.Duration // Without a WAL, keys expire this long after their last write, zero means never.
+	MmapTables *bool          // Read tables through a memory mapping of their files.
+	Paranoid   *bool          // Check internal invariants at runtime.
+	Debug      *bool
+
+	// Config adjusts the fields of the configuration these options do not
+	// cover, it runs once the options are applied and before validation.
+	Config func(*shared.EngineConfig)
+}
+
+// Value returns a pointer to v, to fill in the fields of Options.
+func Value[T any](v T) *T {
+	return &v
+}
+
+// Open opens the database at path configured by opts. The options are
+// checked, alone and against each other, before anything is opened, an
+// invalid one is reported as a *shared.ErrInvalidConfig naming it.
+func Open(path string, opts *Options) (*Engine, error) {
+	config, err := opts.config()
+	if err != nil {
+		return nil, err
+	}
+	return internal.NewEngine(path, config)
+}
+
+// config returns the engine configuration of the options, the defaults
+// filled in for the unset ones.
+func (o *Options) config() (shared.EngineConfig, error) {
+	config := shared.DefaultConfig
+	if o == nil {
+		return config, nil
+	}
+	if err := o.validate(); err != nil {
+		return config, err
+	}
+
+	set(&config.MemtableSizeThreshold, o.MemtableSize)
+	set(&config.CompactionThreshold, o.CompactionThreshold)
+	set(&config.SmallTableMergeSize, o.SmallTableMergeSize)
+	set(&config.BlockSizeBytes, o.BlockSize)
+	set(&config.BlockCompression, o.BlockCompression)
+	set(&config.FilterPolicy, o.FilterPolicy)
+	set(&config.FalsePositiveRate, o.FalsePositiveRate)
+	set(&config.BitsPerKey, o.BitsPerKey)
+	set(&config.FilterMemoryBudget, o.FilterMemoryBudget)
+	set(&config.NegativeCacheSize, o.NegativeCacheSize)
+	set(&config.RowCacheSize, o.RowCacheSize)
+	set(&config.KeysMemoryBudget, o.KeysMemoryBudget)
+	set(&config.IORetryAttempts, o.IORetryAttempts)
+	set(&config.WALSync, o.WALSync)
+	set(&config.WALSyncInterval, o.WALSyncInterval)
+	set(&config.ReadOnly, o.ReadOnly)
+	set(&config.CacheMode, o.DisableWAL)
+	set(&config.CacheTTL, o.CacheTTL)
+	set(&config.MmapTables, o.MmapTables)
+	set(&config.Paranoid, o.Paranoid)
+	set(&config.Debug, o.Debug)
+
+	if o.Config != nil {
+		o.Config(&config)
+	}
+	if err := config.Validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// validate checks what the engine configuration can not tell apart from its
+// defaults: values that are set but unusable and options that contradict
+// each other.
+func (o *Options) validate() error {
+	if o.MemtableSize != nil && *o.MemtableSize == 0 {
+		return &shared.ErrInvalidConfig{Field: "MemtableSize", Reason: "the memtable must hold at least one pair"}
+	}
+	if o.CompactionThreshold != nil && *o.CompactionThreshold == 0 {
+		return &shared.ErrInvalidConfig{Field: "CompactionThreshold", Reason: "at least one table must be allowed before compacting"}
+	}
+	if o.IORetryAttempts != nil && *o.IORetryAttempts == 0 {
+		return &shared.ErrInvalidConfig{Field: "IORetryAttempts", Reason: "every disk operation is attempted at least once"}
+	}
+
+	if o.FalsePositiveRate != nil && o.BitsPerKey != nil {
+		return &shared.ErrInvalidConfig{Field: "BitsPerKey", Reason: "filters are sized either by their false positive rate or their bits per key"}
+	}
+
+	readOnly := o.ReadOnly != nil && *o.ReadOnly
+	noWAL := o.DisableWAL != nil && *o.DisableWAL
+	if readOnly && noWAL {
+		return &shared.ErrInvalidConfig{Field: "DisableWAL", Reason: "a read-only engine never writes a WAL to disable"}
+	}
+	if (readOnly || noWAL) && (o.WALSync != nil || o.WALSyncInterval != nil) {
+		return &shared.ErrInvalidConfig{Field: "WALSync", Reason: "the engine writes no WAL to sync"}
+	}
+	if o.WALSyncInterval != nil && (o.WALSync == nil || *o.WALSync != shared.SyncInterval) {
+		return &shared.ErrInvalidConfig{Field: "WALSyncInterval", Reason: "an interval is only used by the SyncInterval policy"}
+	}
+	if o.CacheTTL != nil && !noWAL {
+		return &shared.ErrInvalidConfig{Field: "CacheTTL", Reason: "keys only expire without a WAL"}
+	}
+	return nil
+}
+
+// set copies the option to the field if it is set.
+func set[T any](field *T, option *T) {
+	if option != nil {
+		*field = *option
+	}
+}
@@ -0,0 +1,186 @@
+// Package persistentmap is a map of string keys shaped after sync.Map whose
+// entries are stored in a goldb engine, so they survive restarts. Every
+// method returns the error of the engine as its last result.
+//
+//	m, err := persistentmap.Open("state", persistentmap.JSON[Session]())
+//	...
+//	defer m.Close()
+//	err = m.Store("alice", Session{...})
+//	session, ok, err := m.Load("alice")
+package persistentmap
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hasssanezzz/goldb"
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// valueHeader starts every stored value, so an empty encoding is not taken
+// for a deletion by the engine.
+const valueHeader = 0
+
+// Codec encodes the values of a map to bytes and back.
+type Codec[V any] interface {
+	Encode(value V) ([]byte, error)
+	Decode(data []byte) (V, error)
+}
+
+type bytesCodec struct{}
+
+func (bytesCodec) Encode(value []byte) ([]byte, error) { return value, nil }
+func (bytesCodec) Decode(data []byte) ([]byte, error)  { return data, nil }
+
+type stringCodec struct{}
+
+func (stringCodec) Encode(value string) ([]byte, error) { return []byte(value), nil }
+func (stringCodec) Decode(data []byte) (string, error)  { return string(data), nil }
+
+type jsonCodec[V any] struct{}
+
+func (jsonCodec[V]) Encode(value V) ([]byte, error) { return json.Marshal(value) }
+
+func (jsonCodec[V]) Decode(data []byte) (V, error) {
+	var value V
+	err := json.Unmarshal(data, &value)
+	return value, err
+}
+
+// Bytes stores []byte values as they are.
+var Bytes Codec[[]byte] = bytesCodec{}
+
+// String stores string values as their bytes.
+var String Codec[string] = stringCodec{}
+
+// JSON stores values as their JSON encoding.
+func JSON[V any]() Codec[V] {
+	return jsonCodec[V]{}
+}
+
+// Map holds the entries under a key prefix of an engine. It is safe for
+// concurrent use, every operation is a single engine operation, and its
+// writes are as durable as the engine's WAL sync policy makes them.
+type Map[V any] struct {
+	engine *goldb.Engine
+	prefix string
+	codec  Codec[V]
+	owned  bool // The engine was opened by Open and is closed with the map.
+}
+
+// New returns the map of the entries under prefix in engine, several maps
+// share an engine under prefixes none of which starts another.
+func New[V any](engine *goldb.Engine, prefix string, codec Codec[V]) *Map[V] {
+	return &Map[V]{engine: engine, prefix: prefix, codec: codec}
+}
+
+// Open opens, or creates, the database at path holding a single map, the
+// database is closed with the map.
+func Open[V any](path string, codec Codec[V]) (*Map[V], error) {
+	engine, err := goldb.Open(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	m := New(engine, "", codec)
+	m.owned = true
+	return m, nil
+}
+
+// Load returns the value stored under key, ok reports whether there is one.
+func (m *Map[V]) Load(key string) (value V, ok bool, err error) {
+	data, err := m.engine.Get(m.prefix + key)
+	if _, missing := err.(*shared.ErrKeyNotFound); missing {
+		return value, false, nil
+	}
+	if err != nil {
+		return value, false, err
+	}
+
+	value, err = m.decode(key, data)
+	return value, err == nil, err
+}
+
+// Store sets the value of key.
+func (m *Map[V]) Store(key string, value V) error {
+	data, err := m.encode(key, value)
+	if err != nil {
+		return err
+	}
+	return m.engine.Set(m.prefix+key, data)
+}
+
+// LoadOrStore returns the value stored under key if there is one, loaded is
+// then true. Otherwise it stores value and returns it.
+func (m *Map[V]) LoadOrStore(key string, value V) (actual V, loaded bool, err error) {
+	data, err := m.encode(key, value)
+	if err != nil {
+		return actual, false, err
+	}
+
+	var current []byte
+	err = m.engine.Update(m.prefix+key, func(old []byte, found bool) ([]byte, bool, error) {
+		current, loaded = old, found
+		if found {
+			return old, false, nil
+		}
+		return data, false, nil
+	})
+	if err != nil || !loaded {
+		return value, false, err
+	}
+
+	actual, err = m.decode(key, current)
+	return actual, err == nil, err
+}
+
+// Delete removes key, deleting a missing key is not an error.
+func (m *Map[V]) Delete(key string) error {
+	return m.engine.Delete(m.prefix + key)
+}
+
+// Range calls f with every entry, in key order, until f returns false. The
+// entries are read from a snapshot taken when Range is called, f may modify
+// the map.
+func (m *Map[V]) Range(f func(key string, value V) bool) error {
+	return m.engine.ScanFunc(m.prefix, func(key string, data []byte) (bool, error) {
+		key = key[len(m.prefix):]
+		value, err := m.decode(key, data)
+		if err != nil {
+			return true, err
+		}
+		return !f(key, value), nil
+	})
+}
+
+// Sync makes the writes made so far durable, whatever the WAL sync policy.
+func (m *Map[V]) Sync() error {
+	return m.engine.Sync()
+}
+
+// Close closes the database if the map was opened with Open.
+func (m *Map[V]) Close() error {
+	if !m.owned {
+		return nil
+	}
+	return m.engine.Close()
+}
+
+func (m *Map[V]) encode(key string, value V) ([]byte, error) {
+	data, err := m.codec.Encode(value)
+	if err != nil {
+		return nil, fmt.Errorf("persistentmap: can not encode the value of %q: %v", key, err)
+	}
+	return append([]byte{valueHeader}, data...), nil
+}
+
+func (m *Map[V]) decode(key string, data []byte) (V, error) {
+	if len(data) == 0 || data[0] != valueHeader {
+		var zero V
+		return zero, fmt.Errorf("persistentmap: value of %q was not stored by a map", key)
+	}
+	value, err := m.codec.Decode(data[1:])
+	if err != nil {
+		return value, fmt.Errorf("persistentmap: can not decode the value of %q: %v", key, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,93 @@
+package persistentmap
+
+import (
+	"testing"
+
+	"github.com/hasssanezzz/goldb"
+)
+
+type session struct {
+	User   string `json:"user"`
+	Visits int    `json:"visits"`
+}
+
+func TestMap(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Open(dir, JSON[session]())
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+
+	for _, s := range []session{{"alice", 1}, {"bob", 2}, {"carol", 3}} {
+		if err := m.Store(s.User, s); err != nil {
+			t.Fatalf("Store() error: %v", err)
+		}
+	}
+	if err := m.Delete("bob"); err != nil {
+		t.Fatalf("Delete() error: %v", err)
+	}
+	if actual, loaded, err := m.LoadOrStore("alice", session{"alice", 9}); err != nil || !loaded || actual.Visits != 1 {
+		t.Errorf("LoadOrStore(alice) = %+v, %t, %v, want the stored session", actual, loaded, err)
+	}
+	if actual, loaded, err := m.LoadOrStore("dave", session{"dave", 4}); err != nil || loaded || actual.Visits != 4 {
+		t.Errorf("LoadOrStore(dave) = %+v, %t, %v, want the new session", actual, loaded, err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	// the entries survive reopening
+	m, err = Open(dir, JSON[session]())
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer m.Close()
+	if s, ok, err := m.Load("carol"); err != nil || !ok || s.Visits != 3 {
+		t.Errorf("Load(carol) = %+v, %t, %v, want 3 visits", s, ok, err)
+	}
+	if _, ok, err := m.Load("bob"); err != nil || ok {
+		t.Errorf("Load(bob) = %t, %v, want it deleted", ok, err)
+	}
+
+	users := []string{}
+	err = m.Range(func(key string, s session) bool {
+		users = append(users, key)
+		return key != "carol"
+	})
+	if err != nil || len(users) != 2 || users[0] != "alice" || users[1] != "carol" {
+		t.Errorf("Range() visited %v, %v, want alice then carol", users, err)
+	}
+}
+
+func TestMapPrefix(t *testing.T) {
+	engine, err := goldb.Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	defer engine.Close()
+
+	names, counters := New(engine, "names:", String), New(engine, "counters:", Bytes)
+	// empty values are stored, not taken for deletions
+	if err := names.Store("empty", ""); err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+	if err := counters.Store("empty", []byte{7}); err != nil {
+		t.Fatalf("Store() error: %v", err)
+	}
+	if value, ok, err := names.Load("empty"); err != nil || !ok || value != "" {
+		t.Errorf("Load(empty) = %q, %t, %v, want an empty name", value, ok, err)
+	}
+
+	count := 0
+	if err := counters.Range(func(key string, value []byte) bool { count++; return true }); err != nil || count != 1 {
+		t.Errorf("Range() visited %d entries, %v, want only the map's one", count, err)
+	}
+
+	// values written without the map are reported
+	if err := engine.Set("names:raw", []byte("raw")); err != nil {
+		t.Fatalf("Set() error: %v", err)
+	}
+	if _, _, err := names.Load("raw"); err == nil {
+		t.Errorf("Load(raw) of a value not stored by the map succeeded")
+	}
+}
@@ -0,0 +1,108 @@
+package shard
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/hasssanezzz/goldb/shared"
+)
+
+// Client sends each request to the goldb server owning its key, the ring's
+// nodes must be server base URLs such as "http://10.0.0.1:3011".
+type Client struct {
+	ring *Ring
+	http *http.Client
+}
+
+// NewClient returns a client routing keys with ring, using http.DefaultClient
+// if httpClient is nil.
+func NewClient(ring *Ring, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{ring: ring, http: httpClient}
+}
+
+// Get returns the value of key, a missing key is reported as shared.ErrKeyNotFound.
+func (c *Client) Get(ctx context.Context, key string) ([]byte, error) {
+	body, status, err := c.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return body, nil
+	case http.StatusNotFound:
+		return nil, &shared.ErrKeyNotFound{Key: key}
+	}
+	return nil, fmt.Errorf("shard: getting %q failed with status %d: %s", key, status, body)
+}
+
+// Has reports whether key exists on the key's node, its value is not transferred.
+func (c *Client) Has(ctx context.Context, key string) (bool, error) {
+	body, status, err := c.do(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return false, err
+	}
+
+	switch status {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("shard: checking %q failed with status %d: %s", key, status, body)
+}
+
+// Set stores value under key on the key's node.
+func (c *Client) Set(ctx context.Context, key string, value []byte) error {
+	body, status, err := c.do(ctx, http.MethodPost, key, value)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("shard: setting %q failed with status %d: %s", key, status, body)
+	}
+	return nil
+}
+
+// Delete removes key from the key's node.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	body, status, err := c.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf("shard: deleting %q failed with status %d: %s", key, status, body)
+	}
+	return nil
+}
+
+func (c *Client) do(ctx context.Context, method, key string, value []byte) ([]byte, int, error) {
+	node := c.ring.Node(key)
+	if len(node) == 0 {
+		return nil, 0, fmt.Errorf("shard: the ring has no nodes")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, node+"/", bytes.NewReader(value))
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Key", key)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("shard: node %q unreachable: %v", node, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, fmt.Errorf("shard: can not read the response of node %q: %v", node, err)
+	}
+	return body, resp.StatusCode, nil
+}
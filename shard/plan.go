@@ -0,0 +1,68 @@
+package shard
+
+import "sort"
+
+// Move is a range of the ring whose keys change owner, it covers the hashes
+// in (Start, End], wrapping around zero when Start >= End.
+type Move struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Start uint64 `json:"start"`
+	End   uint64 `json:"end"`
+}
+
+// Contains reports whether key falls in the moved range.
+func (m Move) Contains(key string) bool {
+	hash := Hash(key)
+	if m.Start < m.End {
+		return hash > m.Start && hash <= m.End
+	}
+	return hash > m.Start || hash <= m.End
+}
+
+// Plan lists the ranges whose owner differs between the two rings, the keys of
+// each range have to be copied from the old owner to the new one to rebalance.
+// Adjacent ranges moving between the same nodes are merged.
+func Plan(from, to *Ring) []Move {
+	if len(from.points) == 0 || len(to.points) == 0 {
+		return nil
+	}
+
+	// every range between two consecutive points of either ring has a
+	// single owner in each ring, the owner of the range's end point
+	hashes := make([]uint64, 0, len(from.points)+len(to.points))
+	for _, p := range from.points {
+		hashes = append(hashes, p.hash)
+	}
+	for _, p := range to.points {
+		hashes = append(hashes, p.hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	moves := []Move{}
+	for i, end := range hashes {
+		start := hashes[(i+len(hashes)-1)%len(hashes)]
+		if start == end && len(hashes) > 1 {
+			continue // duplicate point
+		}
+
+		oldOwner, newOwner := from.owner(end), to.owner(end)
+		if oldOwner == newOwner {
+			continue
+		}
+
+		if n := len(moves); n > 0 && moves[n-1].End == start && moves[n-1].From == oldOwner && moves[n-1].To == newOwner {
+			moves[n-1].End = end
+			continue
+		}
+		moves = append(moves, Move{From: oldOwner, To: newOwner, Start: start, End: end})
+	}
+
+	// the last range may continue into the first one across zero
+	if n := len(moves); n > 1 && moves[n-1].End == moves[0].Start && moves[n-1].From == moves[0].From && moves[n-1].To == moves[0].To {
+		moves[0].Start = moves[n-1].Start
+		moves = moves[:n-1]
+	}
+
+	return moves
+}
@@ -0,0 +1,120 @@
+// Package shard spreads keys over several independent goldb servers with
+// consistent hashing, so adding or removing a server only moves a small
+// share of the keys.
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// DefaultVirtualNodes is the number of points each node gets on the ring,
+// more points spread the keys more evenly.
+const DefaultVirtualNodes = 128
+
+type point struct {
+	hash uint64
+	node string
+}
+
+// Ring is a consistent hash ring of named nodes, typically server base URLs.
+// A key belongs to the first point clockwise from its hash. A Ring is not safe
+// for concurrent modification, build a new one to change the nodes of a ring
+// that is in use.
+type Ring struct {
+	vnodes int
+	points []point // Sorted by hash.
+	nodes  map[string]bool
+}
+
+// NewRing returns a ring of the given nodes with vnodes points per node,
+// DefaultVirtualNodes if vnodes is zero or less.
+func NewRing(vnodes int, nodes ...string) *Ring {
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+
+	r := &Ring{vnodes: vnodes, nodes: map[string]bool{}}
+	for _, node := range nodes {
+		r.Add(node)
+	}
+	return r
+}
+
+// Add places the node on the ring, adding a node twice has no effect.
+func (r *Ring) Add(node string) {
+	if r.nodes[node] {
+		return
+	}
+	r.nodes[node] = true
+
+	for i := range r.vnodes {
+		r.points = append(r.points, point{hash: hashString(fmt.Sprintf("%s#%d", node, i)), node: node})
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i].hash < r.points[j].hash })
+}
+
+// Remove takes the node off the ring, its keys move to the following nodes.
+func (r *Ring) Remove(node string) {
+	if !r.nodes[node] {
+		return
+	}
+	delete(r.nodes, node)
+
+	points := r.points[:0]
+	for _, p := range r.points {
+		if p.node != node {
+			points = append(points, p)
+		}
+	}
+	r.points = points
+}
+
+// Nodes returns the nodes of the ring in sorted order.
+func (r *Ring) Nodes() []string {
+	nodes := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// Node returns the node owning key, an empty string if the ring has no nodes.
+func (r *Ring) Node(key string) string {
+	return r.owner(hashString(key))
+}
+
+// owner returns the node of the first point at or after hash, wrapping around.
+func (r *Ring) owner(hash uint64) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].node
+}
+
+// Hash returns the position of key on the ring.
+func Hash(key string) uint64 {
+	return hashString(key)
+}
+
+// hashString mixes the FNV-1a hash of s, FNV alone leaves the high bits of
+// short similar strings such as virtual node names clustered.
+func hashString(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
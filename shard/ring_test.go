@@ -0,0 +1,60 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRingBalance(t *testing.T) {
+	ring := NewRing(0, "a", "b", "c")
+
+	counts := map[string]int{}
+	for i := range 30000 {
+		counts[ring.Node(fmt.Sprintf("key%d", i))]++
+	}
+	for _, node := range ring.Nodes() {
+		if counts[node] < 7000 || counts[node] > 13000 {
+			t.Errorf("node %q owns %d of 30000 keys, want roughly a third", node, counts[node])
+		}
+	}
+
+	if node := NewRing(0).Node("key"); node != "" {
+		t.Errorf("Node() on an empty ring = %q, want none", node)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	from := NewRing(16, "a", "b", "c")
+	to := NewRing(16, "a", "b", "c", "d")
+
+	moves := Plan(from, to)
+	if len(moves) == 0 {
+		t.Fatalf("Plan() found nothing to move after adding a node")
+	}
+
+	// every key changing owner is covered by exactly one move, which names both owners
+	for i := range 5000 {
+		key := fmt.Sprintf("key%d", i)
+		oldOwner, newOwner := from.Node(key), to.Node(key)
+
+		covering := []Move{}
+		for _, move := range moves {
+			if move.Contains(key) {
+				covering = append(covering, move)
+			}
+		}
+
+		if oldOwner == newOwner {
+			if len(covering) != 0 {
+				t.Fatalf("key %q stays on %q but is covered by %+v", key, oldOwner, covering)
+			}
+			continue
+		}
+		if newOwner != "d" {
+			t.Fatalf("key %q moves from %q to %q, only moves to d are expected", key, oldOwner, newOwner)
+		}
+		if len(covering) != 1 || covering[0].From != oldOwner || covering[0].To != newOwner {
+			t.Fatalf("key %q moving from %q to %q is covered by %+v", key, oldOwner, newOwner, covering)
+		}
+	}
+}
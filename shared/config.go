@@ -1,26 +1,236 @@
 package shared
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 const UintSize = 4
 
+// Bounds of the SSTable block settings.
+const (
+	MinBlockSizeBytes  = 256
+	MaxBlockSizeBytes  = 1 << 20
+	MaxRestartInterval = 1024
+	MaxDictionarySize  = 1 << 20
+	MaxBitsPerKey      = 64
+)
+
+// SyncPolicy controls when WAL appends are fsynced.
+type SyncPolicy uint8
+
+const (
+	SyncNever    SyncPolicy = iota // Rely on the OS, writes are durable once flushed to an SSTable.
+	SyncAlways                     // Fsync the WAL after every write.
+	SyncInterval                   // Fsync the WAL in the background every WALSyncInterval.
+)
+
+// Compression is the codec compressing the blocks of new SSTables, every
+// table records its own so the setting can change between restarts.
+type Compression uint8
+
+const (
+	CompressionNone   Compression = iota // Blocks are stored as is.
+	CompressionSnappy                    // Snappy, fast with a fair ratio.
+	CompressionLZ4                       // LZ4, faster to decode than Snappy.
+	CompressionZstd                      // Zstandard, levels may share a trained dictionary between their blocks.
+)
+
+// ParseCompression parses a codec name: none, snappy, lz4 or zstd.
+func ParseCompression(name string) (Compression, error) {
+	switch name {
+	case "none", "":
+		return CompressionNone, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "lz4":
+		return CompressionLZ4, nil
+	case "zstd":
+		return CompressionZstd, nil
+	}
+	return CompressionNone, fmt.Errorf("unknown compression %q, want none, snappy, lz4 or zstd", name)
+}
+
+// FilterPolicy is the probabilistic structure built into new SSTables to rule
+// out the keys they do not hold, every table records its own so the setting
+// can change between restarts.
+type FilterPolicy uint8
+
+const (
+	FilterBloom  FilterPolicy = iota // Bloom filter, the default.
+	FilterCuckoo                     // Cuckoo filter, keys can be removed from it.
+	FilterRibbon                     // Ribbon filter, smaller than a bloom filter for the same rate but slower to build.
+)
+
+// ParseFilterPolicy parses a filter policy name: bloom, cuckoo or ribbon.
+func ParseFilterPolicy(name string) (FilterPolicy, error) {
+	switch name {
+	case "bloom", "":
+		return FilterBloom, nil
+	case "cuckoo":
+		return FilterCuckoo, nil
+	case "ribbon":
+		return FilterRibbon, nil
+	}
+	return FilterBloom, fmt.Errorf("unknown filter policy %q, want bloom, cuckoo or ribbon", name)
+}
+
+// MergeFn combines the merge operands of key, oldest first, with its existing
+// value, nil if the key does not exist, into its new value.
+type MergeFn func(key string, existing []byte, operands [][]byte) ([]byte, error)
+
+// KeyPolicy restricts the keys written by users, zero fields are not enforced.
+// Keys written by the engine itself, such as queued messages, bypass it.
+type KeyPolicy struct {
+	MaxLength        uint32   // Longest key in bytes, at most KeySize.
+	AllowedChars     string   // Characters keys can be made of, empty allows any.
+	ReservedPrefixes []string // Prefixes kept for internal keys.
+}
+
+// Check returns an ErrInvalidKey if the policy rejects key.
+func (p *KeyPolicy) Check(key string) error {
+	if p == nil {
+		return nil
+	}
+
+	if p.MaxLength > 0 && len(key) > int(p.MaxLength) {
+		return &ErrInvalidKey{Key: key, Reason: fmt.Sprintf("longer than %d bytes", p.MaxLength)}
+	}
+	if len(p.AllowedChars) > 0 {
+		if i := strings.IndexFunc(key, func(r rune) bool { return !strings.ContainsRune(p.AllowedChars, r) }); i >= 0 {
+			return &ErrInvalidKey{Key: key, Reason: fmt.Sprintf("character %q is not allowed", []rune(key[i:])[0])}
+		}
+	}
+	for _, prefix := range p.ReservedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return &ErrInvalidKey{Key: key, Reason: fmt.Sprintf("prefix %q is reserved", prefix)}
+		}
+	}
+	return nil
+}
+
+// TenantQuota limits the keys starting with Prefix, zero limits are not enforced
+// but the tenant's usage is still tracked.
+type TenantQuota struct {
+	Prefix   string
+	MaxKeys  uint64 // Maximum number of live keys.
+	MaxBytes uint64 // Maximum bytes of live keys and values.
+}
+
+// JSONIndex indexes a field of the JSON values of the keys under Prefix,
+// see Engine.QueryIndex.
+type JSONIndex struct {
+	Name   string // Name queries refer to the index by, without ':'.
+	Prefix string // Keys whose values are indexed, "" selects every key.
+	Path   string // Field indexed, see ParseJSONPath.
+}
+
 var DefaultConfig = EngineConfig{
 	KeySize:               KeySize,
 	MemtableSizeThreshold: 1000,
 	CompactionThreshold:   10,
+	SmallTableMergeSize:   100,
 	SSTableNamePrefix:     "sst_",
 	LevelFileNamePrefix:   "lvl_",
+	BlockSizeBytes:        4096,
+	RestartInterval:       16,
+	FilterMemoryBudget:    0,
+	FalsePositiveRate:     0.01,
+	NegativeCacheSize:     1024,
+	RowCacheSize:          0,
+	RowCacheMaxValueSize:  4096,
+	KeysMemoryBudget:      256 << 20,
+	IORetryAttempts:       3,
+	IORetryBaseDelay:      10 * time.Millisecond,
+	IORetryMaxDelay:       time.Second,
+	BackgroundIOMaxDelay:  20 * time.Millisecond,
 	Debug:                 false,
 }
 
 // EngineConfig defines the configuration parameters for the Goldb database engine.
 // It allows customization of key sizes, memtable thresholds, file naming conventions, and compaction behavior.
 type EngineConfig struct {
-	KeySize               uint32 // Maximum size of a key in bytes.
-	MemtableSizeThreshold uint32 // Maximum number of key-value pairs the memtable can hold before flushing to disk.
-	CompactionThreshold   uint32 // Number of SSTables that if exceeded will trigger compaction.
-	SSTableNamePrefix     string // Prefix for SSTable file names.
-	LevelFileNamePrefix   string // Prefix for level file names.
-	Homepath              string // Source directory
-	Debug                 bool
+	KeySize               uint32  // Maximum size of a key in bytes, at most shared.KeySize.
+	MemtableSizeThreshold uint32  // Maximum number of key-value pairs the memtable can hold before flushing to disk.
+	MemtableMinSize       uint32  // Lower bound of the adaptive flush threshold.
+	MemtableMaxSize       uint32  // Upper bound of the adaptive flush threshold, zero keeps MemtableSizeThreshold fixed.
+	CompactionThreshold   uint32  // Number of SSTables that if exceeded will trigger compaction.
+	SmallTableMergeSize   uint32  // SSTables with at most this many pairs are merged early with their neighbors, zero disables it.
+	SSTableNamePrefix     string  // Prefix for SSTable file names.
+	LevelFileNamePrefix   string  // Prefix for level file names.
+	Homepath              string  // Source directory
+	BlockSizeBytes        uint32  // Target size of SSTable blocks, larger blocks favor scans and smaller ones point lookups.
+	RestartInterval       uint32  // Keys between two uncompressed restart keys in a block, lower values make lookups faster but blocks larger.
+	FilterMemoryBudget    uint64  // Maximum bytes of loaded bloom filters, zero means unlimited.
+	RebuildFilters        bool    // Rebuild broken or outdated bloom filters into sidecar files when opening.
+	FilterRebuildRate     float64 // Observed false positive rate over which scrubs and compactions rebuild a table's filter tighter, zero disables it.
+	SidecarFiles          bool    // Also write the filter and block index of new tables to sidecar files.
+	MmapTables            bool    // Read tables through a memory mapping of their files instead of read calls.
+	ReadOnly              bool    // Open the database without ever modifying its files.
+	WALCompression        bool    // Compress large values in the WAL.
+	VerifyOnOpen          bool    // Check index positions against the data file when opening.
+	VerifySpotChecks      uint32  // Values per table read back by the open check.
+	NegativeCacheSize     uint32  // Number of recently missed keys remembered, zero disables the cache.
+	RowCacheSize          uint64  // Maximum bytes of cached keys and values, zero disables the cache.
+	RowCacheMaxValueSize  uint32  // Values larger than this are never cached.
+	KeysMemoryBudget      uint64  // Maximum bytes of keys Keys and Scan collect before failing, zero means unlimited.
+	DedupValues           bool    // Store identical values once in the data file, referencing the first copy.
+	DataPreallocateSize   uint64  // Bytes of disk reserved ahead of the end of the data file in the background, zero disables it.
+	DataGarbagePercent    uint32  // Share of the data file, in percent, its garbage reaches before a compaction is due, zero disables counting the garbage.
+	MergeFn               MergeFn // Combines the operands written by Engine.Merge, nil disables merges.
+
+	TenantQuotas []TenantQuota // Tenants tracked by key prefix, a key belongs to the longest matching prefix.
+
+	KeyPolicy *KeyPolicy // Validates the keys written by users, nil accepts any key up to KeySize.
+
+	FilterPolicy      FilterPolicy // Filter built into new tables.
+	FalsePositiveRate float64      // Share of absent keys the filters of new tables let through, zero means 1%.
+	BitsPerKey        float64      // Bits per key of the filters of new tables, sizing them in place of FalsePositiveRate when set.
+
+	BlockCompression    Compression // Codec compressing the blocks of new tables, blocks it does not shrink are stored as is.
+	BlockDictionarySize uint32      // Size of the dictionary trained from the keys of every new level compressed with zstd, zero disables it.
+
+	HotKeys        uint32 // Most read keys reported in Stats, found from a sample of the reads, zero disables the sampling.
+	HotKeySampling uint32 // One read in this many is sampled for HotKeys, zero means 16.
+
+	WriteAmpSeparator string // Bytes written are tracked per keyspace, the keys up to and including this separator, empty disables the tracking.
+
+	ColocatedValuePrefixes []string // Keys whose values move into a value file per table when flushed, removed along with the table, "" selects every key.
+
+	JSONIndexes []JSONIndex // Fields of JSON values indexed as they are written, queried with Engine.QueryIndex.
+
+	PrimeBlocks uint32 // Most read table blocks logged on close and read back in the background on open to warm the page cache, zero disables it.
+
+	LevelBaseSize  uint64 // Pairs level 1 holds before its tables are compacted into level 2, zero means CompactionThreshold memtables.
+	LevelSizeRatio uint32 // Times more pairs each level holds than the one above it, zero means 10.
+	LevelTableSize uint32 // Pairs per table written by compactions into the levels, zero means MemtableSizeThreshold.
+
+	CompactionWindows       []string // Off-peak windows (see ParseTimeWindow) when heavy compactions are preferred, none means any time.
+	CompactionMaxConcurrent uint32   // Heavy compactions allowed to run at once outside the windows, zero defers them to the next window.
+
+	WALSync         SyncPolicy    // When WAL appends are fsynced.
+	WALSyncInterval time.Duration // Pause between background WAL syncs under SyncInterval.
+	WALSyncDeletes  bool          // Fsync the WAL after every delete whatever the policy, so deletions are durable when acknowledged.
+
+	IORetryAttempts  uint32        // Attempts made for disk operations failing with transient errors.
+	IORetryBaseDelay time.Duration // Delay before the first retry, doubled on every retry.
+	IORetryMaxDelay  time.Duration // Upper bound of the delay between retries.
+
+	BackgroundIOMaxDelay time.Duration // Longest a background disk access yields to user operations, zero disables prioritization.
+	ScanNiceness         time.Duration // Longest a scan yields to user operations before each table block it reads, zero scans at full speed.
+
+	ScrubInterval       time.Duration // Pause between background integrity scrubs, zero disables scrubbing.
+	ScrubBytesPerSecond uint64        // Maximum read rate of the scrubber, zero means unthrottled.
+	ScrubQuarantine     bool          // Move corrupt tables out of the read path instead of only reporting them.
+
+	RepairSources []string // Checkpoints, or directories of checkpoints such as a replica's, read for an intact copy of a block failing its checksum.
+
+	CacheMode bool          // Run as a best effort cache: no WAL, writes only persist once the memtable is flushed or the engine closed.
+	CacheTTL  time.Duration // In cache mode, keys expire this long after their last write, zero means never.
+
+	Paranoid bool // Check internal invariants at runtime and fail fast when one is broken.
+	Debug    bool
 }
 
 func NewEngineConfig() *EngineConfig {
@@ -30,14 +240,48 @@ func NewEngineConfig() *EngineConfig {
 		SSTableNamePrefix:     DefaultConfig.SSTableNamePrefix,
 		LevelFileNamePrefix:   DefaultConfig.LevelFileNamePrefix,
 		CompactionThreshold:   DefaultConfig.CompactionThreshold,
+		SmallTableMergeSize:   DefaultConfig.SmallTableMergeSize,
+		BlockSizeBytes:        DefaultConfig.BlockSizeBytes,
+		RestartInterval:       DefaultConfig.RestartInterval,
+		FilterMemoryBudget:    DefaultConfig.FilterMemoryBudget,
+		FalsePositiveRate:     DefaultConfig.FalsePositiveRate,
+		NegativeCacheSize:     DefaultConfig.NegativeCacheSize,
+		RowCacheSize:          DefaultConfig.RowCacheSize,
+		RowCacheMaxValueSize:  DefaultConfig.RowCacheMaxValueSize,
+		KeysMemoryBudget:      DefaultConfig.KeysMemoryBudget,
+		IORetryAttempts:       DefaultConfig.IORetryAttempts,
+		IORetryBaseDelay:      DefaultConfig.IORetryBaseDelay,
+		IORetryMaxDelay:       DefaultConfig.IORetryMaxDelay,
+		BackgroundIOMaxDelay:  DefaultConfig.BackgroundIOMaxDelay,
 	}
 }
 
+// NewCacheConfig returns the profile of an engine deployed as a persistent-ish
+// cache: no WAL, keys expiring ten minutes after their last write, a large
+// memtable flushed lazily, and a row cache.
+func NewCacheConfig() *EngineConfig {
+	return NewEngineConfig().
+		WithCacheMode(true, 10*time.Minute).
+		WithMemtableSizeThreshold(100_000).
+		WithRowCache(64<<20, DefaultConfig.RowCacheMaxValueSize)
+}
+
+func (ec *EngineConfig) WithCacheMode(value bool, ttl time.Duration) *EngineConfig {
+	ec.CacheMode = value
+	ec.CacheTTL = ttl
+	return ec
+}
+
 func (ec *EngineConfig) WithDebug(value bool) *EngineConfig {
 	ec.Debug = value
 	return ec
 }
 
+func (ec *EngineConfig) WithParanoid(value bool) *EngineConfig {
+	ec.Paranoid = value
+	return ec
+}
+
 func (ec *EngineConfig) WithKeySize(value uint32) *EngineConfig {
 	ec.KeySize = value
 	return ec
@@ -48,11 +292,30 @@ func (ec *EngineConfig) WithMemtableSizeThreshold(value uint32) *EngineConfig {
 	return ec
 }
 
+// WithAdaptiveMemtable lets the flush threshold follow the write rate and the
+// compaction backlog between min and max pairs, starting from MemtableSizeThreshold.
+func (ec *EngineConfig) WithAdaptiveMemtable(min, max uint32) *EngineConfig {
+	ec.MemtableMinSize = min
+	ec.MemtableMaxSize = max
+	return ec
+}
+
 func (ec *EngineConfig) WithCompactionThreshold(value uint32) *EngineConfig {
 	ec.CompactionThreshold = value
 	return ec
 }
 
+func (ec *EngineConfig) WithSmallTableMergeSize(value uint32) *EngineConfig {
+	ec.SmallTableMergeSize = value
+	return ec
+}
+
+func (ec *EngineConfig) WithCompactionWindows(maxConcurrent uint32, windows ...string) *EngineConfig {
+	ec.CompactionWindows = windows
+	ec.CompactionMaxConcurrent = maxConcurrent
+	return ec
+}
+
 func (ec *EngineConfig) WithSSTableNamePrefix(value string) *EngineConfig {
 	ec.SSTableNamePrefix = value
 	return ec
@@ -63,19 +326,352 @@ func (ec *EngineConfig) WithLevelFileNamePrefix(value string) *EngineConfig {
 	return ec
 }
 
+func (ec *EngineConfig) WithBlockSize(value uint32) *EngineConfig {
+	ec.BlockSizeBytes = value
+	return ec
+}
+
+func (ec *EngineConfig) WithRestartInterval(value uint32) *EngineConfig {
+	ec.RestartInterval = value
+	return ec
+}
+
+func (ec *EngineConfig) WithFilterMemoryBudget(value uint64) *EngineConfig {
+	ec.FilterMemoryBudget = value
+	return ec
+}
+
+func (ec *EngineConfig) WithReadOnly(value bool) *EngineConfig {
+	ec.ReadOnly = value
+	return ec
+}
+
+func (ec *EngineConfig) WithWALCompression(value bool) *EngineConfig {
+	ec.WALCompression = value
+	return ec
+}
+
+// WithHotKeys reports the top most read keys in the engine's Stats, sampling
+// one read in sampling to find them.
+func (ec *EngineConfig) WithHotKeys(top, sampling uint32) *EngineConfig {
+	ec.HotKeys = top
+	ec.HotKeySampling = sampling
+	return ec
+}
+
+// WithJSONIndex indexes the field at path of the JSON values written under
+// prefix as the index name. The keys of indexed values can be at most KeySize
+// less 26 bytes and the length of the name, the prefix of their index entries.
+func (ec *EngineConfig) WithJSONIndex(name, prefix, path string) *EngineConfig {
+	ec.JSONIndexes = append(ec.JSONIndexes, JSONIndex{Name: name, Prefix: prefix, Path: path})
+	return ec
+}
+
+// WithColocatedValues moves the values of the keys under the prefixes into a
+// value file per table when they are flushed. Compactions rewrite the values
+// with the keys and remove the value files of the tables they replace.
+func (ec *EngineConfig) WithColocatedValues(prefixes ...string) *EngineConfig {
+	ec.ColocatedValuePrefixes = prefixes
+	return ec
+}
+
+// WithPrimeBlocks logs the blocks most read by lookups when the engine closes
+// and reads them back in the background when it opens, see Engine.Warmup.
+func (ec *EngineConfig) WithPrimeBlocks(blocks uint32) *EngineConfig {
+	ec.PrimeBlocks = blocks
+	return ec
+}
+
+// WithWriteAmpSeparator tracks the bytes ingested and rewritten per keyspace,
+// the keys sharing their part up to and including the first separator.
+func (ec *EngineConfig) WithWriteAmpSeparator(separator string) *EngineConfig {
+	ec.WriteAmpSeparator = separator
+	return ec
+}
+
+// WithLevels sizes the levels compactions move the SSTables down through:
+// level 1 holds base pairs and every deeper one ratio times more, in tables
+// of tableSize pairs. Zero values keep their defaults.
+func (ec *EngineConfig) WithLevels(base uint64, ratio, tableSize uint32) *EngineConfig {
+	ec.LevelBaseSize = base
+	ec.LevelSizeRatio = ratio
+	ec.LevelTableSize = tableSize
+	return ec
+}
+
+func (ec *EngineConfig) WithFilterPolicy(policy FilterPolicy) *EngineConfig {
+	ec.FilterPolicy = policy
+	return ec
+}
+
+func (ec *EngineConfig) WithFalsePositiveRate(rate float64) *EngineConfig {
+	ec.FalsePositiveRate = rate
+	return ec
+}
+
+// WithBitsPerKey sizes the filters of new tables by the memory they spend per
+// key, the false positive rate follows from it and the filter policy.
+func (ec *EngineConfig) WithBitsPerKey(bits float64) *EngineConfig {
+	ec.BitsPerKey = bits
+	return ec
+}
+
+func (ec *EngineConfig) WithBlockCompression(codec Compression) *EngineConfig {
+	ec.BlockCompression = codec
+	return ec
+}
+
+func (ec *EngineConfig) WithBlockDictionarySize(value uint32) *EngineConfig {
+	ec.BlockDictionarySize = value
+	return ec
+}
+
+func (ec *EngineConfig) WithWALSync(policy SyncPolicy, interval time.Duration) *EngineConfig {
+	ec.WALSync = policy
+	ec.WALSyncInterval = interval
+	return ec
+}
+
+func (ec *EngineConfig) WithWALSyncDeletes(value bool) *EngineConfig {
+	ec.WALSyncDeletes = value
+	return ec
+}
+
+func (ec *EngineConfig) WithVerifyOnOpen(value bool, spotChecks uint32) *EngineConfig {
+	ec.VerifyOnOpen = value
+	ec.VerifySpotChecks = spotChecks
+	return ec
+}
+
+// WithRebuildFilters checks the bloom filter of every table when opening and
+// rebuilds the broken or outdated ones into sidecar files next to their tables.
+func (ec *EngineConfig) WithRebuildFilters(value bool) *EngineConfig {
+	ec.RebuildFilters = value
+	return ec
+}
+
+func (ec *EngineConfig) WithFilterRebuildRate(value float64) *EngineConfig {
+	ec.FilterRebuildRate = value
+	return ec
+}
+
+// WithMmapTables reads tables through a memory mapping of their files, lookups
+// search the mapped blocks in place without a read call. Platforms that can
+// not map files fall back to reads.
+func (ec *EngineConfig) WithMmapTables(value bool) *EngineConfig {
+	ec.MmapTables = value
+	return ec
+}
+
+// WithDataPreallocation keeps size bytes of disk reserved ahead of the end of
+// the data file, reserved in the background as the appends reach them, so the
+// file grows by large contiguous allocations. Only Linux supports it.
+func (ec *EngineConfig) WithDataPreallocation(size uint64) *EngineConfig {
+	ec.DataPreallocateSize = size
+	return ec
+}
+
+// WithRepairSources serves the table blocks failing their checksum from the
+// checkpoints in sources, each a checkpoint or a directory of checkpoints
+// searched newest first, before reporting the corruption.
+func (ec *EngineConfig) WithRepairSources(sources ...string) *EngineConfig {
+	ec.RepairSources = sources
+	return ec
+}
+
+// WithSidecarFiles copies the bloom filter and block index of every new table
+// to sidecar files next to it, which can then be rebuilt or replaced without
+// rewriting the table.
+func (ec *EngineConfig) WithSidecarFiles(value bool) *EngineConfig {
+	ec.SidecarFiles = value
+	return ec
+}
+
+func (ec *EngineConfig) WithNegativeCacheSize(value uint32) *EngineConfig {
+	ec.NegativeCacheSize = value
+	return ec
+}
+
+func (ec *EngineConfig) WithRowCache(size uint64, maxValueSize uint32) *EngineConfig {
+	ec.RowCacheSize = size
+	ec.RowCacheMaxValueSize = maxValueSize
+	return ec
+}
+
+// WithKeysMemoryBudget bounds the memory of the keys collected by Keys and
+// Scan, which fail with an ErrMemoryBudget beyond it. Streaming scans such
+// as ScanKeysFunc are not bounded.
+func (ec *EngineConfig) WithKeysMemoryBudget(value uint64) *EngineConfig {
+	ec.KeysMemoryBudget = value
+	return ec
+}
+
+func (ec *EngineConfig) WithDedupValues(value bool) *EngineConfig {
+	ec.DedupValues = value
+	return ec
+}
+
+// WithDataGarbagePercent counts the bytes of the data file held by overwritten
+// and deleted values, a compaction is due once they make up percent of it.
+func (ec *EngineConfig) WithDataGarbagePercent(percent uint32) *EngineConfig {
+	ec.DataGarbagePercent = percent
+	return ec
+}
+
+func (ec *EngineConfig) WithMergeFn(fn MergeFn) *EngineConfig {
+	ec.MergeFn = fn
+	return ec
+}
+
+// WithKeyPolicy validates user keys with policy, the engine's internal key
+// prefixes are reserved on top of policy.ReservedPrefixes.
+func (ec *EngineConfig) WithKeyPolicy(policy KeyPolicy) *EngineConfig {
+	ec.KeyPolicy = &policy
+	return ec
+}
+
+func (ec *EngineConfig) WithTenantQuota(prefix string, maxKeys, maxBytes uint64) *EngineConfig {
+	ec.TenantQuotas = append(ec.TenantQuotas, TenantQuota{Prefix: prefix, MaxKeys: maxKeys, MaxBytes: maxBytes})
+	return ec
+}
+
+func (ec *EngineConfig) WithIORetry(attempts uint32, baseDelay, maxDelay time.Duration) *EngineConfig {
+	ec.IORetryAttempts = attempts
+	ec.IORetryBaseDelay = baseDelay
+	ec.IORetryMaxDelay = maxDelay
+	return ec
+}
+
+func (ec *EngineConfig) WithBackgroundIOMaxDelay(value time.Duration) *EngineConfig {
+	ec.BackgroundIOMaxDelay = value
+	return ec
+}
+
+// WithScanNiceness makes scans, ranges and iterators wait up to delay before
+// each table block they read while point lookups or writes are in flight.
+func (ec *EngineConfig) WithScanNiceness(delay time.Duration) *EngineConfig {
+	ec.ScanNiceness = delay
+	return ec
+}
+
+func (ec *EngineConfig) WithScrub(interval time.Duration, bytesPerSecond uint64, quarantine bool) *EngineConfig {
+	ec.ScrubInterval = interval
+	ec.ScrubBytesPerSecond = bytesPerSecond
+	ec.ScrubQuarantine = quarantine
+	return ec
+}
+
+// Validate reports the first configuration value out of its allowed range.
+func (ec *EngineConfig) Validate() error {
+	if ec.KeySize == 0 || ec.KeySize > KeySize {
+		return &ErrInvalidConfig{Field: "KeySize", Reason: fmt.Sprintf("%d is not between 1 and %d", ec.KeySize, KeySize)}
+	}
+
+	if ec.BlockSizeBytes < MinBlockSizeBytes || ec.BlockSizeBytes > MaxBlockSizeBytes {
+		return &ErrInvalidConfig{Field: "BlockSizeBytes", Reason: fmt.Sprintf("%d is not between %d and %d", ec.BlockSizeBytes, MinBlockSizeBytes, MaxBlockSizeBytes)}
+	}
+
+	if ec.RestartInterval < 1 || ec.RestartInterval > MaxRestartInterval {
+		return &ErrInvalidConfig{Field: "RestartInterval", Reason: fmt.Sprintf("%d is not between 1 and %d", ec.RestartInterval, MaxRestartInterval)}
+	}
+
+	if ec.BlockCompression > CompressionZstd {
+		return &ErrInvalidConfig{Field: "BlockCompression", Reason: fmt.Sprintf("unknown codec %d", ec.BlockCompression)}
+	}
+
+	if ec.FilterPolicy > FilterRibbon {
+		return &ErrInvalidConfig{Field: "FilterPolicy", Reason: fmt.Sprintf("unknown policy %d", ec.FilterPolicy)}
+	}
+	if !(ec.FalsePositiveRate >= 0 && ec.FalsePositiveRate < 1) {
+		return &ErrInvalidConfig{Field: "FalsePositiveRate", Reason: fmt.Sprintf("%v is not at least 0 and below 1", ec.FalsePositiveRate)}
+	}
+	if !(ec.BitsPerKey >= 0 && ec.BitsPerKey <= MaxBitsPerKey) {
+		return &ErrInvalidConfig{Field: "BitsPerKey", Reason: fmt.Sprintf("%v is not between 0 and %d", ec.BitsPerKey, MaxBitsPerKey)}
+	}
+
+	if ec.LevelSizeRatio == 1 {
+		return &ErrInvalidConfig{Field: "LevelSizeRatio", Reason: "every level must hold more pairs than the one above it"}
+	}
+
+	if ec.BlockDictionarySize > MaxDictionarySize {
+		return &ErrInvalidConfig{Field: "BlockDictionarySize", Reason: fmt.Sprintf("%d is over %d", ec.BlockDictionarySize, MaxDictionarySize)}
+	}
+
+	for _, spec := range ec.CompactionWindows {
+		if _, err := ParseTimeWindow(spec); err != nil {
+			return &ErrInvalidConfig{Field: "CompactionWindows", Reason: err.Error()}
+		}
+	}
+
+	if ec.ScanNiceness < 0 {
+		return &ErrInvalidConfig{Field: "ScanNiceness", Reason: fmt.Sprintf("%v is negative", ec.ScanNiceness)}
+	}
+
+	if ec.DataGarbagePercent > 100 {
+		return &ErrInvalidConfig{Field: "DataGarbagePercent", Reason: fmt.Sprintf("%d is over 100", ec.DataGarbagePercent)}
+	}
+
+	if ec.FilterRebuildRate < 0 || ec.FilterRebuildRate >= 1 {
+		return &ErrInvalidConfig{Field: "FilterRebuildRate", Reason: fmt.Sprintf("%g is not between 0 and 1", ec.FilterRebuildRate)}
+	}
+
+	if ec.MemtableMaxSize > 0 && (ec.MemtableMinSize == 0 || ec.MemtableMinSize > ec.MemtableMaxSize) {
+		return &ErrInvalidConfig{Field: "MemtableMinSize", Reason: fmt.Sprintf("%d is not between 1 and the max size %d", ec.MemtableMinSize, ec.MemtableMaxSize)}
+	}
+
+	if ec.CacheMode && ec.ReadOnly {
+		return &ErrInvalidConfig{Field: "CacheMode", Reason: "a read-only engine can not run as a cache"}
+	}
+	if ec.CacheTTL < 0 || (ec.CacheTTL > 0 && !ec.CacheMode) {
+		return &ErrInvalidConfig{Field: "CacheTTL", Reason: "a positive ttl is only used in cache mode"}
+	}
+
+	tenants := map[string]bool{}
+	for _, quota := range ec.TenantQuotas {
+		if len(quota.Prefix) == 0 {
+			return &ErrInvalidConfig{Field: "TenantQuotas", Reason: "tenant prefixes can not be empty"}
+		}
+		if tenants[quota.Prefix] {
+			return &ErrInvalidConfig{Field: "TenantQuotas", Reason: fmt.Sprintf("prefix %q is configured twice", quota.Prefix)}
+		}
+		tenants[quota.Prefix] = true
+	}
+
+	indexes := map[string]bool{}
+	for _, index := range ec.JSONIndexes {
+		if index.Name == "" || strings.Contains(index.Name, ":") {
+			return &ErrInvalidConfig{Field: "JSONIndexes", Reason: fmt.Sprintf("index name %q must be set and can not contain ':'", index.Name)}
+		}
+		if indexes[index.Name] {
+			return &ErrInvalidConfig{Field: "JSONIndexes", Reason: fmt.Sprintf("index %q is configured twice", index.Name)}
+		}
+		indexes[index.Name] = true
+		if _, err := ParseJSONPath(index.Path); err != nil {
+			return &ErrInvalidConfig{Field: "JSONIndexes", Reason: err.Error()}
+		}
+	}
+
+	if ec.KeyPolicy != nil && ec.KeyPolicy.MaxLength > ec.KeySize {
+		return &ErrInvalidConfig{Field: "KeyPolicy", Reason: fmt.Sprintf("max length %d is above the key size %d", ec.KeyPolicy.MaxLength, ec.KeySize)}
+	}
+
+	return nil
+}
+
 // GetMetadataSize calculates the size of the metadata section in an SSTable.
 // The metadata includes the serial number, pair count, min key, and max key.
+// Keys are padded to KeySize whatever the configured key size, see PadKey.
 // Returns the total size in bytes.
 func (ec *EngineConfig) GetMetadataSize() uint32 {
 	// TODO: this is very wrong, if the metadata struct changes this will not be reflected
-	return ec.KeySize*2 + UintSize*3 + 1
+	return KeySize*2 + UintSize*3 + 1
 }
 
-// GetKVPairSize calculates the size of a key-value pair in an SSTable.
-// Each pair consists of a key, an offset, and a size.
+// GetKVPairSize calculates the size of a key-value pair in a fixed format
+// SSTable. Each pair consists of a key padded to KeySize, an offset, and a size.
 // Returns the total size in bytes.
 func (ec *EngineConfig) GetKVPairSize() uint32 {
-	return ec.KeySize + UintSize*2 // "<key><offset><size>"
+	return KeySize + UintSize*2 // "<key><offset><size>"
 }
 
 // GetSSTableExpectedSize calculates the expected size of an SSTable based on the configuration.
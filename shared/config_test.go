@@ -0,0 +1,40 @@
+package shared
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateBounds(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *EngineConfig
+		field  string // Field rejected, empty for a valid config.
+	}{
+		{"default", NewEngineConfig(), ""},
+		{"zero config", &EngineConfig{}, "KeySize"},
+		{"zero key size", NewEngineConfig().WithKeySize(0), "KeySize"},
+		{"max key size", NewEngineConfig().WithKeySize(KeySize), ""},
+		{"key size over max", NewEngineConfig().WithKeySize(KeySize + 1), "KeySize"},
+		{"zero block size", NewEngineConfig().WithBlockSize(0), "BlockSizeBytes"},
+		{"block size under min", NewEngineConfig().WithBlockSize(MinBlockSizeBytes - 1), "BlockSizeBytes"},
+		{"min block size", NewEngineConfig().WithBlockSize(MinBlockSizeBytes), ""},
+		{"max block size", NewEngineConfig().WithBlockSize(MaxBlockSizeBytes), ""},
+		{"block size over max", NewEngineConfig().WithBlockSize(MaxBlockSizeBytes + 1), "BlockSizeBytes"},
+		{"zero restart interval", NewEngineConfig().WithRestartInterval(0), "RestartInterval"},
+		{"restart every key", NewEngineConfig().WithRestartInterval(1), ""},
+		{"max restart interval", NewEngineConfig().WithRestartInterval(MaxRestartInterval), ""},
+		{"restart interval over max", NewEngineConfig().WithRestartInterval(MaxRestartInterval + 1), "RestartInterval"},
+	}
+
+	for _, test := range tests {
+		err := test.config.Validate()
+		var invalid *ErrInvalidConfig
+		switch {
+		case test.field == "" && err != nil:
+			t.Errorf("%s: Validate() error: %v", test.name, err)
+		case test.field != "" && (!errors.As(err, &invalid) || invalid.Field != test.field):
+			t.Errorf("%s: Validate() = %v, want %s rejected", test.name, err, test.field)
+		}
+	}
+}
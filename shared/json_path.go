@@ -0,0 +1,57 @@
+package shared
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// JSONPathStep is a step of a path into a JSON document, an object field or,
+// when Field is empty, the Index'th element of an array.
+type JSONPathStep struct {
+	Field string
+	Index int
+}
+
+// ParseJSONPath parses paths such as "$.user.email", "user.email" or
+// "tags[0]", a subset of JSONPath selecting a single value.
+func ParseJSONPath(path string) ([]JSONPathStep, error) {
+	rest := strings.TrimPrefix(path, "$")
+	if rest != path {
+		rest = strings.TrimPrefix(rest, ".")
+	}
+
+	steps := []JSONPathStep{}
+	for first := true; rest != "" || first; first = false {
+		if strings.HasPrefix(rest, "[") {
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("JSON path %q has an unclosed '['", path)
+			}
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("JSON path %q has an invalid index %q", path, rest[1:end])
+			}
+			steps = append(steps, JSONPathStep{Index: index})
+			rest = rest[end+1:]
+			continue
+		}
+
+		if !first {
+			if !strings.HasPrefix(rest, ".") {
+				return nil, fmt.Errorf("JSON path %q must separate fields with '.'", path)
+			}
+			rest = rest[1:]
+		}
+		end := strings.IndexAny(rest, ".[")
+		if end < 0 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("JSON path %q has an empty field", path)
+		}
+		steps = append(steps, JSONPathStep{Field: rest[:end]})
+		rest = rest[end:]
+	}
+	return steps, nil
+}
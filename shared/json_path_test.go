@@ -0,0 +1,27 @@
+package shared
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONPath(t *testing.T) {
+	for path, want := range map[string][]JSONPathStep{
+		"email":            {{Field: "email"}},
+		"$.user.email":     {{Field: "user"}, {Field: "email"}},
+		"tags[0]":          {{Field: "tags"}, {Index: 0}},
+		"$.orders[2].id":   {{Field: "orders"}, {Index: 2}, {Field: "id"}},
+		"$[1][0]":          {{Index: 1}, {Index: 0}},
+		"$.matrix[1][3].x": {{Field: "matrix"}, {Index: 1}, {Index: 3}, {Field: "x"}},
+	} {
+		if steps, err := ParseJSONPath(path); err != nil || !reflect.DeepEqual(steps, want) {
+			t.Errorf("ParseJSONPath(%q) = %+v, %v, want %+v", path, steps, err, want)
+		}
+	}
+
+	for _, path := range []string{"", "$", "user..email", "tags[", "tags[-1]", "tags[x]", "tags[0]x", ".email"} {
+		if steps, err := ParseJSONPath(path); err == nil {
+			t.Errorf("ParseJSONPath(%q) = %+v, want an error", path, steps)
+		}
+	}
+}
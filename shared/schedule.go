@@ -0,0 +1,108 @@
+package shared
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TimeWindow is a recurring window of the day, optionally limited to some
+// days of the week. A window ending before it starts spans midnight and
+// belongs to the day it starts on.
+type TimeWindow struct {
+	Days  [7]bool       // Indexed by time.Weekday, no day set means every day.
+	Start time.Duration // Since midnight.
+	End   time.Duration // Since midnight, at most 24h.
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseTimeWindow parses windows such as "22:00-06:00", "Sat,Sun 00:00-24:00"
+// or "Mon-Fri 01:30-05:00". Times are in the local time zone.
+func ParseTimeWindow(spec string) (TimeWindow, error) {
+	window := TimeWindow{}
+
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 2 {
+		return window, fmt.Errorf("time window %q must be \"[days] HH:MM-HH:MM\"", spec)
+	}
+
+	if len(fields) == 2 {
+		for _, days := range strings.Split(fields[0], ",") {
+			first, last, isRange := strings.Cut(days, "-")
+			from, ok := weekdays[strings.ToLower(first)]
+			if !ok {
+				return window, fmt.Errorf("time window %q has an unknown day %q", spec, first)
+			}
+			to := from
+			if isRange {
+				if to, ok = weekdays[strings.ToLower(last)]; !ok {
+					return window, fmt.Errorf("time window %q has an unknown day %q", spec, last)
+				}
+			}
+			for day := from; ; day = (day + 1) % 7 {
+				window.Days[day] = true
+				if day == to {
+					break
+				}
+			}
+		}
+	}
+
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return window, fmt.Errorf("time window %q must be \"[days] HH:MM-HH:MM\"", spec)
+	}
+
+	var err error
+	if window.Start, err = parseTimeOfDay(start); err != nil {
+		return window, fmt.Errorf("time window %q: %v", spec, err)
+	}
+	if window.End, err = parseTimeOfDay(end); err != nil {
+		return window, fmt.Errorf("time window %q: %v", spec, err)
+	}
+	if window.Start == window.End {
+		return window, fmt.Errorf("time window %q is empty", spec)
+	}
+
+	return window, nil
+}
+
+// Contains reports whether t falls in the window.
+func (w TimeWindow) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	now := t.Sub(midnight)
+
+	if w.Start < w.End {
+		return now >= w.Start && now < w.End && w.onDay(t.Weekday())
+	}
+
+	// spanning midnight, the early hours belong to the previous day's window
+	if now >= w.Start {
+		return w.onDay(t.Weekday())
+	}
+	return now < w.End && w.onDay((t.Weekday()+6)%7)
+}
+
+func (w TimeWindow) onDay(day time.Weekday) bool {
+	for _, set := range w.Days {
+		if set {
+			return w.Days[day]
+		}
+	}
+	return true
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(value, "%d:%d", &hours, &minutes); err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", value)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("time of day %q is out of range", value)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
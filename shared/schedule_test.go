@@ -0,0 +1,45 @@
+package shared
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeWindow(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		// 2024-01-07 is a Sunday
+		return time.Date(2024, 1, 7+day, hour, minute, 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		spec string
+		at   time.Time
+		want bool
+	}{
+		{"01:00-05:00", at(1, 3, 0), true},
+		{"01:00-05:00", at(1, 5, 0), false},
+		{"22:00-06:00", at(2, 23, 30), true},
+		{"22:00-06:00", at(2, 5, 59), true},
+		{"22:00-06:00", at(2, 12, 0), false},
+		{"Sat,Sun 00:00-24:00", at(0, 12, 0), true},
+		{"Sat,Sun 00:00-24:00", at(1, 12, 0), false},
+		{"Mon-Fri 22:00-02:00", at(6, 1, 0), true},  // Saturday early hours belong to Friday
+		{"Mon-Fri 22:00-02:00", at(1, 1, 0), false}, // Monday early hours belong to Sunday
+	}
+
+	for _, test := range tests {
+		window, err := ParseTimeWindow(test.spec)
+		if err != nil {
+			t.Fatalf("ParseTimeWindow(%q) error: %v", test.spec, err)
+		}
+		if got := window.Contains(test.at); got != test.want {
+			t.Errorf("%q.Contains(%s) = %v, want %v", test.spec, test.at.Format("Mon 15:04"), got, test.want)
+		}
+	}
+
+	for _, spec := range []string{"", "25:00-01:00", "Moon 01:00-02:00", "01:00", "01:00-01:00"} {
+		if _, err := ParseTimeWindow(spec); err == nil {
+			t.Errorf("ParseTimeWindow(%q) should fail", spec)
+		}
+	}
+}
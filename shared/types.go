@@ -2,6 +2,7 @@ package shared
 
 import (
 	"fmt"
+	"time"
 )
 
 type ErrKeyTooLong struct {
@@ -24,3 +25,138 @@ type ErrKeyRemoved struct{ Key string }
 func (e *ErrKeyRemoved) Error() string {
 	return fmt.Sprintf("key %q is deleted", e.Key)
 }
+
+type ErrUnsequenced struct{ Key string }
+
+func (e *ErrUnsequenced) Error() string {
+	return fmt.Sprintf("key %q was written before writes were numbered, it has no sequence number", e.Key)
+}
+
+type ErrInvalidPattern struct {
+	Pattern string
+	Reason  string
+}
+
+func (e *ErrInvalidPattern) Error() string {
+	return fmt.Sprintf("invalid key pattern %q: %s", e.Pattern, e.Reason)
+}
+
+// ErrPositionOutOfRange reports an index entry pointing past the end of the data file.
+type ErrPositionOutOfRange struct {
+	Table    uint32
+	Key      string
+	Offset   uint64
+	Size     uint32
+	DataSize int64
+}
+
+func (e *ErrPositionOutOfRange) Error() string {
+	return fmt.Sprintf("key %q of table %d points to (%d, %d) beyond the data file size %d",
+		e.Key, e.Table, e.Offset, e.Size, e.DataSize)
+}
+
+// ErrInvalidConfig reports an EngineConfig field with an unusable value.
+type ErrInvalidConfig struct {
+	Field  string
+	Reason string
+}
+
+func (e *ErrInvalidConfig) Error() string {
+	return fmt.Sprintf("invalid engine config %s: %s", e.Field, e.Reason)
+}
+
+// ErrInvariantViolated reports an internal invariant found broken in paranoid mode.
+type ErrInvariantViolated struct {
+	Invariant string
+	Detail    string
+}
+
+func (e *ErrInvariantViolated) Error() string {
+	return fmt.Sprintf("invariant %q violated: %s", e.Invariant, e.Detail)
+}
+
+// ErrCorruptRecord reports a data file record failing its checksum or cut short.
+type ErrCorruptRecord struct {
+	Offset int64
+	Reason string
+}
+
+func (e *ErrCorruptRecord) Error() string {
+	return fmt.Sprintf("corrupt data record at offset %d: %s", e.Offset, e.Reason)
+}
+
+// ErrCorruption reports a table block or WAL record failing its checksum,
+// Offset is where the block or record starts in the file at Path.
+type ErrCorruption struct {
+	Path   string
+	Offset int64
+	Reason string
+}
+
+func (e *ErrCorruption) Error() string {
+	return fmt.Sprintf("corruption in %q at offset %d: %s", e.Path, e.Offset, e.Reason)
+}
+
+// ErrMemoryBudget reports an operation collecting every key in memory that
+// would take more than the configured KeysMemoryBudget.
+type ErrMemoryBudget struct {
+	Operation string
+	Budget    uint64
+}
+
+func (e *ErrMemoryBudget) Error() string {
+	return fmt.Sprintf("%s would hold more than its %d bytes memory budget, iterate the keys instead", e.Operation, e.Budget)
+}
+
+// ErrInvalidKey reports a key rejected by the engine's KeyPolicy.
+type ErrInvalidKey struct {
+	Key    string
+	Reason string
+}
+
+func (e *ErrInvalidKey) Error() string {
+	return fmt.Sprintf("invalid key %q: %s", e.Key, e.Reason)
+}
+
+// ErrQuotaExceeded reports a write that would take a tenant over its quota,
+// Resource is either "keys" or "bytes".
+type ErrQuotaExceeded struct {
+	Tenant   string
+	Resource string
+	Limit    uint64
+}
+
+func (e *ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("tenant %q exceeded its quota of %d %s", e.Tenant, e.Limit, e.Resource)
+}
+
+// ErrLeaseHeld reports a lease acquired or released while another owner holds it.
+type ErrLeaseHeld struct {
+	Key       string
+	Owner     string
+	ExpiresAt time.Time
+}
+
+func (e *ErrLeaseHeld) Error() string {
+	return fmt.Sprintf("lease %q is held by %q until %s", e.Key, e.Owner, e.ExpiresAt.Format(time.RFC3339Nano))
+}
+
+// ErrBestEffort reports a durability request to an engine in cache mode,
+// whose writes are only persisted by memtable flushes.
+type ErrBestEffort struct{ Path string }
+
+func (e *ErrBestEffort) Error() string {
+	return fmt.Sprintf("database %q runs in cache mode, writes are best effort", e.Path)
+}
+
+type ErrReadOnly struct{ Path string }
+
+func (e *ErrReadOnly) Error() string {
+	return fmt.Sprintf("database %q is opened in read-only mode", e.Path)
+}
+
+type ErrIndexNotFound struct{ Name string }
+
+func (e *ErrIndexNotFound) Error() string {
+	return fmt.Sprintf("index %q is not configured", e.Name)
+}
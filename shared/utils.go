@@ -1,25 +1,58 @@
 package shared
 
 import (
+	"encoding/binary"
+	"fmt"
 	"strings"
 )
 
+// KeySize is the longest key that can be stored, and the width of the keys
+// of the fixed width encodings. Keys are never truncated: a longer key can
+// not be encoded and is reported as an ErrKeyTooLong.
+//
 // TODO: disallow \x00 in keys
 const KeySize = 256
 
-func KeyToBytes(key string) []byte {
-	keyBytes := []byte(key)
-	if len(keyBytes) > KeySize {
-		return keyBytes[:KeySize] // truncate
+// PadKey returns the fixed width encoding of key, null padded to KeySize
+// bytes, as written by the WAL, the table metadata and fixed format tables.
+func PadKey(key string) ([]byte, error) {
+	if len(key) > KeySize {
+		return nil, &ErrKeyTooLong{Key: key, KeySize: KeySize}
 	}
 
-	// Pad with null bytes
 	padded := make([]byte, KeySize)
-	copy(padded, keyBytes)
-	return padded
+	copy(padded, key)
+	return padded, nil
 }
 
 // TrimPaddedKey removes the null bytes from the end of a string.
 func TrimPaddedKey(key string) string {
 	return strings.TrimRight(key, "\x00")
 }
+
+// AppendKey appends the length prefixed encoding of key to dst, its length
+// as a uvarint followed by its bytes.
+func AppendKey(dst []byte, key string) ([]byte, error) {
+	if len(key) > KeySize {
+		return dst, &ErrKeyTooLong{Key: key, KeySize: KeySize}
+	}
+
+	dst = binary.AppendUvarint(dst, uint64(len(key)))
+	return append(dst, key...), nil
+}
+
+// ReadKey decodes the key AppendKey encoded at the start of buf and returns
+// it with the number of bytes it took.
+func ReadKey(buf []byte) (string, int, error) {
+	length, n := binary.Uvarint(buf)
+	if n <= 0 {
+		return "", 0, fmt.Errorf("key length is invalid")
+	}
+	if length > KeySize {
+		return "", 0, fmt.Errorf("key length %d exceeds the maximum key size", length)
+	}
+	if uint64(len(buf)-n) < length {
+		return "", 0, fmt.Errorf("key of %d bytes is cut short", length)
+	}
+	return string(buf[n : n+int(length)]), n + int(length), nil
+}
@@ -0,0 +1,33 @@
+package shared
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestKeyEncodings(t *testing.T) {
+	long := strings.Repeat("k", KeySize+1)
+
+	padded, err := PadKey("key")
+	if err != nil || len(padded) != KeySize || TrimPaddedKey(string(padded)) != "key" {
+		t.Errorf("PadKey(key) = %d bytes, %v", len(padded), err)
+	}
+	if _, err := PadKey(long); !errors.As(err, new(*ErrKeyTooLong)) {
+		t.Errorf("PadKey() of a long key error = %v, want ErrKeyTooLong", err)
+	}
+
+	buf, err := AppendKey([]byte{0xFF}, "key")
+	if err != nil {
+		t.Fatalf("AppendKey() error: %v", err)
+	}
+	if key, n, err := ReadKey(buf[1:]); err != nil || key != "key" || n != len(buf)-1 {
+		t.Errorf("ReadKey() = %q, %d, %v, want key, %d", key, n, err, len(buf)-1)
+	}
+	if _, _, err := ReadKey(buf[1 : len(buf)-1]); err == nil {
+		t.Errorf("ReadKey() of a key cut short succeeded")
+	}
+	if _, err := AppendKey(nil, long); !errors.As(err, new(*ErrKeyTooLong)) {
+		t.Errorf("AppendKey() of a long key error = %v, want ErrKeyTooLong", err)
+	}
+}